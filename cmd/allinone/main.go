@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"signal/infra/logger"
+	"strconv"
+	"syscall"
+	"time"
+
+	dis "signal/infra/discovery"
+	h "signal/infra/http"
+	"signal/infra/kafka"
+	"signal/infra/mysql"
+	db "signal/infra/redis"
+	"signal/pkg/bus"
+	conf "signal/pkg/conf/allinone"
+	"signal/pkg/log"
+	biz "signal/pkg/node/biz"
+	islb "signal/pkg/node/islb"
+	issr "signal/pkg/node/issr"
+	"signal/pkg/node/sfu"
+	"signal/pkg/rollup"
+	"signal/pkg/rtc"
+	"signal/pkg/rtc/plugins"
+	"signal/pkg/store"
+	"signal/util"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	registry *dis.MemoryRegistry
+	network  *bus.Network
+	factory  logger.Factory
+)
+
+func close() {
+	biz.Close()
+	sfu.Close()
+	if conf.Issr.Enable {
+		issr.Close()
+	}
+	islb.Close()
+}
+
+func main() {
+	defer close()
+
+	log.Init(conf.Log.Level)
+
+	if conf.Registry.Backend == conf.BackendMemory {
+		registry = dis.NewMemoryRegistry()
+	}
+	if conf.Bus.Backend == conf.BackendMemory {
+		network = bus.NewNetwork()
+	}
+	if conf.Registry.Backend == conf.BackendEtcd && conf.Bus.Backend == conf.BackendNats {
+		if f := logger.NewDefaultFactory(conf.Etcd.Addrs, conf.Nats.NatsLog); f != nil {
+			factory = f
+		}
+	} else {
+		factory = logger.NewWriterFactory(os.Stdout)
+	}
+
+	if conf.Global.Pprof != "" {
+		go func() {
+			log.Infof("Start pprof on %s", conf.Global.Pprof)
+			http.ListenAndServe(conf.Global.Pprof, nil)
+		}()
+	}
+
+	httpserver := h.Http{}
+	httpserver.Init(conf.Probe.Host, strconv.Itoa(conf.Probe.Port))
+	g := httpserver.Group("/api/v1", nil, nil)
+	g.Post("/probe", probe, nil)
+
+	http.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if http.ListenAndServe(":"+strconv.Itoa(conf.Monitor.Port), nil) == nil {
+			log.Errorf("start prometheus service fail.")
+		}
+	}()
+
+	// islb最先启动,其他服务启动时就能找到
+	roomStore, err := store.New(store.Config{
+		Backend: conf.Islb.Store,
+		Redis:   redisConfig(),
+		Etcd:    util.ProcessUrlString(conf.Etcd.Addrs),
+	})
+	if err != nil {
+		log.Errorf("islb init store err=%v", err)
+		return
+	}
+	node, watcher := newService("islb", conf.Islb.Nid)
+	islb.Init(node, watcher, newBus(), roomStore, newLogger("islb", conf.Islb.Nid))
+	islb.InitChat(conf.Islb.HistorySize, mysql.MysqlConfig{
+		Host:     conf.Islb.Mysql.Host,
+		Port:     conf.Islb.Mysql.Port,
+		Username: conf.Islb.Mysql.Username,
+		Password: conf.Islb.Mysql.Password,
+		Database: conf.Islb.Mysql.Database,
+	})
+
+	if conf.Issr.Enable {
+		producer, err := newProducer()
+		if err != nil {
+			log.Errorf("issr init sink err=%v", err)
+			return
+		}
+		var kv db.KV = db.NewMemory()
+		if conf.Issr.State == conf.BackendRedis {
+			r := db.NewRedis(redisConfig())
+			if r == nil {
+				log.Errorf("issr redis connect failed")
+				return
+			}
+			kv = r
+		}
+		node, watcher := newService("issr", conf.Issr.Nid)
+		issr.SetTopology(*conf.Topology)
+		issr.SetWebhook(conf.Issr.Webhook)
+		issr.SetExporter(conf.Issr.Exporter)
+		issr.SetBilling(conf.Issr.Billing)
+		issr.SetOutbox(conf.Issr.Outbox)
+		rollups, err := newRollupStore()
+		if err != nil {
+			log.Errorf("issr init rollup err=%v", err)
+			return
+		}
+		issr.SetRollup(rollups)
+		if err := issr.SetTiers(conf.Issr.Tiers); err != nil {
+			log.Errorf("issr init tiers err=%v", err)
+			return
+		}
+		issr.Init(node, watcher, newBus(), producer, kv, newLogger("issr", conf.Issr.Nid))
+	}
+
+	node, watcher = newService("sfu", conf.Sfu.Nid)
+	sfu.SetCapacity(conf.Sfu.Capacity)
+	sfu.Init(node, watcher, newBus(), rtcConfig(), newLogger("sfu", conf.Sfu.Nid))
+
+	node, watcher = newService("biz", conf.Biz.Nid)
+	l := newLogger("biz", conf.Biz.Nid)
+	biz.InitRPC(conf.Biz.RPC.Methods, bus.BreakerConfig{Threshold: conf.Biz.RPC.Threshold, Cooldown: conf.Biz.RPC.Cooldown})
+	biz.Init(node, watcher, newBus(), l)
+	if conf.Biz.RateLimit.Enable {
+		biz.InitRateLimit(conf.Biz.RateLimit.Peer, conf.Biz.RateLimit.AppID)
+	}
+	if err := biz.InitBalance(conf.Biz.Balance.Strategy, conf.Biz.Balance.Weights, conf.Biz.Balance.Fallback, conf.Biz.Balance.Overflow, *conf.Topology); err != nil {
+		log.Errorf("biz.InitBalance err=%v", err)
+		return
+	}
+	biz.InitAdmin(g, conf.Biz.Admin.Token)
+	if conf.Issr.Enable {
+		issr.InitUsageAPI(g, conf.Issr.Rollup.Token)
+	}
+	biz.InitServerAPI(g, conf.Biz.Server.Secrets(), conf.Biz.Server.Window)
+	biz.InitSignalServer(conf.Biz.Signal.Host, conf.Biz.Signal.Port, conf.Biz.Signal.Cert, conf.Biz.Signal.Key)
+
+	log.Infof("allinone start, registry=%s bus=%s sink=%s", conf.Registry.Backend, conf.Bus.Backend, conf.Sink.Backend)
+
+	// 收到SIGHUP时重新加载issr的分辨率计费档位,收到退出信号后先下线,等待客户端离开
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for s := range sig {
+		if s == syscall.SIGHUP {
+			if conf.Issr.Enable {
+				reloadTiers()
+			}
+			continue
+		}
+		l.Infof(fmt.Sprintf("allinone receive signal %v, draining.", s))
+		biz.Drain(time.Duration(conf.Global.Drain) * time.Second)
+		return
+	}
+}
+
+// reloadTiers 重新加载issr的分辨率计费档位,配置错误时保留原来的档位
+func reloadTiers() {
+	c, err := conf.ReloadTiers()
+	if err == nil {
+		err = issr.SetTiers(c)
+	}
+	if err != nil {
+		log.Errorf("issr reload tiers err=%v", err)
+		return
+	}
+	log.Infof("issr tiers reloaded")
+}
+
+// newService 创建服务注册和发现对象并注册节点
+func newService(name, nid string) (*dis.ServiceNode, *dis.ServiceWatcher) {
+	var node *dis.ServiceNode
+	var watcher *dis.ServiceWatcher
+	if registry != nil {
+		node = dis.NewServiceNodeWithRegistry(registry.Session(), conf.Global.Ndc, nid, name, conf.Global.Nip)
+		watcher = dis.NewServiceWatcherWithRegistry(registry.Session())
+	} else {
+		node = dis.NewServiceNode(util.ProcessUrlString(conf.Etcd.Addrs), conf.Global.Ndc, nid, name, conf.Global.Nip)
+		watcher = dis.NewServiceWatcher(util.ProcessUrlString(conf.Etcd.Addrs))
+	}
+	node.RegisterNode()
+	return node, watcher
+}
+
+// newBus 每个服务使用自己的总线,和分开部署时一样各自订阅
+func newBus() bus.Bus {
+	if network != nil {
+		return network.Connect()
+	}
+	return bus.NewNatsBus(conf.Nats.URL)
+}
+
+func newLogger(name, nid string) *logger.Logger {
+	return logger.NewLogger(conf.Global.Ndc, name, nid, conf.Global.Nip, "info", true, factory)
+}
+
+// newProducer 创建计费事件输出
+func newProducer() (kafka.Producer, error) {
+	if conf.Sink.Backend == conf.BackendKafka {
+		return kafka.NewProducer(conf.Kafka.URL)
+	}
+	if dir := filepath.Dir(conf.Sink.File); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return kafka.NewFileProducer(conf.Sink.File)
+}
+
+func redisConfig() db.Config {
+	return db.Config{
+		Addrs: conf.Redis.Addrs,
+		Pwd:   conf.Redis.Pwd,
+		DB:    conf.Redis.DB,
+	}
+}
+
+// rtcConfig 根据配置生成sfu参数
+func rtcConfig() rtc.Config {
+	config := rtc.Config{
+		ICEPortRange: conf.Sfu.WebRTC.ICEPortRange,
+		Plugins: plugins.Config{
+			On: conf.Sfu.Plugins.On,
+			JitterBuffer: plugins.JitterBufferConfig{
+				On:            conf.Sfu.Plugins.JitterBuffer.On,
+				REMBCycle:     conf.Sfu.Plugins.JitterBuffer.REMBCycle,
+				PLICycle:      conf.Sfu.Plugins.JitterBuffer.PLICycle,
+				MaxBandwidth:  conf.Sfu.Plugins.JitterBuffer.MaxBandwidth,
+				MaxBufferTime: conf.Sfu.Plugins.JitterBuffer.MaxBufferTime,
+			},
+		},
+	}
+	for _, iceServer := range conf.Sfu.WebRTC.ICEServers {
+		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
+			URLs:       iceServer.URLs,
+			Username:   iceServer.Username,
+			Credential: iceServer.Credential,
+		})
+	}
+	return config
+}
+
+func probe(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if biz.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// newRollupStore 创建用量汇总存储,backend为空时不汇总
+func newRollupStore() (rollup.Store, error) {
+	switch conf.Issr.Rollup.Backend {
+	case conf.BackendMysql:
+		return rollup.NewMysqlStore(mysql.MysqlConfig{
+			Host:     conf.Issr.Rollup.Mysql.Host,
+			Port:     conf.Issr.Rollup.Mysql.Port,
+			Username: conf.Issr.Rollup.Mysql.Username,
+			Password: conf.Issr.Rollup.Mysql.Password,
+			Database: conf.Issr.Rollup.Mysql.Database,
+		})
+	case conf.BackendMemory:
+		return rollup.NewMemoryStore(), nil
+	}
+	return nil, nil
+}
@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"signal/infra/logger"
 	"strconv"
+	"syscall"
+	"time"
 
 	dis "signal/infra/discovery"
 	h "signal/infra/http"
+	"signal/pkg/bus"
 	conf "signal/pkg/conf/biz"
 	"signal/pkg/log"
 	biz "signal/pkg/node/biz"
@@ -53,14 +58,34 @@ func main() {
 	serviceNode := dis.NewServiceNode(util.ProcessUrlString(conf.Etcd.Addrs), conf.Global.Ndc, conf.Global.Nid, conf.Global.Name, conf.Global.Nip)
 	serviceNode.RegisterNode()
 	serviceWatcher := dis.NewServiceWatcher(util.ProcessUrlString(conf.Etcd.Addrs))
-	biz.Init(serviceNode, serviceWatcher, conf.Nats.URL, l)
+	biz.InitRPC(conf.RPC.Methods, bus.BreakerConfig{Threshold: conf.RPC.Threshold, Cooldown: conf.RPC.Cooldown})
+	biz.Init(serviceNode, serviceWatcher, bus.NewNatsBus(conf.Nats.URL), l)
+	if conf.RateLimit.Enable {
+		biz.InitRateLimit(conf.RateLimit.Peer, conf.RateLimit.AppID)
+	}
+	if err := biz.InitBalance(conf.Balance.Strategy, conf.Balance.Weights, conf.Balance.Fallback, conf.Balance.Overflow, *conf.Topology); err != nil {
+		l.Errorf(fmt.Sprintf("biz.InitBalance err=%v", err))
+		return
+	}
+	biz.InitAdmin(g, conf.Admin.Token)
+	biz.InitServerAPI(g, conf.Server.Secrets(), conf.Server.Window)
 	biz.InitSignalServer(conf.Signal.Host, conf.Signal.Port, conf.Signal.Cert, conf.Signal.Key)
 
 	l.Infof(fmt.Sprintf("biz %s start.", conf.Global.Nid))
 
-	select {}
+	// 收到退出信号后先下线,等待客户端迁移
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	s := <-sig
+	l.Infof(fmt.Sprintf("biz %s receive signal %v, draining.", conf.Global.Nid, s))
+	biz.Drain(time.Duration(conf.Global.Drain) * time.Second)
 }
 
 func probe(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if biz.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
 	w.Write([]byte("OK"))
 }
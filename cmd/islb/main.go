@@ -10,10 +10,13 @@ import (
 
 	dis "signal/infra/discovery"
 	h "signal/infra/http"
+	"signal/infra/mysql"
 	db "signal/infra/redis"
+	"signal/pkg/bus"
 	conf "signal/pkg/conf/islb"
 	"signal/pkg/log"
 	islb "signal/pkg/node/islb"
+	"signal/pkg/store"
 	"signal/util"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -54,13 +57,28 @@ func main() {
 	serviceNode := dis.NewServiceNode(util.ProcessUrlString(conf.Etcd.Addrs), conf.Global.Ndc, conf.Global.Nid, conf.Global.Name, conf.Global.Nip)
 	serviceNode.RegisterNode()
 	serviceWatcher := dis.NewServiceWatcher(util.ProcessUrlString(conf.Etcd.Addrs))
-	config := db.Config{
-		Addrs: conf.Redis.Addrs,
-		Pwd:   conf.Redis.Pwd,
-		DB:    conf.Redis.DB,
+	roomStore, err := store.New(store.Config{
+		Backend: conf.Store.Backend,
+		Redis: db.Config{
+			Addrs: conf.Redis.Addrs,
+			Pwd:   conf.Redis.Pwd,
+			DB:    conf.Redis.DB,
+		},
+		Etcd: util.ProcessUrlString(conf.Etcd.Addrs),
+	})
+	if err != nil {
+		l.Errorf(fmt.Sprintf("islb init store err=%v", err))
+		return
 	}
 
-	islb.Init(serviceNode, serviceWatcher, conf.Nats.URL, config, l)
+	islb.Init(serviceNode, serviceWatcher, bus.NewNatsBus(conf.Nats.URL), roomStore, l)
+	islb.InitChat(conf.Chat.HistorySize, mysql.MysqlConfig{
+		Host:     conf.Mysql.Host,
+		Port:     conf.Mysql.Port,
+		Username: conf.Mysql.Username,
+		Password: conf.Mysql.Password,
+		Database: conf.Mysql.Database,
+	})
 
 	l.Infof(fmt.Sprintf("islb %s start.", conf.Global.Nid))
 
@@ -5,15 +5,22 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"signal/infra/logger"
 	"strconv"
+	"syscall"
 
 	dis "signal/infra/discovery"
 	h "signal/infra/http"
+	"signal/infra/kafka"
+	"signal/infra/mysql"
 	db "signal/infra/redis"
+	"signal/pkg/bus"
 	conf "signal/pkg/conf/issr"
 	"signal/pkg/log"
 	issr "signal/pkg/node/issr"
+	"signal/pkg/rollup"
 	"signal/util"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -56,11 +63,65 @@ func main() {
 		DB:    conf.Redis.DB,
 	}
 
-	issr.Init(serviceNode, serviceWatcher, conf.Nats.URL, conf.Kafka.URL, config, l)
+	producer, err := kafka.NewProducer(conf.Kafka.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	issr.SetTopology(*conf.Topology)
+	issr.SetWebhook(*conf.Webhook)
+	issr.SetExporter(*conf.Exporter)
+	issr.SetBilling(*conf.Billing)
+	issr.SetOutbox(*conf.Outbox)
+	issr.SetRollup(newRollupStore())
+	if err := issr.SetTiers(*conf.Tiers); err != nil {
+		panic(err)
+	}
+	issr.Init(serviceNode, serviceWatcher, bus.NewNatsBus(conf.Nats.URL), producer, db.NewRedis(config), l)
+	issr.InitUsageAPI(g, conf.Rollup.Token)
 
 	l.Infof(fmt.Sprintf("issr %s start.", conf.Global.Nid))
 
-	select {}
+	// 收到SIGHUP时重新加载分辨率计费档位
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		reloadTiers()
+	}
+}
+
+// reloadTiers 重新加载分辨率计费档位,配置错误时保留原来的档位
+func reloadTiers() {
+	c, err := conf.ReloadTiers()
+	if err == nil {
+		err = issr.SetTiers(c)
+	}
+	if err != nil {
+		log.Errorf("issr reload tiers err=%v", err)
+		return
+	}
+	log.Infof("issr tiers reloaded")
+}
+
+// newRollupStore 创建用量汇总存储,backend为空时不汇总
+func newRollupStore() rollup.Store {
+	switch conf.Rollup.Backend {
+	case "mysql":
+		store, err := rollup.NewMysqlStore(mysql.MysqlConfig{
+			Host:     conf.Rollup.Mysql.Host,
+			Port:     conf.Rollup.Mysql.Port,
+			Username: conf.Rollup.Mysql.Username,
+			Password: conf.Rollup.Mysql.Password,
+			Database: conf.Rollup.Mysql.Database,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return store
+	case "memory":
+		return rollup.NewMemoryStore()
+	}
+	return nil
 }
 
 func probe(ctx context.Context, w http.ResponseWriter, req *http.Request) {
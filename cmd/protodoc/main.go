@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"signal/pkg/proto"
+)
+
+// 生成信令协议的OpenAPI文档
+// go run ./cmd/protodoc -o docs/signal.openapi.json
+func main() {
+	out := flag.String("o", "", "output file, default stdout")
+	version := flag.String("v", "1.0.0", "document version")
+	flag.Parse()
+
+	buf, err := json.MarshalIndent(proto.OpenAPI(*version), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	buf = append(buf, '\n')
+	if *out == "" {
+		os.Stdout.Write(buf)
+		return
+	}
+	if err := ioutil.WriteFile(*out, buf, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
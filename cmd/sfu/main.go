@@ -5,16 +5,24 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
 	"signal/infra/logger"
 	"strconv"
+	"syscall"
+	"time"
 
 	dis "signal/infra/discovery"
 	h "signal/infra/http"
+	"signal/pkg/bus"
 	conf "signal/pkg/conf/sfu"
 	"signal/pkg/log"
 	"signal/pkg/node/sfu"
+	"signal/pkg/rtc"
+	"signal/pkg/rtc/plugins"
 	"signal/util"
 
+	"github.com/pion/webrtc/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -26,6 +34,8 @@ func main() {
 
 	defer close()
 
+	log.Init(conf.Log.Level)
+
 	//init logger
 	factory := logger.NewDefaultFactory(conf.Etcd.Addrs, conf.Nats.NatsLog)
 	l := logger.NewLogger(conf.Global.Ndc, conf.Global.Name, conf.Global.Nid, conf.Global.Nip, "info", true, factory)
@@ -55,13 +65,50 @@ func main() {
 	serviceNode := dis.NewServiceNode(util.ProcessUrlString(conf.Etcd.Addrs), conf.Global.Ndc, conf.Global.Nid, conf.Global.Name, conf.Global.Nip)
 	serviceNode.RegisterNode()
 	serviceWatcher := dis.NewServiceWatcher(util.ProcessUrlString(conf.Etcd.Addrs))
-	sfu.Init(serviceNode, serviceWatcher, conf.Nats.URL, l)
+	sfu.SetCapacity(*conf.Capacity)
+	sfu.Init(serviceNode, serviceWatcher, bus.NewNatsBus(conf.Nats.URL), rtcConfig(), l)
+	sfu.InitAdmin(g, conf.Admin.Token)
 
 	l.Infof(fmt.Sprintf("sfu %s start.", conf.Global.Nid))
 
-	select {}
+	// 收到退出信号后先下线,等待客户端迁移
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	s := <-sig
+	l.Infof(fmt.Sprintf("sfu %s receive signal %v, draining.", conf.Global.Nid, s))
+	sfu.Drain(time.Duration(conf.Global.Drain) * time.Second)
+}
+
+// rtcConfig 根据配置生成sfu参数
+func rtcConfig() rtc.Config {
+	config := rtc.Config{
+		ICEPortRange: conf.WebRTC.ICEPortRange,
+		Plugins: plugins.Config{
+			On: conf.Plugins.On,
+			JitterBuffer: plugins.JitterBufferConfig{
+				On:            conf.Plugins.JitterBuffer.On,
+				REMBCycle:     conf.Plugins.JitterBuffer.REMBCycle,
+				PLICycle:      conf.Plugins.JitterBuffer.PLICycle,
+				MaxBandwidth:  conf.Plugins.JitterBuffer.MaxBandwidth,
+				MaxBufferTime: conf.Plugins.JitterBuffer.MaxBufferTime,
+			},
+		},
+	}
+	for _, iceServer := range conf.WebRTC.ICEServers {
+		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
+			URLs:       iceServer.URLs,
+			Username:   iceServer.Username,
+			Credential: iceServer.Credential,
+		})
+	}
+	return config
 }
 
 func probe(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	if sfu.IsDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("DRAINING"))
+		return
+	}
 	w.Write([]byte("OK"))
 }
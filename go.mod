@@ -6,7 +6,7 @@ replace google.golang.org/grpc => google.golang.org/grpc v1.26.0
 
 require (
 	github.com/Shopify/sarama v1.28.0
-	github.com/coreos/etcd v3.3.25+incompatible // indirect
+	github.com/coreos/etcd v3.3.25+incompatible
 	github.com/dustin/go-humanize v1.0.0 // indirect
 	github.com/gearghost/go-protoo v0.1.4
 	github.com/gearghost/nats-protoo v0.1.1
@@ -176,6 +176,21 @@ func (e *Etcd) GetByPrefix(key string) (map[string]string, error) {
 	return data, err
 }
 
+// GetKeysByPrefix 获取指定前缀的key,不返回值
+func (e *Etcd) GetKeysByPrefix(key string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	resp, err := e.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
 // GetResponseByPrefix 获取指定前缀的key对应的值
 func (e *Etcd) GetResponseByPrefix(key string) (*clientv3.GetResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
@@ -187,3 +202,192 @@ func (e *Etcd) GetResponseByPrefix(key string) (*clientv3.GetResponse, error) {
 	cancel()
 	return resp, nil
 }
+
+// grant 按ttl申请租约,ttl为0时不使用租约
+func (e *Etcd) grant(ctx context.Context, ttl time.Duration) ([]clientv3.OpOption, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	resp, err := e.client.Grant(ctx, seconds)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(resp.ID)}, nil
+}
+
+// Put 写入key-value,ttl大于0时到期自动删除
+func (e *Etcd) Put(key, value string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	opts, err := e.grant(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Put(ctx, key, value, opts...)
+	return err
+}
+
+// PutIfAbsent key不存在时写入,返回key当前的值以及是否写入
+func (e *Etcd) PutIfAbsent(key, value string, ttl time.Duration) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	opts, err := e.grant(ctx, ttl)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value, opts...)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return "", false, err
+	}
+	if resp.Succeeded {
+		return value, true, nil
+	}
+	for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+		return string(kv.Value), false, nil
+	}
+	return "", false, nil
+}
+
+// PutFirst 写入key-value,返回写入前prefix下是否没有key
+func (e *Etcd) PutFirst(key, value string, ttl time.Duration, prefix string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	opts, err := e.grant(ctx, ttl)
+	if err != nil {
+		return false, err
+	}
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(prefix).WithPrefix(), "=", 0)).
+		Then(clientv3.OpPut(key, value, opts...)).
+		Else(clientv3.OpPut(key, value, opts...)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// DeleteLast 删除key,返回是否删除了prefix下最后一个key
+func (e *Etcd) DeleteLast(key, prefix string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	resp, err := e.client.Txn(ctx).
+		Then(clientv3.OpDelete(key), clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	deleted := resp.Responses[0].GetResponseDeleteRange().Deleted
+	return deleted > 0 && resp.Responses[1].GetResponseRange().Count == 0, nil
+}
+
+// Refresh 续期key的租约,key不存在或没有租约时返回false
+func (e *Etcd) Refresh(key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	resp, err := e.client.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if len(resp.Kvs) == 0 || resp.Kvs[0].Lease == 0 {
+		return false, nil
+	}
+	_, err = e.client.KeepAliveOnce(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GrantLease 申请ttl的租约,多个key可以共用同一个租约
+func (e *Etcd) GrantLease(ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	resp, err := e.client.Grant(ctx, seconds)
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+// RefreshLease 续期租约,租约已过期时返回错误
+func (e *Etcd) RefreshLease(lease int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	_, err := e.client.KeepAliveOnce(ctx, clientv3.LeaseID(lease))
+	return err
+}
+
+// PutWithLease 使用已有的租约写入key-value
+func (e *Etcd) PutWithLease(key, value string, lease int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	_, err := e.client.Put(ctx, key, value, clientv3.WithLease(clientv3.LeaseID(lease)))
+	return err
+}
+
+// GetKeysByPrefixDesc 按key倒序获取指定前缀的key,最多limit个
+func (e *Etcd) GetKeysByPrefixDesc(key string, limit int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	resp, err := e.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys, nil
+}
+
+// DeleteRange 删除[key, end)范围内的key
+func (e *Etcd) DeleteRange(key, end string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	_, err := e.client.Delete(ctx, key, clientv3.WithRange(end))
+	return err
+}
+
+// DeleteWithPrev 删除key,prefix是否前缀,返回被删除的key-value
+func (e *Etcd) DeleteWithPrev(key string, prefix bool) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	resp, err := e.client.Delete(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]string)
+	for _, kv := range resp.PrevKvs {
+		data[string(kv.Key)] = string(kv.Value)
+	}
+	return data, nil
+}
+
+// CountByPrefix 获取指定前缀的key数量
+func (e *Etcd) CountByPrefix(key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOperationTimeout)
+	defer cancel()
+	resp, err := e.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
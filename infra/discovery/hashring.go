@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas 哈希环上每个节点的虚拟节点数量
+const DefaultReplicas = 160
+
+// Ring 一致性哈希环,节点增减时只有相邻区间的key改变归属
+type Ring struct {
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+}
+
+// NewRing 新建一个哈希环,replicas小于等于0时使用DefaultReplicas
+func NewRing(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+	}
+	for _, node := range nodes {
+		r.add(node)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+func (r *Ring) add(node string) {
+	for i := 0; i < r.replicas; i++ {
+		h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + node))
+		// 哈希冲突时保留字典序较小的节点,保证所有服务计算结果一致
+		if owner, ok := r.owners[h]; ok {
+			if node < owner {
+				r.owners[h] = node
+			}
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+}
+
+// Get 获取key归属的节点,环为空时返回空
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Len 环上的节点数量
+func (r *Ring) Len() int {
+	return len(r.hashes) / r.replicas
+}
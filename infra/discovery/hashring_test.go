@@ -0,0 +1,70 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingGet(t *testing.T) {
+	if NewRing(0).Get("room1") != "" {
+		t.Errorf("empty ring should return empty node")
+	}
+	r := NewRing(0, "islb1", "islb2", "islb3")
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[r.Get(fmt.Sprintf("room%d", i))]++
+	}
+	for node, count := range counts {
+		if count < 600 {
+			t.Errorf("node %s owns %d keys, distribution is uneven", node, count)
+		}
+	}
+	if r.Get("room1") != NewRing(0, "islb3", "islb1", "islb2").Get("room1") {
+		t.Errorf("owner should not depend on node order")
+	}
+}
+
+func TestRingResharding(t *testing.T) {
+	before := NewRing(0, "islb1", "islb2", "islb3")
+	after := NewRing(0, "islb1", "islb2", "islb3", "islb4")
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("room%d", i)
+		if before.Get(key) != after.Get(key) {
+			moved++
+			if after.Get(key) != "islb4" {
+				t.Errorf("key %s moved between existing nodes", key)
+			}
+		}
+	}
+	if moved == 0 || moved > 1200 {
+		t.Errorf("moved %d keys", moved)
+	}
+}
+
+func TestGetNodeByHash(t *testing.T) {
+	w := &ServiceWatcher{nodes: make(map[string]Node), rings: make(map[string]*Ring)}
+	w.setNode(Node{Nid: "islb1", Name: "islb"})
+	w.setNode(Node{Nid: "islb2", Name: "islb"})
+	w.setNode(Node{Nid: "sfu1", Name: "sfu"})
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("room%d", i)
+		node, find := w.GetNodeByHash("islb", key)
+		if !find || node.Name != "islb" {
+			t.Fatalf("hash %s = %v", key, node)
+		}
+		owners[key] = node.Nid
+	}
+	// islb2下线后所有房间归属islb1
+	w.setNode(Node{Nid: "islb2", Name: "islb", Nstate: NodeDraining})
+	for key := range owners {
+		if node, _ := w.GetNodeByHash("islb", key); node.Nid != "islb1" {
+			t.Errorf("hash %s = %s after draining", key, node.Nid)
+		}
+	}
+	w.DeleteNodesByID("islb1")
+	if _, find := w.GetNodeByHash("islb", "room1"); find {
+		t.Errorf("no islb should be found")
+	}
+}
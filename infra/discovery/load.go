@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Load 节点负载,由节点定期上报到etcd
+type Load struct {
+	// CPU cpu使用率,0-100
+	CPU float64 `json:"cpu"`
+	// Ingress 入口带宽,kbps
+	Ingress int64 `json:"ingress"`
+	// Egress 出口带宽,kbps
+	Egress int64 `json:"egress"`
+	// Routers 发布流数量
+	Routers int `json:"routers"`
+	// Subs 订阅流数量
+	Subs int `json:"subs"`
+	// Capacity 节点配置的承载上限
+	Capacity Capacity `json:"capacity"`
+}
+
+// Capacity 节点承载上限,0表示不限制
+type Capacity struct {
+	// CPU cpu使用率上限,0-100
+	CPU float64 `json:"cpu" mapstructure:"cpu"`
+	// Bandwidth 出入口带宽之和的上限,kbps
+	Bandwidth int64 `json:"bandwidth" mapstructure:"bandwidth"`
+	// Streams 发布流和订阅流数量之和的上限
+	Streams int `json:"streams" mapstructure:"streams"`
+}
+
+// Weights 负载打分时各项的权重
+type Weights struct {
+	CPU       float64 `mapstructure:"cpu"`
+	Bandwidth float64 `mapstructure:"bandwidth"`
+	Streams   float64 `mapstructure:"streams"`
+}
+
+// Streams 发布流和订阅流数量之和
+func (load *Load) Streams() int {
+	return load.Routers + load.Subs
+}
+
+// Bandwidth 出入口带宽之和,kbps
+func (load *Load) Bandwidth() int64 {
+	return load.Ingress + load.Egress
+}
+
+// Overloaded 任意一项达到上限即认为节点已满
+func (load *Load) Overloaded() bool {
+	c := load.Capacity
+	return (c.CPU > 0 && load.CPU >= c.CPU) ||
+		(c.Bandwidth > 0 && load.Bandwidth() >= c.Bandwidth) ||
+		(c.Streams > 0 && load.Streams() >= c.Streams)
+}
+
+// Score 负载得分,越小越空闲
+// 配置了上限的项按使用比例加权平均,都没有配置时退化为流数量
+func (load *Load) Score(w Weights) float64 {
+	if w.CPU == 0 && w.Bandwidth == 0 && w.Streams == 0 {
+		w = Weights{CPU: 1, Bandwidth: 1, Streams: 1}
+	}
+	c := load.Capacity
+	var score, total float64
+	if c.CPU > 0 {
+		score += w.CPU * load.CPU / c.CPU
+		total += w.CPU
+	}
+	if c.Bandwidth > 0 {
+		score += w.Bandwidth * float64(load.Bandwidth()) / float64(c.Bandwidth)
+		total += w.Bandwidth
+	}
+	if c.Streams > 0 {
+		score += w.Streams * float64(load.Streams()) / float64(c.Streams)
+		total += w.Streams
+	}
+	if total == 0 {
+		return float64(load.Streams())
+	}
+	return score / total
+}
+
+// GetLoad 获取节点负载,没有上报负载的节点使用Npayload作为流数量
+func (node *Node) GetLoad() Load {
+	var load Load
+	if node.Nload != "" && json.Unmarshal([]byte(node.Nload), &load) == nil {
+		return load
+	}
+	load.Routers, _ = strconv.Atoi(node.Npayload)
+	return load
+}
+
+// Encode 将负载转换成string
+func (load *Load) Encode() string {
+	buf, err := json.Marshal(load)
+	if err != nil {
+		return ""
+	}
+	return string(buf)
+}
@@ -3,6 +3,7 @@ package discovery
 import (
 	"encoding/json"
 	"signal/util"
+	"strings"
 )
 
 // NodeStateType 节点状态
@@ -15,6 +16,9 @@ const (
 	ServerDown NodeStateType = 1
 )
 
+// NodeDraining 节点正在下线,不再分配新的请求
+const NodeDraining = "draining"
+
 // Node 服务节点对象
 type Node struct {
 	// Ndc 节点区域
@@ -27,11 +31,20 @@ type Node struct {
 	Nip string
 	// 节点负载
 	Npayload string
+	// 节点负载详情,Load的json格式
+	Nload string
+	// 节点状态,为空表示正常
+	Nstate string
+}
+
+// IsDraining 节点是否正在下线
+func (node *Node) IsDraining() bool {
+	return node.Nstate == NodeDraining
 }
 
 // GetNodeValue 获取节点保存的值
 func (node *Node) GetNodeValue() string {
-	return Encode(util.Map2("Ndc", node.Ndc, "Nid", node.Nid, "Name", node.Name, "Nip", node.Nip, "Npayload", node.Npayload))
+	return Encode(util.Map2("Ndc", node.Ndc, "Nid", node.Nid, "Name", node.Name, "Nip", node.Nip, "Npayload", node.Npayload, "Nload", node.Nload, "Nstate", node.Nstate))
 }
 
 // Encode 将map格式转换成string
@@ -58,6 +71,11 @@ func GetEventChannel(node Node) string {
 	return "event-" + node.Nid
 }
 
+// GetNidFromEventChannel 从广播对象string中获取节点id
+func GetNidFromEventChannel(channel string) string {
+	return strings.TrimPrefix(channel, "event-")
+}
+
 // GetRPCChannel 获取RPC对象string
 func GetRPCChannel(node Node) string {
 	return "rpc-" + node.Nid
@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Registry 服务注册中心,Etcd实现了该接口
+type Registry interface {
+	// Keep 写入key-value,注册对象关闭后删除
+	Keep(key, value string) error
+	// Update 更新key-value
+	Update(key, value string) error
+	// Watch 观察指定的key,有改变通过watchFunc回调告知
+	Watch(key string, watchFunc WatchCallback, prefix bool) error
+	// GetResponseByPrefix 按前缀获取key-value
+	GetResponseByPrefix(key string) (*clientv3.GetResponse, error)
+	// Close 关闭对象
+	Close() error
+}
+
+// MemoryRegistry 进程内注册中心,用于单进程部署和测试
+// 每个服务通过Session获取自己的注册对象,Session关闭时删除它写入的key,相当于etcd租约过期
+type MemoryRegistry struct {
+	sync.Mutex
+	kvs      map[string]string
+	watchers map[*memoryWatcher]struct{}
+	revision int64
+}
+
+// NewMemoryRegistry 创建进程内注册中心
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		kvs:      make(map[string]string),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+// Session 创建注册对象
+func (r *MemoryRegistry) Session() Registry {
+	return &memorySession{registry: r, keys: make(map[string]struct{}), watchers: make([]*memoryWatcher, 0)}
+}
+
+func (r *MemoryRegistry) put(key, value string) {
+	r.Lock()
+	defer r.Unlock()
+	r.revision++
+	r.kvs[key] = value
+	r.notify(mvccpb.PUT, key, value)
+}
+
+func (r *MemoryRegistry) delete(key string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, found := r.kvs[key]; !found {
+		return
+	}
+	r.revision++
+	delete(r.kvs, key)
+	r.notify(mvccpb.DELETE, key, "")
+}
+
+func (r *MemoryRegistry) notify(typ mvccpb.Event_EventType, key, value string) {
+	for w := range r.watchers {
+		if w.match(key) {
+			w.push(&clientv3.Event{
+				Type: typ,
+				Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: r.revision},
+			})
+		}
+	}
+}
+
+// memoryWatcher 按顺序把事件发送到watch通道
+type memoryWatcher struct {
+	sync.Mutex
+	key    string
+	prefix bool
+	ch     chan clientv3.WatchResponse
+	queue  []*clientv3.Event
+	signal chan struct{}
+	closed bool
+}
+
+func newMemoryWatcher(key string, prefix bool) *memoryWatcher {
+	w := &memoryWatcher{
+		key:    key,
+		prefix: prefix,
+		ch:     make(chan clientv3.WatchResponse),
+		signal: make(chan struct{}, 1),
+	}
+	go w.run()
+	return w
+}
+
+func (w *memoryWatcher) match(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+func (w *memoryWatcher) push(ev *clientv3.Event) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	w.queue = append(w.queue, ev)
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) run() {
+	defer close(w.ch)
+	for range w.signal {
+		w.Lock()
+		events := w.queue
+		w.queue = nil
+		w.Unlock()
+		if len(events) > 0 {
+			w.ch <- clientv3.WatchResponse{Events: events}
+		}
+	}
+}
+
+func (w *memoryWatcher) close() {
+	w.Lock()
+	defer w.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.signal)
+	}
+}
+
+// memorySession 一个服务的注册对象
+type memorySession struct {
+	sync.Mutex
+	registry *MemoryRegistry
+	keys     map[string]struct{}
+	watchers []*memoryWatcher
+	stop     bool
+}
+
+func (s *memorySession) Keep(key, value string) error {
+	s.Lock()
+	s.keys[key] = struct{}{}
+	s.Unlock()
+	s.registry.put(key, value)
+	return nil
+}
+
+func (s *memorySession) Update(key, value string) error {
+	return s.Keep(key, value)
+}
+
+func (s *memorySession) Watch(key string, watchFunc WatchCallback, prefix bool) error {
+	w := newMemoryWatcher(key, prefix)
+	s.Lock()
+	s.watchers = append(s.watchers, w)
+	s.Unlock()
+	s.registry.Lock()
+	s.registry.watchers[w] = struct{}{}
+	s.registry.Unlock()
+	watchFunc(w.ch)
+	return nil
+}
+
+func (s *memorySession) GetResponseByPrefix(key string) (*clientv3.GetResponse, error) {
+	s.registry.Lock()
+	defer s.registry.Unlock()
+	resp := &clientv3.GetResponse{}
+	for k, v := range s.registry.kvs {
+		if strings.HasPrefix(k, key) {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+		}
+	}
+	sort.Slice(resp.Kvs, func(i, j int) bool {
+		return string(resp.Kvs[i].Key) < string(resp.Kvs[j].Key)
+	})
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (s *memorySession) Close() error {
+	s.Lock()
+	if s.stop {
+		s.Unlock()
+		return nil
+	}
+	s.stop = true
+	keys := s.keys
+	watchers := s.watchers
+	s.Unlock()
+	s.registry.Lock()
+	for _, w := range watchers {
+		delete(s.registry.watchers, w)
+		w.close()
+	}
+	s.registry.Unlock()
+	for key := range keys {
+		s.registry.delete(key)
+	}
+	return nil
+}
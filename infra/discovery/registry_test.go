@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	registry := NewMemoryRegistry()
+	islb := NewServiceNodeWithRegistry(registry.Session(), "sz", "islb1", "islb", "127.0.0.1")
+	islb.RegisterNode()
+
+	watcher := NewServiceWatcherWithRegistry(registry.Session())
+	events := make(chan NodeStateType, 10)
+	watcher.WatchServiceNode("", func(state NodeStateType, node Node) {
+		events <- state
+	})
+
+	wait := func(want NodeStateType) {
+		select {
+		case state := <-events:
+			if state != want {
+				t.Fatalf("state = %v, want %v", state, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event, want %v", want)
+		}
+	}
+	// 已经注册的节点和后注册的节点都会通知
+	wait(ServerUp)
+	sfu := NewServiceNodeWithRegistry(registry.Session(), "sz", "sfu1", "sfu", "127.0.0.1")
+	sfu.RegisterNode()
+	wait(ServerUp)
+	if _, found := watcher.GetNodeByID("sfu1"); !found {
+		t.Errorf("sfu1 not found")
+	}
+
+	sfu.SetDraining()
+	wait(ServerUp)
+	if node, _ := watcher.GetNodeByID("sfu1"); node == nil || !node.IsDraining() {
+		t.Errorf("sfu1 = %v", node)
+	}
+	// 下线中不再更新负载,不会覆盖下线状态
+	sfu.UpdateNodePayload(5)
+	if node := sfu.NodeInfo(); !node.IsDraining() || node.Npayload != "0" {
+		t.Errorf("sfu1 after update = %v", node)
+	}
+
+	// 注册对象关闭后节点下线
+	sfu.Close()
+	wait(ServerDown)
+	if _, found := watcher.GetNodeByID("sfu1"); found {
+		t.Errorf("sfu1 still found")
+	}
+	islb.Close()
+	wait(ServerDown)
+}
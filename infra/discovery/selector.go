@@ -0,0 +1,126 @@
+package discovery
+
+import "fmt"
+
+const (
+	// StrategyDC 优先选择本区域的节点,本区域没有可用节点时按延迟选择其他区域
+	StrategyDC = "dc"
+	// StrategyAffinity 优先选择指定的节点,如房间已绑定的sfu
+	StrategyAffinity = "affinity"
+	// StrategyLeastLoad 按负载得分选择最空闲的节点
+	StrategyLeastLoad = "leastload"
+)
+
+// SelectOption 选择节点的条件
+type SelectOption struct {
+	// Dc 优先选择的区域
+	Dc string
+	// Affinity 优先选择的节点id
+	Affinity string
+	// Except 排除的节点id
+	Except string
+	// Unhealthy 返回true的节点不参与选择,如请求连续失败已熔断的节点
+	Unhealthy func(nid string) bool
+}
+
+// Selector 节点选择策略
+type Selector interface {
+	Select(nodes []Node, opt SelectOption) *Node
+}
+
+// available 节点是否可以分配新的请求
+func available(node *Node, opt SelectOption) bool {
+	if node.IsDraining() || node.Nid == opt.Except {
+		return false
+	}
+	if opt.Unhealthy != nil && opt.Unhealthy(node.Nid) {
+		return false
+	}
+	load := node.GetLoad()
+	return !load.Overloaded()
+}
+
+// LeastLoaded 选择负载得分最低的节点,跳过正在下线和已满的节点
+type LeastLoaded struct {
+	Weights Weights
+}
+
+// Select 实现Selector
+func (s *LeastLoaded) Select(nodes []Node, opt SelectOption) *Node {
+	var selected *Node
+	var min float64
+	for i := range nodes {
+		node := &nodes[i]
+		if !available(node, opt) {
+			continue
+		}
+		load := node.GetLoad()
+		score := load.Score(s.Weights)
+		if selected == nil || score < min {
+			selected = node
+			min = score
+		}
+	}
+	return selected
+}
+
+// RoomAffinity 优先选择opt.Affinity指定的节点,该节点不可用时交给Next选择
+type RoomAffinity struct {
+	Next Selector
+}
+
+// Select 实现Selector
+func (s *RoomAffinity) Select(nodes []Node, opt SelectOption) *Node {
+	if opt.Affinity != "" {
+		for i := range nodes {
+			if nodes[i].Nid == opt.Affinity && available(&nodes[i], opt) {
+				return &nodes[i]
+			}
+		}
+	}
+	return s.Next.Select(nodes, opt)
+}
+
+// DCPreference 优先在opt.Dc区域内选择,没有可用节点时按Topology中的延迟依次选择其他区域
+// Fallback为true时以上区域都没有可用节点再选择任意区域
+type DCPreference struct {
+	Next     Selector
+	Fallback bool
+	Topology Topology
+}
+
+// Select 实现Selector
+func (s *DCPreference) Select(nodes []Node, opt SelectOption) *Node {
+	for _, dc := range s.Topology.Nearby(opt.Dc) {
+		candidates := make([]Node, 0, len(nodes))
+		for _, node := range nodes {
+			if node.Ndc == dc {
+				candidates = append(candidates, node)
+			}
+		}
+		if selected := s.Next.Select(candidates, opt); selected != nil {
+			return selected
+		}
+	}
+	if s.Fallback {
+		return s.Next.Select(nodes, opt)
+	}
+	return nil
+}
+
+// NewSelector 按策略名称组合选择器,前面的策略优先,最后总是按负载选择
+func NewSelector(strategies []string, weights Weights, fallback bool, topology Topology) (Selector, error) {
+	var selector Selector = &LeastLoaded{Weights: weights}
+	for i := len(strategies) - 1; i >= 0; i-- {
+		switch strategies[i] {
+		case StrategyDC:
+			selector = &DCPreference{Next: selector, Fallback: fallback, Topology: topology}
+		case StrategyAffinity:
+			selector = &RoomAffinity{Next: selector}
+		case StrategyLeastLoad:
+		default:
+			return nil, fmt.Errorf("unknown select strategy %s", strategies[i])
+		}
+	}
+	return selector, nil
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import "testing"
+
+func sfuNode(dc, nid string, load Load) Node {
+	return Node{Ndc: dc, Nid: nid, Name: "sfu", Nload: load.Encode()}
+}
+
+func TestLoadScore(t *testing.T) {
+	c := Capacity{CPU: 80, Bandwidth: 1000, Streams: 100}
+	load := Load{CPU: 40, Ingress: 200, Egress: 300, Routers: 10, Subs: 40, Capacity: c}
+	if score := load.Score(Weights{}); score != 0.5 {
+		t.Errorf("score = %v", score)
+	}
+	if load.Overloaded() {
+		t.Errorf("load should not be overloaded")
+	}
+	load.Subs = 90
+	if !load.Overloaded() {
+		t.Errorf("load should be overloaded")
+	}
+	node := Node{Npayload: "7"}
+	if l := node.GetLoad(); l.Score(Weights{}) != 7 {
+		t.Errorf("payload score = %v", l.Score(Weights{}))
+	}
+}
+
+func TestSelector(t *testing.T) {
+	c := Capacity{Streams: 100}
+	nodes := []Node{
+		sfuNode("sz", "sfu1", Load{Routers: 50, Capacity: c}),
+		sfuNode("sz", "sfu2", Load{Routers: 10, Capacity: c}),
+		sfuNode("sz", "sfu3", Load{Routers: 100, Capacity: c}),
+		sfuNode("bj", "sfu4", Load{Routers: 0, Capacity: c}),
+	}
+	selector, err := NewSelector([]string{StrategyDC, StrategyAffinity, StrategyLeastLoad}, Weights{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		opt SelectOption
+		nid string
+	}{
+		{SelectOption{Dc: "sz"}, "sfu2"},
+		{SelectOption{Dc: "sz", Affinity: "sfu1"}, "sfu1"},
+		{SelectOption{Dc: "sz", Affinity: "sfu3"}, "sfu2"},
+		{SelectOption{Dc: "sz", Except: "sfu2"}, "sfu1"},
+		{SelectOption{Dc: "sz", Affinity: "sfu1", Unhealthy: func(nid string) bool { return nid != "sfu4" }}, "sfu4"},
+		{SelectOption{Dc: "gz"}, "sfu4"},
+	}
+	for _, c := range cases {
+		node := selector.Select(nodes, c.opt)
+		if node == nil || node.Nid != c.nid {
+			t.Errorf("select %+v = %v, want %s", c.opt, node, c.nid)
+		}
+	}
+
+	strict, _ := NewSelector([]string{StrategyDC}, Weights{}, false, nil)
+	if node := strict.Select(nodes, SelectOption{Dc: "gz"}); node != nil {
+		t.Errorf("strict select = %v", node)
+	}
+	if _, err := NewSelector([]string{"random"}, Weights{}, false, nil); err == nil {
+		t.Errorf("unknown strategy should fail")
+	}
+}
+
+func TestTopology(t *testing.T) {
+	topology := Topology{"sz": {"gz": 8, "bj": 45, "sh": 30}}
+	nearby := topology.Nearby("sz")
+	if len(nearby) != 4 || nearby[0] != "sz" || nearby[1] != "gz" || nearby[2] != "sh" || nearby[3] != "bj" {
+		t.Errorf("nearby = %v", nearby)
+	}
+	if nearby := topology.Nearby("bj"); len(nearby) != 1 {
+		t.Errorf("nearby = %v", nearby)
+	}
+
+	nodes := []Node{
+		sfuNode("bj", "sfu1", Load{}),
+		sfuNode("sh", "sfu2", Load{Routers: 10}),
+	}
+	selector, _ := NewSelector([]string{StrategyDC}, Weights{}, false, topology)
+	if node := selector.Select(nodes, SelectOption{Dc: "sz"}); node == nil || node.Nid != "sfu2" {
+		t.Errorf("select = %v, want sfu2", node)
+	}
+}
@@ -3,22 +3,35 @@ package discovery
 import (
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"log"
 )
 
 // ServiceNode 服务注册对象
+// 负载更新由一个goroutine串行写入注册中心,每次写入都读取最新的节点信息,写入期间的修改在写完后再写一次
 type ServiceNode struct {
-	etcd *Etcd
-	node Node
+	sync.Mutex
+	registry Registry
+	node     Node
+	updating bool // 有goroutine正在写入
+	dirty    bool // 写入期间节点信息有修改
 }
 
 // NewServiceNode 新建一个服务注册对象
 func NewServiceNode(endpoints []string, dc, nid, name, nip string) *ServiceNode {
+	var registry Registry
+	if etcd, err := NewEtcd(endpoints); err == nil {
+		registry = etcd
+	}
+	return NewServiceNodeWithRegistry(registry, dc, nid, name, nip)
+}
+
+// NewServiceNodeWithRegistry 使用指定的注册中心新建一个服务注册对象
+func NewServiceNodeWithRegistry(registry Registry, dc, nid, name, nip string) *ServiceNode {
 	var serverNode ServiceNode
-	etcd, _ := NewEtcd(endpoints)
-	serverNode.etcd = etcd
+	serverNode.registry = registry
 	serverNode.node = Node{
 		Ndc:      dc,
 		Nid:      nid,
@@ -31,13 +44,15 @@ func NewServiceNode(endpoints []string, dc, nid, name, nip string) *ServiceNode
 
 // Close 关闭资源
 func (serverNode *ServiceNode) Close() {
-	if serverNode.etcd != nil {
-		serverNode.etcd.Close()
+	if serverNode.registry != nil {
+		serverNode.registry.Close()
 	}
 }
 
 // NodeInfo 返回服务节点信息
 func (serverNode *ServiceNode) NodeInfo() Node {
+	serverNode.Lock()
+	defer serverNode.Unlock()
 	return serverNode.node
 }
 
@@ -56,23 +71,73 @@ func (serverNode *ServiceNode) RegisterNode() error {
 	if serverNode.node.Ndc == "" || serverNode.node.Nid == "" || serverNode.node.Name == "" {
 		return fmt.Errorf("Node dc id or name must be non empty")
 	}
-	go serverNode.keepRegistered(serverNode.node)
+	go serverNode.keepRegistered(serverNode.NodeInfo())
 	return nil
 }
 
-// UpdateNodePayload 更新节点负载
+// UpdateNodePayload 更新节点负载,节点下线中不再更新
 func (serverNode *ServiceNode) UpdateNodePayload(payload int) error {
+	serverNode.Lock()
+	defer serverNode.Unlock()
+	if serverNode.node.IsDraining() {
+		return nil
+	}
 	if serverNode.node.Npayload != strconv.Itoa(payload) {
 		serverNode.node.Npayload = strconv.Itoa(payload)
-		go serverNode.updateRegistered(serverNode.node)
+		serverNode.update()
+	}
+	return nil
+}
+
+// UpdateNodeLoad 更新节点负载详情,同时把流数量写入Npayload以兼容只读取Npayload的服务,节点下线中不再更新
+func (serverNode *ServiceNode) UpdateNodeLoad(load Load) error {
+	payload := strconv.Itoa(load.Streams())
+	nload := load.Encode()
+	serverNode.Lock()
+	defer serverNode.Unlock()
+	if serverNode.node.IsDraining() {
+		return nil
+	}
+	if serverNode.node.Npayload != payload || serverNode.node.Nload != nload {
+		serverNode.node.Npayload = payload
+		serverNode.node.Nload = nload
+		serverNode.update()
 	}
 	return nil
 }
 
+// SetDraining 标记节点正在下线,其他服务不再选择该节点
+func (serverNode *ServiceNode) SetDraining() error {
+	serverNode.Lock()
+	serverNode.node.Nstate = NodeDraining
+	node := serverNode.node
+	// 正在写入的负载更新可能在这次写入之后完成,让它写完后再写一次最新的节点信息
+	serverNode.dirty = serverNode.updating
+	serverNode.Unlock()
+	return serverNode.registry.Update(node.Nid, node.GetNodeValue())
+}
+
+// IsDraining 节点是否正在下线
+func (serverNode *ServiceNode) IsDraining() bool {
+	serverNode.Lock()
+	defer serverNode.Unlock()
+	return serverNode.node.IsDraining()
+}
+
+// update 节点信息有修改,没有goroutine在写入时启动一个,调用时需持有锁
+func (serverNode *ServiceNode) update() {
+	if serverNode.updating {
+		serverNode.dirty = true
+		return
+	}
+	serverNode.updating = true
+	go serverNode.updateRegistered()
+}
+
 // keepRegistered 注册一个服务节点到etcd服务管理上
 func (serverNode *ServiceNode) keepRegistered(node Node) {
 	for {
-		err := serverNode.etcd.Keep(node.Nid, node.GetNodeValue())
+		err := serverNode.registry.Keep(node.Nid, node.GetNodeValue())
 		if err != nil {
 			log.Printf("keepRegistered err = %s", err)
 			time.Sleep(5 * time.Second)
@@ -83,16 +148,28 @@ func (serverNode *ServiceNode) keepRegistered(node Node) {
 	}
 }
 
-// keepRegistered 更新一个服务节点到etcd服务管理上
-func (serverNode *ServiceNode) updateRegistered(node Node) {
+// updateRegistered 更新一个服务节点到etcd服务管理上,每次重试都写入最新的节点信息
+func (serverNode *ServiceNode) updateRegistered() {
 	for {
-		err := serverNode.etcd.Update(node.Nid, node.GetNodeValue())
+		serverNode.Lock()
+		node := serverNode.node
+		serverNode.dirty = false
+		serverNode.Unlock()
+
+		err := serverNode.registry.Update(node.Nid, node.GetNodeValue())
 		if err != nil {
 			log.Printf("updateRegistered err = %s", err)
 			time.Sleep(5 * time.Second)
-		} else {
-			log.Printf("Node [%s] updateRegistered success!", node.Nid)
+			continue
+		}
+		log.Printf("Node [%s] updateRegistered success!", node.Nid)
+
+		serverNode.Lock()
+		if !serverNode.dirty {
+			serverNode.updating = false
+			serverNode.Unlock()
 			return
 		}
+		serverNode.Unlock()
 	}
 }
@@ -0,0 +1,26 @@
+package discovery
+
+import "sort"
+
+// Topology 区域之间的延迟,毫秒
+// 本区域没有可用节点时按延迟从低到高选择其他区域,没有配置的区域不参与就近选择
+type Topology map[string]map[string]int
+
+// Nearby 获取dc和按延迟从低到高排列的其他区域
+func (t Topology) Nearby(dc string) []string {
+	dcs := []string{dc}
+	latency := t[dc]
+	others := make([]string, 0, len(latency))
+	for other := range latency {
+		if other != dc {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if latency[others[i]] == latency[others[j]] {
+			return others[i] < others[j]
+		}
+		return latency[others[i]] < latency[others[j]]
+	})
+	return append(dcs, others...)
+}
@@ -2,8 +2,8 @@ package discovery
 
 import (
 	"log"
-	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"go.etcd.io/etcd/clientv3"
 )
@@ -13,31 +13,40 @@ type ServiceWatchCallback func(state NodeStateType, node Node)
 
 // ServiceWatcher 服务发现对象
 type ServiceWatcher struct {
-	etcd     *Etcd
-	bStop    bool
+	registry Registry
+	bStop    int32
 	nodes    map[string]Node
 	nodeLook sync.Mutex
 	callback ServiceWatchCallback
+	// rings 按服务名缓存的一致性哈希环,成员变化时清除
+	rings map[string]*Ring
 }
 
 // NewServiceWatcher 新建一个服务发现对象
 func NewServiceWatcher(endpoints []string) *ServiceWatcher {
-	watch, _ := NewEtcd(endpoints)
-	serviceWatcher := &ServiceWatcher{
-		bStop:    false,
+	var registry Registry
+	if etcd, err := NewEtcd(endpoints); err == nil {
+		registry = etcd
+	}
+	log.Printf("New Service Watcher: etcd => %v", endpoints)
+	return NewServiceWatcherWithRegistry(registry)
+}
+
+// NewServiceWatcherWithRegistry 使用指定的注册中心新建一个服务发现对象
+func NewServiceWatcherWithRegistry(registry Registry) *ServiceWatcher {
+	return &ServiceWatcher{
 		nodes:    make(map[string]Node),
-		etcd:     watch,
+		rings:    make(map[string]*Ring),
+		registry: registry,
 		callback: nil,
 	}
-	log.Printf("New Service Watcher: etcd => %v", endpoints)
-	return serviceWatcher
 }
 
 // Close 关闭资源
 func (serviceWatcher *ServiceWatcher) Close() {
-	serviceWatcher.bStop = true
-	if serviceWatcher.etcd != nil {
-		serviceWatcher.etcd.Close()
+	atomic.StoreInt32(&serviceWatcher.bStop, 1)
+	if serviceWatcher.registry != nil {
+		serviceWatcher.registry.Close()
 	}
 }
 
@@ -68,45 +77,95 @@ func (serviceWatcher *ServiceWatcher) GetNodeByID(nid string) (*Node, bool) {
 	return nil, false
 }
 
-// GetNodeByPayload 获取指定区域内指定服务节点负载最低的节点
+// GetNodeByPayload 获取指定区域内指定服务节点负载最低的节点,跳过正在下线和已满的节点
 func (serviceWatcher *ServiceWatcher) GetNodeByPayload(dc, name string) (*Node, bool) {
-	var tempObj Node
-	var nodeObj *Node = nil
-	var payload int = 65535
+	return serviceWatcher.GetNodeByPayloadExcept(dc, name, "")
+}
+
+// GetNodeByPayloadExcept 获取负载最低的节点,跳过正在下线、已满的节点和指定id的节点
+func (serviceWatcher *ServiceWatcher) GetNodeByPayloadExcept(dc, name, except string) (*Node, bool) {
+	selector := &DCPreference{Next: &LeastLoaded{}}
+	return serviceWatcher.SelectNode(name, selector, SelectOption{Dc: dc, Except: except})
+}
+
+// GetNodeByDC 获取指定服务的节点,优先本区域,其次按延迟就近选择,都没有时选择任意区域
+func (serviceWatcher *ServiceWatcher) GetNodeByDC(dc, name string, topology Topology) (*Node, bool) {
+	selector := &DCPreference{Next: &LeastLoaded{}, Fallback: true, Topology: topology}
+	return serviceWatcher.SelectNode(name, selector, SelectOption{Dc: dc})
+}
+
+// SelectNode 按选择策略获取指定服务的节点
+func (serviceWatcher *ServiceWatcher) SelectNode(name string, selector Selector, opt SelectOption) (*Node, bool) {
 	serviceWatcher.nodeLook.Lock()
-	defer serviceWatcher.nodeLook.Unlock()
+	nodes := make([]Node, 0, len(serviceWatcher.nodes))
 	for _, node := range serviceWatcher.nodes {
-		if node.Ndc == dc && node.Name == name {
-			pay, _ := strconv.Atoi(node.Npayload)
-			if pay <= payload {
-				tempObj = node
-				nodeObj = &tempObj
-				payload = pay
-			}
+		if node.Name == name {
+			nodes = append(nodes, node)
 		}
 	}
-	if nodeObj == nil {
+	serviceWatcher.nodeLook.Unlock()
+
+	node := selector.Select(nodes, opt)
+	if node == nil {
 		return nil, false
 	}
-	return nodeObj, true
+	return node, true
 }
 
 // DeleteNodesByID 删除指定节点id的服务节点
 func (serviceWatcher *ServiceWatcher) DeleteNodesByID(nid string) bool {
 	serviceWatcher.nodeLook.Lock()
 	defer serviceWatcher.nodeLook.Unlock()
-	_, find := serviceWatcher.nodes[nid]
+	node, find := serviceWatcher.nodes[nid]
 	if find {
 		delete(serviceWatcher.nodes, nid)
+		delete(serviceWatcher.rings, node.Name)
 	}
 	return true
 }
 
+// setNode 保存服务节点,节点新增或状态改变时清除该服务的哈希环
+func (serviceWatcher *ServiceWatcher) setNode(node Node) {
+	serviceWatcher.nodeLook.Lock()
+	defer serviceWatcher.nodeLook.Unlock()
+	old, find := serviceWatcher.nodes[node.Nid]
+	if !find || old.Nstate != node.Nstate || old.Name != node.Name {
+		delete(serviceWatcher.rings, node.Name)
+		if find {
+			delete(serviceWatcher.rings, old.Name)
+		}
+	}
+	serviceWatcher.nodes[node.Nid] = node
+}
+
+// GetNodeByHash 根据key的一致性哈希获取指定服务的节点,正在下线的节点不参与分配
+// 所有服务看到的节点成员一致时,同一个key总是落在同一个节点上
+func (serviceWatcher *ServiceWatcher) GetNodeByHash(name, key string) (*Node, bool) {
+	serviceWatcher.nodeLook.Lock()
+	defer serviceWatcher.nodeLook.Unlock()
+	ring, find := serviceWatcher.rings[name]
+	if !find {
+		nids := make([]string, 0)
+		for _, node := range serviceWatcher.nodes {
+			if node.Name == name && !node.IsDraining() {
+				nids = append(nids, node.Nid)
+			}
+		}
+		ring = NewRing(DefaultReplicas, nids...)
+		serviceWatcher.rings[name] = ring
+	}
+	node, find := serviceWatcher.nodes[ring.Get(key)]
+	if !find {
+		return nil, false
+	}
+	return &node, true
+}
+
 // WatchNode 监控到服务节点状态改变
 func (serviceWatcher *ServiceWatcher) WatchNode(ch clientv3.WatchChan) {
 	go func() {
 		for {
-			if serviceWatcher.bStop {
+			if atomic.LoadInt32(&serviceWatcher.bStop) == 1 {
 				return
 			}
 			msg := <-ch
@@ -121,10 +180,10 @@ func (serviceWatcher *ServiceWatcher) WatchNode(ch clientv3.WatchChan) {
 						node.Name = nodeObj["Name"]
 						node.Nip = nodeObj["Nip"]
 						node.Npayload = nodeObj["Npayload"]
+						node.Nload = nodeObj["Nload"]
+						node.Nstate = nodeObj["Nstate"]
 
-						serviceWatcher.nodeLook.Lock()
-						serviceWatcher.nodes[nid] = node
-						serviceWatcher.nodeLook.Unlock()
+						serviceWatcher.setNode(node)
 
 						log.Printf("Node Up [%v]", node)
 						if serviceWatcher.callback != nil {
@@ -152,12 +211,12 @@ func (serviceWatcher *ServiceWatcher) WatchNode(ch clientv3.WatchChan) {
 func (serviceWatcher *ServiceWatcher) WatchServiceNode(prefix string, callback ServiceWatchCallback) {
 	serviceWatcher.callback = callback
 	serviceWatcher.GetServiceNodes(prefix)
-	serviceWatcher.etcd.Watch(prefix, serviceWatcher.WatchNode, true)
+	serviceWatcher.registry.Watch(prefix, serviceWatcher.WatchNode, true)
 }
 
 // GetServiceNodes 获取已经存在节点
 func (serviceWatcher *ServiceWatcher) GetServiceNodes(prefix string) {
-	rsp, err := serviceWatcher.etcd.GetResponseByPrefix(prefix)
+	rsp, err := serviceWatcher.registry.GetResponseByPrefix(prefix)
 	if err != nil {
 		log.Printf(err.Error())
 	}
@@ -170,10 +229,10 @@ func (serviceWatcher *ServiceWatcher) GetServiceNodes(prefix string) {
 			node.Name = nodeobj["Name"]
 			node.Nip = nodeobj["Nip"]
 			node.Npayload = nodeobj["Npayload"]
+			node.Nload = nodeobj["Nload"]
+			node.Nstate = nodeobj["Nstate"]
 
-			serviceWatcher.nodeLook.Lock()
-			serviceWatcher.nodes[node.Nid] = node
-			serviceWatcher.nodeLook.Unlock()
+			serviceWatcher.setNode(node)
 
 			log.Printf("find Node [%v]", node)
 			if serviceWatcher.callback != nil {
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// TokenFilter 校验请求头Authorization: Bearer <token>,token为空时拒绝所有请求
+func TokenFilter(token string) Filter {
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) bool {
+		auth := req.Header.Get("Authorization")
+		if token != "" && strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+			return true
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+}
+
+// WriteJSON 以json格式返回数据
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ReadJSON 解析json格式的请求数据
+func ReadJSON(req *http.Request, v interface{}) error {
+	defer req.Body.Close()
+	return json.NewDecoder(req.Body).Decode(v)
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 服务端之间的请求签名
+// signature = hex(hmac-sha256(secret, appid + "\n" + timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n" + body))
+// timestamp为unix秒,nonce为每个请求不同的随机串,uri包含查询参数
+const (
+	HeaderAppID     = "X-Signal-AppID"
+	HeaderTimestamp = "X-Signal-Timestamp"
+	HeaderNonce     = "X-Signal-Nonce"
+	HeaderSignature = "X-Signal-Signature"
+
+	maxNonceLength = 64
+)
+
+// Sign 计算请求签名
+func Sign(secret, appid, timestamp, nonce, method, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(appid + "\n" + timestamp + "\n" + nonce + "\n" + method + "\n" + uri + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest 给请求加上签名头,body为请求体,每次调用生成新的nonce
+func SignRequest(req *http.Request, appid, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	nonce := hex.EncodeToString(buf)
+	req.Header.Set(HeaderAppID, appid)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(secret, appid, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+}
+
+// nonceCache 时间窗口内已经使用过的nonce,在请求时间加window之后过期
+type nonceCache struct {
+	sync.Mutex
+	seen  map[string]time.Time
+	sweep time.Time
+}
+
+// add 记录nonce,窗口内已经使用过时返回false
+func (c *nonceCache) add(nonce string, expire, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+	if now.Sub(c.sweep) >= time.Second {
+		for s, t := range c.seen {
+			if now.After(t) {
+				delete(c.seen, s)
+			}
+		}
+		c.sweep = now
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = expire
+	return true
+}
+
+// SignFilter 校验请求签名,secret返回appid的密钥,未知的appid返回空
+// 请求时间和本机时间相差超过window时拒绝,窗口内同一appid的nonce只接受一次,防止请求被重放
+// 已使用的nonce保存在进程内,多个节点时每个节点各自去重
+func SignFilter(secret func(appid string) string, window time.Duration) Filter {
+	cache := &nonceCache{seen: make(map[string]time.Time)}
+	return func(ctx context.Context, w http.ResponseWriter, req *http.Request) bool {
+		appid := req.Header.Get(HeaderAppID)
+		timestamp := req.Header.Get(HeaderTimestamp)
+		nonce := req.Header.Get(HeaderNonce)
+		key := secret(appid)
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if key == "" || err != nil || nonce == "" || len(nonce) > maxNonceLength {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		if d := time.Since(time.Unix(ts, 0)); d > window || d < -window {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return false
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		expected := Sign(key, appid, timestamp, nonce, req.Method, req.URL.RequestURI(), body)
+		if !hmac.Equal([]byte(expected), []byte(req.Header.Get(HeaderSignature))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		if !cache.add(appid+"\n"+nonce, time.Unix(ts, 0).Add(window), time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+}
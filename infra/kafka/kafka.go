@@ -16,12 +16,16 @@ type SyncProducer struct {
 	sarama.SyncProducer
 }
 
+// NewKafkaClient 创建kafka连接,生产者开启幂等,broker重试时不会重复写入
+// 相同key的消息写入同一分区,没有key的消息随机选择分区
 func NewKafkaClient(url string) (*KafkaClient, error) {
 	config := sarama.NewConfig()
-	config.Version = sarama.V0_10_2_0
+	config.Version = sarama.V0_11_0_0
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Return.Successes = true
-	config.Producer.Partitioner = sarama.NewRandomPartitioner
+	config.Producer.Idempotent = true
+	config.Net.MaxOpenRequests = 1
+	config.Producer.Partitioner = sarama.NewHashPartitioner
 	config.Consumer.Return.Errors = true
 	client, err := sarama.NewClient(processUrlString(url), config)
 	if err != nil {
@@ -50,8 +54,16 @@ func NewSyncProducer(client *KafkaClient) (*SyncProducer, error) {
 }
 
 func (s *SyncProducer) Produce(topic, message string) error {
+	return s.ProduceWithKey(topic, "", message)
+}
+
+// ProduceWithKey 发送带key的消息,key为空时不设置
+func (s *SyncProducer) ProduceWithKey(topic, key, message string) error {
 	msg := &sarama.ProducerMessage{}
 	msg.Topic = topic
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
 	msg.Value = sarama.StringEncoder(message)
 	pid, offset, err := s.SendMessage(msg)
 	if err != nil {
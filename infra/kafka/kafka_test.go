@@ -1,17 +1,27 @@
 package kafka
 
 import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
 )
 
 func TestSyncProducer(t *testing.T) {
 	client, err := NewKafkaClient("localhost:9092")
 	if err != nil {
-		t.Error(err)
+		// 需要本地kafka,连接不上时跳过
+		t.Skip(err)
 	}
 	producer, err := NewSyncProducer(client)
 	if err != nil {
-		t.Error(err)
+		client.Close()
+		t.Fatal(err)
 	}
 	err = producer.Produce("waht", "i don't care")
 	if err != nil {
@@ -20,3 +30,159 @@ func TestSyncProducer(t *testing.T) {
 	producer.Close()
 	client.Close()
 }
+
+// memoryProducer 记录收到的消息
+type memoryProducer struct {
+	sync.Mutex
+	messages []string
+}
+
+func (p *memoryProducer) Produce(topic, message string) error {
+	p.Lock()
+	defer p.Unlock()
+	p.messages = append(p.messages, topic+":"+message)
+	return nil
+}
+
+func (p *memoryProducer) Close() error {
+	return nil
+}
+
+func TestAsyncProducer(t *testing.T) {
+	p := &memoryProducer{}
+	a := NewAsyncProducer(p, 10)
+	for i := 0; i < 5; i++ {
+		if err := a.Produce("topic", "message"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 关闭时发送完队列中的消息
+	a.Close()
+	if len(p.messages) != 5 || p.messages[0] != "topic:message" {
+		t.Errorf("messages = %v", p.messages)
+	}
+	if err := a.Produce("topic", "message"); err != ErrClosed {
+		t.Errorf("produce after close err = %v", err)
+	}
+}
+
+// flakyProducer 前fails次发送失败,err为失败时返回的错误
+type flakyProducer struct {
+	memoryProducer
+	fails int
+	err   error
+}
+
+func (p *flakyProducer) ProduceWithKey(topic, key, message string) error {
+	p.Lock()
+	if p.fails > 0 && topic != "dead" {
+		p.fails--
+		p.Unlock()
+		return p.err
+	}
+	p.Unlock()
+	return p.Produce(topic, key+":"+message)
+}
+
+func (p *flakyProducer) count() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.messages)
+}
+
+func waitMessages(t *testing.T, p *flakyProducer, n int) {
+	for i := 0; i < 100 && p.count() < n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.count() != n {
+		t.Fatalf("messages = %v", p.messages)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	p := &flakyProducer{fails: 2, err: errors.New("broker down")}
+	o, err := NewOutbox(p, OutboxConfig{Dir: dir, Backoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := o.Send("usage", key, "m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 失败后按顺序重试
+	waitMessages(t, p, 3)
+	if p.messages[0] != "usage:a:m" || p.messages[2] != "usage:c:m" || o.Depth() != 0 {
+		t.Errorf("messages = %v depth = %d", p.messages, o.Depth())
+	}
+
+	// 发送失败时关闭,重新打开后继续发送
+	p.fails, p.messages = 1000, nil
+	o.Send("usage", "d", "m")
+	o.Close()
+	if err := o.Send("usage", "e", "m"); err != ErrClosed {
+		t.Errorf("send after close err = %v", err)
+	}
+	p.fails = 0
+	o, err = NewOutbox(p, OutboxConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMessages(t, p, 1)
+	if p.messages[0] != "usage:d:m" {
+		t.Errorf("resent = %v", p.messages)
+	}
+	o.Close()
+}
+
+func TestOutboxCompact(t *testing.T) {
+	defer func(size int64) { walCompactSize = size }(walCompactSize)
+	walCompactSize = 1
+	dir := t.TempDir()
+	p := &flakyProducer{fails: 1000, err: errors.New("broker down")}
+	o, err := NewOutbox(p, OutboxConfig{Dir: dir, Backoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		o.Send("usage", key, "m")
+	}
+	o.Close()
+
+	// a和b已提交,重新打开时WAL中只保留c
+	ioutil.WriteFile(filepath.Join(dir, offsetFile), []byte("2"), 0644)
+	p.fails = 1000
+	o, err = NewOutbox(p, OutboxConfig{Dir: dir, Backoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(filepath.Join(dir, walFile))
+	if lines := strings.Split(strings.TrimSpace(string(buf)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"key":"c"`) {
+		t.Errorf("wal = %q", buf)
+	}
+	// 压缩后的WAL继续追加
+	o.Send("usage", "d", "m")
+	p.Lock()
+	p.fails = 0
+	p.Unlock()
+	waitMessages(t, p, 2)
+	if p.messages[0] != "usage:c:m" || p.messages[1] != "usage:d:m" {
+		t.Errorf("messages = %v", p.messages)
+	}
+	o.Close()
+}
+
+func TestOutboxDeadLetter(t *testing.T) {
+	p := &flakyProducer{fails: 1000, err: sarama.ErrMessageSizeTooLarge}
+	o, err := NewOutbox(p, OutboxConfig{Dir: t.TempDir(), DeadLetter: "dead", Backoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	o.Send("usage", "a", "m")
+	waitMessages(t, p, 1)
+	if p.messages[0][:7] != "dead:a:" {
+		t.Errorf("dead letter = %v", p.messages)
+	}
+}
@@ -0,0 +1,366 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 发送箱,消息先追加写入本地WAL并落盘,再由后台协程按写入顺序发送
+// 发送成功后记录已提交的序号,进程重启时从WAL中未提交的消息继续发送
+// 消息全部发送后清空WAL,已提交的部分超过walCompactSize时把未发送的消息写入新文件替换WAL
+// 崩溃时最后一条消息可能重复发送,消息带有幂等key,消费者按key去重
+// 当前sarama版本不支持事务,broker端依靠幂等生产者去重
+
+// OutboxConfig 发送箱配置
+type OutboxConfig struct {
+	Dir        string `mapstructure:"dir"`        // WAL目录
+	DeadLetter string `mapstructure:"deadletter"` // 死信topic,为空时不使用死信
+	Attempts   int    `mapstructure:"attempts"`   // 发送失败多少次后写入死信topic
+	Backoff    int    `mapstructure:"backoff"`    // 毫秒,第一次重试前等待的时间,之后每次翻倍
+}
+
+// 默认配置
+const (
+	DefaultOutboxDir = "outbox"
+	DefaultAttempts  = 10
+	DefaultBackoff   = 500
+	maxBackoff       = 30 * time.Second
+)
+
+const (
+	walFile    = "outbox.wal"
+	offsetFile = "outbox.offset"
+)
+
+// walCompactSize WAL中已提交部分的字节数超过该值时压缩
+var walCompactSize int64 = 16 * 1024 * 1024
+
+// outboxEntry WAL中的一条消息,time为写入时间,毫秒,size为在WAL中占用的字节数
+type outboxEntry struct {
+	Seq     uint64 `json:"seq"`
+	Key     string `json:"key"`
+	Topic   string `json:"topic"`
+	Message string `json:"message"`
+	Time    int64  `json:"time"`
+	size    int64
+}
+
+// DeadLetter 写入死信topic的消息
+type DeadLetter struct {
+	Key      string `json:"key"`
+	Topic    string `json:"topic"`
+	Message  string `json:"message"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+	Time     int64  `json:"time"`
+}
+
+// Outbox 持久化的发送箱
+type Outbox struct {
+	sync.Mutex
+	producer  Producer
+	config    OutboxConfig
+	wal       *os.File
+	seq       uint64
+	committed uint64
+	garbage   int64 // WAL中已提交和无法解析的部分的字节数
+	pending   []outboxEntry
+	notify    chan struct{}
+	closing   chan struct{}
+	done      chan struct{}
+	closed    bool
+}
+
+// NewOutbox 打开发送箱并开始发送WAL中未提交的消息,p由调用者关闭
+func NewOutbox(p Producer, c OutboxConfig) (*Outbox, error) {
+	if c.Dir == "" {
+		c.Dir = DefaultOutboxDir
+	}
+	if c.Attempts <= 0 {
+		c.Attempts = DefaultAttempts
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = DefaultBackoff
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, err
+	}
+	o := &Outbox{
+		producer: p,
+		config:   c,
+		notify:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(c.Dir, walFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	o.wal = wal
+	if len(o.pending) == 0 {
+		o.wal.Truncate(0)
+		o.garbage = 0
+	} else if o.garbage >= walCompactSize {
+		if err := o.compact(); err != nil {
+			log.Printf("outbox compact failed,err => %v", err)
+		}
+	}
+	go o.run()
+	return o, nil
+}
+
+// load 读取已提交的序号和WAL中未提交的消息,最后一行不完整时忽略
+func (o *Outbox) load() error {
+	if buf, err := ioutil.ReadFile(filepath.Join(o.config.Dir, offsetFile)); err == nil {
+		o.committed, _ = strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	o.seq = o.committed
+
+	file, err := os.Open(filepath.Join(o.config.Dir, walFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry outboxEntry
+		size := int64(len(scanner.Bytes()) + 1)
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("outbox skip broken entry,err => %v", err)
+			o.garbage += size
+			continue
+		}
+		entry.size = size
+		if entry.Seq > o.seq {
+			o.seq = entry.Seq
+		}
+		if entry.Seq > o.committed {
+			o.pending = append(o.pending, entry)
+		} else {
+			o.garbage += size
+		}
+	}
+	return scanner.Err()
+}
+
+// Send 写入WAL并落盘后返回,key用于消费者去重
+func (o *Outbox) Send(topic, key, message string) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.closed {
+		return ErrClosed
+	}
+	entry := outboxEntry{Seq: o.seq + 1, Key: key, Topic: topic, Message: message, Time: time.Now().UnixNano() / int64(time.Millisecond)}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := o.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := o.wal.Sync(); err != nil {
+		return err
+	}
+	o.seq = entry.Seq
+	entry.size = int64(len(line) + 1)
+	o.pending = append(o.pending, entry)
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Depth 未发送的消息数
+func (o *Outbox) Depth() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.pending)
+}
+
+// Lag 最早一条未发送消息的等待时间
+func (o *Outbox) Lag() time.Duration {
+	o.Lock()
+	defer o.Unlock()
+	if len(o.pending) == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, o.pending[0].Time*int64(time.Millisecond)))
+}
+
+// Close 发送失败时立即停止,未发送的消息保留在WAL中,下次打开时继续发送,不关闭p
+func (o *Outbox) Close() error {
+	o.Lock()
+	if o.closed {
+		o.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.closing)
+	o.Unlock()
+	<-o.done
+	return o.wal.Close()
+}
+
+func (o *Outbox) run() {
+	defer close(o.done)
+	for {
+		entry, ok := o.next()
+		if !ok {
+			return
+		}
+		if !o.deliver(entry) {
+			return
+		}
+		if err := o.commit(entry.Seq); err != nil {
+			log.Printf("outbox commit %d failed,err => %v", entry.Seq, err)
+		}
+	}
+}
+
+// next 等待下一条消息,关闭且没有消息时返回false
+func (o *Outbox) next() (outboxEntry, bool) {
+	for {
+		o.Lock()
+		if len(o.pending) > 0 {
+			entry := o.pending[0]
+			o.Unlock()
+			return entry, true
+		}
+		o.Unlock()
+		select {
+		case <-o.notify:
+		case <-o.closing:
+			return outboxEntry{}, false
+		}
+	}
+}
+
+// deliver 按顺序重试发送,多次失败或消息无法写入时写入死信topic,关闭时返回false
+func (o *Outbox) deliver(entry outboxEntry) bool {
+	backoff := time.Duration(o.config.Backoff) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := o.produce(entry.Topic, entry.Key, entry.Message)
+		if err == nil {
+			return true
+		}
+		log.Printf("outbox send %s to %s failed %d times,err => %v", entry.Key, entry.Topic, attempt, err)
+		if o.config.DeadLetter != "" && (permanentError(err) || attempt >= o.config.Attempts) {
+			if o.deadLetter(entry, err, attempt) == nil {
+				return true
+			}
+		}
+		select {
+		case <-time.After(backoff):
+		case <-o.closing:
+			return false
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (o *Outbox) deadLetter(entry outboxEntry, cause error, attempts int) error {
+	buf, err := json.Marshal(&DeadLetter{
+		Key: entry.Key, Topic: entry.Topic, Message: entry.Message,
+		Error: cause.Error(), Attempts: attempts, Time: entry.Time,
+	})
+	if err != nil {
+		return err
+	}
+	if err := o.produce(o.config.DeadLetter, entry.Key, string(buf)); err != nil {
+		log.Printf("outbox send %s to dead letter failed,err => %v", entry.Key, err)
+		return err
+	}
+	return nil
+}
+
+func (o *Outbox) produce(topic, key, message string) error {
+	if p, ok := o.producer.(KeyedProducer); ok {
+		return p.ProduceWithKey(topic, key, message)
+	}
+	return o.producer.Produce(topic, message)
+}
+
+// commit 记录已发送的序号,消息全部发送后清空WAL,已提交的部分过大时压缩
+func (o *Outbox) commit(seq uint64) error {
+	o.Lock()
+	defer o.Unlock()
+	o.garbage += o.pending[0].size
+	o.pending = o.pending[1:]
+	o.committed = seq
+	path := filepath.Join(o.config.Dir, offsetFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(strconv.FormatUint(seq, 10)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if len(o.pending) == 0 {
+		o.garbage = 0
+		return o.wal.Truncate(0)
+	}
+	if o.garbage >= walCompactSize {
+		return o.compact()
+	}
+	return nil
+}
+
+// compact 把未发送的消息写入新文件并落盘后替换WAL,替换前失败时继续使用原来的WAL
+func (o *Outbox) compact() error {
+	path := filepath.Join(o.config.Dir, walFile)
+	wal, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(wal)
+	for _, entry := range o.pending {
+		line, _ := json.Marshal(entry)
+		w.Write(append(line, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = wal.Sync()
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		wal.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	o.wal.Close()
+	o.wal = wal
+	o.garbage = 0
+	return nil
+}
+
+// permanentError 重试也无法成功的错误
+func permanentError(err error) bool {
+	switch err {
+	case sarama.ErrInvalidMessage, sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidTopic, sarama.ErrTopicAuthorizationFailed:
+		return true
+	}
+	_, ok := err.(sarama.ConfigurationError)
+	return ok
+}
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Producer 消息生产者
+type Producer interface {
+	Produce(topic, message string) error
+	Close() error
+}
+
+// KeyedProducer 可以指定消息key的生产者,消费者按key去重
+type KeyedProducer interface {
+	ProduceWithKey(topic, key, message string) error
+}
+
+// clientProducer 关闭时同时关闭kafka连接
+type clientProducer struct {
+	*SyncProducer
+	client *KafkaClient
+}
+
+// NewProducer 连接kafka并创建生产者
+func NewProducer(url string) (Producer, error) {
+	client, err := NewKafkaClient(url)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := NewSyncProducer(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &clientProducer{SyncProducer: producer, client: client}, nil
+}
+
+func (p *clientProducer) Close() error {
+	err := p.SyncProducer.Close()
+	if cerr := p.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// FileProducer 把消息按行写入文件,用于没有kafka的部署
+type FileProducer struct {
+	sync.Mutex
+	file *os.File
+}
+
+// NewFileProducer 创建文件生产者,文件不存在时创建,存在时追加
+func NewFileProducer(path string) (*FileProducer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileProducer{file: file}, nil
+}
+
+// Produce 写入一行json,包含时间、topic和消息
+func (p *FileProducer) Produce(topic, message string) error {
+	return p.ProduceWithKey(topic, "", message)
+}
+
+// ProduceWithKey 写入一行json,key不为空时一起写入
+func (p *FileProducer) ProduceWithKey(topic, key, message string) error {
+	record := map[string]interface{}{
+		"time":    time.Now().Format(time.RFC3339),
+		"topic":   topic,
+		"message": message,
+	}
+	if key != "" {
+		record["key"] = key
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	_, err = p.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close 关闭文件
+func (p *FileProducer) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.file.Close()
+}
+
+// AsyncProducer 异步生产者,消息先放入队列,由后台协程通过p发送
+// 队列满时Produce返回错误,发送失败只记录日志
+type AsyncProducer struct {
+	sync.RWMutex
+	producer Producer
+	queue    chan asyncMessage
+	done     chan struct{}
+	closed   bool
+}
+
+type asyncMessage struct {
+	topic   string
+	message string
+}
+
+var (
+	// ErrQueueFull 异步生产者队列已满
+	ErrQueueFull = errors.New("kafka: async producer queue full")
+	// ErrClosed 异步生产者已关闭
+	ErrClosed = errors.New("kafka: async producer closed")
+)
+
+// NewAsyncProducer 创建异步生产者,size为队列长度
+func NewAsyncProducer(p Producer, size int) *AsyncProducer {
+	if size <= 0 {
+		size = 1024
+	}
+	a := &AsyncProducer{
+		producer: p,
+		queue:    make(chan asyncMessage, size),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncProducer) run() {
+	defer close(a.done)
+	for msg := range a.queue {
+		if err := a.producer.Produce(msg.topic, msg.message); err != nil {
+			log.Printf("async produce to %s failed,err => %v", msg.topic, err)
+		}
+	}
+}
+
+// Produce 放入队列,不等待发送结果
+func (a *AsyncProducer) Produce(topic, message string) error {
+	a.RLock()
+	defer a.RUnlock()
+	if a.closed {
+		return ErrClosed
+	}
+	select {
+	case a.queue <- asyncMessage{topic: topic, message: message}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close 发送完队列中的消息后返回,不关闭p
+func (a *AsyncProducer) Close() error {
+	a.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.Unlock()
+	<-a.done
+	return nil
+}
@@ -0,0 +1,24 @@
+package logger
+
+import (
+	"io"
+	"sync"
+)
+
+// WriterFactory 把日志按行写入io.Writer,用于没有logsvr的部署
+type WriterFactory struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewWriterFactory 创建写入w的日志输出
+func NewWriterFactory(w io.Writer) *WriterFactory {
+	return &WriterFactory{w: w}
+}
+
+// OutPut 输出一行日志
+func (f *WriterFactory) OutPut(msg string) {
+	f.Lock()
+	defer f.Unlock()
+	f.w.Write([]byte(msg + "\n"))
+}
@@ -26,3 +26,13 @@ func NewMonitorCounter(name, help string, labels []string) *prometheus.CounterVe
 	prometheus.MustRegister(counter)
 	return counter
 }
+
+func NewMonitorGaugeFunc(name, help string, fn func() float64) prometheus.GaugeFunc {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: name,
+		Help: help,
+	}, fn,
+	)
+	prometheus.MustRegister(gauge)
+	return gauge
+}
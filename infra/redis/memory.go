@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KV 服务使用的key-value和列表操作,Redis和Memory实现了该接口
+type KV interface {
+	Get(k string) string
+	Set(k, v string, t time.Duration) error
+	SetNx(k, v string, t time.Duration) bool
+	Del(k string) error
+	LPop(k string) string
+	RPush(k string, v ...interface{}) error
+	LLen(k string) int64
+	Lock(lockKey string) bool
+	Unlock(lockKey string)
+}
+
+type memoryValue struct {
+	value  string
+	expire time.Time // 为零表示不过期
+}
+
+// Memory 进程内的KV,用于单进程部署和测试
+type Memory struct {
+	mu     sync.Mutex
+	values map[string]memoryValue
+	lists  map[string][]string
+}
+
+// NewMemory 创建进程内的KV
+func NewMemory() *Memory {
+	return &Memory{
+		values: make(map[string]memoryValue),
+		lists:  make(map[string][]string),
+	}
+}
+
+func (m *Memory) get(k string) (string, bool) {
+	v, found := m.values[k]
+	if found && !v.expire.IsZero() && time.Now().After(v.expire) {
+		delete(m.values, k)
+		return "", false
+	}
+	return v.value, found
+}
+
+func (m *Memory) set(k, v string, t time.Duration) {
+	val := memoryValue{value: v}
+	if t > 0 {
+		val.expire = time.Now().Add(t)
+	}
+	m.values[k] = val
+}
+
+// Get 获取key的值
+func (m *Memory) Get(k string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, _ := m.get(k)
+	return v
+}
+
+// Set 设置key的值,t为0时不过期
+func (m *Memory) Set(k, v string, t time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(k, v, t)
+	return nil
+}
+
+// SetNx key不存在时设置
+func (m *Memory) SetNx(k, v string, t time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.get(k); found {
+		return false
+	}
+	m.set(k, v, t)
+	return true
+}
+
+// Del 删除key
+func (m *Memory) Del(k string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, k)
+	delete(m.lists, k)
+	return nil
+}
+
+// LPop 从列表头部取出一个值
+func (m *Memory) LPop(k string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.lists[k]
+	if len(list) == 0 {
+		return ""
+	}
+	v := list[0]
+	if len(list) == 1 {
+		delete(m.lists, k)
+	} else {
+		m.lists[k] = list[1:]
+	}
+	return v
+}
+
+// RPush 在列表尾部添加值
+func (m *Memory) RPush(k string, v ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, val := range v {
+		m.lists[k] = append(m.lists[k], fmt.Sprint(val))
+	}
+	return nil
+}
+
+// LLen 列表长度
+func (m *Memory) LLen(k string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.lists[k]))
+}
+
+// Lock 加锁,和Redis一样锁在1秒后过期
+func (m *Memory) Lock(lockKey string) bool {
+	for {
+		if m.SetNx(lockKey, "lock", 1000*time.Millisecond) {
+			return true
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// Unlock 解锁
+func (m *Memory) Unlock(lockKey string) {
+	m.Del(lockKey)
+}
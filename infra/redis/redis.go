@@ -175,3 +175,96 @@ func (r *Redis) HGetAll(k string) map[string]string {
 	}
 	return r.single.HGetAll(k).Val()
 }
+
+// LPush redis从列表头部插入数据
+func (r *Redis) LPush(k string, v ...interface{}) error {
+	if r.clusterMode {
+		return r.cluster.LPush(k, v...).Err()
+	}
+	return r.single.LPush(k, v...).Err()
+}
+
+// LTrim redis裁剪列表,只保留指定区间内的数据
+func (r *Redis) LTrim(k string, start, stop int64) error {
+	if r.clusterMode {
+		return r.cluster.LTrim(k, start, stop).Err()
+	}
+	return r.single.LTrim(k, start, stop).Err()
+}
+
+// LRange redis读取列表指定区间内的数据
+func (r *Redis) LRange(k string, start, stop int64) []string {
+	if r.clusterMode {
+		return r.cluster.LRange(k, start, stop).Val()
+	}
+	return r.single.LRange(k, start, stop).Val()
+}
+
+// HLen redis读取hash散列表key的字段数量
+func (r *Redis) HLen(k string) int64 {
+	if r.clusterMode {
+		return r.cluster.HLen(k).Val()
+	}
+	return r.single.HLen(k).Val()
+}
+
+// Script Lua脚本,脚本内的操作原子执行
+type Script struct {
+	script *db.Script
+}
+
+// NewScript 创建Lua脚本
+func NewScript(src string) *Script {
+	return &Script{script: db.NewScript(src)}
+}
+
+// Run 执行Lua脚本,优先使用EVALSHA,脚本未加载时自动使用EVAL
+// 集群模式下keys必须落在同一个slot
+func (r *Redis) Run(s *Script, keys []string, args ...interface{}) (interface{}, error) {
+	var cmd *db.Cmd
+	if r.clusterMode {
+		cmd = s.script.Run(r.cluster, keys, args...)
+	} else {
+		cmd = s.script.Run(r.single, keys, args...)
+	}
+	val, err := cmd.Result()
+	if err == db.Nil {
+		return nil, nil
+	}
+	return val, err
+}
+
+// Scan redis以SCAN方式分批遍历符合给定模式的key,不会像KEYS一样阻塞redis
+// 集群模式下遍历所有master节点
+func (r *Redis) Scan(match string, count int64, fn func(keys []string) error) error {
+	scan := func(client *db.Client) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, match, count).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err := fn(keys); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+	if r.clusterMode {
+		return r.cluster.ForEachMaster(scan)
+	}
+	return scan(r.single)
+}
+
+// Close 关闭redis连接
+func (r *Redis) Close() error {
+	if r.clusterMode {
+		return r.cluster.Close()
+	}
+	return r.single.Close()
+}
@@ -0,0 +1,418 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	db "signal/infra/redis"
+	"signal/pkg/log"
+	"signal/pkg/proto"
+	"signal/pkg/timing"
+)
+
+// 按订阅sid计费,有视频的订阅按视频和分辨率档位计费,只有音频的订阅按音频计费
+// sfu通知订阅添加和移除,并定时上报节点上所有的订阅,issr据此补齐丢失的添加和关闭丢失移除的订阅
+// 会话持续时按固定周期输出计费记录,视频分辨率档位变化时按应用的聚合规则结束当前区间或按最高档位计费
+
+// Config 计费配置
+type Config struct {
+	Cadence int `mapstructure:"cadence"` // 秒,会话持续时按该周期输出计费记录
+	Timeout int `mapstructure:"timeout"` // 秒,sfu超过该时间没有心跳时关闭节点上的所有订阅
+}
+
+// 默认配置
+const (
+	DefaultCadence = 300
+	DefaultTimeout = 120
+)
+
+// 计费记录的结束原因
+const (
+	ReasonCadence = "cadence" // 固定周期
+	ReasonTier    = "tier"    // 视频分辨率档位变化
+	ReasonOrphan  = "orphan"  // 心跳中没有该订阅或sfu心跳超时
+)
+
+// 媒体类型
+const (
+	MediaAudio = "audio"
+	MediaVideo = "video"
+)
+
+const (
+	// closed 已关闭会话的占位值,防止迟到的添加和心跳重新打开会话
+	closed = "closed"
+	// sessionTTL 会话在每次心跳时续期
+	sessionTTL = 24 * time.Hour
+	// orphanGrace 刚打开的会话可能还没有出现在心跳中,超过该时间才按心跳关闭
+	orphanGrace = 10
+)
+
+// Record 一段订阅的计费记录,同一会话的记录首尾相接,uid为订阅者,mid为发布流
+type Record struct {
+	AppID      string `json:"appid"`
+	RID        string `json:"rid"`
+	UID        string `json:"uid"`
+	SID        string `json:"sid"`
+	MID        string `json:"mid"`
+	MediaType  string `json:"mediatype"`
+	Resolution string `json:"resolution,omitempty"` // 视频分辨率档位
+	Start      int64  `json:"start"`                // 秒
+	End        int64  `json:"end"`
+	Seconds    int64  `json:"seconds"`
+	Reason     string `json:"reason"`
+	Timestamp  int64  `json:"timestamp"` // 微秒
+	Type       int    `json:"type,omitempty"`
+}
+
+// Key 计费记录的幂等key,同一会话的同一区间相同,直播没有sid,按rid/uid/mid区分
+func (r Record) Key() string {
+	if r.SID == "" {
+		return fmt.Sprintf("%s/%s/%d", liveID(r.RID, r.UID, r.MID), r.MediaType, r.Start)
+	}
+	return fmt.Sprintf("%s/%s/%d", r.SID, r.MediaType, r.Start)
+}
+
+// session 计费中的订阅,start为当前区间的开始时间
+type session struct {
+	proto.Subscription
+	NID   string `json:"nid"`
+	Tier  string `json:"tier"`
+	Start int64  `json:"start"`
+}
+
+// nodeIndex sfu节点上计费中的订阅,sid -> 打开时间,time为最近一次心跳时间
+type nodeIndex struct {
+	Time int64            `json:"time"`
+	Subs map[string]int64 `json:"subs"`
+}
+
+// Engine 订阅计费,状态保存在kv中,多个issr可以同时处理
+type Engine struct {
+	cadence int64
+	timeout int64
+	kv      db.KV
+	emit    func(Record)
+	now     func() time.Time
+	tiers   atomic.Value // *timing.Tiers
+	unknown sync.Map     // 已经告警过的未知分辨率
+}
+
+// New 创建Engine,emit负责发送计费记录
+func New(c Config, kv db.KV, emit func(Record)) *Engine {
+	e := &Engine{cadence: int64(c.Cadence), timeout: int64(c.Timeout), kv: kv, emit: emit, now: time.Now}
+	if e.cadence <= 0 {
+		e.cadence = DefaultCadence
+	}
+	if e.timeout <= 0 {
+		e.timeout = DefaultTimeout
+	}
+	tiers, _ := timing.NewTiers(timing.TierConfig{})
+	e.SetTiers(tiers)
+	return e
+}
+
+// SetTiers 设置分辨率档位,运行中重新加载时新的档位从下一次心跳开始生效
+func (e *Engine) SetTiers(t *timing.Tiers) {
+	e.tiers.Store(t)
+}
+
+// tier 视频分辨率档位,未知分辨率每个应用只告警一次
+func (e *Engine) tier(minfo *proto.MediaInfo) string {
+	if minfo == nil || MediaType(minfo) != MediaVideo {
+		return ""
+	}
+	tier, err := e.tiers.Load().(*timing.Tiers).Tier(minfo.AppID, minfo.Resolution)
+	if err != nil {
+		if _, warned := e.unknown.LoadOrStore(minfo.AppID+"/"+minfo.Resolution, true); !warned {
+			log.Warnf("billing.tier appid=%s resolution=%s err=%v", minfo.AppID, minfo.Resolution, err)
+		}
+	}
+	return tier
+}
+
+// advance 心跳时按聚合规则处理档位变化,到达周期时输出计费记录,minfo和tier为当前的流信息和档位
+func (e *Engine) advance(s *session, minfo *proto.MediaInfo, tier string, now int64) {
+	tiers := e.tiers.Load().(*timing.Tiers)
+	appid := s.MInfo.AppID
+	split := tiers.Aggregate(appid) == timing.AggregateSplit
+	higher := tiers.Rank(appid, tier) > tiers.Rank(appid, s.Tier)
+	switch {
+	case tier != s.Tier && split:
+		e.report(s, now, ReasonTier)
+		s.MInfo, s.Tier, s.Start = minfo, tier, now
+	case now-s.Start >= e.cadence:
+		if higher {
+			s.Tier = tier
+		}
+		e.report(s, now, ReasonCadence)
+		s.MInfo, s.Tier, s.Start = minfo, tier, now
+	case higher:
+		// 按最高档位计费,区间不变
+		s.MInfo, s.Tier = minfo, tier
+	}
+}
+
+// MediaType 订阅的计费媒体类型,不是音视频订阅时返回空
+func MediaType(minfo *proto.MediaInfo) string {
+	if minfo == nil {
+		return ""
+	}
+	if minfo.Video || minfo.Screen || minfo.Canvas {
+		return MediaVideo
+	}
+	if minfo.Audio {
+		return MediaAudio
+	}
+	return ""
+}
+
+// Start 订阅添加,开始计费,会话已存在或已关闭时忽略
+func (e *Engine) Start(nid string, sub proto.Subscription) {
+	if sub.MInfo == nil || sub.MInfo.AppID == "" {
+		log.Warnf("billing.Start sid=%s appid not found", sub.SID)
+		return
+	}
+	now := e.now().Unix()
+	lockKey := proto.GetBillingLockKey(sub.SID)
+	e.kv.Lock(lockKey)
+	if e.kv.Get(proto.GetBillingSessionKey(sub.SID)) != "" {
+		e.kv.Unlock(lockKey)
+		return
+	}
+	e.save(&session{Subscription: sub, NID: nid, Tier: e.tier(sub.MInfo), Start: now})
+	e.kv.Unlock(lockKey)
+
+	e.updateNode(nid, func(index *nodeIndex) {
+		index.Subs[sub.SID] = now
+	})
+}
+
+// Stop 订阅移除,输出最后一段计费记录,会话不存在或已关闭时返回false
+func (e *Engine) Stop(sid, reason string) bool {
+	s := e.close(sid, reason)
+	if s == nil {
+		return false
+	}
+	e.updateNode(s.NID, func(index *nodeIndex) {
+		delete(index.Subs, sid)
+	})
+	return true
+}
+
+// Heartbeat 按sfu上报的订阅对账,补齐没有收到添加的订阅,关闭已经不存在的订阅
+func (e *Engine) Heartbeat(nid string, subs []proto.Subscription) {
+	now := e.now().Unix()
+	live := make(map[string]bool)
+	for _, sub := range subs {
+		if sub.SID == "" {
+			continue
+		}
+		live[sub.SID] = true
+		if !e.tick(nid, sub, now) {
+			e.Start(nid, sub)
+		}
+	}
+
+	orphans := make([]string, 0)
+	e.updateNode(nid, func(index *nodeIndex) {
+		index.Time = now
+		for sid, opened := range index.Subs {
+			if !live[sid] && now-opened >= orphanGrace {
+				orphans = append(orphans, sid)
+				delete(index.Subs, sid)
+			}
+		}
+	})
+	for _, sid := range orphans {
+		e.close(sid, ReasonOrphan)
+	}
+}
+
+// Sweep 关闭心跳超时的sfu上的所有订阅,返回关闭的订阅数
+func (e *Engine) Sweep() int {
+	now := e.now().Unix()
+	count := 0
+	for _, nid := range e.nodes() {
+		var sids []string
+		removed := false
+		e.updateNode(nid, func(index *nodeIndex) {
+			if now-index.Time < e.timeout {
+				return
+			}
+			for sid := range index.Subs {
+				sids = append(sids, sid)
+			}
+			index.Subs = nil
+			removed = true
+		})
+		if !removed {
+			continue
+		}
+		log.Warnf("billing.Sweep sfu %s heartbeat timeout, close %d subscriptions", nid, len(sids))
+		for _, sid := range sids {
+			if e.close(sid, ReasonOrphan) != nil {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// tick 心跳时检查会话,档位变化或到达周期时输出计费记录,会话不存在时返回false
+func (e *Engine) tick(nid string, sub proto.Subscription, now int64) bool {
+	lockKey := proto.GetBillingLockKey(sub.SID)
+	e.kv.Lock(lockKey)
+	defer e.kv.Unlock(lockKey)
+	value := e.kv.Get(proto.GetBillingSessionKey(sub.SID))
+	if value == closed {
+		return true
+	}
+	s := e.load(value)
+	if s == nil {
+		return false
+	}
+	minfo, tier := s.MInfo, s.Tier
+	if sub.MInfo != nil {
+		minfo, tier = sub.MInfo, e.tier(sub.MInfo)
+	}
+	e.advance(s, minfo, tier, now)
+	s.NID = nid
+	e.save(s)
+	return true
+}
+
+// close 关闭会话并输出最后一段计费记录,会话不存在或已关闭时返回nil
+func (e *Engine) close(sid, reason string) *session {
+	lockKey := proto.GetBillingLockKey(sid)
+	e.kv.Lock(lockKey)
+	defer e.kv.Unlock(lockKey)
+	key := proto.GetBillingSessionKey(sid)
+	s := e.load(e.kv.Get(key))
+	if s == nil {
+		return nil
+	}
+	e.report(s, e.now().Unix(), reason)
+	// 保留占位值,迟到的添加和心跳不会重新计费
+	if err := e.kv.Set(key, closed, time.Duration(2*e.timeout)*time.Second); err != nil {
+		log.Errorf("billing.close sid=%s set err=%v", sid, err)
+	}
+	return s
+}
+
+// report 输出会话当前区间的计费记录
+func (e *Engine) report(s *session, end int64, reason string) {
+	seconds := end - s.Start
+	media := MediaType(s.MInfo)
+	if seconds <= 0 || media == "" {
+		return
+	}
+	record := Record{
+		AppID:     s.MInfo.AppID,
+		RID:       s.RID,
+		UID:       s.UID,
+		SID:       s.SID,
+		MID:       s.MID,
+		MediaType: media,
+		Start:     s.Start,
+		End:       end,
+		Seconds:   seconds,
+		Reason:    reason,
+		Timestamp: e.now().UnixNano() / 1000,
+	}
+	if media == MediaVideo {
+		record.Resolution = s.Tier
+	}
+	e.emit(record)
+}
+
+func (e *Engine) load(value string) *session {
+	if value == "" || value == closed {
+		return nil
+	}
+	var s session
+	if err := json.Unmarshal([]byte(value), &s); err != nil {
+		log.Errorf("billing.load unmarshal err=%v", err)
+		return nil
+	}
+	return &s
+}
+
+func (e *Engine) save(s *session) {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		log.Errorf("billing.save sid=%s marshal err=%v", s.SID, err)
+		return
+	}
+	if err := e.kv.Set(proto.GetBillingSessionKey(s.SID), string(buf), sessionTTL); err != nil {
+		log.Errorf("billing.save sid=%s set err=%v", s.SID, err)
+	}
+}
+
+// updateNode 在锁内修改节点上的订阅,没有订阅时删除节点
+func (e *Engine) updateNode(nid string, fn func(index *nodeIndex)) {
+	lockKey := proto.GetBillingLockKey("node/" + nid)
+	e.kv.Lock(lockKey)
+	defer e.kv.Unlock(lockKey)
+
+	key := proto.GetBillingNodeKey(nid)
+	index := nodeIndex{Time: e.now().Unix(), Subs: make(map[string]int64)}
+	if value := e.kv.Get(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &index); err != nil {
+			log.Errorf("billing.updateNode nid=%s unmarshal err=%v", nid, err)
+		}
+		if index.Subs == nil {
+			index.Subs = make(map[string]int64)
+		}
+	}
+	fn(&index)
+
+	if len(index.Subs) == 0 {
+		e.kv.Del(key)
+		e.updateNodes(func(nodes map[string]bool) { delete(nodes, nid) })
+		return
+	}
+	buf, _ := json.Marshal(index)
+	if err := e.kv.Set(key, string(buf), sessionTTL); err != nil {
+		log.Errorf("billing.updateNode nid=%s set err=%v", nid, err)
+	}
+	e.updateNodes(func(nodes map[string]bool) { nodes[nid] = true })
+}
+
+// nodes 有计费订阅的sfu节点
+func (e *Engine) nodes() []string {
+	nids := make([]string, 0)
+	e.updateNodes(func(nodes map[string]bool) {
+		for nid := range nodes {
+			nids = append(nids, nid)
+		}
+	})
+	return nids
+}
+
+func (e *Engine) updateNodes(fn func(nodes map[string]bool)) {
+	lockKey := proto.GetBillingLockKey("nodes")
+	e.kv.Lock(lockKey)
+	defer e.kv.Unlock(lockKey)
+
+	key := proto.GetBillingNodesKey()
+	nodes := make(map[string]bool)
+	if value := e.kv.Get(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &nodes); err != nil {
+			log.Errorf("billing.updateNodes unmarshal err=%v", err)
+		}
+	}
+	count := len(nodes)
+	fn(nodes)
+	// 每次只增加或删除一个节点,数量不变时没有修改
+	if len(nodes) == count {
+		return
+	}
+	buf, _ := json.Marshal(nodes)
+	if err := e.kv.Set(key, string(buf), 0); err != nil {
+		log.Errorf("billing.updateNodes set err=%v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	db "signal/infra/redis"
+	"signal/pkg/proto"
+	"signal/pkg/timing"
+)
+
+// clock 测试用的时钟
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) add(seconds int) {
+	c.t = c.t.Add(time.Duration(seconds) * time.Second)
+}
+
+func newEngine(records *[]Record) (*Engine, *clock) {
+	c := &clock{t: time.Unix(1000, 0)}
+	e := New(Config{Cadence: 60, Timeout: 90}, db.NewMemory(), func(r Record) {
+		*records = append(*records, r)
+	})
+	e.now = c.now
+	return e, c
+}
+
+func subscription(sid, resolution string) proto.Subscription {
+	return proto.Subscription{
+		SID: sid, RID: "r", UID: "bob", MID: "alice#1",
+		MInfo: &proto.MediaInfo{Audio: true, Video: true, Resolution: resolution, AppID: "a"},
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	e.Start("sfu1", subscription("bob#1", "720p"))
+	e.Start("sfu1", subscription("bob#1", "720p"))
+	c.add(30)
+	if !e.Stop("bob#1", "unsubscribe") {
+		t.Fatal("stop failed")
+	}
+	if e.Stop("bob#1", "unsubscribe") {
+		t.Error("stopped twice")
+	}
+	if len(records) != 1 {
+		t.Fatalf("records = %+v", records)
+	}
+	if r := records[0]; r.MediaType != MediaVideo || r.Seconds != 30 || r.AppID != "a" || r.Resolution != "HD" || r.Reason != "unsubscribe" {
+		t.Errorf("video = %+v", r)
+	}
+
+	// 关闭后迟到的添加不重新计费
+	e.Start("sfu1", subscription("bob#1", "720p"))
+	c.add(30)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "720p")})
+	if e.Stop("bob#1", "unsubscribe") || len(records) != 1 {
+		t.Errorf("closed session reopened: %+v", records)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	// 没有收到添加时按心跳打开会话
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "720p")})
+	c.add(60)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "720p")})
+	if len(records) != 1 || records[0].Reason != ReasonCadence || records[0].Seconds != 60 {
+		t.Fatalf("cadence records = %+v", records)
+	}
+
+	c.add(20)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "1080p")})
+	if len(records) != 2 || records[1].Reason != ReasonTier || records[1].Resolution != "HD" || records[1].Seconds != 20 {
+		t.Fatalf("tier records = %+v", records)
+	}
+
+	// 心跳中没有该订阅时关闭
+	c.add(10)
+	e.Heartbeat("sfu1", nil)
+	if len(records) != 3 || records[2].Reason != ReasonOrphan || records[2].Resolution != "FHD" || records[2].Seconds != 10 {
+		t.Fatalf("orphan records = %+v", records)
+	}
+	if e.Stop("bob#1", "unsubscribe") {
+		t.Error("orphan stopped twice")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	e.Start("sfu1", subscription("bob#1", "360"))
+	e.Start("sfu2", subscription("bob#2", "360"))
+	c.add(60)
+	e.Heartbeat("sfu2", []proto.Subscription{subscription("bob#2", "360")})
+	c.add(40)
+	if n := e.Sweep(); n != 1 {
+		t.Fatalf("sweep closed %d", n)
+	}
+	if e.Sweep() != 0 {
+		t.Error("sweep closed twice")
+	}
+	if !e.Stop("bob#2", "unsubscribe") || e.Stop("bob#1", "unsubscribe") {
+		t.Error("wrong session closed")
+	}
+}
+
+func TestAudio(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	audio := subscription("bob#1", "")
+	audio.MInfo.Video = false
+	e.Start("sfu1", audio)
+	c.add(60)
+	e.Heartbeat("sfu1", []proto.Subscription{audio})
+	c.add(15)
+	e.Stop("bob#1", "unsubscribe")
+	if len(records) != 2 {
+		t.Fatalf("records = %+v", records)
+	}
+	for i, seconds := range []int64{60, 15} {
+		r := records[i]
+		if r.MediaType != MediaAudio || r.Resolution != "" || r.Seconds != seconds || r.UID != "bob" || r.AppID != "a" {
+			t.Errorf("audio record %d = %+v", i, r)
+		}
+	}
+}
+
+func live(mid, resolution string) proto.LiveStream {
+	return proto.LiveStream{
+		AppID: "a", RID: "r", UID: "alice", MID: mid, NID: "mcu1",
+		MInfo: &proto.MediaInfo{Audio: true, Video: true, Resolution: resolution, Record: 1, Index: 1},
+	}
+}
+
+func TestLive(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	l := &Live{e: e}
+	guest := live("mcu1#2", "720p")
+	guest.MInfo.Index = 0
+	l.Start(guest)
+	l.Start(live("mcu1#1", "720p"))
+	// 没有收到添加时按上报打开会话
+	l.Heartbeat([]proto.LiveStream{live("mcu1#1", "720p"), live("mcu1#3", "360p")})
+	c.add(60)
+	l.Heartbeat([]proto.LiveStream{live("mcu1#1", "1080p")})
+	if len(records) != 1 || records[0].Reason != ReasonTier || records[0].Resolution != "HD" || records[0].Seconds != 60 {
+		t.Fatalf("tier records = %+v", records)
+	}
+	if records[0].Key() != "r/alice/mcu1#1/video/1000" {
+		t.Errorf("key = %s", records[0].Key())
+	}
+
+	// 超时没有出现在上报中的直播流按异常关闭
+	c.add(90)
+	if n := l.Sweep(); n != 2 {
+		t.Fatalf("sweep closed %d", n)
+	}
+	if len(records) != 3 || records[1].Resolution != "FHD" || records[1].Seconds != 90 || records[2].MID != "mcu1#3" || records[2].Seconds != 150 {
+		t.Fatalf("orphan records = %+v", records)
+	}
+	if l.Stop("r", "alice", "mcu1#1", "stop") || l.Stop("r", "alice", "mcu1#2", "stop") {
+		t.Error("stopped twice")
+	}
+}
+
+func TestAggregateMax(t *testing.T) {
+	var records []Record
+	e, c := newEngine(&records)
+	tiers, err := timing.NewTiers(timing.TierConfig{Apps: []timing.AppTierConfig{{AppID: "a", Aggregate: timing.AggregateMax}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetTiers(tiers)
+	e.Start("sfu1", subscription("bob#1", "720p"))
+	// 区间内按最高档位计费
+	c.add(20)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "1080p")})
+	c.add(20)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "360")})
+	if len(records) != 0 {
+		t.Fatalf("tier change records = %+v", records)
+	}
+	c.add(20)
+	e.Heartbeat("sfu1", []proto.Subscription{subscription("bob#1", "360")})
+	c.add(10)
+	e.Stop("bob#1", "unsubscribe")
+	if len(records) != 2 || records[0].Resolution != "FHD" || records[0].Seconds != 60 || records[1].Resolution != "SD" || records[1].Seconds != 10 {
+		t.Errorf("max records = %+v", records)
+	}
+}
@@ -0,0 +1,214 @@
+package billing
+
+import (
+	"encoding/json"
+	"time"
+
+	db "signal/infra/redis"
+	"signal/pkg/log"
+	"signal/pkg/proto"
+	"signal/pkg/timing"
+)
+
+// 直播计时,按rid/uid/mid保存会话,只对启用录制的主播直播流计时,分辨率档位取直播流的minfo
+// islb通知直播添加和移除,并定时上报负责的房间中所有的直播流
+// biz或mcu异常时直播流可能没有移除,超过timeout没有出现在上报中的直播流按异常关闭
+
+// Live 直播计时,状态保存在kv中,biz重启不影响计时
+type Live struct {
+	e *Engine
+}
+
+// NewLive 创建Live,emit负责发送计时记录
+func NewLive(c Config, kv db.KV, emit func(Record)) *Live {
+	return &Live{e: New(c, kv, emit)}
+}
+
+// liveID 直播会话id
+func liveID(rid, uid, mid string) string {
+	return rid + "/" + uid + "/" + mid
+}
+
+// Metered 直播流是否计时,启用录制的主播直播流
+func Metered(minfo *proto.MediaInfo) bool {
+	return minfo != nil && minfo.Record == 1 && minfo.Index == 1
+}
+
+// Start 直播添加,开始计时,会话已存在或已关闭时忽略
+func (l *Live) Start(live proto.LiveStream) {
+	if !Metered(live.MInfo) {
+		return
+	}
+	if live.AppID == "" {
+		log.Warnf("billing.Live.Start rid=%s mid=%s appid not found", live.RID, live.MID)
+		return
+	}
+	id := liveID(live.RID, live.UID, live.MID)
+	now := l.e.now().Unix()
+	lockKey := proto.GetBillingLockKey("live/" + id)
+	l.e.kv.Lock(lockKey)
+	if l.e.kv.Get(proto.GetLiveSessionKey(id)) != "" {
+		l.e.kv.Unlock(lockKey)
+		return
+	}
+	l.save(id, l.session(live, now))
+	l.e.kv.Unlock(lockKey)
+
+	l.updateIndex(func(index map[string]int64) {
+		index[id] = now
+	})
+}
+
+// Stop 直播移除,输出最后一段计时记录,会话不存在或已关闭时返回false
+func (l *Live) Stop(rid, uid, mid, reason string) bool {
+	id := liveID(rid, uid, mid)
+	if l.close(id, reason) == nil {
+		return false
+	}
+	l.updateIndex(func(index map[string]int64) {
+		delete(index, id)
+	})
+	return true
+}
+
+// Heartbeat 按islb上报的直播流对账,补齐没有收到添加的直播流,到达周期或档位变化时输出计时记录
+func (l *Live) Heartbeat(lives []proto.LiveStream) {
+	now := l.e.now().Unix()
+	seen := make(map[string]bool)
+	for _, live := range lives {
+		if !Metered(live.MInfo) {
+			continue
+		}
+		id := liveID(live.RID, live.UID, live.MID)
+		if l.tick(id, live, now) {
+			seen[id] = true
+		} else {
+			l.Start(live)
+		}
+	}
+	if len(seen) == 0 {
+		return
+	}
+	l.updateIndex(func(index map[string]int64) {
+		for id := range seen {
+			if _, ok := index[id]; ok {
+				index[id] = now
+			}
+		}
+	})
+}
+
+// Sweep 关闭超过timeout没有出现在上报中的直播流,返回关闭的直播流数
+func (l *Live) Sweep() int {
+	now := l.e.now().Unix()
+	ids := make([]string, 0)
+	l.updateIndex(func(index map[string]int64) {
+		for id, seen := range index {
+			if now-seen >= l.e.timeout {
+				ids = append(ids, id)
+				delete(index, id)
+			}
+		}
+	})
+	count := 0
+	for _, id := range ids {
+		if l.close(id, ReasonOrphan) != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// SetTiers 设置分辨率档位,运行中重新加载时新的档位从下一次上报开始生效
+func (l *Live) SetTiers(t *timing.Tiers) {
+	l.e.SetTiers(t)
+}
+
+// session 直播流对应的会话,appid写入minfo
+func (l *Live) session(live proto.LiveStream, start int64) *session {
+	minfo := *live.MInfo
+	minfo.AppID = live.AppID
+	return &session{
+		Subscription: proto.Subscription{RID: live.RID, UID: live.UID, MID: live.MID, MInfo: &minfo},
+		NID:          live.NID,
+		Tier:         l.e.tier(&minfo),
+		Start:        start,
+	}
+}
+
+// tick 上报时检查会话,档位变化或到达周期时输出计时记录,会话不存在时返回false
+func (l *Live) tick(id string, live proto.LiveStream, now int64) bool {
+	lockKey := proto.GetBillingLockKey("live/" + id)
+	l.e.kv.Lock(lockKey)
+	defer l.e.kv.Unlock(lockKey)
+	value := l.e.kv.Get(proto.GetLiveSessionKey(id))
+	if value == closed {
+		return true
+	}
+	s := l.e.load(value)
+	if s == nil {
+		return false
+	}
+	if live.AppID == "" {
+		live.AppID = s.MInfo.AppID
+	}
+	current := l.session(live, now)
+	l.e.advance(s, current.MInfo, current.Tier, now)
+	s.NID = live.NID
+	l.save(id, s)
+	return true
+}
+
+// close 关闭会话并输出最后一段计时记录,会话不存在或已关闭时返回nil
+func (l *Live) close(id, reason string) *session {
+	lockKey := proto.GetBillingLockKey("live/" + id)
+	l.e.kv.Lock(lockKey)
+	defer l.e.kv.Unlock(lockKey)
+	key := proto.GetLiveSessionKey(id)
+	s := l.e.load(l.e.kv.Get(key))
+	if s == nil {
+		return nil
+	}
+	l.e.report(s, l.e.now().Unix(), reason)
+	// 保留占位值,迟到的添加和上报不会重新计时
+	if err := l.e.kv.Set(key, closed, time.Duration(2*l.e.timeout)*time.Second); err != nil {
+		log.Errorf("billing.Live.close id=%s set err=%v", id, err)
+	}
+	return s
+}
+
+func (l *Live) save(id string, s *session) {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		log.Errorf("billing.Live.save id=%s marshal err=%v", id, err)
+		return
+	}
+	if err := l.e.kv.Set(proto.GetLiveSessionKey(id), string(buf), sessionTTL); err != nil {
+		log.Errorf("billing.Live.save id=%s set err=%v", id, err)
+	}
+}
+
+// updateIndex 在锁内修改计时中的直播流,id -> 最近一次出现在上报中的时间
+func (l *Live) updateIndex(fn func(index map[string]int64)) {
+	lockKey := proto.GetBillingLockKey("lives")
+	l.e.kv.Lock(lockKey)
+	defer l.e.kv.Unlock(lockKey)
+
+	key := proto.GetLiveSessionsKey()
+	index := make(map[string]int64)
+	if value := l.e.kv.Get(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &index); err != nil {
+			log.Errorf("billing.Live.updateIndex unmarshal err=%v", err)
+		}
+	}
+	fn(index)
+
+	if len(index) == 0 {
+		l.e.kv.Del(key)
+		return
+	}
+	buf, _ := json.Marshal(index)
+	if err := l.e.kv.Set(key, string(buf), 0); err != nil {
+		log.Errorf("billing.Live.updateIndex set err=%v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package bus
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常放行请求
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 连续失败后熔断,请求直接失败
+	BreakerOpen
+	// BreakerHalfOpen 熔断冷却结束,放行一个探测请求
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// 熔断器默认参数
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 10000
+)
+
+// BreakerConfig 熔断参数
+type BreakerConfig struct {
+	// Threshold 连续失败多少次后熔断
+	Threshold int `mapstructure:"threshold"`
+	// Cooldown 熔断后多久放行探测请求,毫秒
+	Cooldown int `mapstructure:"cooldown"`
+}
+
+// Breaker 节点熔断器,连续失败达到阈值后熔断,冷却结束后放行一个探测请求
+// 探测成功恢复正常,失败重新熔断
+type Breaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probeAt   time.Time
+	onChange  func(state BreakerState)
+	now       func() time.Time
+}
+
+// NewBreaker 创建熔断器,参数为0时使用默认值,onChange在状态变化时回调,可以为空
+func NewBreaker(config BreakerConfig, onChange func(state BreakerState)) *Breaker {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBreakerThreshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultBreakerCooldown
+	}
+	return &Breaker{
+		threshold: config.Threshold,
+		cooldown:  time.Duration(config.Cooldown) * time.Millisecond,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+// Allow 是否放行请求,半开状态同一时间只放行一个探测请求
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+	case BreakerHalfOpen:
+		// 探测请求没有结果(如被调用方取消)时,冷却后再放行一个
+		if now.Sub(b.probeAt) < b.cooldown {
+			return false
+		}
+	default:
+		return true
+	}
+	b.probeAt = now
+	return true
+}
+
+// Available 节点是否可以分配新的请求,熔断且未冷却时返回false
+func (b *Breaker) Available() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.state != BreakerOpen || b.now().Sub(b.openedAt) >= b.cooldown
+}
+
+// State 当前状态
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// Success 记录一次成功
+func (b *Breaker) Success() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures = 0
+	b.setState(BreakerClosed)
+}
+
+// Failure 记录一次失败,半开状态失败立即重新熔断
+func (b *Breaker) Failure() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
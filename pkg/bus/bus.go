@@ -0,0 +1,141 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	nprotoo "github.com/gearghost/nats-protoo"
+)
+
+// DefaultRequestTimeout 默认请求超时时间
+const DefaultRequestTimeout = nprotoo.DefaultRequestTimeout
+
+// 总线自身返回的错误码,和nats-protoo一致
+const (
+	// CodeTimeout 请求超时
+	CodeTimeout = 480
+	// CodeCanceled 请求被调用方取消
+	CodeCanceled = 499
+	// CodeNoListener channel上没有处理函数
+	CodeNoListener = 500
+)
+
+// 消息格式沿用nats-protoo,节点处理函数不需要区分具体实现
+type (
+	// Error 请求失败的错误码和原因
+	Error = nprotoo.Error
+	// AcceptFunc 请求成功回调
+	AcceptFunc = nprotoo.AcceptFunc
+	// RejectFunc 请求失败回调
+	RejectFunc = nprotoo.RejectFunc
+	// RequestFunc 请求处理函数
+	RequestFunc = nprotoo.RequestFunc
+	// BroadcastFunc 广播处理函数
+	BroadcastFunc = nprotoo.BroadCastFunc
+)
+
+// Bus 节点之间的消息总线
+type Bus interface {
+	// OnRequest 处理channel上的请求
+	OnRequest(channel string, listener RequestFunc)
+	// NewRequestor 创建向channel发送请求的对象
+	NewRequestor(channel string) Requestor
+	// NewBroadcaster 创建向channel发送广播的对象
+	NewBroadcaster(channel string) Broadcaster
+	// OnBroadcast 订阅channel上的广播,每个订阅者都会收到
+	OnBroadcast(channel string, listener BroadcastFunc)
+	// OnBroadcastWithGroup 按组订阅channel上的广播,同一组只有一个订阅者收到
+	OnBroadcastWithGroup(channel, group string, listener BroadcastFunc)
+	// Close 关闭总线
+	Close()
+}
+
+// Requestor 请求对象
+type Requestor interface {
+	// SetRequestTimeout 设置请求超时时间
+	SetRequestTimeout(d time.Duration)
+	// Request 发送请求,结果通过回调返回
+	Request(method string, data map[string]interface{}, accept AcceptFunc, reject RejectFunc)
+	// SyncRequest 发送请求并等待结果
+	SyncRequest(method string, data map[string]interface{}) (map[string]interface{}, *Error)
+	// AsyncRequest 发送请求,返回Future
+	AsyncRequest(method string, data map[string]interface{}) *Future
+	// Call 发送请求并等待结果,ctx取消或超时时立即返回,晚到的结果丢弃
+	Call(ctx context.Context, method string, data map[string]interface{}) (map[string]interface{}, *Error)
+}
+
+// Broadcaster 广播对象
+type Broadcaster interface {
+	// Say 发送广播
+	Say(method string, data map[string]interface{})
+}
+
+// Future 异步请求结果
+type Future struct {
+	c      chan struct{}
+	result map[string]interface{}
+	err    *Error
+}
+
+// NewFuture 创建Future
+func NewFuture() *Future {
+	return &Future{c: make(chan struct{})}
+}
+
+// Await 等待结果
+func (f *Future) Await() (map[string]interface{}, *Error) {
+	<-f.c
+	return f.result, f.err
+}
+
+// AwaitContext 等待结果,ctx超时返回CodeTimeout,取消返回CodeCanceled
+func (f *Future) AwaitContext(ctx context.Context) (map[string]interface{}, *Error) {
+	select {
+	case <-f.c:
+		return f.result, f.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &Error{Code: CodeTimeout, Reason: "Request timeout: " + ctx.Err().Error()}
+		}
+		return nil, &Error{Code: CodeCanceled, Reason: "Request canceled: " + ctx.Err().Error()}
+	}
+}
+
+// Then 结果返回后回调
+func (f *Future) Then(resolve func(result map[string]interface{}), reject func(err *Error)) {
+	go func() {
+		<-f.c
+		if f.err != nil {
+			reject(f.err)
+		} else {
+			resolve(f.result)
+		}
+	}()
+}
+
+func (f *Future) resolve(result map[string]interface{}) {
+	f.result = result
+	close(f.c)
+}
+
+func (f *Future) reject(err *Error) {
+	f.err = err
+	close(f.c)
+}
+
+// asyncRequest 用Request实现AsyncRequest
+func asyncRequest(r Requestor, method string, data map[string]interface{}) *Future {
+	future := NewFuture()
+	r.Request(method, data, future.resolve, func(code int, reason string) {
+		future.reject(&Error{Code: code, Reason: reason})
+	})
+	return future
+}
+
+// call 用AsyncRequest实现Call
+func call(ctx context.Context, r Requestor, method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	if err := ctx.Err(); err != nil {
+		return NewFuture().AwaitContext(ctx)
+	}
+	return r.AsyncRequest(method, data).AwaitContext(ctx)
+}
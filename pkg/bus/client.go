@@ -0,0 +1,167 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CodeUnavailable 节点已熔断,请求没有发出
+const CodeUnavailable = 503
+
+// DefaultCallTimeout 没有配置超时的方法每次请求的超时时间,毫秒
+const DefaultCallTimeout = 5000
+
+// Policy 方法的调用策略
+type Policy struct {
+	// Timeout 每次请求的超时时间,毫秒
+	Timeout int `mapstructure:"timeout"`
+	// Retries 超时或节点不可达时的重试次数,只能给幂等的方法配置
+	Retries int `mapstructure:"retries"`
+	// Backoff 第一次重试前的等待时间,之后每次翻倍,毫秒
+	Backoff int `mapstructure:"backoff"`
+}
+
+// ClientConfig 调用策略和熔断参数,Policies中没有的方法使用Default
+type ClientConfig struct {
+	Default  Policy
+	Policies map[string]Policy
+	Breaker  BreakerConfig
+	// OnStateChange 节点熔断状态变化时回调,可以为空
+	OnStateChange func(nid string, state BreakerState)
+}
+
+// IsUnavailable 错误是否表示节点不可达
+func IsUnavailable(err *Error) bool {
+	return err != nil && (err.Code == CodeNoListener || err.Code == CodeUnavailable)
+}
+
+// IsTimeout 错误是否表示请求超时或被取消
+func IsTimeout(err *Error) bool {
+	return err != nil && (err.Code == CodeTimeout || err.Code == CodeCanceled)
+}
+
+// Client 发往一个节点的请求对象,按方法设置超时,幂等方法失败时重试
+// 节点连续超时或不可达时熔断,熔断期间请求直接返回CodeUnavailable
+type Client struct {
+	sync.Mutex
+	nid       string
+	requestor Requestor
+	config    *ClientConfig
+	timeout   int
+	breaker   *Breaker
+}
+
+// NewClient 创建节点的请求对象,config为空时使用默认策略
+func NewClient(nid string, requestor Requestor, config *ClientConfig) *Client {
+	if config == nil {
+		config = &ClientConfig{}
+	}
+	c := &Client{
+		nid:       nid,
+		requestor: requestor,
+		config:    config,
+		timeout:   config.Default.Timeout,
+	}
+	c.breaker = NewBreaker(config.Breaker, func(state BreakerState) {
+		if config.OnStateChange != nil {
+			config.OnStateChange(nid, state)
+		}
+	})
+	return c
+}
+
+// Nid 节点id
+func (c *Client) Nid() string {
+	return c.nid
+}
+
+// Available 节点是否可以分配新的请求
+func (c *Client) Available() bool {
+	return c.breaker.Available()
+}
+
+// State 节点的熔断状态
+func (c *Client) State() BreakerState {
+	return c.breaker.State()
+}
+
+// policy 查询方法的调用策略
+func (c *Client) policy(method string) Policy {
+	policy, found := c.config.Policies[method]
+	if !found {
+		policy = c.config.Default
+		c.Lock()
+		policy.Timeout = c.timeout
+		c.Unlock()
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = DefaultCallTimeout
+	}
+	return policy
+}
+
+// SetRequestTimeout 设置没有单独配置的方法的超时时间
+func (c *Client) SetRequestTimeout(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.timeout = int(d / time.Millisecond)
+}
+
+// Request 发送请求,结果通过回调返回
+func (c *Client) Request(method string, data map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	go func() {
+		result, err := c.Call(context.Background(), method, data)
+		if err != nil {
+			reject(err.Code, err.Reason)
+		} else {
+			accept(result)
+		}
+	}()
+}
+
+// SyncRequest 发送请求并等待结果,超时时间由方法的调用策略决定
+func (c *Client) SyncRequest(method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	return c.Call(context.Background(), method, data)
+}
+
+// AsyncRequest 发送请求,返回Future
+func (c *Client) AsyncRequest(method string, data map[string]interface{}) *Future {
+	return asyncRequest(c, method, data)
+}
+
+// Call 发送请求并等待结果,每次请求的超时时间取方法超时和ctx中较早的一个
+// 只有超时和节点不可达计入熔断,业务错误直接返回
+func (c *Client) Call(ctx context.Context, method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	policy := c.policy(method)
+	backoff := time.Duration(policy.Backoff) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.Allow() {
+			return nil, &Error{Code: CodeUnavailable, Reason: fmt.Sprintf("Circuit open, node[%s] method[%s]", c.nid, method)}
+		}
+		result, err := c.call(ctx, policy, method, data)
+		if err == nil || !(IsUnavailable(err) || err.Code == CodeTimeout) {
+			if err == nil || err.Code != CodeCanceled {
+				c.breaker.Success()
+			}
+			return result, err
+		}
+		c.breaker.Failure()
+		if attempt >= policy.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) call(ctx context.Context, policy Policy, method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(policy.Timeout)*time.Millisecond)
+	defer cancel()
+	return c.requestor.Call(ctx, method, data)
+}
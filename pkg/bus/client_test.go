@@ -0,0 +1,124 @@
+package bus
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var states []BreakerState
+	b := NewBreaker(BreakerConfig{Threshold: 2, Cooldown: 1000}, func(state BreakerState) {
+		states = append(states, state)
+	})
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	b.Success()
+	b.Failure()
+	if !b.Allow() || b.State() != BreakerClosed {
+		t.Fatal("breaker should stay closed after one failure")
+	}
+	b.Failure()
+	if b.Allow() || b.Available() {
+		t.Fatal("breaker should open after two failures")
+	}
+
+	now = now.Add(time.Second)
+	if !b.Available() || !b.Allow() {
+		t.Fatal("breaker should let a probe through after cooldown")
+	}
+	if b.Allow() {
+		t.Error("breaker should let only one probe through")
+	}
+	b.Failure()
+	if b.State() != BreakerOpen || b.Available() {
+		t.Fatal("failed probe should open breaker again")
+	}
+
+	now = now.Add(time.Second)
+	b.Allow()
+	b.Success()
+	if b.State() != BreakerClosed || !b.Allow() {
+		t.Fatal("successful probe should close breaker")
+	}
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v", states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("states = %v", states)
+			break
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	network := NewNetwork()
+	server := network.Connect()
+	var count int32
+	server.OnRequest("rpc", func(request map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+		// 第一次请求不回复
+		if atomic.AddInt32(&count, 1) == 1 {
+			return
+		}
+		if request["method"] == "fail" {
+			reject(1001, "bad request")
+			return
+		}
+		accept(map[string]interface{}{"ok": true})
+	})
+
+	c := NewClient("node1", network.Connect().NewRequestor("rpc"), &ClientConfig{
+		Default:  Policy{Timeout: 50},
+		Policies: map[string]Policy{"get": {Timeout: 50, Retries: 1, Backoff: 10}},
+	})
+	if _, err := c.SyncRequest("set", nil); err == nil || err.Code != CodeTimeout {
+		t.Fatalf("set err = %v", err)
+	}
+	if _, err := c.SyncRequest("fail", nil); err == nil || err.Code != 1001 {
+		t.Fatalf("fail err = %v", err)
+	}
+
+	atomic.StoreInt32(&count, 0)
+	result, err := c.SyncRequest("get", nil)
+	if err != nil || result["ok"] != true {
+		t.Fatalf("get = %v, %v", result, err)
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Errorf("get requests = %d", n)
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	network := NewNetwork()
+	var changes int32
+	c := NewClient("node1", network.Connect().NewRequestor("rpc"), &ClientConfig{
+		Default: Policy{Timeout: 50},
+		Breaker: BreakerConfig{Threshold: 2, Cooldown: 60000},
+		OnStateChange: func(nid string, state BreakerState) {
+			if nid == "node1" && state == BreakerOpen {
+				atomic.AddInt32(&changes, 1)
+			}
+		},
+	})
+	// 没有节点订阅,请求超时
+	for i := 0; i < 2; i++ {
+		if _, err := c.SyncRequest("get", nil); err == nil || err.Code != CodeTimeout {
+			t.Fatalf("err = %v", err)
+		}
+	}
+	if c.Available() || atomic.LoadInt32(&changes) != 1 {
+		t.Fatal("client should be unavailable")
+	}
+	start := time.Now()
+	if _, err := c.Call(context.Background(), "get", nil); err == nil || err.Code != CodeUnavailable {
+		t.Fatalf("err = %v", err)
+	}
+	if time.Since(start) > 10*time.Millisecond {
+		t.Error("open breaker should fail fast")
+	}
+}
@@ -0,0 +1,328 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 进程内消息总线,用于单进程部署和测试
+// 消息和nats一样经过json编解码,每个订阅按顺序处理消息
+
+// Network 进程内消息网络,同一个Network上的总线可以互相通信
+type Network struct {
+	sync.RWMutex
+	subs map[string][]*memorySub
+}
+
+// NewNetwork 创建进程内消息网络
+func NewNetwork() *Network {
+	return &Network{subs: make(map[string][]*memorySub)}
+}
+
+// Connect 创建连接到网络的总线,每个节点使用自己的总线
+func (n *Network) Connect() *MemoryBus {
+	return &MemoryBus{
+		network:            n,
+		requestListener:    make(map[string]RequestFunc),
+		broadcastListeners: make(map[string][]BroadcastFunc),
+		subs:               make(map[string]*memorySub),
+	}
+}
+
+func (n *Network) subscribe(sub *memorySub) {
+	n.Lock()
+	defer n.Unlock()
+	n.subs[sub.channel] = append(n.subs[sub.channel], sub)
+}
+
+func (n *Network) unsubscribe(sub *memorySub) {
+	n.Lock()
+	defer n.Unlock()
+	subs := n.subs[sub.channel]
+	for i, s := range subs {
+		if s == sub {
+			n.subs[sub.channel] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(n.subs[sub.channel]) == 0 {
+		delete(n.subs, sub.channel)
+	}
+}
+
+// publish 发送消息,没有分组的订阅都会收到,同一分组随机选一个
+func (n *Network) publish(channel string, msg *memoryMsg) {
+	n.RLock()
+	groups := make(map[string][]*memorySub)
+	targets := make([]*memorySub, 0)
+	for _, sub := range n.subs[channel] {
+		if sub.group == "" {
+			targets = append(targets, sub)
+		} else {
+			groups[sub.group] = append(groups[sub.group], sub)
+		}
+	}
+	n.RUnlock()
+	for _, subs := range groups {
+		targets = append(targets, subs[rand.Intn(len(subs))])
+	}
+	for _, sub := range targets {
+		sub.push(msg)
+	}
+}
+
+type memoryMsg struct {
+	payload []byte
+	reply   func(payload []byte)
+}
+
+// memorySub 一个channel的订阅,消息按顺序交给总线处理
+type memorySub struct {
+	sync.Mutex
+	channel string
+	group   string
+	handle  func(msg *memoryMsg)
+	queue   []*memoryMsg
+	signal  chan struct{}
+	closed  bool
+}
+
+func newMemorySub(channel, group string, handle func(msg *memoryMsg)) *memorySub {
+	sub := &memorySub{
+		channel: channel,
+		group:   group,
+		handle:  handle,
+		signal:  make(chan struct{}, 1),
+	}
+	go sub.run()
+	return sub
+}
+
+func (s *memorySub) push(msg *memoryMsg) {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return
+	}
+	s.queue = append(s.queue, msg)
+	s.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySub) run() {
+	for range s.signal {
+		for {
+			s.Lock()
+			if s.closed || len(s.queue) == 0 {
+				s.Unlock()
+				break
+			}
+			msg := s.queue[0]
+			s.queue = s.queue[1:]
+			s.Unlock()
+			s.handle(msg)
+		}
+	}
+}
+
+func (s *memorySub) close() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.signal)
+	}
+}
+
+// MemoryBus 进程内消息总线
+type MemoryBus struct {
+	sync.Mutex
+	network            *Network
+	requestListener    map[string]RequestFunc
+	broadcastListeners map[string][]BroadcastFunc
+	subs               map[string]*memorySub
+	closed             bool
+}
+
+// subscribe channel第一次订阅时加入网络
+func (b *MemoryBus) subscribe(channel, group string) {
+	if _, found := b.subs[channel]; found || b.closed {
+		return
+	}
+	sub := newMemorySub(channel, group, func(msg *memoryMsg) {
+		b.handleMessage(channel, msg)
+	})
+	b.subs[channel] = sub
+	b.network.subscribe(sub)
+}
+
+func (b *MemoryBus) handleMessage(channel string, msg *memoryMsg) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(msg.payload, &data); err != nil {
+		return
+	}
+	b.Lock()
+	listener := b.requestListener[channel]
+	listeners := b.broadcastListeners[channel]
+	b.Unlock()
+
+	if data["request"] != nil {
+		var once sync.Once
+		reply := func(v interface{}) {
+			once.Do(func() {
+				payload, err := json.Marshal(v)
+				if err == nil && msg.reply != nil {
+					msg.reply(payload)
+				}
+			})
+		}
+		accept := func(result map[string]interface{}) {
+			reply(map[string]interface{}{"response": true, "ok": true, "data": result})
+		}
+		reject := func(code int, reason string) {
+			reply(map[string]interface{}{"response": true, "ok": false, "errorCode": code, "errorReason": reason})
+		}
+		if listener == nil {
+			reject(CodeNoListener, fmt.Sprintf("Not found listener for %s!", channel))
+			return
+		}
+		listener(data, accept, reject)
+	} else if data["notification"] != nil {
+		for _, l := range listeners {
+			l(data, channel)
+		}
+	}
+}
+
+// OnRequest 处理channel上的请求
+func (b *MemoryBus) OnRequest(channel string, listener RequestFunc) {
+	b.Lock()
+	defer b.Unlock()
+	b.requestListener[channel] = listener
+	b.subscribe(channel, "")
+}
+
+// NewRequestor 创建向channel发送请求的对象
+func (b *MemoryBus) NewRequestor(channel string) Requestor {
+	return &memoryRequestor{network: b.network, channel: channel, timeout: DefaultRequestTimeout}
+}
+
+// NewBroadcaster 创建向channel发送广播的对象
+func (b *MemoryBus) NewBroadcaster(channel string) Broadcaster {
+	return &memoryBroadcaster{network: b.network, channel: channel}
+}
+
+// OnBroadcast 订阅channel上的广播
+func (b *MemoryBus) OnBroadcast(channel string, listener BroadcastFunc) {
+	b.OnBroadcastWithGroup(channel, "", listener)
+}
+
+// OnBroadcastWithGroup 按组订阅channel上的广播,分组以channel第一次订阅时为准
+func (b *MemoryBus) OnBroadcastWithGroup(channel, group string, listener BroadcastFunc) {
+	b.Lock()
+	defer b.Unlock()
+	for _, l := range b.broadcastListeners[channel] {
+		if fmt.Sprintf("%p", l) == fmt.Sprintf("%p", listener) {
+			return
+		}
+	}
+	b.broadcastListeners[channel] = append(b.broadcastListeners[channel], listener)
+	b.subscribe(channel, group)
+}
+
+// Close 取消所有订阅
+func (b *MemoryBus) Close() {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for channel, sub := range b.subs {
+		b.network.unsubscribe(sub)
+		sub.close()
+		delete(b.subs, channel)
+	}
+}
+
+type memoryBroadcaster struct {
+	network *Network
+	channel string
+}
+
+func (bc *memoryBroadcaster) Say(method string, data map[string]interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{"notification": true, "method": method, "data": data})
+	if err != nil {
+		return
+	}
+	bc.network.publish(bc.channel, &memoryMsg{payload: payload})
+}
+
+type memoryRequestor struct {
+	sync.Mutex
+	network *Network
+	channel string
+	timeout time.Duration
+}
+
+func (r *memoryRequestor) SetRequestTimeout(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.timeout = d
+}
+
+func (r *memoryRequestor) Request(method string, data map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	payload, err := json.Marshal(map[string]interface{}{"request": true, "id": rand.Int31(), "method": method, "data": data})
+	if err != nil {
+		reject(500, err.Error())
+		return
+	}
+	r.Lock()
+	timeout := r.timeout
+	r.Unlock()
+
+	var once sync.Once
+	timer := time.AfterFunc(timeout, func() {
+		once.Do(func() {
+			reject(CodeTimeout, fmt.Sprintf("Request timeout %fs, method[%s]", timeout.Seconds(), method))
+		})
+	})
+	reply := func(payload []byte) {
+		once.Do(func() {
+			timer.Stop()
+			var response map[string]interface{}
+			if err := json.Unmarshal(payload, &response); err != nil {
+				reject(500, err.Error())
+				return
+			}
+			if ok, _ := response["ok"].(bool); ok {
+				result, _ := response["data"].(map[string]interface{})
+				accept(result)
+			} else {
+				code, _ := response["errorCode"].(float64)
+				reason, _ := response["errorReason"].(string)
+				reject(int(code), reason)
+			}
+		})
+	}
+	r.network.publish(r.channel, &memoryMsg{payload: payload, reply: reply})
+}
+
+func (r *memoryRequestor) SyncRequest(method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	return r.AsyncRequest(method, data).Await()
+}
+
+func (r *memoryRequestor) AsyncRequest(method string, data map[string]interface{}) *Future {
+	return asyncRequest(r, method, data)
+}
+
+func (r *memoryRequestor) Call(ctx context.Context, method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	return call(ctx, r, method, data)
+}
@@ -0,0 +1,149 @@
+package bus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRequest(t *testing.T) {
+	network := NewNetwork()
+	server := network.Connect()
+	client := network.Connect()
+	defer server.Close()
+	defer client.Close()
+
+	server.OnRequest("rpc-islb", func(request map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+		method := request["method"].(string)
+		data := request["data"].(map[string]interface{})
+		switch method {
+		case "echo":
+			accept(data)
+		default:
+			reject(404, "unknown method "+method)
+		}
+	})
+
+	rpc := client.NewRequestor("rpc-islb")
+	result, err := rpc.SyncRequest("echo", map[string]interface{}{"rid": "room1", "count": 2})
+	if err != nil {
+		t.Fatalf("echo err = %v", err)
+	}
+	// 和nats一样经过json编解码,数字变成float64
+	if result["rid"] != "room1" || result["count"] != float64(2) {
+		t.Errorf("echo result = %v", result)
+	}
+
+	_, err = rpc.SyncRequest("nope", map[string]interface{}{})
+	if err == nil || err.Code != 404 {
+		t.Errorf("nope err = %v", err)
+	}
+
+	done := make(chan *Error, 1)
+	rpc.AsyncRequest("echo", map[string]interface{}{}).Then(func(result map[string]interface{}) {
+		done <- nil
+	}, func(err *Error) {
+		done <- err
+	})
+	if err := <-done; err != nil {
+		t.Errorf("async echo err = %v", err)
+	}
+}
+
+func TestMemoryRequestTimeout(t *testing.T) {
+	network := NewNetwork()
+	client := network.Connect()
+	rpc := client.NewRequestor("rpc-none")
+	rpc.SetRequestTimeout(20 * time.Millisecond)
+	if _, err := rpc.SyncRequest("echo", nil); err == nil || err.Code != 480 {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestMemoryBroadcast(t *testing.T) {
+	network := NewNetwork()
+	var lock sync.Mutex
+	received := make(map[string]int)
+	var wg sync.WaitGroup
+	listen := func(name string) BroadcastFunc {
+		return func(msg map[string]interface{}, subj string) {
+			lock.Lock()
+			received[name]++
+			lock.Unlock()
+			wg.Done()
+		}
+	}
+
+	biz1, biz2 := network.Connect(), network.Connect()
+	issr1, issr2 := network.Connect(), network.Connect()
+	biz1.OnBroadcast("event-islb", listen("biz1"))
+	biz2.OnBroadcast("event-islb", listen("biz2"))
+	issr1.OnBroadcastWithGroup("event-islb", "issr", listen("issr"))
+	issr2.OnBroadcastWithGroup("event-islb", "issr", listen("issr"))
+
+	// 每条广播biz各收到一次,issr组内只有一个收到
+	wg.Add(3 * 10)
+	broadcaster := network.Connect().NewBroadcaster("event-islb")
+	for i := 0; i < 10; i++ {
+		broadcaster.Say("stream-add", map[string]interface{}{"i": i})
+	}
+	wg.Wait()
+	if received["biz1"] != 10 || received["biz2"] != 10 || received["issr"] != 10 {
+		t.Errorf("received = %v", received)
+	}
+
+	biz2.Close()
+	wg.Add(2)
+	broadcaster.Say("stream-add", nil)
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+	if received["biz2"] != 10 {
+		t.Errorf("closed bus received = %d", received["biz2"])
+	}
+}
+
+func TestMemoryBroadcastOrder(t *testing.T) {
+	network := NewNetwork()
+	sub := network.Connect()
+	got := make(chan float64, 100)
+	sub.OnBroadcast("event-sfu", func(msg map[string]interface{}, subj string) {
+		data := msg["data"].(map[string]interface{})
+		got <- data["i"].(float64)
+	})
+	broadcaster := sub.NewBroadcaster("event-sfu")
+	for i := 0; i < 100; i++ {
+		broadcaster.Say("stream-remove", map[string]interface{}{"i": i})
+	}
+	for i := 0; i < 100; i++ {
+		if v := <-got; v != float64(i) {
+			t.Fatalf("message %d = %v", i, v)
+		}
+	}
+}
+
+func TestMemoryCall(t *testing.T) {
+	network := NewNetwork()
+	server := network.Connect()
+	release := make(chan struct{})
+	server.OnRequest("rpc-sfu", func(request map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+		go func() {
+			<-release
+			accept(map[string]interface{}{})
+		}()
+	})
+	defer close(release)
+	rpc := network.Connect().NewRequestor("rpc-sfu")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rpc.Call(ctx, "publish", nil); err == nil || err.Code != CodeTimeout {
+		t.Errorf("deadline err = %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := rpc.Call(ctx, "publish", nil); err == nil || err.Code != CodeCanceled {
+		t.Errorf("canceled err = %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package bus
+
+import (
+	"context"
+	"time"
+
+	"signal/util"
+
+	nprotoo "github.com/gearghost/nats-protoo"
+)
+
+// NatsBus 基于nats-protoo的消息总线
+type NatsBus struct {
+	protoo *nprotoo.NatsProtoo
+}
+
+// NewNatsBus 连接nats创建消息总线
+func NewNatsBus(natsURL string) *NatsBus {
+	return &NatsBus{protoo: nprotoo.NewNatsProtoo(util.GenerateNatsUrlString(natsURL))}
+}
+
+// OnRequest 处理channel上的请求
+func (b *NatsBus) OnRequest(channel string, listener RequestFunc) {
+	b.protoo.OnRequest(channel, listener)
+}
+
+// NewRequestor 创建向channel发送请求的对象
+func (b *NatsBus) NewRequestor(channel string) Requestor {
+	return &natsRequestor{requestor: b.protoo.NewRequestor(channel)}
+}
+
+// NewBroadcaster 创建向channel发送广播的对象
+func (b *NatsBus) NewBroadcaster(channel string) Broadcaster {
+	return b.protoo.NewBroadcaster(channel)
+}
+
+// OnBroadcast 订阅channel上的广播
+func (b *NatsBus) OnBroadcast(channel string, listener BroadcastFunc) {
+	b.protoo.OnBroadcast(channel, listener)
+}
+
+// OnBroadcastWithGroup 按组订阅channel上的广播
+func (b *NatsBus) OnBroadcastWithGroup(channel, group string, listener BroadcastFunc) {
+	b.protoo.OnBroadcastWithGroup(channel, group, listener)
+}
+
+// Close 关闭连接
+func (b *NatsBus) Close() {
+	b.protoo.Close()
+}
+
+type natsRequestor struct {
+	requestor *nprotoo.Requestor
+}
+
+func (r *natsRequestor) SetRequestTimeout(d time.Duration) {
+	r.requestor.SetRequestTimeout(d)
+}
+
+func (r *natsRequestor) Request(method string, data map[string]interface{}, accept AcceptFunc, reject RejectFunc) {
+	r.requestor.Request(method, data, accept, reject)
+}
+
+func (r *natsRequestor) SyncRequest(method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	return r.AsyncRequest(method, data).Await()
+}
+
+func (r *natsRequestor) AsyncRequest(method string, data map[string]interface{}) *Future {
+	return asyncRequest(r, method, data)
+}
+
+func (r *natsRequestor) Call(ctx context.Context, method string, data map[string]interface{}) (map[string]interface{}, *Error) {
+	return call(ctx, r, method, data)
+}
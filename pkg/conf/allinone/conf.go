@@ -0,0 +1,364 @@
+package conf
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	dis "signal/infra/discovery"
+	kafka2 "signal/infra/kafka"
+	"signal/pkg/billing"
+	"signal/pkg/bus"
+	"signal/pkg/exporter"
+	"signal/pkg/ratelimit"
+	"signal/pkg/timing"
+	"signal/pkg/webhook"
+
+	"github.com/spf13/viper"
+)
+
+// 后端选择
+const (
+	BackendMemory = "memory"
+	BackendEtcd   = "etcd"
+	BackendNats   = "nats"
+	BackendRedis  = "redis"
+	BackendFile   = "file"
+	BackendKafka  = "kafka"
+	BackendMysql  = "mysql"
+)
+
+// defaultDrain 没有配置global.drain时的下线等待时间,秒
+const defaultDrain = 30
+
+var (
+	cfg = config{}
+	// Global 全局设置
+	Global = &cfg.Global
+	// Log 日志级别设置
+	Log = &cfg.Log
+	// Registry 服务注册中心设置
+	Registry = &cfg.Registry
+	// Bus 消息总线设置
+	Bus = &cfg.Bus
+	// Sink 计费事件输出设置
+	Sink = &cfg.Sink
+	// Etcd Etcd设置
+	Etcd = &cfg.Etcd
+	// Nats 消息中间件设置
+	Nats = &cfg.Nats
+	// Redis Redis设置
+	Redis = &cfg.Redis
+	// Kafka Kafka设置
+	Kafka = &cfg.Kafka
+	// Probe http探针
+	Probe = &cfg.Probe
+	// Monitor monitor
+	Monitor = &cfg.Monitor
+	// Biz biz设置
+	Biz = &cfg.Biz
+	// Islb islb设置
+	Islb = &cfg.Islb
+	// Sfu sfu设置
+	Sfu = &cfg.Sfu
+	// Issr issr设置
+	Issr = &cfg.Issr
+	// Topology 区域之间的延迟
+	Topology = &cfg.Topology
+)
+
+func init() {
+	if !cfg.parse() {
+		showHelp()
+		os.Exit(-1)
+	}
+}
+
+type global struct {
+	Pprof string `mapstructure:"pprof"`
+	Ndc   string `mapstructure:"dc"`
+	Nip   string `mapstructure:"nip"`
+	Drain int    `mapstructure:"drain"` // 下线等待时间,秒,不填时为defaultDrain
+}
+
+type log struct {
+	Level string `mapstructure:"level"`
+}
+
+type backend struct {
+	Backend string `mapstructure:"backend"`
+}
+
+type sink struct {
+	Backend string `mapstructure:"backend"`
+	File    string `mapstructure:"file"`
+}
+
+type etcd struct {
+	Addrs string `mapstructure:"addrs"`
+}
+
+type nats struct {
+	URL     string `mapstructure:"url"`
+	NatsLog string `mapstructure:"natslog"`
+}
+
+type redis struct {
+	Addrs []string `mapstructure:"addrs"`
+	Pwd   string   `mapstructure:"password"`
+	DB    int      `mapstructure:"db"`
+}
+
+type kafka struct {
+	URL string `mapstructure:"url"`
+}
+
+type probe struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+}
+
+type monitor struct {
+	Port int `mapstructure:"port"`
+}
+
+type signal struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
+type rateLimit struct {
+	Enable bool                      `mapstructure:"enable"`
+	Peer   map[string]ratelimit.Rule `mapstructure:"peer"`
+	AppID  map[string]ratelimit.Rule `mapstructure:"appid"`
+}
+
+type balance struct {
+	Strategy []string    `mapstructure:"strategy"`
+	Fallback bool        `mapstructure:"fallback"`
+	Weights  dis.Weights `mapstructure:"weights"`
+	Overflow string      `mapstructure:"overflow"`
+}
+
+type rpc struct {
+	Threshold int                   `mapstructure:"threshold"`
+	Cooldown  int                   `mapstructure:"cooldown"` // 毫秒
+	Methods   map[string]bus.Policy `mapstructure:"methods"`
+}
+
+type biz struct {
+	Nid       string    `mapstructure:"nid"`
+	Signal    signal    `mapstructure:"signal"`
+	RateLimit rateLimit `mapstructure:"ratelimit"`
+	Balance   balance   `mapstructure:"balance"`
+	RPC       rpc       `mapstructure:"rpc"`
+	Admin     admin     `mapstructure:"admin"`
+	Server    server    `mapstructure:"server"`
+}
+
+type admin struct {
+	Token string `mapstructure:"token"` // 为空时不开启管理接口
+}
+
+type app struct {
+	AppID  string `mapstructure:"appid"`
+	Secret string `mapstructure:"secret"`
+}
+
+type server struct {
+	Window int   `mapstructure:"window"` // 请求时间允许的偏差,秒
+	Apps   []app `mapstructure:"apps"`
+}
+
+// Secrets appid到签名密钥的映射
+func (s *server) Secrets() map[string]string {
+	secrets := make(map[string]string)
+	for _, a := range s.Apps {
+		secrets[a.AppID] = a.Secret
+	}
+	return secrets
+}
+
+type mysql struct {
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Database string `mapstructure:"database"`
+}
+
+type islb struct {
+	Nid         string `mapstructure:"nid"`
+	Store       string `mapstructure:"store"`
+	HistorySize int    `mapstructure:"historysize"`
+	Mysql       mysql  `mapstructure:"mysql"`
+}
+
+type jitterBuffer struct {
+	On            bool `mapstructure:"on"`
+	REMBCycle     int  `mapstructure:"rembcycle"`
+	PLICycle      int  `mapstructure:"plicycle"`
+	MaxBandwidth  int  `mapstructure:"maxbandwidth"`
+	MaxBufferTime int  `mapstructure:"maxbuffertime"`
+}
+
+type plugins struct {
+	On           bool         `mapstructure:"on"`
+	JitterBuffer jitterBuffer `mapstructure:"jitterbuffer"`
+}
+
+type iceserver struct {
+	URLs       []string `mapstructure:"urls"`
+	Username   string   `mapstructure:"username"`
+	Credential string   `mapstructure:"credential"`
+}
+
+type webrtc struct {
+	ICEPortRange []uint16    `mapstructure:"portrange"`
+	ICEServers   []iceserver `mapstructure:"iceserver"`
+}
+
+type sfu struct {
+	Nid      string       `mapstructure:"nid"`
+	Plugins  plugins      `mapstructure:"plugins"`
+	WebRTC   webrtc       `mapstructure:"webrtc"`
+	Capacity dis.Capacity `mapstructure:"capacity"`
+}
+
+type issr struct {
+	Enable   bool                `mapstructure:"enable"`
+	Nid      string              `mapstructure:"nid"`
+	State    string              `mapstructure:"state"`
+	Webhook  webhook.Config      `mapstructure:"webhook"`
+	Exporter exporter.Config     `mapstructure:"exporter"`
+	Billing  billing.Config      `mapstructure:"billing"`
+	Outbox   kafka2.OutboxConfig `mapstructure:"outbox"`
+	Rollup   rollup              `mapstructure:"rollup"`
+	Tiers    timing.TierConfig   `mapstructure:"tiers"`
+}
+
+type rollup struct {
+	Backend string `mapstructure:"backend"` // 为空时不汇总
+	Token   string `mapstructure:"token"`   // 为空时不开启查询接口
+	Mysql   mysql  `mapstructure:"mysql"`
+}
+
+type config struct {
+	Global   global       `mapstructure:"global"`
+	Log      log          `mapstructure:"log"`
+	Registry backend      `mapstructure:"registry"`
+	Bus      backend      `mapstructure:"bus"`
+	Sink     sink         `mapstructure:"sink"`
+	Etcd     etcd         `mapstructure:"etcd"`
+	Nats     nats         `mapstructure:"nats"`
+	Redis    redis        `mapstructure:"redis"`
+	Kafka    kafka        `mapstructure:"kafka"`
+	Probe    probe        `mapstructure:"probe"`
+	Monitor  monitor      `mapstructure:"monitor"`
+	Biz      biz          `mapstructure:"biz"`
+	Islb     islb         `mapstructure:"islb"`
+	Sfu      sfu          `mapstructure:"sfu"`
+	Issr     issr         `mapstructure:"issr"`
+	Topology dis.Topology `mapstructure:"topology"`
+	CfgFile  string
+}
+
+func showHelp() {
+	fmt.Printf("Usage:%s {params}\n", os.Args[0])
+	fmt.Println("      -c {config file}")
+	fmt.Println("      -h (show help info)")
+}
+
+// check 检查后端选择
+func (c *config) check() error {
+	choices := []struct {
+		name    string
+		value   string
+		allowed []string
+	}{
+		{"registry.backend", c.Registry.Backend, []string{BackendMemory, BackendEtcd}},
+		{"bus.backend", c.Bus.Backend, []string{BackendMemory, BackendNats}},
+		{"sink.backend", c.Sink.Backend, []string{BackendFile, BackendKafka}},
+		{"islb.store", c.Islb.Store, []string{BackendMemory, BackendRedis, BackendEtcd}},
+		{"issr.state", c.Issr.State, []string{BackendMemory, BackendRedis}},
+		{"issr.rollup.backend", c.Issr.Rollup.Backend, []string{"", BackendMemory, BackendMysql}},
+	}
+	for _, choice := range choices {
+		found := false
+		for _, allowed := range choice.allowed {
+			if choice.value == allowed {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v, got %q", choice.name, choice.allowed, choice.value)
+		}
+	}
+	if len(c.Sfu.WebRTC.ICEPortRange) != 0 && (len(c.Sfu.WebRTC.ICEPortRange) != 2 || c.Sfu.WebRTC.ICEPortRange[1]-c.Sfu.WebRTC.ICEPortRange[0] <= 100) {
+		return fmt.Errorf("sfu.webrtc.portrange must be [min, max] and max - min >= %d", 100)
+	}
+	if _, err := timing.NewTiers(c.Issr.Tiers); err != nil {
+		return fmt.Errorf("issr.tiers: %v", err)
+	}
+	return nil
+}
+
+func (c *config) load() bool {
+	_, err := os.Stat(c.CfgFile)
+	if err != nil {
+		return false
+	}
+
+	viper.SetConfigFile(c.CfgFile)
+	viper.SetConfigType("toml")
+	viper.SetDefault("global.drain", defaultDrain)
+
+	err = viper.ReadInConfig()
+	if err != nil {
+		fmt.Printf("config file %s read failed. %v\n", c.CfgFile, err)
+		return false
+	}
+	err = viper.GetViper().UnmarshalExact(c)
+	if err != nil {
+		fmt.Printf("config file %s loaded failed. %v\n", c.CfgFile, err)
+		return false
+	}
+	if err := c.check(); err != nil {
+		fmt.Printf("config file %s loaded failed. %v\n", c.CfgFile, err)
+		return false
+	}
+	fmt.Printf("config %s load ok!\n", c.CfgFile)
+	return true
+}
+
+// ReloadTiers 重新读取配置文件中issr的分辨率计费档位,其他配置修改后需要重启
+func ReloadTiers() (timing.TierConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(cfg.CfgFile)
+	v.SetConfigType("toml")
+	if err := v.ReadInConfig(); err != nil {
+		return timing.TierConfig{}, err
+	}
+	var c config
+	if err := v.UnmarshalExact(&c); err != nil {
+		return timing.TierConfig{}, err
+	}
+	return c.Issr.Tiers, nil
+}
+
+func (c *config) parse() bool {
+	flag.StringVar(&c.CfgFile, "c", "conf/conf.toml", "config file")
+	help := flag.Bool("h", false, "help info")
+	flag.Parse()
+	if !c.load() {
+		return false
+	}
+
+	if *help {
+		showHelp()
+		return false
+	}
+	return true
+}
@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"os"
 
+	dis "signal/infra/discovery"
+	"signal/pkg/bus"
+	"signal/pkg/ratelimit"
+
 	"github.com/spf13/viper"
 )
 
+// defaultDrain 没有配置global.drain时的下线等待时间,秒
+const defaultDrain = 30
+
 var (
 	cfg = config{}
 	// Global 全局设置
@@ -24,6 +31,18 @@ var (
 	Probe = &cfg.Probe
 	// monitor
 	Monitor = &cfg.Monitor
+	// RateLimit 信令请求限流
+	RateLimit = &cfg.RateLimit
+	// Balance sfu选择策略
+	Balance = &cfg.Balance
+	// Topology 区域之间的延迟
+	Topology = &cfg.Topology
+	// RPC 节点之间请求的超时、重试和熔断
+	RPC = &cfg.RPC
+	// Admin 管理接口
+	Admin = &cfg.Admin
+	// Server 应用服务端接口
+	Server = &cfg.Server
 )
 
 func init() {
@@ -39,6 +58,7 @@ type global struct {
 	Name  string `mapstructure:"name"`
 	Nid   string `mapstructure:"nid"`
 	Nip   string `mapstructure:"nip"`
+	Drain int    `mapstructure:"drain"` // 下线等待时间,秒,不填时为defaultDrain
 }
 
 type log struct {
@@ -75,15 +95,63 @@ type monitor struct {
 	Key  string `mapstructure:"key"`
 }
 
+type admin struct {
+	Token string `mapstructure:"token"` // 为空时不开启管理接口
+}
+
+type app struct {
+	AppID  string `mapstructure:"appid"`
+	Secret string `mapstructure:"secret"`
+}
+
+type server struct {
+	Window int   `mapstructure:"window"` // 请求时间允许的偏差,秒
+	Apps   []app `mapstructure:"apps"`
+}
+
+// Secrets appid到签名密钥的映射
+func (s *server) Secrets() map[string]string {
+	secrets := make(map[string]string)
+	for _, a := range s.Apps {
+		secrets[a.AppID] = a.Secret
+	}
+	return secrets
+}
+
+type rateLimit struct {
+	Enable bool                      `mapstructure:"enable"`
+	Peer   map[string]ratelimit.Rule `mapstructure:"peer"`
+	AppID  map[string]ratelimit.Rule `mapstructure:"appid"`
+}
+
+type balance struct {
+	Strategy []string    `mapstructure:"strategy"`
+	Fallback bool        `mapstructure:"fallback"`
+	Weights  dis.Weights `mapstructure:"weights"`
+	Overflow string      `mapstructure:"overflow"`
+}
+
+type rpc struct {
+	Threshold int                   `mapstructure:"threshold"`
+	Cooldown  int                   `mapstructure:"cooldown"` // 毫秒
+	Methods   map[string]bus.Policy `mapstructure:"methods"`
+}
+
 type config struct {
-	Global  global  `mapstructure:"global"`
-	Log     log     `mapstructure:"log"`
-	Etcd    etcd    `mapstructure:"etcd"`
-	Signal  signal  `mapstructure:"signal"`
-	Nats    nats    `mapstructure:"nats"`
-	Probe   probe   `mapstructure:"probe"`
-	Monitor monitor `mapstructure:"monitor"`
-	CfgFile string
+	Global    global       `mapstructure:"global"`
+	Log       log          `mapstructure:"log"`
+	Etcd      etcd         `mapstructure:"etcd"`
+	Signal    signal       `mapstructure:"signal"`
+	Nats      nats         `mapstructure:"nats"`
+	Probe     probe        `mapstructure:"probe"`
+	Monitor   monitor      `mapstructure:"monitor"`
+	RateLimit rateLimit    `mapstructure:"ratelimit"`
+	Balance   balance      `mapstructure:"balance"`
+	Topology  dis.Topology `mapstructure:"topology"`
+	RPC       rpc          `mapstructure:"rpc"`
+	Admin     admin        `mapstructure:"admin"`
+	Server    server       `mapstructure:"server"`
+	CfgFile   string
 }
 
 func showHelp() {
@@ -100,6 +168,7 @@ func (c *config) load() bool {
 
 	viper.SetConfigFile(c.CfgFile)
 	viper.SetConfigType("toml")
+	viper.SetDefault("global.drain", defaultDrain)
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -20,6 +20,12 @@ var (
 	Nats = &cfg.Nats
 	// Redis Redis设置
 	Redis = &cfg.Redis
+	// Store 房间状态存储设置
+	Store = &cfg.Store
+	// Mysql 聊天记录归档设置,host为空不归档
+	Mysql = &cfg.Mysql
+	// Chat 聊天记录设置
+	Chat = &cfg.Chat
 	// http探针
 	Probe = &cfg.Probe
 	//monitor
@@ -60,6 +66,22 @@ type redis struct {
 	DB    int      `mapstructure:"db"`
 }
 
+type store struct {
+	Backend string `mapstructure:"backend"`
+}
+
+type mysql struct {
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Database string `mapstructure:"database"`
+}
+
+type chat struct {
+	HistorySize int `mapstructure:"historysize"`
+}
+
 type probe struct {
 	Host string `mapstructure:"host"`
 	Port int    `mapstructure:"port"`
@@ -80,6 +102,9 @@ type config struct {
 	Etcd    etcd    `mapstructure:"etcd"`
 	Nats    nats    `mapstructure:"nats"`
 	Redis   redis   `mapstructure:"redis"`
+	Store   store   `mapstructure:"store"`
+	Mysql   mysql   `mapstructure:"mysql"`
+	Chat    chat    `mapstructure:"chat"`
 	Probe   probe   `mapstructure:"probe"`
 	Monitor monitor `mapstructure:"monitor"`
 	CfgFile string
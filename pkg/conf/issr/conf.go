@@ -5,6 +5,13 @@ import (
 	"fmt"
 	"os"
 
+	dis "signal/infra/discovery"
+	kafka2 "signal/infra/kafka"
+	"signal/pkg/billing"
+	"signal/pkg/exporter"
+	"signal/pkg/timing"
+	"signal/pkg/webhook"
+
 	"github.com/spf13/viper"
 )
 
@@ -26,6 +33,20 @@ var (
 	Monitor = &cfg.Monitor
 	// Redis Redis设置
 	Redis = &cfg.Redis
+	// Topology 区域之间的延迟
+	Topology = &cfg.Topology
+	// Webhook 应用的webhook
+	Webhook = &cfg.Webhook
+	// Exporter 信令事件导出到kafka
+	Exporter = &cfg.Exporter
+	// Billing 订阅计费
+	Billing = &cfg.Billing
+	// Outbox 计费记录发送箱
+	Outbox = &cfg.Outbox
+	// Rollup 用量汇总和查询接口
+	Rollup = &cfg.Rollup
+	// Tiers 分辨率计费档位
+	Tiers = &cfg.Tiers
 )
 
 func init() {
@@ -80,16 +101,37 @@ type redis struct {
 	DB    int      `mapstructure:"db"`
 }
 
+type mysql struct {
+	Host     string `mapstructure:"host"`
+	Port     string `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Database string `mapstructure:"database"`
+}
+
+type rollup struct {
+	Backend string `mapstructure:"backend"` // 为空时不汇总
+	Token   string `mapstructure:"token"`   // 为空时不开启查询接口
+	Mysql   mysql  `mapstructure:"mysql"`
+}
+
 type config struct {
-	Global  global  `mapstructure:"global"`
-	Log     log     `mapstructure:"log"`
-	Etcd    etcd    `mapstructure:"etcd"`
-	Nats    nats    `mapstructure:"nats"`
-	Kafka   kafka   `mapstructure:"kafka"`
-	Probe   probe   `mapstructure:"probe"`
-	Monitor monitor `mapstructure:"monitor"`
-	Redis   redis   `mapstructure:"redis"`
-	CfgFile string
+	Global   global              `mapstructure:"global"`
+	Log      log                 `mapstructure:"log"`
+	Etcd     etcd                `mapstructure:"etcd"`
+	Nats     nats                `mapstructure:"nats"`
+	Kafka    kafka               `mapstructure:"kafka"`
+	Probe    probe               `mapstructure:"probe"`
+	Monitor  monitor             `mapstructure:"monitor"`
+	Redis    redis               `mapstructure:"redis"`
+	Topology dis.Topology        `mapstructure:"topology"`
+	Webhook  webhook.Config      `mapstructure:"webhook"`
+	Exporter exporter.Config     `mapstructure:"exporter"`
+	Billing  billing.Config      `mapstructure:"billing"`
+	Outbox   kafka2.OutboxConfig `mapstructure:"outbox"`
+	Rollup   rollup              `mapstructure:"rollup"`
+	Tiers    timing.TierConfig   `mapstructure:"tiers"`
+	CfgFile  string
 }
 
 func showHelp() {
@@ -121,6 +163,21 @@ func (c *config) load() bool {
 	return true
 }
 
+// ReloadTiers 重新读取配置文件中的分辨率计费档位,其他配置修改后需要重启
+func ReloadTiers() (timing.TierConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(cfg.CfgFile)
+	v.SetConfigType("toml")
+	if err := v.ReadInConfig(); err != nil {
+		return timing.TierConfig{}, err
+	}
+	var c config
+	if err := v.UnmarshalExact(&c); err != nil {
+		return timing.TierConfig{}, err
+	}
+	return c.Tiers, nil
+}
+
 func (c *config) parse() bool {
 	flag.StringVar(&c.CfgFile, "c", "conf/conf.toml", "config file")
 	help := flag.Bool("h", false, "help info")
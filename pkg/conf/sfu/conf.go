@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"os"
 
+	dis "signal/infra/discovery"
+
 	"github.com/spf13/viper"
 )
 
+// defaultDrain 没有配置global.drain时的下线等待时间,秒
+const defaultDrain = 30
+
 var (
 	cfg = config{}
 	// Global 全局设置
@@ -26,6 +31,10 @@ var (
 	Probe = &cfg.Probe
 	//monitor
 	Monitor = &cfg.Monitor
+	// Capacity 节点承载上限
+	Capacity = &cfg.Capacity
+	// Admin 管理接口
+	Admin = &cfg.Admin
 )
 
 func init() {
@@ -41,6 +50,7 @@ type global struct {
 	Name  string `mapstructure:"name"`
 	Nid   string `mapstructure:"nid"`
 	Nip   string `mapstructure:"nip"`
+	Drain int    `mapstructure:"drain"` // 下线等待时间,秒,不填时为defaultDrain
 }
 
 type jitterBuffer struct {
@@ -94,16 +104,22 @@ type monitor struct {
 	Key  string `mapstructure:"key"`
 }
 
+type admin struct {
+	Token string `mapstructure:"token"` // 为空时不开启管理接口
+}
+
 type config struct {
-	Global  global  `mapstructure:"global"`
-	Plugins plugins `mapstructure:"plugins"`
-	WebRTC  webrtc  `mapstructure:"webrtc"`
-	Log     log     `mapstructure:"log"`
-	Etcd    etcd    `mapstructure:"etcd"`
-	Nats    nats    `mapstructure:"nats"`
-	Probe   probe   `mapstructure:"probe"`
-	Monitor monitor `mapstructure:"monitor"`
-	CfgFile string
+	Global   global       `mapstructure:"global"`
+	Plugins  plugins      `mapstructure:"plugins"`
+	WebRTC   webrtc       `mapstructure:"webrtc"`
+	Log      log          `mapstructure:"log"`
+	Etcd     etcd         `mapstructure:"etcd"`
+	Nats     nats         `mapstructure:"nats"`
+	Probe    probe        `mapstructure:"probe"`
+	Monitor  monitor      `mapstructure:"monitor"`
+	Capacity dis.Capacity `mapstructure:"capacity"`
+	Admin    admin        `mapstructure:"admin"`
+	CfgFile  string
 }
 
 func showHelp() {
@@ -120,6 +136,7 @@ func (c *config) load() bool {
 
 	viper.SetConfigFile(c.CfgFile)
 	viper.SetConfigType("toml")
+	viper.SetDefault("global.drain", defaultDrain)
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,132 @@
+package exporter
+
+import (
+	"encoding/json"
+	"time"
+
+	"signal/infra/kafka"
+	"signal/pkg/log"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+// 把islb的房间和流事件、sfu的流事件导出到kafka,供分析系统还原会话
+// 每个事件写入一条proto.EventRecord,topic按事件名配置
+
+// Config 导出配置
+type Config struct {
+	Enable bool              `mapstructure:"enable"`
+	Topic  string            `mapstructure:"topic"`  // 默认topic
+	Topics map[string]string `mapstructure:"topics"` // 事件名 -> topic,覆盖默认topic
+	Async  bool              `mapstructure:"async"`  // 异步发送,不等待kafka确认
+	Queue  int               `mapstructure:"queue"`  // 异步发送的队列长度
+}
+
+// DefaultTopic 没有配置topic时使用
+const DefaultTopic = "Livs-Signal-Event"
+
+// 事件来源
+const (
+	SourceIslb = "islb"
+	SourceSfu  = "sfu"
+)
+
+// events 导出的事件,聊天广播等不属于房间和流生命周期的事件不导出
+var events = map[string]map[string]bool{
+	SourceIslb: {
+		proto.IslbToBizOnRoomAdd:      true,
+		proto.IslbToBizOnRoomRemove:   true,
+		proto.IslbToBizOnJoin:         true,
+		proto.IslbToBizOnLeave:        true,
+		proto.IslbToBizOnStreamAdd:    true,
+		proto.IslbToBizOnStreamRemove: true,
+		proto.IslbToBizOnStreamUpdate: true,
+		proto.IslbToBizOnLiveAdd:      true,
+		proto.IslbToBizOnLiveRemove:   true,
+		proto.IslbToBizOnMigrate:      true,
+	},
+	SourceSfu: {
+		proto.SfuToIslbOnStreamRemove:    true,
+		proto.SfuToIssrOnSubscribeAdd:    true,
+		proto.SfuToIssrOnSubscribeRemove: true,
+	},
+}
+
+// Exporter 信令事件导出
+type Exporter struct {
+	producer kafka.Producer
+	async    *kafka.AsyncProducer
+	topic    string
+	topics   map[string]string
+}
+
+// New 创建Exporter,p由调用者关闭,未开启时返回nil
+func New(c Config, p kafka.Producer) *Exporter {
+	if !c.Enable {
+		return nil
+	}
+	e := &Exporter{producer: p, topic: c.Topic, topics: c.Topics}
+	if e.topic == "" {
+		e.topic = DefaultTopic
+	}
+	if c.Async {
+		e.async = kafka.NewAsyncProducer(p, c.Queue)
+		e.producer = e.async
+	}
+	return e
+}
+
+// Record 把广播转换为导出记录,不需要导出的事件返回nil
+func Record(source, nid, method string, data map[string]interface{}) *proto.EventRecord {
+	if !events[source][method] {
+		return nil
+	}
+	record := &proto.EventRecord{
+		Version: proto.EventRecordVersion,
+		ID:      util.RandStr(16),
+		Source:  source,
+		NID:     nid,
+		Event:   method,
+		AppID:   util.Val(data, "appid"),
+		RID:     util.Val(data, "rid"),
+		UID:     util.Val(data, "uid"),
+		MID:     util.Val(data, "mid"),
+		Time:    time.Now().UnixNano() / int64(time.Millisecond),
+		Data:    data,
+	}
+	if minfo, ok := data["minfo"].(map[string]interface{}); ok && record.AppID == "" {
+		record.AppID = util.Val(minfo, "appid")
+	}
+	// sfu移除流时mid为router的key
+	if kind, rid, uid, mid, ok := proto.ParseMediaKey(record.MID); ok && kind == "pub" {
+		record.RID, record.UID, record.MID = rid, uid, mid
+	}
+	return record
+}
+
+// Export 导出一条广播,source为发出广播的服务,nid为发出广播的节点
+func (e *Exporter) Export(source, nid, method string, data map[string]interface{}) {
+	record := Record(source, nid, method, data)
+	if record == nil {
+		return
+	}
+	str, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("exporter.Export json marshal failed=%v", err)
+		return
+	}
+	topic, ok := e.topics[method]
+	if !ok {
+		topic = e.topic
+	}
+	if err := e.producer.Produce(topic, string(str)); err != nil {
+		log.Errorf("exporter.Export %s to %s err=%v", method, topic, err)
+	}
+}
+
+// Close 发送完异步队列中的事件
+func (e *Exporter) Close() {
+	if e.async != nil {
+		e.async.Close()
+	}
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"signal/pkg/proto"
+)
+
+// memoryProducer 按topic记录收到的消息
+type memoryProducer struct {
+	sync.Mutex
+	messages map[string][]string
+}
+
+func (p *memoryProducer) Produce(topic, message string) error {
+	p.Lock()
+	defer p.Unlock()
+	p.messages[topic] = append(p.messages[topic], message)
+	return nil
+}
+
+func (p *memoryProducer) Close() error {
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	if r := Record(SourceIslb, "islb1", proto.IslbToBizBroadcast, map[string]interface{}{"rid": "r"}); r != nil {
+		t.Errorf("broadcast exported: %+v", r)
+	}
+	r := Record(SourceIslb, "islb1", proto.IslbToBizOnStreamAdd, map[string]interface{}{
+		"rid": "r", "uid": "u", "mid": "u#1", "minfo": map[string]interface{}{"appid": "a"},
+	})
+	if r == nil || r.Version != proto.EventRecordVersion || r.AppID != "a" || r.RID != "r" || r.MID != "u#1" || r.NID != "islb1" {
+		t.Errorf("stream-add = %+v", r)
+	}
+	r = Record(SourceSfu, "sfu1", proto.SfuToIslbOnStreamRemove, map[string]interface{}{
+		"mid": proto.GetMediaPubKey("r", "u", "u#1"), "nid": "sfu1",
+	})
+	if r == nil || r.RID != "r" || r.UID != "u" || r.MID != "u#1" {
+		t.Errorf("sfu-stream-remove = %+v", r)
+	}
+}
+
+func TestExport(t *testing.T) {
+	if New(Config{}, nil) != nil {
+		t.Fatal("disabled exporter created")
+	}
+	p := &memoryProducer{messages: make(map[string][]string)}
+	e := New(Config{Enable: true, Async: true, Topics: map[string]string{proto.IslbToBizOnJoin: "joins"}}, p)
+	e.Export(SourceIslb, "islb1", proto.IslbToBizOnJoin, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a"})
+	e.Export(SourceIslb, "islb1", proto.IslbToBizOnLeave, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a"})
+	e.Export(SourceIslb, "islb1", proto.IslbToBizBroadcast, map[string]interface{}{"rid": "r", "uid": "u"})
+	e.Close()
+
+	if len(p.messages["joins"]) != 1 || len(p.messages[DefaultTopic]) != 1 {
+		t.Fatalf("messages = %v", p.messages)
+	}
+	var r proto.EventRecord
+	if err := json.Unmarshal([]byte(p.messages[DefaultTopic][0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Event != proto.IslbToBizOnLeave || r.Source != SourceIslb || r.AppID != "a" || r.ID == "" {
+		t.Errorf("record = %+v", r)
+	}
+}
@@ -0,0 +1,272 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	dis "signal/infra/discovery"
+	h "signal/infra/http"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+// 管理接口,查询集群节点、房间和sfu上router的状态,踢出用户和关闭房间
+// 挂在探活http服务下,请求头需要带上配置的token
+
+// adminServices 管理接口列出的服务
+var adminServices = []string{"biz", "islb", "sfu", "mcu", "issr"}
+
+// AdminNode 节点信息,breaker为本节点发往该节点请求的熔断状态
+type AdminNode struct {
+	Ndc     string    `json:"ndc"`
+	Nid     string    `json:"nid"`
+	Name    string    `json:"name"`
+	Nip     string    `json:"nip"`
+	State   string    `json:"state,omitempty"`
+	Load    *dis.Load `json:"load,omitempty"`
+	Breaker string    `json:"breaker,omitempty"`
+}
+
+// AdminRoom 房间详情,routers为房间发布流在sfu上的转发状态
+type AdminRoom struct {
+	Room    proto.RoomInfo     `json:"room"`
+	Routers []proto.RouterInfo `json:"routers"`
+}
+
+// AdminCloseRequest 关闭房间
+type AdminCloseRequest struct {
+	RID string `json:"rid" validate:"required"`
+}
+
+// InitAdmin 在g下注册管理接口,token为空时不开启
+func InitAdmin(g *h.PathGroup, token string) {
+	if token == "" {
+		return
+	}
+	auth := h.TokenFilter(token)
+	g.Get("/admin/nodes", adminNodes, auth)
+	g.Get("/admin/rooms", adminRooms, auth)
+	g.Get("/admin/room", adminRoom, auth)
+	g.Get("/admin/router", adminRouter, auth)
+	g.Post("/admin/kick", adminKick, auth)
+	g.Post("/admin/close", adminClose, auth)
+}
+
+// adminNodes 列出所有服务节点
+func adminNodes(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	nodes := make([]AdminNode, 0)
+	for _, name := range adminServices {
+		services, _ := watch.GetNodes(name)
+		for _, n := range services {
+			info := AdminNode{Ndc: n.Ndc, Nid: n.Nid, Name: n.Name, Nip: n.Nip, State: n.Nstate}
+			if n.Nload != "" {
+				load := n.GetLoad()
+				info.Load = &load
+			}
+			if c, find := getRPC(n.Nid); find {
+				info.Breaker = c.State().String()
+			}
+			nodes = append(nodes, info)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].Nid < nodes[j].Nid
+	})
+	h.WriteJSON(w, http.StatusOK, util.Map("nodes", nodes))
+}
+
+// adminRooms 汇总所有islb分片上有用户的房间
+func adminRooms(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	islbs, _ := watch.GetNodes("islb")
+	var lastErr *bus.Error
+	found := make(map[string]bool)
+	for nid := range islbs {
+		rpc, find := getRPC(nid)
+		if !find {
+			continue
+		}
+		resp, err := rpc.Call(ctx, proto.BizToIslbGetRooms, util.Map())
+		if err != nil {
+			logger.Warnf(fmt.Sprintf("biz.adminRooms islb %s err=%v", nid, err.Reason))
+			lastErr = err
+			continue
+		}
+		var rooms proto.IslbRoomsResponse
+		if err := proto.Decode(resp, &rooms); err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rid := range rooms.Rooms {
+			found[rid] = true
+		}
+		lastErr = nil
+	}
+	if len(found) == 0 && lastErr != nil {
+		writeHTTPError(w, lastErr, proto.ErrIslbUnavailable)
+		return
+	}
+	rids := make([]string, 0, len(found))
+	for rid := range found {
+		rids = append(rids, rid)
+	}
+	sort.Strings(rids)
+	h.WriteJSON(w, http.StatusOK, proto.IslbRoomsResponse{Rooms: rids})
+}
+
+// getRoomInfo 从房间所在的islb分片查询房间的用户、流和绑定的节点
+func getRoomInfo(ctx context.Context, rid string) (*proto.RoomInfo, *bus.Error) {
+	rpc := getIslbRequestor(rid)
+	if rpc == nil {
+		return nil, proto.NewError(proto.ErrIslbUnavailable)
+	}
+	resp, err := rpc.Call(ctx, proto.BizToIslbGetRoomInfo, util.Map("rid", rid))
+	if err != nil {
+		return nil, err
+	}
+	var info proto.RoomInfo
+	if err := proto.Decode(resp, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// adminRoom 查询房间详情,GET /admin/room?rid=
+func adminRoom(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	rid := req.URL.Query().Get("rid")
+	if rid == "" {
+		writeHTTPError(w, proto.NewError(proto.ErrRIDMissing), 0)
+		return
+	}
+	info, err := getRoomInfo(ctx, rid)
+	if err != nil {
+		writeHTTPError(w, err, proto.ErrIslbUnavailable)
+		return
+	}
+	// 按发布流所在的sfu查询订阅情况
+	sfus := make(map[string]bool)
+	if info.Sfu != "" {
+		sfus[info.Sfu] = true
+	}
+	for _, pub := range info.Pubs {
+		if pub.NID != "" {
+			sfus[pub.NID] = true
+		}
+	}
+	room := AdminRoom{Room: *info, Routers: make([]proto.RouterInfo, 0)}
+	for nid := range sfus {
+		routers, err := getRouters(ctx, nid, proto.SfuRoutersRequest{RID: rid})
+		if err != nil {
+			logger.Warnf(fmt.Sprintf("biz.adminRoom sfu %s err=%v", nid, err.Reason), "rid", rid)
+			continue
+		}
+		room.Routers = append(room.Routers, routers...)
+	}
+	h.WriteJSON(w, http.StatusOK, room)
+}
+
+// getRouters 查询sfu上router的转发状态
+func getRouters(ctx context.Context, nid string, req proto.SfuRoutersRequest) ([]proto.RouterInfo, *bus.Error) {
+	rpc, find := getRPC(nid)
+	if !find {
+		return nil, proto.NewError(proto.ErrSfuUnavailable, nid)
+	}
+	resp, err := rpc.Call(ctx, proto.BizToSfuGetRouters, proto.ToMap(&req))
+	if err != nil {
+		return nil, err
+	}
+	var routers proto.SfuRoutersResponse
+	if err := proto.Decode(resp, &routers); err != nil {
+		return nil, err
+	}
+	return routers.Routers, nil
+}
+
+// adminRouter 查询sfu上一个router的状态,GET /admin/router?nid=&id=
+func adminRouter(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	nid := req.URL.Query().Get("nid")
+	id := req.URL.Query().Get("id")
+	if nid == "" || id == "" {
+		writeHTTPError(w, proto.NewError(proto.ErrInvalidParams, "nid and id required"), 0)
+		return
+	}
+	routers, err := getRouters(ctx, nid, proto.SfuRoutersRequest{ID: id})
+	if err != nil {
+		writeHTTPError(w, err, proto.ErrSfuUnavailable)
+		return
+	}
+	if len(routers) == 0 {
+		writeHTTPError(w, proto.NewError(proto.ErrRouterNotFound, id), 0)
+		return
+	}
+	h.WriteJSON(w, http.StatusOK, routers[0])
+}
+
+// kickPeer 通知用户所在的biz踢出用户,biz已下线时直接从islb删除用户
+func kickPeer(rid, uid string) *bus.Error {
+	_, err := callPeer(rid, uid, proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid))
+	if err == nil || err.Code != proto.ErrBizUnavailable {
+		return err
+	}
+	logger.Warnf(fmt.Sprintf("biz.kickPeer %s, remove from islb", err.Reason), "uid", uid, "rid", rid)
+	return removePeer(getIslbRequestor(rid), rid, uid, proto.LeaveReasonKick)
+}
+
+// adminKick 踢出用户,POST /admin/kick {"rid","uid"}
+func adminKick(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.BizKickRequest
+	if err := readHTTPRequest(req, &msg); err != nil {
+		writeHTTPError(w, err, 0)
+		return
+	}
+	logger.Infof(fmt.Sprintf("biz.adminKick rid=%s uid=%s", msg.RID, msg.UID), "uid", msg.UID, "rid", msg.RID)
+	if err := kickPeer(msg.RID, msg.UID); err != nil {
+		writeHTTPError(w, err, proto.ErrBizUnavailable)
+		return
+	}
+	h.WriteJSON(w, http.StatusOK, util.Map())
+}
+
+// closeRoom 踢出房间所有用户并关闭sfu上的发布流,返回踢出的用户数
+func closeRoom(ctx context.Context, rid string) (int, *bus.Error) {
+	info, err := getRoomInfo(ctx, rid)
+	if err != nil {
+		return 0, err
+	}
+	kicked := 0
+	for _, user := range info.Users {
+		if err := kickPeer(rid, user.UID); err != nil {
+			logger.Warnf(fmt.Sprintf("biz.closeRoom kick err=%v", err.Reason), "uid", user.UID, "rid", rid)
+			continue
+		}
+		kicked++
+	}
+	// 用户离开时biz会取消发布,这里再清理一次,防止biz异常时sfu上残留router
+	for _, pub := range info.Pubs {
+		if rpc, find := getRPC(pub.NID); find {
+			rpc.AsyncRequest(proto.BizToSfuUnPublish, util.Map("rid", rid, "uid", pub.UID, "mid", pub.MID))
+		}
+	}
+	return kicked, nil
+}
+
+// adminClose 关闭房间,POST /admin/close {"rid"}
+func adminClose(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg AdminCloseRequest
+	if err := readHTTPRequest(req, &msg); err != nil {
+		writeHTTPError(w, err, 0)
+		return
+	}
+	logger.Infof(fmt.Sprintf("biz.adminClose rid=%s", msg.RID), "rid", msg.RID)
+	kicked, err := closeRoom(ctx, msg.RID)
+	if err != nil {
+		writeHTTPError(w, err, proto.ErrIslbUnavailable)
+		return
+	}
+	h.WriteJSON(w, http.StatusOK, util.Map("kicked", kicked))
+}
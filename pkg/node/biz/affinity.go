@@ -0,0 +1,87 @@
+package biz
+
+import (
+	"fmt"
+
+	dis "signal/infra/discovery"
+	"signal/infra/monitor"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/pkg/ws"
+)
+
+// 房间绑定的sfu已满时的处理方式
+const (
+	// OverflowSpill 新的发布流分配到其他sfu,房间绑定不变
+	OverflowSpill = "spill"
+	// OverflowReject 拒绝新的发布流,保证房间内的流都在同一个sfu
+	OverflowReject = "reject"
+	// OverflowRebind 新的发布流分配到其他sfu,并把房间绑定到该sfu
+	OverflowRebind = "rebind"
+)
+
+var (
+	overflowPolicy  = OverflowSpill
+	overflowCounter = monitor.NewMonitorCounter("room_sfu_overflow", "publish not placed on the room bound sfu", []string{"policy"})
+)
+
+// FindSfuNodeByRoom 为房间的新发布流选择sfu,优先选择房间绑定的sfu,dc为客户端所在区域
+// 房间未绑定时绑定到选中的sfu,绑定的sfu已满时按overflowPolicy处理
+// 没有可用的sfu时返回ErrSfuUnavailable,按reject策略拒绝时返回ErrRoomFull
+func FindSfuNodeByRoom(rid, dc string) (*dis.Node, *bus.Error) {
+	bound := GetRoomSfu(rid)
+	sfu := FindSfuNode(dis.SelectOption{Dc: dc, Affinity: bound})
+	if sfu == nil {
+		return nil, proto.NewError(proto.ErrSfuUnavailable)
+	}
+
+	if bound == "" {
+		// 同时有其他发布者绑定了房间时使用已绑定的sfu
+		nid := SetRoomSfu(rid, sfu.Nid, false)
+		if nid != "" && nid != sfu.Nid {
+			if other := FindSfuNode(dis.SelectOption{Dc: dc, Affinity: nid}); other != nil {
+				sfu = other
+			}
+		}
+		return sfu, nil
+	}
+	if sfu.Nid == bound {
+		return sfu, nil
+	}
+
+	// 绑定的sfu已经下线或正在下线时直接重新绑定
+	node := FindSfuNodeByID(bound)
+	if node == nil || node.IsDraining() {
+		logger.Infof(fmt.Sprintf("biz.FindSfuNodeByRoom bound sfu %s unavailable, rebind to %s", bound, sfu.Nid), "rid", rid)
+		SetRoomSfu(rid, sfu.Nid, true)
+		return sfu, nil
+	}
+	// 没有启用affinity策略时不算作溢出
+	if load := node.GetLoad(); !load.Overloaded() {
+		return sfu, nil
+	}
+
+	overflowCounter.WithLabelValues(overflowPolicy).Inc()
+	logger.Warnf(fmt.Sprintf("biz.FindSfuNodeByRoom bound sfu %s is full, policy=%s", bound, overflowPolicy), "rid", rid)
+	switch overflowPolicy {
+	case OverflowReject:
+		return nil, proto.NewError(proto.ErrRoomFull, "sfu "+bound+" is full")
+	case OverflowRebind:
+		SetRoomSfu(rid, sfu.Nid, true)
+	}
+	return sfu, nil
+}
+
+// isCrossDC sfu是否不在客户端所在区域,客户端没有提示区域时以本节点区域为准
+func isCrossDC(peer *ws.Peer, sfu *dis.Node, method string) bool {
+	dc := peer.GetRegion()
+	if dc == "" {
+		dc = node.NodeInfo().Ndc
+	}
+	if sfu.Ndc == dc {
+		return false
+	}
+	crossDCCounter.WithLabelValues(method).Inc()
+	logger.Infof(fmt.Sprintf("biz.%s cross dc access, client dc=%s sfu=%s dc=%s", method, dc, sfu.Nid, sfu.Ndc), "uid", peer.ID())
+	return true
+}
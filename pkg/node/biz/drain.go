@@ -0,0 +1,76 @@
+package biz
+
+import (
+	"fmt"
+	"time"
+
+	"signal/pkg/proto"
+	"signal/pkg/ws"
+)
+
+const drainCheckCycle = time.Second
+
+// IsDraining 节点是否正在下线
+func IsDraining() bool {
+	return node != nil && node.IsDraining()
+}
+
+// Drain 标记节点下线并通知所有客户端迁移,客户端全部离开或超时后返回
+func Drain(timeout time.Duration) {
+	logger.Infof(fmt.Sprintf("biz.Drain start timeout=%v", timeout))
+	if err := node.SetDraining(); err != nil {
+		logger.Errorf(fmt.Sprintf("biz.Drain set draining err=%v", err))
+	}
+
+	nid := node.NodeInfo().Nid
+	roomLock.RLock()
+	for rid, roomNode := range rooms {
+		for uid, peer := range roomNode.room.GetPeers() {
+			peer.Notify(proto.BizToClientOnMigrate, proto.ToMap(&proto.MigrateNotification{
+				RID: rid, UID: uid, NID: nid, Kind: proto.MigrateNode, Reason: "drain",
+			}))
+		}
+	}
+	roomLock.RUnlock()
+
+	deadline := time.Now().Add(timeout)
+	t := time.NewTicker(drainCheckCycle)
+	defer t.Stop()
+	for range t.C {
+		count := peerCount()
+		if count == 0 {
+			logger.Infof("biz.Drain all peers left")
+			return
+		}
+		if time.Now().After(deadline) {
+			logger.Warnf(fmt.Sprintf("biz.Drain timeout, %d peers left", count))
+			return
+		}
+	}
+}
+
+// peerCount 当前节点上的用户数
+func peerCount() int {
+	roomLock.RLock()
+	defer roomLock.RUnlock()
+	count := 0
+	for _, roomNode := range rooms {
+		count += len(roomNode.room.GetPeers())
+	}
+	return count
+}
+
+// dropPeer 下线期间客户端断开后直接从本地房间删除,islb中的数据由新节点覆盖
+func dropPeer(peer *ws.Peer) {
+	roomLock.RLock()
+	rids := make([]string, 0, len(rooms))
+	for rid := range rooms {
+		rids = append(rids, rid)
+	}
+	roomLock.RUnlock()
+	for _, rid := range rids {
+		if GetPeer(rid, peer.ID()) == peer {
+			DelPeer(rid, peer.ID())
+		}
+	}
+}
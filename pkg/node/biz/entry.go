@@ -5,7 +5,6 @@ import (
 	dis "signal/infra/discovery"
 	"signal/infra/monitor"
 	"signal/pkg/proto"
-	"signal/pkg/timing"
 	"signal/pkg/ws"
 	"signal/util"
 )
@@ -39,8 +38,16 @@ func Entry(method string, peer *ws.Peer, msg map[string]interface{}, accept ws.A
 		listusers(peer, msg, accept, reject)
 	case proto.ClientToBizGetRoomLives:
 		listlives(peer, msg, accept, reject)
+	case proto.ClientToBizMessage:
+		message(peer, msg, accept, reject)
+	case proto.ClientToBizGetHistory:
+		history(peer, msg, accept, reject)
+	case proto.ClientToBizRepublish:
+		republish(peer, msg, accept, reject)
+	case proto.ClientToBizGetErrors:
+		accept(proto.ToMap(&proto.ErrorsResponse{Errors: proto.GetErrorCatalogue()}))
 	default:
-		ws.DefaultReject(codeUnknownErr, codeStr(codeUnknownErr))
+		reject(proto.ErrInvalidMethod, codeStr(proto.ErrInvalidMethod))
 	}
 	processTime.Stop()
 	processMetricsGauge.WithLabelValues(method).Set(processTime.GetDuration())
@@ -58,43 +65,58 @@ func Entry(method string, peer *ws.Peer, msg map[string]interface{}, accept ws.A
 // 用户加入房间
 func join(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.join uid=%s msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) {
+	var req proto.JoinRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	info := util.Val(msg, "info")
+	rid := req.RID
+	info := marshalInfo(req.Info)
+
+	// 节点下线中,不再接受新用户
+	if IsDraining() {
+		logger.Warnf("biz.join node is draining", "uid", uid, "rid", rid)
+		reject(proto.ErrBizUnavailable, codeStr(proto.ErrBizUnavailable))
+		return
+	}
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.join islb node not found", "uid", uid, "rid", rid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.join islb rpc not found", "uid", uid, "rid", rid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
 	// 查询uid是否在房间中
 	resp, err := rpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	if err != nil && err.Code != proto.ErrPeerNotFound {
+		logger.Errorf(fmt.Sprintf("biz.join request islb err=%v", err.Reason), "uid", uid, "rid", rid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
 	if err == nil {
 		// uid已经存在，先删除
-		biz := resp["nid"].(string)
+		biz := util.Val(resp, "nid")
 		if biz != node.NodeInfo().Nid {
 			// 不在当前节点
-			rpcBiz := rpcs[biz]
-			if rpcBiz != nil {
-				rpcBiz.SyncRequest(proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid))
+			rpcBiz, find := getRPC(biz)
+			if find {
+				if _, err := rpcBiz.SyncRequest(proto.BizToBizOnKick, util.Map("rid", rid, "uid", uid)); err != nil {
+					logger.Warnf(fmt.Sprintf("biz.join kick %s err=%v", biz, err.Reason), "uid", uid, "rid", rid)
+				}
 			}
 		} else {
 			// 在当前节点
-			rpc.SyncRequest(proto.BizToIslbOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-			rpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-			rpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
+			if err := removePeer(rpc, rid, uid, ""); err != nil {
+				logger.Warnf(fmt.Sprintf("biz.join remove old peer err=%v", err.Reason), "uid", uid, "rid", rid)
+			}
 			// 删除老的peer数据
 			oldpeer := GetPeer(rid, uid)
 			if oldpeer != nil {
@@ -108,7 +130,13 @@ func join(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, rejec
 	// 重新加入房间
 	AddPeer(rid, peer)
 	// 通知房间其他人
-	rpc.SyncRequest(proto.BizToIslbOnJoin, util.Map("rid", rid, "uid", uid, "nid", node.NodeInfo().Nid, "info", info))
+	_, err = rpc.SyncRequest(proto.BizToIslbOnJoin, proto.ToMap(&proto.IslbJoinRequest{RID: rid, UID: uid, NID: node.NodeInfo().Nid, Info: info, AppID: peer.GetAppID()}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.join request islb err=%v", err.Reason), "uid", uid, "rid", rid)
+		DelPeer(rid, uid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
 
 	// 查询房间其他所有用户
 	_, users := FindRoomUsers(uid, rid)
@@ -129,31 +157,35 @@ func join(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, rejec
 // leave 离开房间
 func leave(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.leave uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) {
+	var req proto.LeaveRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
+	rid := req.RID
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.leave islb node not found", "uid", uid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.leave islb rpc not found", "uid", uid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	// 删除加入的房间和流
-	rpc.SyncRequest(proto.BizToIslbOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-	rpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-	rpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
+	// 删除加入的房间和流,islb失败时本节点仍然删除用户,islb上的数据保活超时后删除
+	err := removePeer(rpc, rid, uid, "")
 	DelPeer(rid, uid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.leave request islb err=%v", err.Reason), "uid", uid, "rid", rid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
 
 	// resp
 	accept(emptyMap)
@@ -170,33 +202,34 @@ func leave(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reje
 */
 // keepalive 保活
 func keepalive(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
-	if invalid(msg, "rid", reject) {
+	var req proto.KeepAliveRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	info := util.Val(msg, "info")
+	rid := req.RID
+	info := marshalInfo(req.Info)
 
 	// 判断是否在房间里面
 	room := GetRoom(rid)
 	if room == nil {
 		logger.Errorf("biz.keepalive room doesn't exist", "uid", uid, "rid", rid)
-		reject(codeRIDErr, codeStr(codeRIDErr))
+		reject(proto.ErrRoomNotFound, codeStr(proto.ErrRoomNotFound))
 		return
 	}
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.keepalive islb node found", "uid", uid, "rid", rid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.keepalive islb rpc not found", "uid", uid, "rid", rid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
 	// 通知islb
@@ -223,81 +256,80 @@ func keepalive(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 // publish 发布流
 func publish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.publish uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "jsep", reject) {
+	var req proto.PublishRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	jsep := msg["jsep"].(map[string]interface{})
-	if invalid(jsep, "sdp", reject) {
-		return
-	}
-	minfo, ok := msg["minfo"].(map[string]interface{})
-	if minfo == nil || !ok {
-		logger.Errorf("biz.publish minfo not found", "uid", uid, "rid", rid)
-		reject(codeMinfoErr, codeStr(codeMinfoErr))
-		return
-	}
+	rid := req.RID
+	minfo := req.MInfo
 
 	//add appid into minfo
-	minfo["appid"] = peer.GetAppID()
+	minfo.AppID = peer.GetAppID()
 
 	// 判断是否在房间里面
 	room := GetRoom(rid)
 	if room == nil {
 		logger.Errorf("biz.publish room doesn't exist", "uid", uid, "rid", rid)
-		reject(codeRIDErr, codeStr(codeRIDErr))
+		reject(proto.ErrRoomNotFound, codeStr(proto.ErrRoomNotFound))
 		return
 	}
 
-	// 查询sfu节点
-	sfu := FindSfuNodeByPayload()
-	if sfu == nil {
-		logger.Errorf("biz.publish sfu node not found", "uid", uid, "rid", rid)
-		reject(codeSfuErr, codeStr(codeSfuErr))
+	// 查询sfu节点,同一个房间的发布流优先分配到同一个sfu
+	sfu, sfuErr := FindSfuNodeByRoom(rid, peer.GetRegion())
+	if sfuErr != nil {
+		logger.Errorf(fmt.Sprintf("biz.publish find sfu err=%v", sfuErr.Reason), "uid", uid, "rid", rid)
+		reject(sfuErr.Code, sfuErr.Reason)
 		return
 	}
-	rpcSfu, find := rpcs[sfu.Nid]
+	rpcSfu, find := getRPC(sfu.Nid)
 	if !find {
 		logger.Errorf("biz.publish sfu rpc not found", "uid", uid, "rid", rid)
-		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
 	// 获取sfu节点的resp
-	resp, err := rpcSfu.SyncRequest(proto.BizToSfuPublish, util.Map("rid", rid, "uid", uid, "minfo", minfo, "jsep", jsep))
+	resp, err := rpcSfu.SyncRequest(proto.BizToSfuPublish, proto.ToMap(&proto.SfuPublishRequest{RID: rid, UID: uid, Jsep: req.Jsep, MInfo: minfo}))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.publish request sfu err=%v", err.Reason), "uid", uid, "rid", rid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
 
 	logger.Infof(fmt.Sprintf("biz.publish request sfu resp=%v", resp), "uid", uid, "rid", rid)
 
+	var sfuResp proto.SfuPublishResponse
+	if err := proto.Decode(resp, &sfuResp); err != nil {
+		logger.Errorf(fmt.Sprintf("biz.publish decode sfu resp err=%v", err.Reason), "uid", uid, "rid", rid)
+		reject(proto.ErrPublishFailed, err.Reason)
+		return
+	}
 	nid := sfu.Nid
-	mid := util.Val(resp, "mid")
+	mid := sfuResp.MID
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.publish islb node not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb, find := rpcs[islb.Nid]
+	rpcIslb, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.publish islb rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	// 通知islb,失败时取消sfu上的发布
+	_, err = rpcIslb.SyncRequest(proto.BizToIslbOnStreamAdd, proto.ToMap(&proto.IslbStreamRequest{RID: rid, UID: uid, MID: mid, NID: nid, MInfo: minfo}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.publish request islb err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		rpcSfu.AsyncRequest(proto.BizToSfuUnPublish, proto.ToMap(&proto.SfuUnPublishRequest{RID: rid, UID: uid, MID: mid}))
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
 		return
 	}
-	// 通知islb
-	rpcIslb.SyncRequest(proto.BizToIslbOnStreamAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", minfo))
 	// resp
-	rsp := make(map[string]interface{})
-	rsp["jsep"] = resp["jsep"]
-	rsp["mid"] = mid
-	rsp["nid"] = nid
-	rsp["minfo"] = minfo
-	accept(rsp)
+	accept(proto.ToMap(&proto.PublishResponse{Jsep: sfuResp.Jsep, MID: mid, NID: nid, MInfo: minfo, DC: sfu.Ndc, CrossDC: isCrossDC(peer, sfu, proto.ClientToBizPublish)}))
 }
 
 /*
@@ -313,17 +345,18 @@ func publish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, re
 // unpublish 取消发布流
 func unpublish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.unpublish uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) {
+	var req proto.UnPublishRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
+	rid := req.RID
+	mid := req.MID
 
 	// 查询sfu节点
 	var sfu *dis.Node
-	nid := util.Val(msg, "nid")
+	nid := req.NID
 	if nid != "" {
 		sfu = FindSfuNodeByID(nid)
 	} else {
@@ -331,31 +364,41 @@ func unpublish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 	}
 	if sfu == nil {
 		logger.Errorf("biz.unpublish sfu node not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuErr, codeStr(codeSfuErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
-	rpcSfu, find := rpcs[sfu.Nid]
+	rpcSfu, find := getRPC(sfu.Nid)
 	if !find {
 		logger.Errorf("biz.unpublish sfu rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
+		return
+	}
+	_, err := rpcSfu.SyncRequest(proto.BizToSfuUnPublish, proto.ToMap(&proto.SfuUnPublishRequest{RID: rid, UID: uid, MID: mid}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.unpublish request sfu err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
-	rpcSfu.SyncRequest(proto.BizToSfuUnPublish, util.Map("rid", rid, "uid", uid, "mid", mid))
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.unpublish islb node not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb, find := rpcs[islb.Nid]
+	rpcIslb, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.unpublish islb rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	_, err = rpcIslb.SyncRequest(proto.BizToIslbOnStreamRemove, proto.ToMap(&proto.IslbStreamRemoveRequest{RID: rid, UID: uid, MID: mid}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.unpublish request islb err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
 		return
 	}
-	rpcIslb.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
 	// resp
 	accept(emptyMap)
 }
@@ -379,34 +422,25 @@ func unpublish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 // subscribe 订阅流
 func subscribe(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.subscribe uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) || invalid(msg, "jsep", reject) {
+	var req proto.SubscribeRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
-	jsep := msg["jsep"].(map[string]interface{})
-	if invalid(jsep, "sdp", reject) {
-		return
-	}
-	minfo, ok := msg["minfo"].(map[string]interface{})
-	if !ok {
-		logger.Errorf("biz.subscribe minfo not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeMinfoErr, codeStr(codeMinfoErr))
-		return
-	}
+	rid := req.RID
+	mid := req.MID
 	// 判断是否在房间里面
 	room := GetRoom(rid)
 	if room == nil {
 		logger.Errorf("biz.subscribe room doesn't exist", "uid", uid, "rid", rid)
-		reject(codeRIDErr, codeStr(codeRIDErr))
+		reject(proto.ErrRoomNotFound, codeStr(proto.ErrRoomNotFound))
 		return
 	}
 
 	// 获取sfu节点信息
 	var sfu *dis.Node
-	nid := util.Val(msg, "nid")
+	nid := req.NID
 	if nid != "" {
 		sfu = FindSfuNodeByID(nid)
 	} else {
@@ -414,32 +448,36 @@ func subscribe(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 	}
 	if sfu == nil {
 		logger.Errorf("biz.subscribe sfu not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuErr, codeStr(codeSfuErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
-	rpcSfu, find := rpcs[sfu.Nid]
+	rpcSfu, find := getRPC(sfu.Nid)
 	if !find {
 		logger.Errorf("biz.subscribe sfu rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
+	// 订阅按订阅者的appid计费
+	req.MInfo.AppID = peer.GetAppID()
 	// 获取sfu节点的resp
-	resp, err := rpcSfu.SyncRequest(proto.BizToSfuSubscribe, util.Map("rid", rid, "uid", uid, "mid", mid, "jsep", jsep, "minfo", minfo))
+	resp, err := rpcSfu.SyncRequest(proto.BizToSfuSubscribe, proto.ToMap(&proto.SfuSubscribeRequest{RID: rid, UID: uid, MID: mid, Jsep: req.Jsep, MInfo: req.MInfo}))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.subscribe request sfu err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
 
 	logger.Infof(fmt.Sprintf("biz.subscribe request sfu resp=%v", resp), "uid", uid, "rid", rid, "mid", mid)
 
-	rspSfu := make(map[string]interface{})
-	rspSfu["jsep"] = resp["jsep"]
-	rspSfu["sid"] = resp["mid"]
-	rspSfu["uid"] = resp["uid"]
+	var sfuResp proto.SfuSubscribeResponse
+	if err := proto.Decode(resp, &sfuResp); err != nil {
+		logger.Errorf(fmt.Sprintf("biz.subscribe decode sfu resp err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrSubscribeFailed, err.Reason)
+		return
+	}
 
 	// resp
-	accept(rspSfu)
+	accept(proto.ToMap(&proto.SubscribeResponse{Jsep: sfuResp.Jsep, SID: sfuResp.MID, UID: sfuResp.UID, DC: sfu.Ndc, CrossDC: isCrossDC(peer, sfu, proto.ClientToBizSubscribe)}))
 }
 
 /*
@@ -455,32 +493,34 @@ func subscribe(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 // unsubscribe 取消订阅流
 func unsubscribe(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.unsubscribe uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) {
+	var req proto.UnSubscribeRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
+	rid := req.RID
+	mid := req.MID
 
 	// 获取sfu节点
-	var sfu *dis.Node
-	nid := util.Val(msg, "nid")
-	if nid != "" {
-		sfu = FindSfuNodeByID(nid)
-	}
+	sfu := FindSfuNodeByID(req.NID)
 	if sfu == nil {
 		logger.Errorf("biz.unsubscribe sfu node not found", "uid", uid, "rid", rid, "sid", mid)
-		reject(codeSfuErr, codeStr(codeSfuErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
-	rpcSfu, find := rpcs[sfu.Nid]
+	rpcSfu, find := getRPC(sfu.Nid)
 	if !find {
 		logger.Errorf("biz.unsubscribe sfu rpc not found", "uid", uid, "rid", rid, "sid", mid)
-		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
+		return
+	}
+	_, err := rpcSfu.SyncRequest(proto.BizToSfuUnSubscribe, proto.ToMap(&proto.SfuUnSubscribeRequest{RID: rid, UID: uid, MID: mid}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.unsubscribe request sfu err=%v", err.Reason), "uid", uid, "rid", rid, "sid", mid)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
-	rpcSfu.SyncRequest(proto.BizToSfuUnSubscribe, util.Map("rid", rid, "uid", uid, "mid", mid))
 
 	// resp
 	accept(emptyMap)
@@ -503,19 +543,20 @@ func unsubscribe(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc
 // 启动直播
 func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.startlivestream uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) {
+	var req proto.StartLivestreamRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
-	record := util.InterfaceToInt(msg["record"])
-	index := util.InterfaceToInt(msg["index"])
+	rid := req.RID
+	mid := req.MID
+	record := req.Record
+	index := req.Index
 
 	// 查找sfu节点
 	var sfu *dis.Node
-	nid := util.Val(msg, "nid")
+	nid := req.NID
 	if nid != "" {
 		sfu = FindSfuNodeByID(nid)
 	} else {
@@ -523,13 +564,13 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 	}
 	if sfu == nil {
 		logger.Errorf("biz.startlivestream sfu not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuErr, codeStr(codeSfuErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
-	rpcSfu, find := rpcs[sfu.Nid]
+	rpcSfu, find := getRPC(sfu.Nid)
 	if !find {
 		logger.Errorf("biz.startlivestream sfu rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeSfuRpcErr, codeStr(codeSfuRpcErr))
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
 	}
 
@@ -539,33 +580,33 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 	if mcu == nil {
 		mcu = FindMcuNodeByPayload()
 		if mcu == nil {
-			reject(-1, "mcu finding fail")
+			reject(proto.ErrMcuUnavailable, codeStr(proto.ErrMcuUnavailable))
 			return
 		}
 		mcu = SetMcuNodeByRid(rid, mcu.Nid)
 		if mcu == nil {
-			reject(-1, "mcu binding fail")
+			reject(proto.ErrMcuUnavailable, codeStr(proto.ErrMcuUnavailable))
 			return
 		}
 	}
-	rpcMcu, find := rpcs[mcu.Nid]
+	rpcMcu, find := getRPC(mcu.Nid)
 	if !find {
 		logger.Errorf("biz.startlivestream mcu rpc not found", "uid", uid, "rid", rid, "mid", mid)
-		reject(codeMcuRpcErr, codeStr(codeMcuRpcErr))
+		reject(proto.ErrMcuUnavailable, codeStr(proto.ErrMcuUnavailable))
 		return
 	}
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.startlivestream islb node not found", "uid", uid, "rid", rid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb, find := rpcs[islb.Nid]
+	rpcIslb, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.startlivestream islb rpc not found", "uid", uid, "rid", rid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
 
@@ -573,11 +614,18 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 	islbresp, err := rpcIslb.SyncRequest(proto.BizToIslbGetMediaInfo, util.Map("rid", rid, "uid", uid, "mid", mid))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.startlivestream request islb err =%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
+	var media proto.IslbMediaInfoResponse
+	if err := proto.Decode(islbresp, &media); err != nil || media.MInfo == nil {
+		logger.Errorf("biz.startlivestream media info invalid", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrMediaNotFound, codeStr(proto.ErrMediaNotFound))
 		return
 	}
-	minfo := islbresp["minfo"].(map[string]interface{})
-	minfo["index"] = index
+	minfo := media.MInfo
+	minfo.Index = index
+	minfo.Record = record
 
 	logger.Infof(fmt.Sprintf("biz.startlivestream request islb resp=%v", islbresp), "uid", uid, "rid", rid, "mid", mid)
 
@@ -585,17 +633,17 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 	sfuresp, err := rpcSfu.SyncRequest(proto.BizToSfuSubscribeRTP, util.Map("rid", rid, "uid", mcu.Nid, "mid", mid))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.startlivestream request sfu offer err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
 
 	logger.Infof(fmt.Sprintf("biz.startlivestream request sfu offer resp=%v", sfuresp), "uid", uid, "rid", rid, "mid", mid)
 
 	// 获取mcu节点的resp
-	mcuresp, err := rpcMcu.SyncRequest(proto.BizToMcuPublishRTP, util.Map("appid", peer.GetAppID(), "rid", rid, "record", record, "uid", sfu.Nid, "jsep", sfuresp["jsep"], "minfo", minfo))
+	mcuresp, err := rpcMcu.SyncRequest(proto.BizToMcuPublishRTP, util.Map("appid", peer.GetAppID(), "rid", rid, "record", record, "uid", sfu.Nid, "jsep", sfuresp["jsep"], "minfo", minfo.Map()))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.startlivestream request mcu answer err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrMcuUnavailable)
 		return
 	}
 
@@ -605,30 +653,22 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 	resp, err := rpcSfu.SyncRequest(proto.BizToSfuSubscribeRTP, util.Map("mid", sfuresp["mid"], "rid", rid, "jsep", mcuresp["jsep"]))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.startlivestream request sfu answer err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
 		return
 	}
 
 	logger.Infof(fmt.Sprintf("biz.startlivestream request sfu answer resp=%v", resp), "uid", uid, "rid", rid, "mid", mid)
 
 	// 发送给islb保存
-	_, err = rpcIslb.SyncRequest(proto.BizToIslbOnLiveAdd, util.Map("rid", rid, "uid", uid, "mid", mcuresp["mid"], "nid", mcu.Nid, "minfo", minfo))
+	liveMid := util.Val(mcuresp, "mid")
+	_, err = rpcIslb.SyncRequest(proto.BizToIslbOnLiveAdd, proto.ToMap(&proto.IslbStreamRequest{RID: rid, UID: uid, MID: liveMid, NID: mcu.Nid, MInfo: minfo}))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.startlivestream request islb for liveStreamAdd err=%v", err.Reason), "uid", uid, "rid", rid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
 		return
 	}
-	//start live streaming timer
-	if record == 1 && index == 1 {
-		livestreamtimer := peer.GetLiveStreamTimer()
-		if livestreamtimer == nil {
-			livestreamtimer = timing.NewLiveStreamTimer(rid, uid, peer.GetAppID(), "FHD")
-			peer.SetLiveStreamTimer(livestreamtimer)
-		}
-		livestreamtimer.Start()
-	}
 	// resp
-	accept(util.Map("mcu", mcu.Nid, "mid", mcuresp["mid"]))
+	accept(proto.ToMap(&proto.StartLivestreamResponse{MCU: mcu.Nid, MID: liveMid}))
 }
 
 /*
@@ -647,30 +687,26 @@ func startlivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.Accept
 // 停止直播
 func stoplivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.stoplivestream uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) || invalid(msg, "mid", reject) {
+	var req proto.StopLivestreamRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
+	rid := req.RID
+	mid := req.MID
 
 	// 查询sfu节点
-	nid := util.Val(msg, "nid")
-	if nid == "" {
-		reject(-1, "sfu nid can't be empty")
+	nid := req.NID
+	sfu := FindSfuNodeByID(nid)
+	if sfu == nil {
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
 		return
-	} else {
-		sfu := FindSfuNodeByID(nid)
-		if sfu == nil {
-			reject(-1, fmt.Sprintf("can't find sfu node by nid:%s", nid))
-			return
-		}
 	}
 
 	// 查询mcu节点
 	var mcu *dis.Node
-	mcuid := util.Val(msg, "mcu")
+	mcuid := req.MCU
 	if mcuid != "" {
 		mcu = FindMcuNodeByID(mcuid)
 	} else {
@@ -678,45 +714,36 @@ func stoplivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptF
 	}
 	if mcu == nil {
 		logger.Errorf("biz.stoplivestream mcu node not found", "uid", uid, "rid", rid, "sid", mid)
-		reject(-1, fmt.Sprintf("can't find mcu node by nid :%s or rid:%s", mcuid, rid))
+		reject(proto.ErrMcuUnavailable, codeStr(proto.ErrMcuUnavailable))
 		return
 	}
-	rpcMcu, find := rpcs[mcu.Nid]
+	rpcMcu, find := getRPC(mcu.Nid)
 	if !find {
 		logger.Errorf("biz.stoplivestream mcu rpc not found", "uid", uid, "rid", rid, "sid", mid)
-		reject(codeMcuRpcErr, codeStr(codeMcuRpcErr))
+		reject(proto.ErrMcuUnavailable, codeStr(proto.ErrMcuUnavailable))
 		return
 	}
 	rpcMcu.AsyncRequest(proto.BizToMcuUnpublish, util.Map("rid", rid, "uid", nid, "mid", mid))
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.stoplivestream islb node not found", "uid", uid, "rid", rid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb, find := rpcs[islb.Nid]
+	rpcIslb, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.stoplivestream islb rpc not found", "uid", uid, "rid", rid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	_, err := rpcIslb.SyncRequest(proto.BizToIslbOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
+	_, err := rpcIslb.SyncRequest(proto.BizToIslbOnLiveRemove, proto.ToMap(&proto.IslbStreamRemoveRequest{RID: rid, UID: uid, MID: mid}))
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("biz.stoplivestream request islb for liveStreamRemove err=%v", err.Reason), "uid", uid, "rid", rid)
-		reject(err.Code, err.Reason)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
 		return
 	}
-	// stop live streaming timer
-	if peer.GetLiveStreamTimer() != nil {
-		livestreamtimer := peer.GetLiveStreamTimer()
-		if !livestreamtimer.IsStopped() {
-			livestreamtimer.Stop()
-			reportLiveStreamTiming(livestreamtimer) //sync operation
-			peer.SetLiveStreamTimer(nil)            //del live streaming timer
-		}
-	}
 	// resp
 	accept(emptyMap)
 }
@@ -733,40 +760,175 @@ func stoplivestream(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptF
 // broadcast 客户端发送广播给对方
 func broadcast(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.broadcast uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) {
+	var req proto.BroadcastRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
+	rid := req.RID
+
+	// 判断是否在房间里面
+	if !inRoom(peer, rid, "biz.broadcast", reject) {
+		return
+	}
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.broadcast islb node not found", "uid", uid, "rid", rid)
-		reject(codeIslbErr, codeStr(codeIslbErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb, find := rpcs[islb.Nid]
+	rpcIslb, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.broadcast islb rpc not found", "uid", uid, "rid", rid)
-		reject(codeIslbRpcErr, codeStr(codeIslbRpcErr))
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
 		return
 	}
-	rpcIslb.AsyncRequest(proto.BizToIslbBroadcast, util.Map("rid", rid, "uid", uid, "data", msg["data"]))
+	rpcIslb.AsyncRequest(proto.BizToIslbBroadcast, proto.ToMap(&proto.IslbBroadcastRequest{RID: rid, UID: uid, Data: req.Data}))
 	// resp
 	accept(emptyMap)
 }
 
+/*
+	"request":true
+	"id":3764139
+	"method":"message"
+	"data":{
+		"rid": "room1",
+		"to": "uid1" 或 ["uid1", "uid2"],
+		"data": "$data"
+	}
+*/
+// message 客户端发送消息给指定用户,返回每个用户的送达结果
+func message(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
+	logger.Infof(fmt.Sprintf("biz.message uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
+	var req proto.MessageRequest
+	if !decode(msg, &req, reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := req.RID
+
+	// 判断是否在房间里面
+	if !inRoom(peer, rid, "biz.message", reject) {
+		return
+	}
+
+	msgid := fmt.Sprintf("%s#%s", uid, util.RandStr(8))
+	data := proto.ToMap(&proto.MessageNotification{RID: rid, UID: uid, MsgID: msgid, Data: req.Data})
+	acks := make(map[string]bool)
+	for _, id := range req.To {
+		acks[id] = deliverMessage(rid, id, data)
+	}
+	// resp
+	accept(proto.ToMap(&proto.MessageResponse{MsgID: msgid, Acks: acks}))
+}
+
+// inRoom 检查peer在本节点加入了房间,同一uid在其他连接上加入的也不算
+func inRoom(peer *ws.Peer, rid, where string, reject ws.RejectFunc) bool {
+	if GetRoom(rid) == nil {
+		logger.Errorf(where+" room doesn't exist", "uid", peer.ID(), "rid", rid)
+		reject(proto.ErrRoomNotFound, codeStr(proto.ErrRoomNotFound))
+		return false
+	}
+	if GetPeer(rid, peer.ID()) != peer {
+		logger.Errorf(where+" peer not in room", "uid", peer.ID(), "rid", rid)
+		reject(proto.ErrPeerNotFound, codeStr(proto.ErrPeerNotFound))
+		return false
+	}
+	return true
+}
+
+// deliverMessage 通过islb找到用户所在biz节点并投递消息
+func deliverMessage(rid, to string, data map[string]interface{}) bool {
+	biz := FindBizNodeByUid(rid, to)
+	if biz == nil {
+		logger.Errorf("biz.deliverMessage biz node not found", "uid", to, "rid", rid)
+		return false
+	}
+	if biz.Nid == node.NodeInfo().Nid {
+		peer := GetPeer(rid, to)
+		if peer == nil {
+			return false
+		}
+		peer.Notify(proto.BizToClientOnMessage, data)
+		return true
+	}
+	rpc, find := getRPC(biz.Nid)
+	if !find {
+		logger.Errorf("biz.deliverMessage biz rpc not found", "uid", to, "rid", rid)
+		return false
+	}
+	_, err := rpc.SyncRequest(proto.BizToBizOnMessage, util.Map("rid", rid, "to", to, "data", data))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.deliverMessage request biz err=%v", err.Reason), "uid", to, "rid", rid)
+		return false
+	}
+	return true
+}
+
+/*
+	"request":true
+	"id":3764139
+	"method":"history"
+	"data":{
+		"rid": "room1",
+		"offset": 0,
+		"limit": 50
+	}
+*/
+// history 分页获取房间聊天记录
+func history(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
+	logger.Infof(fmt.Sprintf("biz.history uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
+	var req proto.HistoryRequest
+	if !decode(msg, &req, reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := req.RID
+
+	// 判断是否在房间里面
+	if !inRoom(peer, rid, "biz.history", reject) {
+		return
+	}
+
+	// 查询islb节点
+	islb := FindIslbNode(rid)
+	if islb == nil {
+		logger.Errorf("biz.history islb node not found", "uid", uid, "rid", rid)
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	rpcIslb, find := getRPC(islb.Nid)
+	if !find {
+		logger.Errorf("biz.history islb rpc not found", "uid", uid, "rid", rid)
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	resp, err := rpcIslb.SyncRequest(proto.BizToIslbGetHistory, proto.ToMap(&req))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.history request islb err=%v", err.Reason), "uid", uid, "rid", rid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
+	// resp
+	accept(resp)
+}
+
 // 获取房间其他用户实时流
 func listusers(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.listusers uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) {
+	var req proto.ListUsersRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
+	rid := req.RID
 	// 查询房间其他用户实时流
 	_, users := FindRoomUsers(uid, rid)
 	result := util.Map("users", users)
@@ -776,14 +938,23 @@ func listusers(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc,
 // 获取房间其他用户直播流
 func listlives(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 	logger.Infof(fmt.Sprintf("biz.listlives uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
-	if invalid(msg, "rid", reject) {
+	var req proto.ListLivesRequest
+	if !decode(msg, &req, reject) {
 		return
 	}
 
 	uid := peer.ID()
-	rid := util.Val(msg, "rid")
+	rid := req.RID
 	// 查询房间其他用户直播流
 	_, lives := FindRoomLives(uid, rid)
 	result := util.Map("lives", lives)
 	accept(result)
 }
+
+// marshalInfo 将用户信息转换为json字符串,为空时返回空字符串
+func marshalInfo(info map[string]interface{}) string {
+	if info == nil {
+		return ""
+	}
+	return util.Marshal(info)
+}
@@ -1,74 +1,81 @@
 package biz
 
 import (
+	"net/http"
+
+	h "signal/infra/http"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
 	"signal/pkg/ws"
 	"signal/util"
 )
 
-const (
-	codeOK int = -iota
-	codeUIDErr
-	codeRIDErr
-	codeMIDErr
-	codeJsepErr
-	codeSdpErr
-	codeMinfoErr
-	codePubErr
-	codeSubErr
-	codeSfuErr
-	codeMcuErr
-	codeIslbErr
-	codeSfuRpcErr
-	codeMcuRpcErr
-	codeIslbRpcErr
-	codeUnknownErr
-)
+// codeStr 获取错误码的默认描述
+func codeStr(code int) string {
+	return proto.ErrorMessage(code, "")
+}
 
-var codeErr = map[int]string{
-	codeOK:         "OK",
-	codeUIDErr:     "uid not found",
-	codeRIDErr:     "rid not found",
-	codeMIDErr:     "mid not found",
-	codeJsepErr:    "jsep not found",
-	codeSdpErr:     "sdp not found",
-	codeMinfoErr:   "media info not found",
-	codePubErr:     "pub not found",
-	codeSubErr:     "sub not found",
-	codeSfuErr:     "sfu not found",
-	codeMcuErr:     "mcu not found",
-	codeIslbErr:    "islb not found",
-	codeSfuRpcErr:  "sfu rpc not found",
-	codeMcuRpcErr:  "mcu rpc not found",
-	codeIslbRpcErr: "islb rpc not found",
-	codeUnknownErr: "unknown error",
+// localizeReject 按客户端语言替换错误描述,保留错误详情,lang为空时保留原描述
+func localizeReject(reject ws.RejectFunc, lang string) ws.RejectFunc {
+	if lang == "" {
+		return reject
+	}
+	return func(code int, reason string) {
+		reject(code, proto.LocalizeReason(code, reason, lang))
+	}
 }
 
-func codeStr(code int) string {
-	return codeErr[code]
+// rejectRPC 把rpc错误返回给客户端,节点不可达或已熔断时返回unavailable,超时返回ErrTimeout
+func rejectRPC(reject ws.RejectFunc, err *bus.Error, unavailable int) {
+	if bus.IsUnavailable(err) {
+		reject(unavailable, codeStr(unavailable))
+		return
+	}
+	reject(proto.ErrorCode(err), err.Reason)
+}
+
+// writeHTTPError 以json格式返回http接口的错误,节点不可达或已熔断时返回unavailable
+func writeHTTPError(w http.ResponseWriter, err *bus.Error, unavailable int) {
+	code := proto.ErrorCode(err)
+	if bus.IsUnavailable(err) {
+		code = unavailable
+	}
+	status := http.StatusInternalServerError
+	switch {
+	case code == proto.ErrTimeout:
+		status = http.StatusGatewayTimeout
+	case code >= 4000 && code < 5000:
+		status = http.StatusServiceUnavailable
+	case code == proto.ErrUnauthorized:
+		status = http.StatusUnauthorized
+	case code >= 2000 && code < 3000 || code == proto.ErrRouterNotFound:
+		status = http.StatusNotFound
+	case code >= 1000 && code < 2000:
+		status = http.StatusBadRequest
+	}
+	reason := err.Reason
+	if code != err.Code {
+		reason = codeStr(code)
+	}
+	h.WriteJSON(w, status, util.Map("code", code, "reason", reason))
+}
+
+// readHTTPRequest 解析并校验请求数据
+func readHTTPRequest(req *http.Request, v interface{}) *bus.Error {
+	var data map[string]interface{}
+	if err := h.ReadJSON(req, &data); err != nil {
+		return proto.NewError(proto.ErrInvalidData, err)
+	}
+	return proto.Decode(data, v)
 }
 
 var emptyMap = map[string]interface{}{}
 
-func invalid(msg map[string]interface{}, key string, reject ws.RejectFunc) bool {
-	val := util.Val(msg, key)
-	if val == "" {
-		switch key {
-		case "uid":
-			reject(codeUIDErr, codeStr(codeUIDErr))
-			return true
-		case "rid":
-			reject(codeRIDErr, codeStr(codeRIDErr))
-			return true
-		case "mid":
-			reject(codeMIDErr, codeStr(codeMIDErr))
-			return true
-		case "jsep":
-			reject(codeJsepErr, codeStr(codeJsepErr))
-			return true
-		case "sdp":
-			reject(codeSdpErr, codeStr(codeSdpErr))
-			return true
-		}
+// decode 解析并校验请求数据,失败时reject并返回false
+func decode(msg map[string]interface{}, v interface{}, reject ws.RejectFunc) bool {
+	if err := proto.Decode(msg, v); err != nil {
+		reject(err.Code, err.Reason)
+		return false
 	}
-	return false
+	return true
 }
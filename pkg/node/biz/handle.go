@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"signal/pkg/proto"
 	"signal/pkg/ws"
 	"signal/util"
 
@@ -23,29 +24,45 @@ func in(transport *transport.WebSocketTransport, request *http.Request) {
 	}
 
 	id := peerID[0]
+	// 客户端语言,用于本地化错误描述
+	lang := vars.Get("lang")
 	logger.Infof(fmt.Sprintf("signal.in,id=%s appid=%s", id, appID[0]), "uid", id, "appid", appID[0])
 
 	peer := ws.NewPeer(id, transport)
 	peer.SetAppID(appID[0])
+	// 客户端所在区域,优先分配该区域的sfu
+	peer.SetRegion(vars.Get("region"))
 
 	handleRequest := func(request map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
 		defer util.Recover("signal.in handleRequest")
+		reject = localizeReject(reject, lang)
 		method := util.Val(request, "method")
 		totalRequestCounter.WithLabelValues(method).Inc()
 		if method == "" {
 			logger.Errorf(fmt.Sprintf("method=%s", method), "uid", id)
-			reject(-1, ws.ErrInvalidMethod)
+			reject(proto.ErrInvalidMethod, codeStr(proto.ErrInvalidMethod))
+			return
+		}
+
+		if !allowRequest(peer, method) {
+			logger.Warnf(fmt.Sprintf("signal.in handleRequest rate limited method=%s", method), "uid", id, "appid", appID[0])
+			reject(proto.ErrRateLimited, codeStr(proto.ErrRateLimited))
 			return
 		}
 
 		data := request["data"]
 		if data == nil {
 			logger.Errorf(fmt.Sprintf("data=%s", data), "uid", id)
-			reject(-1, ws.ErrInvalidData)
+			reject(proto.ErrInvalidData, codeStr(proto.ErrInvalidData))
 			return
 		}
 
-		msg := data.(map[string]interface{})
+		msg, ok := data.(map[string]interface{})
+		if !ok {
+			logger.Errorf(fmt.Sprintf("data=%v", data), "uid", id)
+			reject(proto.ErrInvalidData, codeStr(proto.ErrInvalidData))
+			return
+		}
 		logger.Infof(fmt.Sprintf("signal.in handleRequest id=%s,method=%s", peer.ID(), method), "uid", id)
 		wsReq(method, peer, msg, accept, reject)
 	}
@@ -55,31 +72,36 @@ func in(transport *transport.WebSocketTransport, request *http.Request) {
 		method := util.Val(notification, "method")
 		if method == "" {
 			logger.Errorf(fmt.Sprintf("method=%s", method), "uid", id)
-			ws.DefaultReject(-1, ws.ErrInvalidMethod)
+			ws.DefaultReject(proto.ErrInvalidMethod, codeStr(proto.ErrInvalidMethod))
+			return
+		}
+
+		if !allowRequest(peer, method) {
+			logger.Warnf(fmt.Sprintf("signal.in handleNotification rate limited method=%s", method), "uid", id, "appid", appID[0])
 			return
 		}
 
 		data := notification["data"]
 		if data == nil {
 			logger.Errorf(fmt.Sprintf("data=%s", data), "uid", id)
-			ws.DefaultReject(-1, ws.ErrInvalidData)
+			ws.DefaultReject(proto.ErrInvalidData, codeStr(proto.ErrInvalidData))
 			return
 		}
 
-		msg := data.(map[string]interface{})
+		msg, ok := data.(map[string]interface{})
+		if !ok {
+			logger.Errorf(fmt.Sprintf("data=%v", data), "uid", id)
+			ws.DefaultReject(proto.ErrInvalidData, codeStr(proto.ErrInvalidData))
+			return
+		}
 		logger.Infof(fmt.Sprintf("signal.in handleNotification id=%s, method=%s", peer.ID(), method), "uid", id)
 		wsReq(method, peer, msg, ws.DefaultAccept, ws.DefaultReject)
 	}
 
 	handleClose := func(code int, err string) {
 		logger.Infof(fmt.Sprintf("signal.in handleClose = peer %s", peer.ID()), "uid", id)
-
-		livestreamtimer := peer.GetLiveStreamTimer()
-		if livestreamtimer != nil {
-			if !livestreamtimer.IsStopped() {
-				livestreamtimer.Stop()
-				reportLiveStreamTiming(livestreamtimer)
-			}
+		if IsDraining() {
+			dropPeer(peer)
 		}
 		peer.Close()
 	}
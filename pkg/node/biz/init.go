@@ -1,39 +1,68 @@
 package biz
 
 import (
-	"errors"
+	"fmt"
+	"sync"
+
 	dis "signal/infra/discovery"
 	logger2 "signal/infra/logger"
 	"signal/infra/monitor"
+	"signal/pkg/bus"
 	"signal/pkg/log"
 	"signal/pkg/proto"
-	"signal/pkg/timing"
 	"signal/util"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 var (
 	logger              *logger2.Logger
-	nats                *nprotoo.NatsProtoo
+	nats                bus.Bus
 	node                *dis.ServiceNode
 	watch               *dis.ServiceWatcher
-	rpcs                = make(map[string]*nprotoo.Requestor)
+	rpcs                = make(map[string]*bus.Client)
+	rpcsLock            sync.RWMutex
 	totalRequestCounter = monitor.NewMonitorCounter("req_counter", "signal service request counter", []string{"method"})
 	totalConnections    = monitor.NewMonitorGauge("clients", "signal service node total clients", []string{"signalServices"})
 	processMetricsGauge = monitor.NewMonitorGauge("processing_time", "signal service request processing time metrics", []string{"method"})
+	// sfuSelector sfu选择策略,默认在本区域内优先选择房间绑定的sfu,再按负载选择
+	sfuSelector dis.Selector = &dis.DCPreference{Next: &dis.RoomAffinity{Next: &dis.LeastLoaded{}}}
+	// topology 区域之间的延迟,用于就近选择其他区域的节点
+	topology       dis.Topology
+	crossDCCounter = monitor.NewMonitorCounter("cross_dc_counter", "media placed on a sfu outside the client region", []string{"method"})
 )
 
 // Init 初始化服务
-func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, natsURL string, log *logger2.Logger) {
+func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, b bus.Bus, log *logger2.Logger) {
 	logger = log
 	node = serviceNode
 	watch = ServiceWatcher
-	nats = nprotoo.NewNatsProtoo(util.GenerateNatsUrlString(natsURL))
+	nats = b
 	handleRPCRequest(node.GetRPCChannel())
 	go watch.WatchServiceNode("", WatchServiceCallBack)
 }
 
+// InitBalance 设置sfu选择策略、房间绑定的sfu已满时的处理方式和区域之间的延迟
+// strategies为空时在本区域内优先选择房间绑定的sfu,再按负载选择
+func InitBalance(strategies []string, weights dis.Weights, fallback bool, overflow string, dcs dis.Topology) error {
+	if len(strategies) == 0 {
+		strategies = []string{dis.StrategyDC, dis.StrategyAffinity}
+	}
+	selector, err := dis.NewSelector(strategies, weights, fallback, dcs)
+	if err != nil {
+		return err
+	}
+	switch overflow {
+	case "":
+		overflow = OverflowSpill
+	case OverflowSpill, OverflowReject, OverflowRebind:
+	default:
+		return fmt.Errorf("unknown overflow policy %s", overflow)
+	}
+	sfuSelector = selector
+	overflowPolicy = overflow
+	topology = dcs
+	return nil
+}
+
 // Close 关闭连接
 func Close() {
 	if nats != nil {
@@ -56,24 +85,36 @@ func WatchServiceCallBack(state dis.NodeStateType, node dis.Node) {
 			nats.OnBroadcast(eventID, handleBroadcast)
 		}
 
-		id := node.Nid
-		_, found := rpcs[id]
-		if !found {
-			rpcID := dis.GetRPCChannel(node)
-			rpcs[id] = nats.NewRequestor(rpcID)
+		rpcsLock.Lock()
+		if _, found := rpcs[node.Nid]; !found {
+			rpcs[node.Nid] = newClient(node)
 		}
+		rpcsLock.Unlock()
 	} else if state == dis.ServerDown {
+		rpcsLock.Lock()
 		delete(rpcs, node.Nid)
+		rpcsLock.Unlock()
 	}
 }
 
-// FindIslbNode 查询全局的可用的islb节点
-func FindIslbNode() *dis.Node {
-	servers, find := watch.GetNodes("islb")
+// getRPC 获取发往节点的请求对象,节点未上线时返回false
+func getRPC(nid string) (*bus.Client, bool) {
+	rpcsLock.RLock()
+	defer rpcsLock.RUnlock()
+	c, found := rpcs[nid]
+	return c, found
+}
+
+// FindIslbNode 根据rid查询房间所在的islb分片
+// 房间按rid一致性哈希分布到所有islb节点上,所有islb都在下线时就近选择一个
+func FindIslbNode(rid string) *dis.Node {
+	islb, find := watch.GetNodeByHash("islb", rid)
 	if find {
-		for _, node := range servers {
-			return &node
-		}
+		return islb
+	}
+	islb, find = watch.GetNodeByDC(node.NodeInfo().Ndc, "islb", topology)
+	if find {
+		return islb
 	}
 	return nil
 }
@@ -89,14 +130,14 @@ func FindBizNodeByID(nid string) *dis.Node {
 
 // FindBizNodeByUid 根据rid, uid查询指定的biz节点
 func FindBizNodeByUid(rid, uid string) *dis.Node {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindBizNodeByUid islb not found")
 		return nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindBizNodeByUid islb rpc not found")
 		return nil
@@ -134,7 +175,16 @@ func FindSfuNodeByID(nid string) *dis.Node {
 
 // FindSfuNodeByPayload 查询指定区域下的可用的sfu节点
 func FindSfuNodeByPayload() *dis.Node {
-	sfu, find := watch.GetNodeByPayload(node.NodeInfo().Ndc, "sfu")
+	return FindSfuNode(dis.SelectOption{})
+}
+
+// FindSfuNode 按sfu选择策略查询可用的sfu节点,opt.Dc为空时使用本节点的区域
+func FindSfuNode(opt dis.SelectOption) *dis.Node {
+	if opt.Dc == "" {
+		opt.Dc = node.NodeInfo().Ndc
+	}
+	opt.Unhealthy = isUnhealthy
+	sfu, find := watch.SelectNode("sfu", sfuSelector, opt)
 	if find {
 		return sfu
 	}
@@ -143,14 +193,14 @@ func FindSfuNodeByPayload() *dis.Node {
 
 // FindSfuNodeByMid 根据rid, mid查询指定的sfu节点
 func FindSfuNodeByMid(rid, mid string) *dis.Node {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindSfuNodeByMid islb not found")
 		return nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindSfuNodeByMid islb rpc not found")
 		return nil
@@ -181,9 +231,10 @@ func FindMcuNodeByID(nid string) *dis.Node {
 	return nil
 }
 
-// FindMcuNodeByPayload 查询指定区域下的可用的mcu节点
+// FindMcuNodeByPayload 查询指定区域下的可用的mcu节点,没有时就近选择其他区域
 func FindMcuNodeByPayload() *dis.Node {
-	mcu, find := watch.GetNodeByPayload(node.NodeInfo().Ndc, "mcu")
+	selector := &dis.DCPreference{Next: &dis.LeastLoaded{}, Topology: topology}
+	mcu, find := watch.SelectNode("mcu", selector, dis.SelectOption{Dc: node.NodeInfo().Ndc, Unhealthy: isUnhealthy})
 	if find {
 		return mcu
 	}
@@ -192,14 +243,14 @@ func FindMcuNodeByPayload() *dis.Node {
 
 // FindMcuNodeByRid 根据rid查询指定的mcu节点
 func FindMcuNodeByRid(rid string) *dis.Node {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindMcuNodeByRid islb not found")
 		return nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindMcuNodeByRid islb rpc not found")
 		return nil
@@ -223,14 +274,14 @@ func FindMcuNodeByRid(rid string) *dis.Node {
 
 // SetMcuNodeByRid 设置rid跟mcu绑定关系
 func SetMcuNodeByRid(rid, nid string) *dis.Node {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("SetMcuNodeByRid islb not found")
 		return nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("SetMcuNodeByRid islb rpc not found")
 		return nil
@@ -252,16 +303,62 @@ func SetMcuNodeByRid(rid, nid string) *dis.Node {
 	return mcu
 }
 
+// GetRoomSfu 查询房间绑定的sfu节点id,未绑定时返回空
+func GetRoomSfu(rid string) string {
+	islb := FindIslbNode(rid)
+	if islb == nil {
+		log.Errorf("GetRoomSfu islb not found")
+		return ""
+	}
+
+	rpc, find := getRPC(islb.Nid)
+	if !find {
+		log.Errorf("GetRoomSfu islb rpc not found")
+		return ""
+	}
+
+	resp, err := rpc.SyncRequest(proto.BizToIslbGetRoomSfu, proto.ToMap(&proto.IslbRoomSfuRequest{RID: rid}))
+	if err != nil {
+		log.Errorf(err.Reason)
+		return ""
+	}
+	return util.Val(resp, "nid")
+}
+
+// SetRoomSfu 设置rid跟sfu绑定关系,force为false时房间已绑定则不修改,返回实际绑定的sfu节点id
+func SetRoomSfu(rid, nid string, force bool) string {
+	islb := FindIslbNode(rid)
+	if islb == nil {
+		log.Errorf("SetRoomSfu islb not found")
+		return ""
+	}
+
+	rpc, find := getRPC(islb.Nid)
+	if !find {
+		log.Errorf("SetRoomSfu islb rpc not found")
+		return ""
+	}
+
+	resp, err := rpc.SyncRequest(proto.BizToIslbSetRoomSfu, proto.ToMap(&proto.IslbRoomSfuRequest{RID: rid, NID: nid, Force: force}))
+	if err != nil {
+		log.Errorf(err.Reason)
+		return ""
+	}
+
+	log.Infof("SetRoomSfu resp ==> %v", resp)
+	return util.Val(resp, "nid")
+}
+
 // FindRoomUsers 获取房间其他用户实时流
 func FindRoomUsers(uid, rid string) (bool, []interface{}) {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindRoomUsers islb not found")
 		return false, nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindRoomUsers islb rpc not found")
 		return false, nil
@@ -286,14 +383,14 @@ func FindRoomUsers(uid, rid string) (bool, []interface{}) {
 
 // FindRoomLives 获取房间其他用户直播流
 func FindRoomLives(uid, rid string) (bool, []interface{}) {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindRoomLives islb not found")
 		return false, nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindRoomLives islb rpc not found")
 		return false, nil
@@ -319,14 +416,14 @@ func FindRoomLives(uid, rid string) (bool, []interface{}) {
 /*
 // FindMediaPubs 查询房间所有人的发布流
 func FindMediaPubs(uid, rid string) (bool, []interface{}) {
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("FindMediaPubs islb not found")
 		return false, nil
 	}
 
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("FindMediaPubs islb rpc not found")
 		return false, nil
@@ -349,69 +446,18 @@ func FindMediaPubs(uid, rid string) (bool, []interface{}) {
 	return true, pubs
 }*/
 
-// findIssrNode 查询全局的可用的Issr节点
-func findIssrNode() *dis.Node {
-	servers, find := watch.GetNodes("issr")
-	if find {
-		for _, node := range servers {
-			return &node
-		}
-	}
-	return nil
-}
-
-// getIssrRequestor 查询issr服务的节点id
-func getIssrRequestor() *nprotoo.Requestor {
-	issr := findIssrNode()
-	if issr == nil {
-		log.Errorf("issr node not found")
-		return nil
-	}
-
-	find := false
-	rpc, find := rpcs[issr.Nid]
-	if !find {
-		log.Errorf("issr rpc not found")
-		return nil
-	}
-	return rpc
-}
-
-// getIslbRequestor 查询islb服务的节点ID
-func getIslbRequestor() *nprotoo.Requestor {
-	islb := FindIslbNode()
+// getIslbRequestor 查询房间所在islb分片的rpc对象
+func getIslbRequestor(rid string) bus.Requestor {
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		log.Errorf("islb node not found")
 		return nil
 	}
 	find := false
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		log.Errorf("islb rpc not found")
 		return nil
 	}
 	return rpc
 }
-
-func reportLiveStreamTiming(timer *timing.LiveStreamTimer) error {
-	log.Infof("reportLiveStreamTiming rid:%s uid:%s resolution:%s,seconds:%d", timer.RID, timer.UID, timer.Resolution,
-		timer.GetTotalSeconds())
-
-	seconds := timer.GetTotalSeconds()
-
-	if seconds != 0 {
-		msg := util.Map("appid", timer.AppID, "rid", timer.RID, "uid", timer.UID,
-			"mediatype", timer.GetMode(), "resolution", timer.Resolution, "seconds", seconds, "type", 700) //700 dedicate to live streaming record
-		issrRpc := getIssrRequestor()
-		if issrRpc == nil {
-			log.Errorf("can't found issr node")
-			return errors.New("can't found issr node")
-		}
-		_, err := issrRpc.SyncRequest(proto.BizToIssrReportStreamState, msg)
-		if err != nil {
-			log.Errorf(err.Reason)
-			return errors.New(err.Reason)
-		}
-	}
-	return nil
-}
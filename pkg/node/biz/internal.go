@@ -2,10 +2,10 @@ package biz
 
 import (
 	"fmt"
+	"signal/pkg/bus"
 	"signal/pkg/proto"
+	"signal/pkg/ws"
 	"signal/util"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 // 接收biz消息处理
@@ -15,21 +15,14 @@ func handleRPCRequest(rpcID string) {
 }
 
 // 处理biz的rpc请求
-func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
-	go func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
+func handleRpcMsg(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
+	go func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
 		defer util.Recover("biz.handleRPCRequest")
 		logger.Infof(fmt.Sprintf("biz.handleRPCRequest recv request=%v", request))
 
-		method := request["method"].(string)
-		data := request["data"].(map[string]interface{})
-		var result map[string]interface{}
-		err := &nprotoo.Error{Code: 400, Reason: fmt.Sprintf("Unkown method [%s]", method)}
-
-		switch method {
-		/* 处理和biz服务器通信 */
-		case proto.BizToBizOnKick:
-			result, err = peerKick(data)
-		}
+		method := util.Val(request, "method")
+		data, _ := request["data"].(map[string]interface{})
+		result, err := dispatch(method, data)
 		if err != nil {
 			reject(err.Code, err.Reason)
 		} else {
@@ -38,29 +31,72 @@ func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, rej
 	}(request, accept, reject)
 }
 
+// dispatch 处理发给本节点的请求
+func dispatch(method string, data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	switch method {
+	/* 处理和biz服务器通信 */
+	case proto.BizToBizOnKick:
+		return peerKick(data)
+	case proto.BizToBizOnMessage:
+		return peerMessage(data)
+	case proto.BizToBizStartLive:
+		return peerLive(data, &proto.BizStartLiveRequest{}, startlivestream)
+	case proto.BizToBizStopLive:
+		return peerLive(data, &proto.BizStopLiveRequest{}, stoplivestream)
+	case proto.AdminToBizMigratePub:
+		return migratePublisher(data)
+	}
+	return nil, proto.NewError(proto.ErrInvalidMethod, method)
+}
+
+// callPeer 经islb找到用户所在的biz并把请求发给该biz,用户在本节点时直接处理
+func callPeer(rid, uid, method string, data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	rpc := getIslbRequestor(rid)
+	if rpc == nil {
+		return nil, proto.NewError(proto.ErrIslbUnavailable)
+	}
+	resp, err := rpc.SyncRequest(proto.BizToIslbGetBizInfo, util.Map("rid", rid, "uid", uid))
+	if err != nil {
+		return nil, err
+	}
+	nid := util.Val(resp, "nid")
+	if nid == node.NodeInfo().Nid {
+		return dispatch(method, data)
+	}
+	rpcBiz, find := getRPC(nid)
+	if !find {
+		return nil, proto.NewError(proto.ErrBizUnavailable, nid)
+	}
+	return rpcBiz.SyncRequest(method, data)
+}
+
 /*
 	"method", proto.BizToBizOnKick, "rid", rid, "uid", uid
 */
 // 踢出房间
-func peerKick(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
+func peerKick(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.BizKickRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
 
 	// 查询islb节点
-	islb := FindIslbNode()
+	islb := FindIslbNode(rid)
 	if islb == nil {
 		logger.Errorf("biz.peerKick islb node not found", "uid", uid, "rid", rid)
-		return nil, &nprotoo.Error{Code: -1, Reason: "islb node not found"}
+		return nil, proto.NewError(proto.ErrIslbUnavailable)
 	}
-	rpc, find := rpcs[islb.Nid]
+	rpc, find := getRPC(islb.Nid)
 	if !find {
 		logger.Errorf("biz.peerKick islb rpc not found", "uid", uid, "rid", rid)
-		return nil, &nprotoo.Error{Code: -1, Reason: "islb node not found"}
+		return nil, proto.NewError(proto.ErrIslbUnavailable)
 	}
 
-	rpc.SyncRequest(proto.BizToIslbOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-	rpc.SyncRequest(proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", ""))
-	rpc.SyncRequest(proto.BizToIslbOnLeave, util.Map("rid", rid, "uid", uid))
+	if err := removePeer(rpc, rid, uid, proto.LeaveReasonKick); err != nil {
+		logger.Warnf(fmt.Sprintf("biz.peerKick request islb err=%v", err.Reason), "uid", uid, "rid", rid)
+	}
 
 	peer := GetPeer(rid, uid)
 	if peer != nil {
@@ -71,13 +107,62 @@ func peerKick(data map[string]interface{}) (map[string]interface{}, *nprotoo.Err
 	return util.Map(), nil
 }
 
+/*
+	"method", proto.BizToBizOnMessage, "rid", rid, "to", to, "data", data
+*/
+// 投递消息给本节点上的用户
+func peerMessage(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.BizMessageRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	to := req.To
+	peer := GetPeer(rid, to)
+	if peer == nil {
+		logger.Errorf("biz.peerMessage peer not found", "uid", to, "rid", rid)
+		return nil, proto.NewError(proto.ErrPeerNotFound, to)
+	}
+	peer.Notify(proto.BizToClientOnMessage, proto.ToMap(req.Data))
+	return util.Map(), nil
+}
+
+/*
+	"method", proto.BizToBizStartLive, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "record", record, "index", index
+	"method", proto.BizToBizStopLive, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "mcu", mcu
+*/
+// 以本节点上用户的身份开始或停止直播,直播计时由issr根据islb的直播广播和心跳统计
+func peerLive(data map[string]interface{}, req interface{}, handler func(*ws.Peer, map[string]interface{}, ws.AcceptFunc, ws.RejectFunc)) (map[string]interface{}, *bus.Error) {
+	if err := proto.Decode(data, req); err != nil {
+		return nil, err
+	}
+	rid := util.Val(data, "rid")
+	uid := util.Val(data, "uid")
+	peer := GetPeer(rid, uid)
+	if peer == nil {
+		logger.Errorf("biz.peerLive peer not found", "uid", uid, "rid", rid)
+		return nil, proto.NewError(proto.ErrPeerNotFound, uid)
+	}
+	var result map[string]interface{}
+	var err *bus.Error
+	handler(peer, data, func(resp map[string]interface{}) {
+		result = resp
+	}, func(code int, reason string) {
+		err = &bus.Error{Code: code, Reason: reason}
+	})
+	return result, err
+}
+
 // handleBroadCastMsgs 处理广播消息
 func handleBroadcast(msg map[string]interface{}, subj string) {
 	defer util.Recover("biz.handleBroadcast")
 	logger.Infof(fmt.Sprintf("biz.handleBroadcast msg=%v", msg))
 
 	method := util.Val(msg, "method")
-	data := msg["data"].(map[string]interface{})
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		return
+	}
 
 	rid := util.Val(data, "rid")
 	uid := util.Val(data, "uid")
@@ -94,6 +179,9 @@ func handleBroadcast(msg map[string]interface{}, subj string) {
 	case proto.IslbToBizOnStreamRemove:
 		/* "method", proto.IslbToBizOnStreamRemove, "rid", rid, "uid", uid, "mid", mid */
 		NotifyAllWithoutID(rid, uid, proto.BizToClientOnStreamRemove, data)
+	case proto.IslbToBizOnStreamUpdate:
+		/* "method", proto.IslbToBizOnStreamUpdate, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", data["minfo"] */
+		NotifyAllWithoutID(rid, uid, proto.BizToClientOnStreamUpdate, data)
 	case proto.IslbToBizBroadcast:
 		/* "method", proto.IslbToBizBroadcast, "rid", rid, "uid", uid, "data", data */
 		NotifyAllWithoutID(rid, uid, proto.BizToClientBroadcast, data)
@@ -101,20 +189,11 @@ func handleBroadcast(msg map[string]interface{}, subj string) {
 		NotifyAllWithoutID(rid, uid, proto.BizToClientOnLiveStreamAdd, data)
 	case proto.IslbToBizOnLiveRemove:
 		NotifyAllWithoutID(rid, uid, proto.BizToClientOnLiveStreamRemove, data)
-		stopLiveStreamTimerByRIDUID(rid, uid)
-	}
-}
-
-func stopLiveStreamTimerByRIDUID(rid, uid string) {
-	roomNode := GetRoom(rid)
-	if roomNode != nil {
-		peer := roomNode.room.GetPeer(uid)
-		if peer != nil && peer.GetLiveStreamTimer() != nil {
-			if !peer.GetLiveStreamTimer().IsStopped() {
-				peer.GetLiveStreamTimer().Stop()
-				reportLiveStreamTiming(peer.GetLiveStreamTimer())
-				peer.SetLiveStreamTimer(nil)
-			}
+	case proto.IslbToBizOnMigrate:
+		/* "method", proto.IslbToBizOnMigrate, "rid", rid, "uid", uid, "kind", kind, "nid", nid, "mid", mid, "sid", sid */
+		peer := GetPeer(rid, uid)
+		if peer != nil {
+			peer.Notify(proto.BizToClientOnMigrate, data)
 		}
 	}
 }
@@ -0,0 +1,168 @@
+package biz
+
+import (
+	"fmt"
+	"time"
+
+	dis "signal/infra/discovery"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/pkg/ws"
+	"signal/util"
+)
+
+// 迁移后旧sfu上的发布流保留的时间,给订阅者切换留出时间
+const migrateGracePeriod = 10 * time.Second
+
+/*
+  "request":true
+  "id":3764139
+  "method":"republish"
+  "data":{
+      "rid":"room1",
+      "mid":"64236c21-21e8-4a3d-9f80-c767d1e1d67f#ABCDEF",
+      "nid":"shenzhen-sfu-2",
+      "jsep": {"type": "offer","sdp": "..."},
+      "minfo": {...}
+  }
+*/
+// republish 将发布流迁移到其他sfu,mid保持不变,订阅者收到stream-update后重新订阅
+func republish(peer *ws.Peer, msg map[string]interface{}, accept ws.AcceptFunc, reject ws.RejectFunc) {
+	logger.Infof(fmt.Sprintf("biz.republish uid=%s,msg=%v", peer.ID(), msg), "uid", peer.ID())
+	var req proto.RepublishRequest
+	if !decode(msg, &req, reject) {
+		return
+	}
+
+	uid := peer.ID()
+	rid := req.RID
+	mid := req.MID
+	minfo := req.MInfo
+	minfo.AppID = peer.GetAppID()
+
+	if proto.GetUIDFromMID(mid) != uid {
+		logger.Errorf("biz.republish mid not belong to peer", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrPubNotFound, codeStr(proto.ErrPubNotFound))
+		return
+	}
+	if GetRoom(rid) == nil {
+		logger.Errorf("biz.republish room doesn't exist", "uid", uid, "rid", rid)
+		reject(proto.ErrRoomNotFound, codeStr(proto.ErrRoomNotFound))
+		return
+	}
+
+	// 查询原来的sfu节点
+	source := FindSfuNodeByMid(rid, mid)
+	if source == nil {
+		logger.Errorf("biz.republish source sfu not found", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrPubNotFound, codeStr(proto.ErrPubNotFound))
+		return
+	}
+	// 查询目标sfu节点
+	var target *dis.Node
+	if req.NID != "" {
+		target = FindSfuNodeByID(req.NID)
+		if target != nil && target.IsDraining() {
+			target = nil
+		}
+	} else {
+		target = FindSfuNode(dis.SelectOption{Dc: peer.GetRegion(), Except: source.Nid})
+	}
+	if target == nil || target.Nid == source.Nid {
+		logger.Errorf("biz.republish target sfu not found", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
+		return
+	}
+	rpcSfu, find := getRPC(target.Nid)
+	if !find {
+		logger.Errorf("biz.republish sfu rpc not found", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrSfuUnavailable, codeStr(proto.ErrSfuUnavailable))
+		return
+	}
+	// 在目标sfu上以相同的mid发布
+	resp, err := rpcSfu.SyncRequest(proto.BizToSfuPublish, proto.ToMap(&proto.SfuPublishRequest{RID: rid, UID: uid, MID: mid, Jsep: req.Jsep, MInfo: minfo}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.republish request sfu err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		rejectRPC(reject, err, proto.ErrSfuUnavailable)
+		return
+	}
+	var sfuResp proto.SfuPublishResponse
+	if err := proto.Decode(resp, &sfuResp); err != nil {
+		logger.Errorf(fmt.Sprintf("biz.republish decode sfu resp err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrPublishFailed, err.Reason)
+		return
+	}
+
+	// 通知islb更新流对应的sfu
+	islb := FindIslbNode(rid)
+	if islb == nil {
+		logger.Errorf("biz.republish islb node not found", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	rpcIslb, find := getRPC(islb.Nid)
+	if !find {
+		logger.Errorf("biz.republish islb rpc not found", "uid", uid, "rid", rid, "mid", mid)
+		reject(proto.ErrIslbUnavailable, codeStr(proto.ErrIslbUnavailable))
+		return
+	}
+	_, err = rpcIslb.SyncRequest(proto.BizToIslbOnStreamUpdate, proto.ToMap(&proto.IslbStreamRequest{RID: rid, UID: uid, MID: mid, NID: target.Nid, MInfo: minfo}))
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("biz.republish request islb err=%v", err.Reason), "uid", uid, "rid", rid, "mid", mid)
+		rejectRPC(reject, err, proto.ErrIslbUnavailable)
+		return
+	}
+
+	// 房间绑定在原来的sfu时跟随迁移
+	sourceNid := source.Nid
+	if GetRoomSfu(rid) == sourceNid {
+		SetRoomSfu(rid, target.Nid, true)
+	}
+
+	// 订阅者切换完成后移除旧sfu上的发布流
+	time.AfterFunc(migrateGracePeriod, func() {
+		defer util.Recover("biz.republish")
+		if rpc, find := getRPC(sourceNid); find {
+			rpc.AsyncRequest(proto.BizToSfuUnPublish, proto.ToMap(&proto.SfuUnPublishRequest{RID: rid, UID: uid, MID: mid}))
+		}
+	})
+
+	logger.Infof(fmt.Sprintf("biz.republish migrated from %s to %s", sourceNid, target.Nid), "uid", uid, "rid", rid, "mid", mid)
+	accept(proto.ToMap(&proto.PublishResponse{Jsep: sfuResp.Jsep, MID: mid, NID: target.Nid, MInfo: minfo, DC: target.Ndc, CrossDC: isCrossDC(peer, target, proto.ClientToBizRepublish)}))
+}
+
+/*
+	"method", proto.AdminToBizMigratePub, "rid", rid, "mid", mid, "nid", nid
+*/
+// migratePublisher 通知发布者将流迁移到其他sfu,发布者不在本节点时转发给对应的biz
+func migratePublisher(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.AdminMigrateRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := proto.GetUIDFromMID(req.MID)
+
+	peer := GetPeer(rid, uid)
+	if peer != nil {
+		peer.Notify(proto.BizToClientOnMigrate, proto.ToMap(&proto.MigrateNotification{
+			RID: rid, UID: uid, Kind: proto.MigratePublish, NID: req.NID, MID: req.MID, Reason: "admin",
+		}))
+		return util.Map(), nil
+	}
+
+	biz := FindBizNodeByUid(rid, uid)
+	if biz == nil || biz.Nid == node.NodeInfo().Nid {
+		logger.Errorf("biz.migratePublisher peer not found", "uid", uid, "rid", rid, "mid", req.MID)
+		return nil, proto.NewError(proto.ErrPeerNotFound, uid)
+	}
+	rpc, find := getRPC(biz.Nid)
+	if !find {
+		logger.Errorf("biz.migratePublisher biz rpc not found", "uid", uid, "rid", rid, "mid", req.MID)
+		return nil, proto.NewError(proto.ErrBizUnavailable)
+	}
+	if _, err := rpc.SyncRequest(proto.AdminToBizMigratePub, proto.ToMap(&req)); err != nil {
+		return nil, err
+	}
+	return util.Map(), nil
+}
@@ -0,0 +1,81 @@
+package biz
+
+import (
+	"time"
+
+	"signal/infra/monitor"
+	"signal/pkg/ratelimit"
+	"signal/pkg/ws"
+)
+
+const (
+	// 规则中未列出的方法使用default规则
+	defaultRateRule  = "default"
+	rateCleanupCycle = time.Minute
+)
+
+var (
+	peerLimiters       map[string]*ratelimit.Limiter
+	appLimiters        map[string]*ratelimit.Limiter
+	rateLimitedCounter = monitor.NewMonitorCounter("rate_limited", "signal service rate limited request counter", []string{"method", "scope"})
+)
+
+// InitRateLimit 初始化限流规则,peer规则按用户限流,appid规则按应用限流
+func InitRateLimit(peerRules, appRules map[string]ratelimit.Rule) {
+	peerLimiters = newLimiters(peerRules)
+	appLimiters = newLimiters(appRules)
+	go cleanupLimiters()
+}
+
+func newLimiters(rules map[string]ratelimit.Rule) map[string]*ratelimit.Limiter {
+	limiters := make(map[string]*ratelimit.Limiter)
+	for method, rule := range rules {
+		if rule.Rate <= 0 {
+			continue
+		}
+		limiters[method] = ratelimit.NewLimiter(rule)
+	}
+	return limiters
+}
+
+// findLimiter 查找方法对应的限流器
+func findLimiter(limiters map[string]*ratelimit.Limiter, method string) *ratelimit.Limiter {
+	if l, ok := limiters[method]; ok {
+		return l
+	}
+	return limiters[defaultRateRule]
+}
+
+// allowRequest 判断请求是否超过限流,超过时返回false并计数
+// 用户按appid/uid限流,不同应用的同名用户互不影响,被应用限流拒绝时归还用户取走的令牌
+func allowRequest(peer *ws.Peer, method string) bool {
+	appid := peer.GetAppID()
+	key := appid + "/" + peer.ID()
+	pl := findLimiter(peerLimiters, method)
+	if pl != nil && !pl.Allow(key) {
+		rateLimitedCounter.WithLabelValues(method, "peer").Inc()
+		return false
+	}
+	if l := findLimiter(appLimiters, method); l != nil && !l.Allow(appid) {
+		if pl != nil {
+			pl.Return(key)
+		}
+		rateLimitedCounter.WithLabelValues(method, "appid").Inc()
+		return false
+	}
+	return true
+}
+
+// cleanupLimiters 定时删除闲置的令牌桶
+func cleanupLimiters() {
+	t := time.NewTicker(rateCleanupCycle)
+	defer t.Stop()
+	for range t.C {
+		for _, l := range peerLimiters {
+			l.Cleanup()
+		}
+		for _, l := range appLimiters {
+			l.Cleanup()
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package biz
+
+import (
+	"fmt"
+	"strings"
+
+	dis "signal/infra/discovery"
+	"signal/infra/monitor"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+// 发往其他节点的请求按方法设置超时,查询类的幂等方法超时或节点不可达时重试
+// 节点连续失败后熔断,熔断期间请求直接失败,选择sfu和mcu时跳过该节点
+
+var (
+	readPolicy   = bus.Policy{Timeout: 3000, Retries: 2, Backoff: 100}
+	removePolicy = bus.Policy{Timeout: 3000, Retries: 1, Backoff: 100}
+	mediaPolicy  = bus.Policy{Timeout: 10000}
+	// livePolicy 开始直播需要依次请求islb、sfu和mcu
+	livePolicy = bus.Policy{Timeout: 30000}
+
+	// rpcPolicies 默认的方法调用策略,没有列出的方法使用rpcConfig.Default
+	rpcPolicies = map[string]bus.Policy{
+		proto.BizToIslbGetBizInfo:   readPolicy,
+		proto.BizToIslbGetSfuInfo:   readPolicy,
+		proto.BizToIslbGetMcuInfo:   readPolicy,
+		proto.BizToIslbGetRoomSfu:   readPolicy,
+		proto.BizToIslbGetRoomUsers: readPolicy,
+		proto.BizToIslbGetRoomLives: readPolicy,
+		proto.BizToIslbGetMediaInfo: readPolicy,
+		proto.BizToIslbGetHistory:   readPolicy,
+		proto.BizToIslbKeepAlive:    readPolicy,
+		// sfu和mcu的取消发布、取消订阅重复执行没有副作用
+		proto.BizToSfuUnPublish:   removePolicy,
+		proto.BizToSfuUnSubscribe: removePolicy,
+		// 媒体协商耗时较长,不重试
+		proto.BizToSfuPublish:      mediaPolicy,
+		proto.BizToSfuSubscribe:    mediaPolicy,
+		proto.BizToSfuSubscribeRTP: mediaPolicy,
+		proto.BizToMcuPublishRTP:   mediaPolicy,
+		proto.BizToBizStartLive:    livePolicy,
+	}
+	rpcConfig = &bus.ClientConfig{
+		Default:       bus.Policy{Timeout: bus.DefaultCallTimeout},
+		Policies:      rpcPolicies,
+		OnStateChange: onBreakerChange,
+	}
+	breakerGauge = monitor.NewMonitorGauge("rpc_breaker_open", "node circuit breaker is open", []string{"nid"})
+)
+
+// InitRPC 设置方法的调用策略和熔断参数,methods中的default为没有列出的方法的策略
+// 方法名不区分大小写,需在Init之前调用
+func InitRPC(methods map[string]bus.Policy, breaker bus.BreakerConfig) {
+	policies := make(map[string]bus.Policy)
+	for method, policy := range rpcPolicies {
+		policies[method] = policy
+	}
+	config := &bus.ClientConfig{
+		Default:       rpcConfig.Default,
+		Policies:      policies,
+		Breaker:       breaker,
+		OnStateChange: onBreakerChange,
+	}
+	for name, policy := range methods {
+		if name == "default" {
+			config.Default = policy
+			continue
+		}
+		policies[methodName(name)] = policy
+	}
+	rpcConfig = config
+}
+
+// methodName 配置文件中的方法名会被转换为小写,按协议中的方法名还原
+func methodName(name string) string {
+	for _, method := range proto.NodeMethods {
+		if strings.EqualFold(method, name) {
+			return method
+		}
+	}
+	return name
+}
+
+// newClient 创建发往节点的请求对象
+func newClient(node dis.Node) *bus.Client {
+	return bus.NewClient(node.Nid, nats.NewRequestor(dis.GetRPCChannel(node)), rpcConfig)
+}
+
+// removePeer 通知islb删除用户的直播流、发布流和用户,每个失败都记录日志,
+// 返回的错误使用第一个失败的错误码,描述包含所有失败的方法
+func removePeer(rpc bus.Requestor, rid, uid, reason string) *bus.Error {
+	requests := []struct {
+		method string
+		data   map[string]interface{}
+	}{
+		{proto.BizToIslbOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", "")},
+		{proto.BizToIslbOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", "")},
+		{proto.BizToIslbOnLeave, proto.ToMap(&proto.IslbLeaveRequest{RID: rid, UID: uid, Reason: reason})},
+	}
+	var first *bus.Error
+	var reasons []string
+	for _, r := range requests {
+		if _, err := rpc.SyncRequest(r.method, r.data); err != nil {
+			logger.Warnf(fmt.Sprintf("biz.removePeer %s err=%v", r.method, err.Reason), "uid", uid, "rid", rid)
+			if first == nil {
+				first = err
+			}
+			reasons = append(reasons, r.method+": "+err.Reason)
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return &bus.Error{Code: first.Code, Reason: strings.Join(reasons, "; ")}
+}
+
+// onBreakerChange 节点熔断状态变化
+func onBreakerChange(nid string, state bus.BreakerState) {
+	logger.Warnf(fmt.Sprintf("biz.rpc node %s circuit %s", nid, state), "nid", nid)
+	if state == bus.BreakerOpen {
+		breakerGauge.WithLabelValues(nid).Set(1)
+	} else {
+		breakerGauge.WithLabelValues(nid).Set(0)
+	}
+}
+
+// isUnhealthy 节点是否已熔断,选择节点时跳过
+func isUnhealthy(nid string) bool {
+	c, find := getRPC(nid)
+	return find && !c.Available()
+}
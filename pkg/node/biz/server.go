@@ -0,0 +1,221 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	h "signal/infra/http"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+// 应用服务端接口,应用服务端不需要以用户身份连接信令,通过http直接操作房间
+// 请求按infra/http.Sign签名,经islb找到用户所在的biz,再由该biz请求sfu和mcu
+// 房间不按appid区分,每个请求都检查房间属于签名的appid,只能操作自己应用的房间
+// 房间所属的应用由创建房间的用户决定,房间没有用户后清除
+
+const (
+	// serverSender 应用服务端发送广播和消息时默认的发送者
+	serverSender = "server"
+	// defaultSignWindow 请求时间允许的偏差,秒
+	defaultSignWindow = 300
+)
+
+// InitServerAPI 在g下注册应用服务端接口,secrets为appid到密钥的映射,为空时不开启
+// window为请求时间允许的偏差,秒,为0时使用默认值
+func InitServerAPI(g *h.PathGroup, secrets map[string]string, window int) {
+	if len(secrets) == 0 {
+		return
+	}
+	if window <= 0 {
+		window = defaultSignWindow
+	}
+	sign := h.SignFilter(func(appid string) string {
+		return secrets[appid]
+	}, time.Duration(window)*time.Second)
+	g.Post("/server/"+proto.ServerBroadcast, serverBroadcast, sign)
+	g.Post("/server/"+proto.ServerMessage, serverMessage, sign)
+	g.Post("/server/"+proto.ServerKick, serverKick, sign)
+	g.Post("/server/"+proto.ServerClose, serverClose, sign)
+	g.Post("/server/"+proto.ServerParticipants, serverParticipants, sign)
+	g.Post("/server/"+proto.ServerStartLive, serverStartLive, sign)
+	g.Post("/server/"+proto.ServerStopLive, serverStopLive, sign)
+	g.Post("/server/"+proto.ServerStartRecord, serverStartRecord, sign)
+	g.Post("/server/"+proto.ServerStopRecord, serverStopLive, sign)
+}
+
+// readServerRequest 解析请求数据并记录日志,失败时返回错误响应
+func readServerRequest(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := readHTTPRequest(req, v); err != nil {
+		writeHTTPError(w, err, 0)
+		return false
+	}
+	logger.Infof(fmt.Sprintf("biz.server %s appid=%s req=%+v", req.URL.Path, req.Header.Get(h.HeaderAppID), v))
+	return true
+}
+
+// checkRoomApp 检查房间属于签名的appid,不属于时返回403,房间不存在时返回404
+func checkRoomApp(ctx context.Context, w http.ResponseWriter, req *http.Request, rid string) (*proto.RoomInfo, bool) {
+	info, err := getRoomInfo(ctx, rid)
+	if err != nil {
+		writeHTTPError(w, err, proto.ErrIslbUnavailable)
+		return nil, false
+	}
+	if appid := req.Header.Get(h.HeaderAppID); info.AppID != appid {
+		logger.Warnf(fmt.Sprintf("biz.server %s appid=%s room belongs to appid=%s", req.URL.Path, appid, info.AppID), "rid", rid)
+		h.WriteJSON(w, http.StatusForbidden, util.Map("code", proto.ErrUnauthorized, "reason", proto.NewError(proto.ErrUnauthorized, "room belongs to another app").Reason))
+		return nil, false
+	}
+	return info, true
+}
+
+// checkSender 检查广播和消息的发送者,为空时使用server,否则必须是房间中的用户
+func checkSender(w http.ResponseWriter, info *proto.RoomInfo, from string) (string, bool) {
+	if from == "" || from == serverSender {
+		return serverSender, true
+	}
+	for _, user := range info.Users {
+		if user.UID == from {
+			return from, true
+		}
+	}
+	logger.Warnf(fmt.Sprintf("biz.server sender %s not in room", from), "rid", info.RID)
+	writeHTTPError(w, proto.NewError(proto.ErrPeerNotFound, from), 0)
+	return "", false
+}
+
+// writeServerResult 返回请求结果
+func writeServerResult(w http.ResponseWriter, result interface{}, err *bus.Error, unavailable int) {
+	if err != nil {
+		writeHTTPError(w, err, unavailable)
+		return
+	}
+	if m, ok := result.(map[string]interface{}); result == nil || ok && m == nil {
+		result = emptyMap
+	}
+	h.WriteJSON(w, http.StatusOK, result)
+}
+
+// serverBroadcast 向房间发送广播,保存到聊天记录
+func serverBroadcast(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.ServerBroadcastRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	info, ok := checkRoomApp(ctx, w, req, msg.RID)
+	if !ok {
+		return
+	}
+	if msg.From, ok = checkSender(w, info, msg.From); !ok {
+		return
+	}
+	rpc := getIslbRequestor(msg.RID)
+	if rpc == nil {
+		writeHTTPError(w, proto.NewError(proto.ErrIslbUnavailable), 0)
+		return
+	}
+	resp, err := rpc.Call(ctx, proto.BizToIslbBroadcast, proto.ToMap(&proto.IslbBroadcastRequest{RID: msg.RID, UID: msg.From, Data: msg.Data}))
+	writeServerResult(w, resp, err, proto.ErrIslbUnavailable)
+}
+
+// serverMessage 给指定用户发送消息,返回每个用户的送达结果
+func serverMessage(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.ServerMessageRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	info, ok := checkRoomApp(ctx, w, req, msg.RID)
+	if !ok {
+		return
+	}
+	if msg.From, ok = checkSender(w, info, msg.From); !ok {
+		return
+	}
+	msgid := fmt.Sprintf("%s#%s", msg.From, util.RandStr(8))
+	data := proto.ToMap(&proto.MessageNotification{RID: msg.RID, UID: msg.From, MsgID: msgid, Data: msg.Data})
+	acks := make(map[string]bool)
+	for _, id := range msg.To {
+		acks[id] = deliverMessage(msg.RID, id, data)
+	}
+	h.WriteJSON(w, http.StatusOK, proto.MessageResponse{MsgID: msgid, Acks: acks})
+}
+
+// serverKick 踢出用户
+func serverKick(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.BizKickRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	if _, ok := checkRoomApp(ctx, w, req, msg.RID); !ok {
+		return
+	}
+	writeServerResult(w, nil, kickPeer(msg.RID, msg.UID), proto.ErrBizUnavailable)
+}
+
+// serverClose 关闭房间,踢出所有用户
+func serverClose(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.ServerRoomRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	if _, ok := checkRoomApp(ctx, w, req, msg.RID); !ok {
+		return
+	}
+	kicked, err := closeRoom(ctx, msg.RID)
+	writeServerResult(w, &proto.ServerCloseResponse{Kicked: kicked}, err, proto.ErrIslbUnavailable)
+}
+
+// serverParticipants 查询房间的用户、实时流和直播流
+func serverParticipants(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.ServerRoomRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	info, ok := checkRoomApp(ctx, w, req, msg.RID)
+	if !ok {
+		return
+	}
+	h.WriteJSON(w, http.StatusOK, proto.ServerParticipantsResponse{RID: info.RID, Users: info.Users, Pubs: info.Pubs, Lives: info.Lives})
+}
+
+// startLive 由用户所在的biz以用户的身份开始直播
+func startLive(ctx context.Context, w http.ResponseWriter, req *http.Request, record bool) {
+	var msg proto.BizStartLiveRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	if _, ok := checkRoomApp(ctx, w, req, msg.RID); !ok {
+		return
+	}
+	if record {
+		msg.Record = 1
+	}
+	resp, err := callPeer(msg.RID, msg.UID, proto.BizToBizStartLive, proto.ToMap(&msg))
+	writeServerResult(w, resp, err, proto.ErrBizUnavailable)
+}
+
+// serverStartLive 以用户的身份开始直播
+func serverStartLive(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	startLive(ctx, w, req, false)
+}
+
+// serverStartRecord 以用户的身份开始直播并录制
+func serverStartRecord(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	startLive(ctx, w, req, true)
+}
+
+// serverStopLive 以用户的身份停止直播或录制
+func serverStopLive(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var msg proto.BizStopLiveRequest
+	if !readServerRequest(w, req, &msg) {
+		return
+	}
+	if _, ok := checkRoomApp(ctx, w, req, msg.RID); !ok {
+		return
+	}
+	resp, err := callPeer(msg.RID, msg.UID, proto.BizToBizStopLive, proto.ToMap(&msg))
+	writeServerResult(w, resp, err, proto.ErrBizUnavailable)
+}
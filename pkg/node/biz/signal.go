@@ -50,14 +50,14 @@ func checkRoom() {
 				biz := FindBizNodeByUid(rid, uid)
 				if biz == nil {
 					// 查询islb节点
-					islb := FindIslbNode()
+					islb := FindIslbNode(rid)
 					if islb == nil {
 						logger.Errorf("biz.checkRoom islb node not found", "uid", uid, "rid", rid)
 						continue
 					}
 
 					find := false
-					rpc, find := rpcs[islb.Nid]
+					rpc, find := getRPC(islb.Nid)
 					if !find {
 						logger.Errorf("biz.checkRoom islb rpc not found", "uid", uid, "rid", rid)
 						continue
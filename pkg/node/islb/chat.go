@@ -0,0 +1,102 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"signal/infra/mysql"
+	"signal/pkg/bus"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+const (
+	defaultHistorySize  = 1000
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+	chatArchiveTable    = "chat_history"
+)
+
+var (
+	historySize = defaultHistorySize
+	archive     *mysql.MysqlDriver
+)
+
+// InitChat 初始化聊天记录,size为每个房间保留的条数,mysql host为空时不归档
+func InitChat(size int, config mysql.MysqlConfig) {
+	if size > 0 {
+		historySize = size
+	}
+	if config.Host == "" {
+		return
+	}
+	archive = mysql.NewMysqlDriver(config)
+	_, err := archive.DbCon.Exec("CREATE TABLE IF NOT EXISTS `" + chatArchiveTable + "` (" +
+		"`id` BIGINT NOT NULL AUTO_INCREMENT," +
+		"`msgid` VARCHAR(64) NOT NULL," +
+		"`rid` VARCHAR(128) NOT NULL," +
+		"`uid` VARCHAR(128) NOT NULL," +
+		"`data` TEXT," +
+		"`time` BIGINT NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `idx_rid_time` (`rid`, `time`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.InitChat create archive table err=%v", err))
+	}
+}
+
+// saveHistory 保存一条房间聊天记录,存储中只保留最近historySize条
+func saveHistory(msgid, rid, uid string, data interface{}, ts int64) error {
+	record := util.Map("msgid", msgid, "rid", rid, "uid", uid, "data", data, "time", ts)
+	err := rooms.AddHistory(rid, util.Marshal(record), historySize)
+	if err != nil {
+		return err
+	}
+
+	if archive != nil {
+		str, _ := util.InterfaceToJsonString(data)
+		_, err = archive.Insert(chatArchiveTable, []string{"msgid", "rid", "uid", "data", "time"},
+			[][]interface{}{{msgid, rid, uid, str, ts}})
+		if err != nil {
+			logger.Errorf(fmt.Sprintf("islb.saveHistory archive err=%v", err), "rid", rid, "uid", uid)
+		}
+	}
+	return nil
+}
+
+/*
+	"method", proto.BizToIslbGetHistory, "rid", rid, "offset", offset, "limit", limit
+*/
+// 分页获取房间聊天记录,按时间倒序返回
+func getHistory(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.HistoryRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	offset := int64(req.Offset)
+	limit := int64(req.Limit)
+	if limit == 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	total, records, err := rooms.GetHistory(rid, offset, limit)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getHistory GetHistory err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	messages := make([]map[string]interface{}, 0)
+	for _, str := range records {
+		messages = append(messages, util.Unmarshal(str))
+	}
+	return util.Map("rid", rid, "total", total, "offset", offset, "messages", messages), nil
+}
+
+// newMessageID 生成消息id
+func newMessageID(uid string) string {
+	return fmt.Sprintf("%s#%d%s", uid, time.Now().UnixNano()/int64(time.Millisecond), util.RandStr(4))
+}
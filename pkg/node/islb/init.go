@@ -1,45 +1,49 @@
 package node
 
 import (
+	"fmt"
 	logger2 "signal/infra/logger"
 	"time"
 
 	dis "signal/infra/discovery"
 	"signal/infra/monitor"
-	db "signal/infra/redis"
-	"signal/util"
-
-	nprotoo "github.com/gearghost/nats-protoo"
+	"signal/pkg/bus"
+	"signal/pkg/store"
 )
 
 const (
-	redisShort  = 60 * time.Second
-	redisKeyTTL = 24 * time.Hour
+	// memberTTL 用户保活时间,biz定时保活
+	memberTTL = 60 * time.Second
+	// liveHeartbeatCycle 上报直播流的周期,issr据此计时
+	liveHeartbeatCycle = 30 * time.Second
 )
 
 var (
 	logger             *logger2.Logger
-	nats               *nprotoo.NatsProtoo
-	broadcaster        *nprotoo.Broadcaster
-	redis              *db.Redis
+	nats               bus.Bus
+	broadcaster        bus.Broadcaster
+	rooms              store.RoomStore
 	node               *dis.ServiceNode
 	watch              *dis.ServiceWatcher
 	rpcCounter         = monitor.NewMonitorCounter("islb_rpc_counter", "islb rpc request counter", []string{"method"})
 	rpcProcessingGauge = monitor.NewMonitorGauge("islb_rpc_processing_time", "islb rpc request processing time", []string{"method"})
+	misroutedCounter   = monitor.NewMonitorCounter("islb_misrouted_counter", "islb rpc request for a room owned by another islb", []string{"method"})
 )
 
 // Init 初始化服务
-func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, natsURL string, config db.Config, log *logger2.Logger) {
+func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, b bus.Bus, roomStore store.RoomStore, log *logger2.Logger) {
 	// 赋值
 	node = serviceNode
 	watch = ServiceWatcher
-	nats = nprotoo.NewNatsProtoo(util.GenerateNatsUrlString(natsURL))
+	nats = b
 	broadcaster = nats.NewBroadcaster(node.GetEventChannel())
-	redis = db.NewRedis(config)
+	rooms = roomStore
 	logger = log
 	// 启动
 	handleRPCRequest(node.GetRPCChannel())
+	go migrateLegacy()
 	go watch.WatchServiceNode("", WatchServiceCallBack)
+	go liveHeartbeat()
 }
 
 // Close 关闭连接
@@ -53,15 +57,45 @@ func Close() {
 	if watch != nil {
 		watch.Close()
 	}
+	if rooms != nil {
+		rooms.Close()
+	}
+}
+
+// migrateLegacy 存储支持时迁移旧版本的房间数据
+func migrateLegacy() {
+	migrator, ok := rooms.(interface {
+		MigrateLegacy(owner string) (int, error)
+	})
+	if !ok {
+		return
+	}
+	total, err := migrator.MigrateLegacy(node.NodeInfo().Nid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.migrateLegacy err=%v", err))
+	}
+	if total > 0 {
+		logger.Infof(fmt.Sprintf("islb.migrateLegacy migrated %d keys", total))
+	}
 }
 
 // WatchServiceCallBack 查看所有的Node节点
 func WatchServiceCallBack(state dis.NodeStateType, node dis.Node) {
 	if state == dis.ServerUp {
-		// 处理sfu和mcu发送的广播
+		// 处理sfu和mcu发送的广播,每个islb都会收到,只处理自己负责的房间
 		if node.Name == "sfu" || node.Name == "mcu" {
 			eventID := dis.GetEventChannel(node)
-			nats.OnBroadcastWithGroup(eventID, "islb", handleBroadcast)
+			nats.OnBroadcast(eventID, handleBroadcast)
 		}
 	}
 }
+
+// ownsRoom 房间是否由本节点负责
+// 房间按rid一致性哈希分布到所有islb节点上,找不到负责的节点时由本节点处理
+func ownsRoom(rid string) bool {
+	owner, find := watch.GetNodeByHash("islb", rid)
+	if !find {
+		return true
+	}
+	return owner.Nid == node.NodeInfo().Nid
+}
@@ -1,13 +1,14 @@
 package node
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
-
-	nprotoo "github.com/gearghost/nats-protoo"
+	"time"
 
 	"signal/infra/monitor"
+	"signal/pkg/bus"
 	"signal/pkg/proto"
+	"signal/pkg/store"
 	"signal/util"
 )
 
@@ -15,39 +16,71 @@ import (
 func handleBroadcast(msg map[string]interface{}, subj string) {
 	go func(msg map[string]interface{}, subj string) {
 		method := util.Val(msg, "method")
-		data := msg["data"].(map[string]interface{})
+		data, ok := msg["data"].(map[string]interface{})
+		if !ok {
+			return
+		}
 
 		rid := util.Val(data, "rid")
 		uid := util.Val(data, "uid")
 		mid := util.Val(data, "mid")
 		switch method {
 		case proto.SfuToIslbOnStreamRemove:
-			sfuRemoveStream(mid)
+			sfuRemoveStream(mid, util.Val(data, "nid"))
 		case proto.McuToIslbOnStreamRemove:
-			mcuRemoveStream(rid, uid, mid)
+			if ownsRoom(rid) {
+				mcuRemoveStream(rid, uid, mid)
+			}
 		case proto.McuToIslbOnRoomRemove:
-			mcuRemoveRoom(rid)
+			if ownsRoom(rid) {
+				mcuRemoveRoom(rid)
+			}
+		case proto.SfuToIslbOnDrain:
+			sfuDrain(data)
 		}
 	}(msg, subj)
 }
 
-// 处理sfu移除流
-func sfuRemoveStream(key string) {
-	msid := strings.Split(key, "/")
-	if len(msid) < 6 {
+// 处理sfu移除流,nid为发出通知的sfu,流已经迁移到其他sfu时忽略
+func sfuRemoveStream(key, nid string) {
+	kind, rid, uid, mid, ok := proto.ParseMediaKey(key)
+	if !ok || kind != "pub" || mid == "" {
 		logger.Errorf("islb.SfuRemoveStream key is err", "mid", key)
 		return
 	}
-
-	rid := msid[3]
-	uid := msid[5]
-	mid := msid[7]
+	if !ownsRoom(rid) {
+		return
+	}
+	if nid != "" {
+		pub, err := rooms.GetStream(store.Media, rid, uid, mid)
+		if err != nil {
+			logger.Errorf(fmt.Sprintf("islb.sfuRemoveStream GetStream err=%v", err), "rid", rid, "uid", uid, "mid", mid)
+		} else if pub != nil && pub.NID != "" && pub.NID != nid {
+			logger.Infof(fmt.Sprintf("islb.sfuRemoveStream stream migrated rid=%s, uid=%s, mid=%s, nid=%s", rid, uid, mid, pub.NID))
+			return
+		}
+	}
 
 	logger.Infof(fmt.Sprintf("islb.sfuRemoveStream rid=%s, uid=%s, mid=%s", rid, uid, mid))
 	data := util.Map("rid", rid, "uid", uid, "mid", mid)
 	streamRemove(data)
 }
 
+// 处理sfu下线,通知用户迁移流
+func sfuDrain(data map[string]interface{}) {
+	var msg proto.SfuDrainNotification
+	if err := proto.Decode(data, &msg); err != nil {
+		logger.Errorf(fmt.Sprintf("islb.sfuDrain decode err=%v", err.Reason))
+		return
+	}
+	logger.Infof(fmt.Sprintf("islb.sfuDrain nid=%s streams=%d", msg.NID, len(msg.Streams)))
+	for _, stream := range msg.Streams {
+		if ownsRoom(stream.RID) {
+			broadcaster.Say(proto.IslbToBizOnMigrate, proto.ToMap(stream))
+		}
+	}
+}
+
 // 处理mcu移除流
 func mcuRemoveStream(rid, uid, mid string) {
 	logger.Infof(fmt.Sprintf("islb.mcuRemoveStream rid=%s, uid=%s, mid=%s", rid, uid, mid))
@@ -68,15 +101,19 @@ func handleRPCRequest(rpcID string) {
 }
 
 // 处理rpc请求
-func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
-	rpcCounter.WithLabelValues(request["method"].(string)).Inc()
-	go func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
+func handleRpcMsg(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
+	rpcCounter.WithLabelValues(util.Val(request, "method")).Inc()
+	go func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
 		defer util.Recover("islb.handleRPCRequest")
-		method := request["method"].(string)
-		data := request["data"].(map[string]interface{})
+		method := util.Val(request, "method")
+		data, _ := request["data"].(map[string]interface{})
+		// 成员变化期间biz可能发到旧的分片,数据在redis中共享,照常处理
+		if rid := util.Val(data, "rid"); rid != "" && !ownsRoom(rid) {
+			misroutedCounter.WithLabelValues(method).Inc()
+		}
 
 		var result map[string]interface{}
-		err := &nprotoo.Error{Code: 400, Reason: fmt.Sprintf("Unkown method [%s]", method)}
+		err := proto.NewError(proto.ErrInvalidMethod, method)
 
 		processingTime := monitor.NewProcessingTimeGauge(method)
 		processingTime.Start()
@@ -95,6 +132,8 @@ func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, rej
 			result, err = streamAdd(data)
 		case proto.BizToIslbOnStreamRemove:
 			result, err = streamRemove(data)
+		case proto.BizToIslbOnStreamUpdate:
+			result, err = streamUpdate(data)
 		case proto.BizToIslbGetSfuInfo:
 			result, err = getSfuByMid(data)
 
@@ -108,6 +147,10 @@ func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, rej
 			result, err = setMcuInfo(data)
 		case proto.BizToIslbGetMediaInfo:
 			result, err = getMediaInfo(data)
+		case proto.BizToIslbGetRoomSfu:
+			result, err = getRoomSfu(data)
+		case proto.BizToIslbSetRoomSfu:
+			result, err = setRoomSfu(data)
 
 		case proto.BizToIslbBroadcast:
 			result, err = broadcast(data)
@@ -115,6 +158,12 @@ func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, rej
 			result, err = getRoomUsers(data)
 		case proto.BizToIslbGetRoomLives:
 			result, err = getRoomLives(data)
+		case proto.BizToIslbGetHistory:
+			result, err = getHistory(data)
+		case proto.BizToIslbGetRooms:
+			result, err = getRooms()
+		case proto.BizToIslbGetRoomInfo:
+			result, err = getRoomInfo(data)
 
 		}
 		processingTime.Stop()
@@ -129,62 +178,84 @@ func handleRpcMsg(request map[string]interface{}, accept nprotoo.AcceptFunc, rej
 
 }
 
+// roomApp 房间所属的appid,广播时带上,用于issr推送webhook
+func roomApp(rid string) string {
+	appid, err := rooms.GetBinding(store.BindApp, rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.roomApp GetBinding err=%v", err), "rid", rid)
+	}
+	return appid
+}
+
 /*
-	"method", proto.BizToIslbOnJoin, "rid", rid, "uid", uid, "nid", nid, "info", info
+	"method", proto.BizToIslbOnJoin, "rid", rid, "uid", uid, "nid", nid, "info", info, "appid", appid
 */
 // 有人加入房间
-func clientJoin(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func clientJoin(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.clientJoin data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	nid := util.Val(data, "nid")
-	info := util.Val(data, "info")
-	// 获取用户的服务器信息
-	uKey := proto.GetUserNodeKey(rid, uid)
-	err := redis.Set(uKey, nid, redisShort)
-	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.clientJoin redis.Set err=%v", err), "rid", rid, "uid", uid)
-		return nil, &nprotoo.Error{Code: 401, Reason: fmt.Sprintf("clientJoin err=%v", err)}
+	var req proto.IslbJoinRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
 	}
-	// 获取用户的信息
-	uKey = proto.GetUserInfoKey(rid, uid)
-	err = redis.Set(uKey, info, redisShort)
+	rid := req.RID
+	uid := req.UID
+	nid := req.NID
+	info := req.Info
+	appid := req.AppID
+	// 保存用户的服务器信息和用户信息,created表示加入前房间没有用户
+	created, err := rooms.Join(rid, store.Member{UID: uid, NID: nid, Info: info}, memberTTL)
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.clientJoin redis.Set err=%v", err), "rid", rid, "uid", uid)
-		return nil, &nprotoo.Error{Code: 401, Reason: fmt.Sprintf("clientJoin err=%v", err)}
+		logger.Errorf(fmt.Sprintf("islb.clientJoin Join err=%v", err), "rid", rid, "uid", uid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if appid != "" {
+		// 房间所属的应用由创建房间的用户决定,房间移除时清除,其他应用的用户加入时只记录日志
+		bound, err := rooms.SetBinding(store.BindApp, rid, appid, created)
+		if err != nil {
+			logger.Errorf(fmt.Sprintf("islb.clientJoin SetBinding err=%v", err), "rid", rid, "uid", uid)
+		} else if bound != appid {
+			logger.Warnf(fmt.Sprintf("islb.clientJoin appid=%s room belongs to appid=%s", appid, bound), "rid", rid, "uid", uid)
+		}
+	} else {
+		appid = roomApp(rid)
 	}
 	// 生成resp对象
-	broadcaster.Say(proto.IslbToBizOnJoin, util.Map("rid", rid, "uid", uid, "nid", nid, "info", util.Unmarshal(data["info"].(string))))
+	if created {
+		broadcaster.Say(proto.IslbToBizOnRoomAdd, util.Map("rid", rid, "uid", uid, "appid", appid))
+	}
+	broadcaster.Say(proto.IslbToBizOnJoin, util.Map("rid", rid, "uid", uid, "nid", nid, "info", util.Unmarshal(info), "appid", appid))
 	return util.Map(), nil
 }
 
 /*
-	"method", proto.BizToIslbOnLeave, "rid", rid, "uid", uid
+	"method", proto.BizToIslbOnLeave, "rid", rid, "uid", uid, "reason", reason
 */
 // 有人退出房间
-func clientLeave(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func clientLeave(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.clientLeave data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	// 获取用户的服务器信息
-	uKey := proto.GetUserNodeKey(rid, uid)
-	ukeys := redis.Keys(uKey)
-	if len(ukeys) > 0 {
-		err := redis.Del(uKey)
-		if err != nil {
-			logger.Errorf(fmt.Sprintf("islb.clientLeave redis.Del err=%v", err), "rid", rid, "uid", uid)
-		}
+	var req proto.IslbLeaveRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
 	}
-	// 获取用户的信息
-	uKey = proto.GetUserInfoKey(rid, uid)
-	ukeys = redis.Keys(uKey)
-	if len(ukeys) > 0 {
-		err := redis.Del(uKey)
-		if err != nil {
-			logger.Errorf(fmt.Sprintf("islb.clientLeave redis.Del err=%v", err), "rid", rid, "uid", uid)
+	rid := req.RID
+	uid := req.UID
+	appid := roomApp(rid)
+	// 删除用户的服务器信息和用户信息,removed表示离开的是房间最后一个用户
+	removed, err := rooms.Leave(rid, uid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.clientLeave Leave err=%v", err), "rid", rid, "uid", uid)
+	}
+	leave := util.Map("rid", rid, "uid", uid, "appid", appid)
+	if req.Reason != "" {
+		leave["reason"] = req.Reason
+	}
+	broadcaster.Say(proto.IslbToBizOnLeave, leave)
+	if removed {
+		broadcaster.Say(proto.IslbToBizOnRoomRemove, util.Map("rid", rid, "uid", uid, "appid", appid))
+		if err := rooms.ClearBinding(store.BindApp, rid); err != nil {
+			logger.Errorf(fmt.Sprintf("islb.clientLeave ClearBinding err=%v", err), "rid", rid, "uid", uid)
 		}
 	}
-	broadcaster.Say(proto.IslbToBizOnLeave, util.Map("rid", rid, "uid", uid))
 	return util.Map(), nil
 }
 
@@ -192,22 +263,22 @@ func clientLeave(data map[string]interface{}) (map[string]interface{}, *nprotoo.
 	"method", proto.BizToIslbKeepAlive, "rid", rid, "uid", uid
 */
 // 保活处理
-func keepalive(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	// 获取用户的服务器信息
-	uKey := proto.GetUserNodeKey(rid, uid)
-	err := redis.Expire(uKey, redisShort)
-	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.keepalive redis.Set err=%v", err), "rid", rid, "uid", uid)
-		return nil, &nprotoo.Error{Code: 402, Reason: fmt.Sprintf("keepalive err=%v", err)}
+func keepalive(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbPeerRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
 	}
-	// 获取用户的信息
-	uKey = proto.GetUserInfoKey(rid, uid)
-	err = redis.Expire(uKey, redisShort)
+	rid := req.RID
+	uid := req.UID
+	// 延长用户的保活时间
+	err := rooms.KeepAlive(rid, uid, memberTTL)
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.keepalive redis.Set err=%v", err), "rid", rid, "uid", uid)
-		return nil, &nprotoo.Error{Code: 402, Reason: fmt.Sprintf("keepalive err=%v", err)}
+		logger.Errorf(fmt.Sprintf("islb.keepalive KeepAlive err=%v", err), "rid", rid, "uid", uid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	// 房间所属的应用只在加入时写入,有用户时随保活续期
+	if err := rooms.RefreshBinding(store.BindApp, rid); err != nil {
+		logger.Errorf(fmt.Sprintf("islb.keepalive RefreshBinding err=%v", err), "rid", rid, "uid", uid)
 	}
 	return util.Map(), nil
 }
@@ -216,112 +287,97 @@ func keepalive(data map[string]interface{}) (map[string]interface{}, *nprotoo.Er
 	"method", proto.BizToIslbGetBizInfo, "rid", rid, "uid", uid
 */
 // 获取uid指定的biz节点信息
-func getBizByUid(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
+func getBizByUid(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbPeerRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
 	// 获取用户的服务器信息
-	uKey := proto.GetUserNodeKey(rid, uid)
-	ukeys := redis.Keys(uKey)
-	if len(ukeys) > 0 {
-		nid := redis.Get(uKey)
-		return util.Map("rid", rid, "nid", nid), nil
-	} else {
-		return nil, &nprotoo.Error{Code: 410, Reason: fmt.Sprintf("can't find peer info by key:%s", uKey)}
+	member, err := rooms.GetMember(rid, uid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getBizByUid GetMember err=%v", err), "rid", rid, "uid", uid)
+		return nil, proto.NewError(proto.ErrStorage, err)
 	}
+	if member == nil {
+		return nil, proto.NewError(proto.ErrPeerNotFound, rid+"/"+uid)
+	}
+	return util.Map("rid", rid, "nid", member.NID), nil
 }
 
 /*
 	"method", proto.BizToIslbOnStreamAdd, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", minfo
 */
 // 有人发布流
-func streamAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func streamAdd(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.streamAdd data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	mid := util.Val(data, "mid")
-	nid := util.Val(data, "nid")
-	minfo := util.Val(data, "minfo")
-	// 获取用户发布的流信息
-	ukey := proto.GetMediaInfoKey(rid, uid, mid)
-	err := redis.Set(ukey, minfo, redisKeyTTL)
-	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.streamAdd redis.Set err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-		return nil, &nprotoo.Error{Code: 403, Reason: fmt.Sprintf("streamAdd err=%v", err)}
+	var req proto.IslbStreamRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
 	}
-	// 获取用户发布流对应的sfu信息
-	ukey = proto.GetMediaPubKey(rid, uid, mid)
-	err = redis.Set(ukey, nid, redisKeyTTL)
-	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.streamAdd redis.Set err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-		return nil, &nprotoo.Error{Code: 403, Reason: fmt.Sprintf("streamAdd err=%v", err)}
+	if err := setStream(&req); err != nil {
+		return nil, err
 	}
 	// 生成resp对象
-	broadcaster.Say(proto.IslbToBizOnStreamAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", data["minfo"]))
+	broadcaster.Say(proto.IslbToBizOnStreamAdd, util.Map("rid", req.RID, "uid", req.UID, "mid", req.MID, "nid", req.NID, "minfo", req.MInfo, "appid", roomApp(req.RID)))
+	return util.Map(), nil
+}
+
+/*
+	"method", proto.BizToIslbOnStreamUpdate, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", minfo
+*/
+// 有人的发布流迁移到其他sfu,mid不变,只更新sfu信息
+func streamUpdate(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	logger.Infof(fmt.Sprintf("islb.streamUpdate data=%v", data))
+	var req proto.IslbStreamRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	if err := setStream(&req); err != nil {
+		return nil, err
+	}
+	broadcaster.Say(proto.IslbToBizOnStreamUpdate, util.Map("rid", req.RID, "uid", req.UID, "mid", req.MID, "nid", req.NID, "minfo", req.MInfo))
 	return util.Map(), nil
 }
 
+// setStream 保存流信息和流对应的sfu
+func setStream(req *proto.IslbStreamRequest) *bus.Error {
+	rid := req.RID
+	uid := req.UID
+	mid := req.MID
+	minfo := util.Marshal(req.MInfo.Map())
+	err := rooms.AddStream(store.Media, rid, store.Stream{UID: uid, MID: mid, NID: req.NID, MInfo: minfo})
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.setStream AddStream err=%v", err), "rid", rid, "uid", uid, "mid", mid)
+		return proto.NewError(proto.ErrStorage, err)
+	}
+	return nil
+}
+
 /*
 	"method", proto.BizToIslbOnStreamRemove, "rid", rid, "uid", uid, "mid", ""
 */
 // 有人取消发布流
-func streamRemove(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func streamRemove(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.streamRemove data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	mid := util.Val(data, "mid")
-	// 判断mid是否为空
-	var ukey string
-	if mid == "" {
-		ukey = "/media/rid/" + rid + "/uid/" + uid + "/mid/*"
-		ukeys := redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.streamRemove media redis.Del err=%v", err), "rid", rid, "uid", uid)
-			}
-		}
-		ukey = "/pub/rid/" + rid + "/uid/" + uid + "/mid/*"
-		ukeys = redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			arr := strings.Split(key, "/")
-			mid := arr[7]
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.streamRemove pub redis.Del err=%v", err), "rid", rid, "uid", uid)
-			}
-			broadcaster.Say(proto.IslbToBizOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
-		}
-	} else {
-		// 获取用户发布的流信息
-		ukey = proto.GetMediaInfoKey(rid, uid, mid)
-		ukeys := redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.streamRemove media redis.Del err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-			}
-		}
-		// 获取用户发布流对应的sfu信息
-		ukey = proto.GetMediaPubKey(rid, uid, mid)
-		ukeys = redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			arr := strings.Split(key, "/")
-			mid := arr[7]
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.streamRemove pub redis.Del err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-			}
-			broadcaster.Say(proto.IslbToBizOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
-		}
+	var req proto.IslbStreamRemoveRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
+	// mid为空时删除用户的所有流
+	mids, err := rooms.RemoveStreams(store.Media, rid, uid, req.MID)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.streamRemove RemoveStreams err=%v", err), "rid", rid, "uid", uid, "mid", req.MID)
+		return nil, proto.NewError(proto.ErrStorage, err)
 	}
+	appid := roomApp(rid)
+	for _, mid := range mids {
+		broadcaster.Say(proto.IslbToBizOnStreamRemove, util.Map("rid", rid, "uid", uid, "mid", mid, "appid", appid))
+	}
+	clearRoomSfu(rid)
 	return util.Map(), nil
 }
 
@@ -329,48 +385,49 @@ func streamRemove(data map[string]interface{}) (map[string]interface{}, *nprotoo
 	"method", proto.BizToIslbGetSfuInfo, "rid", rid, "mid", mid
 */
 // 获取mid指定对应的sfu节点
-func getSfuByMid(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	mid := util.Val(data, "mid")
+func getSfuByMid(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbMediaRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	mid := req.MID
 	uid := proto.GetUIDFromMID(mid)
 	// 获取用户发布流对应的sfu信息
-	uKey := proto.GetMediaPubKey(rid, uid, mid)
-	ukeys := redis.Keys(uKey)
-	if len(ukeys) > 0 {
-		nid := redis.Get(uKey)
-		return util.Map("rid", rid, "nid", nid), nil
-	} else {
-		return nil, &nprotoo.Error{Code: 411, Reason: fmt.Sprintf("can't find sfu node by mid:%s", uKey)}
+	pub, err := rooms.GetStream(store.Media, rid, uid, mid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getSfuByMid GetStream err=%v", err), "rid", rid, "mid", mid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if pub == nil || pub.NID == "" {
+		return nil, proto.NewError(proto.ErrPubNotFound, rid+"/"+mid)
 	}
+	return util.Map("rid", rid, "nid", pub.NID), nil
 }
 
 /*
 	"method", proto.BizToIslbOnLiveAdd, "rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", minfo
 */
 // 有人发布直播流
-func liveAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func liveAdd(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.liveAdd data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	mid := util.Val(data, "mid")
-	nid := util.Val(data, "nid")
-	minfo := util.Val(data, "minfo")
-	// 获取用户发布的直播流信息
-	ukey := proto.GetLiveInfoKey(rid, uid, mid)
-	err := redis.Set(ukey, minfo, redisKeyTTL)
-	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.liveAdd redis.Set err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-		return nil, &nprotoo.Error{Code: 404, Reason: fmt.Sprintf("liveAdd err=%v", err)}
+	var req proto.IslbStreamRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
 	}
-	// 获取用户发布直播流对应的mcu节点
-	ukey = proto.GetLivePubKey(rid, uid, mid)
-	err = redis.Set(ukey, nid, redisKeyTTL)
+	rid := req.RID
+	uid := req.UID
+	mid := req.MID
+	nid := req.NID
+	minfo := util.Marshal(req.MInfo.Map())
+	// 保存用户发布的直播流信息和对应的mcu节点
+	err := rooms.AddStream(store.Live, rid, store.Stream{UID: uid, MID: mid, NID: nid, MInfo: minfo})
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.liveAdd redis.Set err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-		return nil, &nprotoo.Error{Code: 404, Reason: fmt.Sprintf("liveAdd err=%v", err)}
+		logger.Errorf(fmt.Sprintf("islb.liveAdd AddStream err=%v", err), "rid", rid, "uid", uid, "mid", mid)
+		return nil, proto.NewError(proto.ErrStorage, err)
 	}
 	// 生成resp对象
-	broadcaster.Say(proto.IslbToBizOnLiveAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", data["minfo"]))
+	broadcaster.Say(proto.IslbToBizOnLiveAdd, util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", req.MInfo, "appid", roomApp(rid)))
 	return util.Map(), nil
 }
 
@@ -378,195 +435,263 @@ func liveAdd(data map[string]interface{}) (map[string]interface{}, *nprotoo.Erro
 	"method", proto.BizToIslbOnLiveRemove, "rid", rid, "uid", uid, "mid", ""
 */
 // 有人取消发布直播流
-func liveRemove(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func liveRemove(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.liveRemove data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	mid := util.Val(data, "mid")
-	// 判断mid是否为空
-	var ukey string
-	if mid == "" {
-		ukey = "/livemedia/rid/" + rid + "/uid/" + uid + "/mid/*"
-		ukeys := redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.liveRemove media redis.Del err=%v", err), "rid", rid, "uid", uid)
+	var req proto.IslbStreamRemoveRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
+	// 删除前记下启用录制的直播流,停止时通知录制结束
+	records := make(map[string]bool)
+	if lives, err := rooms.GetStreams(store.Live, rid); err == nil {
+		for _, live := range lives {
+			var minfo proto.MediaInfo
+			if live.UID == uid && json.Unmarshal([]byte(live.MInfo), &minfo) == nil && minfo.Record == 1 {
+				records[live.MID] = true
 			}
 		}
-		ukey = "/livepub/rid/" + rid + "/uid/" + uid + "/mid/*"
-		ukeys = redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			arr := strings.Split(key, "/")
-			mid := arr[7]
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.liveRemove pub redis.Del err=%v", err), "rid", rid, "uid", uid)
-			}
-			broadcaster.Say(proto.IslbToBizOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
+	}
+	// mid为空时删除用户的所有直播流
+	mids, err := rooms.RemoveStreams(store.Live, rid, uid, req.MID)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.liveRemove RemoveStreams err=%v", err), "rid", rid, "uid", uid, "mid", req.MID)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	appid := roomApp(rid)
+	for _, mid := range mids {
+		live := util.Map("rid", rid, "uid", uid, "mid", mid, "appid", appid)
+		if records[mid] {
+			live["record"] = 1
 		}
-	} else {
-		// 获取用户发布的流信息
-		ukey = proto.GetLiveInfoKey(rid, uid, mid)
-		ukeys := redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			// 删除key值
-			err := redis.Del(ukey)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.liveRemove media redis.Del err=%v", err), "rid", rid, "uid", uid, "mid", mid)
-			}
+		broadcaster.Say(proto.IslbToBizOnLiveRemove, live)
+	}
+	return util.Map(), nil
+}
+
+// liveHeartbeat 定时上报负责的房间中所有的直播流,biz或mcu异常时没有移除的直播流由issr超时关闭
+func liveHeartbeat() {
+	t := time.NewTicker(liveHeartbeatCycle)
+	defer t.Stop()
+	for range t.C {
+		rids, err := rooms.GetRooms()
+		if err != nil {
+			logger.Errorf(fmt.Sprintf("islb.liveHeartbeat GetRooms err=%v", err))
+			continue
 		}
-		// 获取用户发布流对应的sfu信息
-		ukey = proto.GetLivePubKey(rid, uid, mid)
-		ukeys = redis.Keys(ukey)
-		for _, key := range ukeys {
-			ukey = key
-			arr := strings.Split(key, "/")
-			mid := arr[7]
-			// 删除key值
-			err := redis.Del(ukey)
+		lives := make([]proto.LiveStream, 0)
+		for _, rid := range rids {
+			if !ownsRoom(rid) {
+				continue
+			}
+			streams, err := rooms.GetStreams(store.Live, rid)
 			if err != nil {
-				logger.Errorf(fmt.Sprintf("islb.liveRemove pub redis.Del err=%v", err), "rid", rid, "uid", uid, "mid", mid)
+				logger.Errorf(fmt.Sprintf("islb.liveHeartbeat GetStreams err=%v", err), "rid", rid)
+				continue
+			}
+			if len(streams) == 0 {
+				continue
+			}
+			appid := roomApp(rid)
+			for _, stream := range streams {
+				live := proto.LiveStream{AppID: appid, RID: rid, UID: stream.UID, MID: stream.MID, NID: stream.NID}
+				var minfo proto.MediaInfo
+				if json.Unmarshal([]byte(stream.MInfo), &minfo) == nil {
+					live.MInfo = &minfo
+				}
+				lives = append(lives, live)
 			}
-			broadcaster.Say(proto.IslbToBizOnLiveRemove, util.Map("rid", rid, "uid", uid, "mid", mid))
 		}
+		broadcaster.Say(proto.IslbToIssrOnLiveHeartbeat, proto.ToMap(&proto.IslbLiveHeartbeatNotification{NID: node.NodeInfo().Nid, Lives: lives}))
 	}
-	return util.Map(), nil
 }
 
 // 设置rid跟mcu绑定关系
-func setMcuInfo(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func setMcuInfo(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.setMcuInfo data=%v", data))
-	rid := util.Val(data, "rid")
-	nid := util.Val(data, "nid")
-	key := proto.GetMcuInfoKey(rid)
-	/*
-		mcu := redis.Get(key)
-		if mcu != "" {
-			return util.Map("nid", mcu), nil
-		}*/
-	err := redis.Set(key, nid, redisKeyTTL)
+	var req proto.IslbMcuRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	nid := req.NID
+	if nid == "" {
+		return nil, proto.NewError(proto.ErrInvalidParams, "nid not found")
+	}
+	_, err := rooms.SetBinding(store.BindMcu, rid, nid, true)
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.setMcuInfo redis.Set err=%v", err), "rid", rid)
-		return nil, &nprotoo.Error{Code: 405, Reason: fmt.Sprintf("setMcuInfo err:%v", err)}
+		logger.Errorf(fmt.Sprintf("islb.setMcuInfo SetBinding err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
 	}
 	return util.Map("nid", nid), nil
 }
 
 // 删除rid跟mcu绑定关系
-func clearMcuInfo(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func clearMcuInfo(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.clearMcuInfo data=%v", data))
 	rid := util.Val(data, "rid")
-	key := proto.GetMcuInfoKey(rid)
-	err := redis.Del(key)
+	err := rooms.ClearBinding(store.BindMcu, rid)
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("islb.clearMcuInfo redis.Del err=%v", err), "rid", rid)
-		return nil, &nprotoo.Error{Code: 406, Reason: fmt.Sprintf("clearMcuInfo err:%v", err)}
+		logger.Errorf(fmt.Sprintf("islb.clearMcuInfo ClearBinding err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
 	}
 	return util.Map(), nil
 }
 
 // 根据rid查询对应mcu节点
-func getMcuInfo(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func getMcuInfo(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.getMcuInfo data=%v", data))
-	rid := util.Val(data, "rid")
-	key := proto.GetMcuInfoKey(rid)
-	nid := redis.Get(key)
+	var req proto.IslbMcuRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	nid, err := rooms.GetBinding(store.BindMcu, rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getMcuInfo GetBinding err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
 	if nid == "" {
-		return nil, &nprotoo.Error{Code: 407, Reason: fmt.Sprintf("can't get mcu info by rid:%s", rid)}
+		return nil, proto.NewError(proto.ErrMcuNotBound, rid)
 	}
 	return util.Map("rid", rid, "nid", nid), nil
 }
 
+// 根据rid查询房间绑定的sfu,未绑定时nid为空
+func getRoomSfu(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	logger.Infof(fmt.Sprintf("islb.getRoomSfu data=%v", data))
+	var req proto.IslbRoomSfuRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	nid, err := rooms.GetBinding(store.BindSfu, rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRoomSfu GetBinding err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	return proto.ToMap(&proto.IslbNodeResponse{RID: rid, NID: nid}), nil
+}
+
+// 设置rid跟sfu绑定关系,force为false时房间已绑定则返回已绑定的sfu
+func setRoomSfu(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	logger.Infof(fmt.Sprintf("islb.setRoomSfu data=%v", data))
+	var req proto.IslbRoomSfuRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	nid := req.NID
+	if nid == "" {
+		return nil, proto.NewError(proto.ErrInvalidParams, "nid not found")
+	}
+	nid, err := rooms.SetBinding(store.BindSfu, rid, nid, req.Force)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.setRoomSfu SetBinding err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	return proto.ToMap(&proto.IslbNodeResponse{RID: rid, NID: nid}), nil
+}
+
+// 房间没有发布流时删除rid跟sfu绑定关系
+func clearRoomSfu(rid string) {
+	if has, err := rooms.HasStreams(store.Media, rid); err != nil || has {
+		return
+	}
+	if err := rooms.ClearBinding(store.BindSfu, rid); err != nil {
+		logger.Errorf(fmt.Sprintf("islb.clearRoomSfu ClearBinding err=%v", err), "rid", rid)
+	}
+}
+
 // 获取实时流对应的minfo信息
-func getMediaInfo(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func getMediaInfo(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.getMediaInfo data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	mid := util.Val(data, "mid")
-	ukey := proto.GetMediaInfoKey(rid, uid, mid)
-	minfo := redis.Get(ukey)
-	if minfo == "" {
-		return nil, &nprotoo.Error{Code: 408, Reason: fmt.Sprintf("minfo doesn't exist:%s", ukey)}
+	var req proto.IslbMediaRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
+	mid := req.MID
+	pub, err := rooms.GetStream(store.Media, rid, uid, mid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getMediaInfo GetStream err=%v", err), "rid", rid, "uid", uid, "mid", mid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if pub == nil || pub.MInfo == "" {
+		return nil, proto.NewError(proto.ErrMediaNotFound, rid+"/"+proto.GetStreamField(uid, mid))
 	}
-	return util.Map("minfo", util.Unmarshal(minfo)), nil
+	return util.Map("minfo", util.Unmarshal(pub.MInfo)), nil
 }
 
 /*
 	"method", proto.BizToIslbBroadcast, "rid", rid, "uid", uid, "data", data
 */
-// 发送广播
-func broadcast(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+// 发送广播,同时保存到房间聊天记录
+func broadcast(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("islb.broadcast data=%v", data))
-	rid := util.Val(data, "rid")
-	uid := util.Val(data, "uid")
-	broadcaster.Say(proto.IslbToBizBroadcast, util.Map("rid", rid, "uid", uid, "data", data["data"]))
-	return util.Map(), nil
+	var req proto.IslbBroadcastRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	uid := req.UID
+	msgid := newMessageID(uid)
+	ts := time.Now().Unix()
+	err := saveHistory(msgid, rid, uid, req.Data, ts)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.broadcast saveHistory err=%v", err), "rid", rid, "uid", uid)
+	}
+	broadcaster.Say(proto.IslbToBizBroadcast, util.Map("rid", rid, "uid", uid, "msgid", msgid, "time", ts, "data", req.Data))
+	return proto.ToMap(&proto.IslbBroadcastResponse{MsgID: msgid}), nil
 }
 
 /*
 	"method", proto.BizToIslbGetRoomUsers, "rid", rid, "uid", uid
 */
 // 获取房间其他用户实时流
-func getRoomUsers(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	id := util.Val(data, "uid")
-	// 获取实时流数据
-	pubs := make([]map[string]interface{}, 0)
-	uKey := "/pub/rid/" + rid + "/uid/*"
-	ukeys := redis.Keys(uKey)
-	for _, key := range ukeys {
-		arr := strings.Split(key, "/")
-		uid := arr[5]
-		mid := arr[7]
-		if uid == id {
+func getRoomUsers(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbPeerRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	id := req.UID
+	// 获取用户信息数据
+	members, err := rooms.GetMembers(rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRoomUsers GetMembers err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	// 获取实时流数据,按uid分组
+	streams, err := rooms.GetStreams(store.Media, rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRoomUsers GetStreams err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	media := make(map[string][]map[string]interface{})
+	for _, stream := range streams {
+		if stream.UID == id || stream.MID == "" {
 			continue
 		}
-
-		nid := redis.Get(key)
-		uKey := proto.GetMediaInfoKey(rid, uid, mid)
-		minfo := redis.Get(uKey)
-
-		pub := util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", util.Unmarshal(minfo))
-		pubs = append(pubs, pub)
+		pub := util.Map("rid", rid, "uid", stream.UID, "mid", stream.MID, "nid", stream.NID, "minfo", util.Unmarshal(stream.MInfo))
+		media[stream.UID] = append(media[stream.UID], pub)
 	}
-	// 获取用户信息数据
-	users := make([]map[string]interface{}, 0)
-	uKey = "/node/rid/" + rid + "/uid/*"
-	ukeys = redis.Keys(uKey)
-	for _, key := range ukeys {
+	users := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
 		// 去掉指定的uid
-		arr := strings.Split(key, "/")
-		uid := arr[5]
-		if uid == id {
+		if member.UID == id {
 			continue
 		}
-
-		nid := redis.Get(key)
-		uKey := proto.GetUserInfoKey(rid, uid)
-		info := redis.Get(uKey)
-
-		media := make([]map[string]interface{}, 0)
-		for _, pub := range pubs {
-			if uid == pub["uid"].(string) {
-				if pub["mid"].(string) != "" {
-					media = append(media, pub)
-				}
-			}
-		}
-
-		if len(media) > 0 {
-			for _, stream := range media {
-				user := util.Map("uid", uid, "nid", nid, "info", util.Unmarshal(info), "media", stream)
+		info := util.Unmarshal(member.Info)
+		if streams := media[member.UID]; len(streams) > 0 {
+			for _, stream := range streams {
+				user := util.Map("uid", member.UID, "nid", member.NID, "info", info, "media", stream)
 				users = append(users, user)
 			}
 		} else {
-			user := util.Map("uid", uid, "nid", nid, "info", util.Unmarshal(info), "media", util.Map())
+			user := util.Map("uid", member.UID, "nid", member.NID, "info", info, "media", util.Map())
 			users = append(users, user)
 		}
 	}
@@ -580,27 +705,26 @@ func getRoomUsers(data map[string]interface{}) (map[string]interface{}, *nprotoo
 	"method", proto.BizToIslbGetRoomLives, "rid", rid, "uid", uid
 */
 // 获取房间其他用户直播流
-func getRoomLives(data map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
-	rid := util.Val(data, "rid")
-	id := util.Val(data, "uid")
+func getRoomLives(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbPeerRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	id := req.UID
 	// 获取直播流数据
+	streams, err := rooms.GetStreams(store.Live, rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRoomLives GetStreams err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
 	lives := make([]map[string]interface{}, 0)
-	uKey := "/livepub/rid/" + rid + "/uid/*"
-	ukeys := redis.Keys(uKey)
-	for _, key := range ukeys {
+	for _, stream := range streams {
 		// 去掉指定的uid
-		arr := strings.Split(key, "/")
-		uid := arr[5]
-		mid := arr[7]
-		if uid == id {
+		if stream.UID == id {
 			continue
 		}
-
-		nid := redis.Get(key)
-		uKey := proto.GetLiveInfoKey(rid, uid, mid)
-		minfo := redis.Get(uKey)
-
-		live := util.Map("rid", rid, "uid", uid, "mid", mid, "nid", nid, "minfo", util.Unmarshal(minfo))
+		live := util.Map("rid", rid, "uid", stream.UID, "mid", stream.MID, "nid", stream.NID, "minfo", util.Unmarshal(stream.MInfo))
 		lives = append(lives, live)
 	}
 	// 返回
@@ -608,3 +732,73 @@ func getRoomLives(data map[string]interface{}) (map[string]interface{}, *nprotoo
 	logger.Infof(fmt.Sprintf("islb.getRoomLives resp=%v ", resp), "rid", rid)
 	return resp, nil
 }
+
+// 获取有用户的房间,用于管理接口
+func getRooms() (map[string]interface{}, *bus.Error) {
+	rids, err := rooms.GetRooms()
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRooms GetRooms err=%v", err))
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if rids == nil {
+		rids = []string{}
+	}
+	return proto.ToMap(&proto.IslbRoomsResponse{Rooms: rids}), nil
+}
+
+/*
+	"method", proto.BizToIslbGetRoomInfo, "rid", rid
+*/
+// 获取房间的用户、流和绑定的节点,用于管理接口
+func getRoomInfo(data map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.IslbPeerRequest
+	if err := proto.Decode(data, &req); err != nil {
+		return nil, err
+	}
+	rid := req.RID
+	members, err := rooms.GetMembers(rid)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("islb.getRoomInfo GetMembers err=%v", err), "rid", rid)
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if len(members) == 0 {
+		return nil, proto.NewError(proto.ErrRoomNotFound, rid)
+	}
+	info := proto.RoomInfo{RID: rid, Users: make([]proto.RoomUser, 0, len(members))}
+	for _, member := range members {
+		info.Users = append(info.Users, proto.RoomUser{UID: member.UID, NID: member.NID, Info: util.Unmarshal(member.Info)})
+	}
+	for _, kind := range []store.StreamKind{store.Media, store.Live} {
+		streams, err := rooms.GetStreams(kind, rid)
+		if err != nil {
+			logger.Errorf(fmt.Sprintf("islb.getRoomInfo GetStreams err=%v", err), "rid", rid)
+			return nil, proto.NewError(proto.ErrStorage, err)
+		}
+		infos := make([]proto.StreamInfo, 0, len(streams))
+		for _, stream := range streams {
+			pub := proto.StreamInfo{RID: rid, UID: stream.UID, MID: stream.MID, NID: stream.NID}
+			if minfo := util.Unmarshal(stream.MInfo); minfo != nil {
+				pub.MInfo = &proto.MediaInfo{}
+				if err := proto.Decode(minfo, pub.MInfo); err != nil {
+					logger.Warnf(fmt.Sprintf("islb.getRoomInfo minfo err=%v", err.Reason), "rid", rid, "mid", stream.MID)
+				}
+			}
+			infos = append(infos, pub)
+		}
+		if kind == store.Media {
+			info.Pubs = infos
+		} else {
+			info.Lives = infos
+		}
+	}
+	if info.Sfu, err = rooms.GetBinding(store.BindSfu, rid); err != nil {
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if info.Mcu, err = rooms.GetBinding(store.BindMcu, rid); err != nil {
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	if info.AppID, err = rooms.GetBinding(store.BindApp, rid); err != nil {
+		return nil, proto.NewError(proto.ErrStorage, err)
+	}
+	return proto.ToMap(&info), nil
+}
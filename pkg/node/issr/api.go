@@ -0,0 +1,101 @@
+package issr
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	h "signal/infra/http"
+	"signal/pkg/proto"
+	"signal/pkg/rollup"
+	"signal/util"
+)
+
+// usageItem 用量查询结果,minutes为seconds换算的分钟数
+type usageItem struct {
+	rollup.Rollup
+	Minutes float64 `json:"minutes"`
+}
+
+// SetRollup 设置用量汇总的存储,需要在Init之前调用,为nil时不汇总
+func SetRollup(s rollup.Store) {
+	rollups = s
+}
+
+// InitUsageAPI 在g下注册用量查询接口,token为空或没有汇总存储时不开启
+func InitUsageAPI(g *h.PathGroup, token string) {
+	if token == "" || rollups == nil {
+		return
+	}
+	g.Get("/usage", queryUsage, h.TokenFilter(token))
+}
+
+// addRollups 计时数据累加到每日汇总
+func addRollups(r rollup.Rollup, start, end int64) {
+	if rollups == nil || r.AppID == "" {
+		return
+	}
+	if err := rollups.Add(rollup.Split(r, start, end)); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.addRollups appid=%s err=%v", r.AppID, err))
+	}
+}
+
+// writeUsageError 返回错误
+func writeUsageError(w http.ResponseWriter, status, code int, detail string) {
+	h.WriteJSON(w, status, util.Map("code", code, "reason", proto.NewError(code, detail).Reason))
+}
+
+// queryUsage 查询每日用量,GET /usage?appid=&from=&to=&type=&mediatype=&resolution=&format=csv
+// from和to为日期 2006-01-02,包含两端,format=csv时导出csv
+func queryUsage(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+	q := rollup.Query{
+		AppID:      values.Get("appid"),
+		From:       values.Get("from"),
+		To:         values.Get("to"),
+		MediaType:  values.Get("mediatype"),
+		Resolution: values.Get("resolution"),
+	}
+	for _, day := range []string{q.From, q.To} {
+		if _, err := time.Parse(rollup.DayLayout, day); day != "" && err != nil {
+			writeUsageError(w, http.StatusBadRequest, proto.ErrInvalidParams, "date should be "+rollup.DayLayout)
+			return
+		}
+	}
+	if typ := values.Get("type"); typ != "" {
+		t, err := strconv.Atoi(typ)
+		if err != nil {
+			writeUsageError(w, http.StatusBadRequest, proto.ErrInvalidParams, "type should be int")
+			return
+		}
+		q.Type = t
+	}
+
+	result, err := rollups.Query(q)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("issr.queryUsage err=%v", err))
+		writeUsageError(w, http.StatusInternalServerError, proto.ErrStorage, err.Error())
+		return
+	}
+	items := make([]usageItem, 0, len(result))
+	for _, r := range result {
+		items = append(items, usageItem{Rollup: r, Minutes: float64(r.Seconds) / 60})
+	}
+
+	if values.Get("format") != "csv" {
+		h.WriteJSON(w, http.StatusOK, util.Map("usage", items))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=usage.csv")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"appid", "day", "type", "mediatype", "resolution", "seconds", "minutes"})
+	for _, item := range items {
+		writer.Write([]string{item.AppID, item.Day, strconv.Itoa(item.Type), item.MediaType, item.Resolution,
+			strconv.FormatInt(item.Seconds, 10), strconv.FormatFloat(item.Minutes, 'f', 2, 64)})
+	}
+	writer.Flush()
+}
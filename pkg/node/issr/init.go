@@ -1,65 +1,101 @@
 package issr
 
 import (
-	"encoding/json"
 	"fmt"
 	dis "signal/infra/discovery"
 	"signal/infra/kafka"
 	logger2 "signal/infra/logger"
 	"signal/infra/monitor"
 	db "signal/infra/redis"
+	"signal/pkg/billing"
+	"signal/pkg/bus"
+	"signal/pkg/exporter"
 	"signal/pkg/log"
 	"signal/pkg/proto"
-	"signal/util"
+	"signal/pkg/rollup"
+	"signal/pkg/timing"
+	"signal/pkg/webhook"
+	"sync"
 	"time"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 var (
-	redisKeyTTL            = 24 * time.Hour
 	failureKey             = proto.GetFailedStreamStateKey()
+	usageTopic             = "Livs-Usage-Event"
 	timingType             = 200
+	audioTimingType        = 201
+	liveTimingType         = 700
 	statCycle              = 60 * time.Second
 	logger                 *logger2.Logger
-	rpcs                   map[string]*nprotoo.Requestor
-	protoo                 *nprotoo.NatsProtoo
-	kafkaClient            *kafka.KafkaClient
-	kafkaProducer          *kafka.SyncProducer
-	redis                  *db.Redis
+	rpcs                   map[string]bus.Requestor
+	rpcsLock               sync.RWMutex
+	protoo                 bus.Bus
+	kafkaProducer          kafka.Producer
+	redis                  db.KV
 	node                   *dis.ServiceNode
 	watch                  *dis.ServiceWatcher
+	topology               dis.Topology
+	webhookConfig          webhook.Config
+	hooks                  *webhook.Dispatcher
+	exporterConfig         exporter.Config
+	events                 *exporter.Exporter
+	billingConfig          billing.Config
+	meter                  *billing.Engine
+	lives                  *billing.Live
+	tiers                  *timing.Tiers
+	outboxConfig           kafka.OutboxConfig
+	usage                  *kafka.Outbox
+	rollups                rollup.Store
 	rpcProcessingTimeGauge = monitor.NewMonitorGauge("issr_rpc_processing_time", "issr rpc request processing time", []string{"method"})
+	outboxDepthGauge       = monitor.NewMonitorGaugeFunc("issr_outbox_depth", "issr usage reports waiting to be sent", func() float64 {
+		if usage == nil {
+			return 0
+		}
+		return float64(usage.Depth())
+	})
+	outboxLagGauge = monitor.NewMonitorGaugeFunc("issr_outbox_lag_seconds", "age of the oldest usage report waiting to be sent", func() float64 {
+		if usage == nil {
+			return 0
+		}
+		return usage.Lag().Seconds()
+	})
 )
 
 // Init 初始化服务
-func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, natsURL, kafkaURL string, config db.Config, l *logger2.Logger) {
+func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, b bus.Bus, producer kafka.Producer, kv db.KV, l *logger2.Logger) {
 	// 赋值
 	logger = l
 	node = serviceNode
 	watch = ServiceWatcher
-	rpcs = make(map[string]*nprotoo.Requestor)
-	//连接kafka
-	client, err := kafka.NewKafkaClient(kafkaURL)
+	rpcsLock.Lock()
+	rpcs = make(map[string]bus.Requestor)
+	rpcsLock.Unlock()
+	kafkaProducer = producer
+	protoo = b
+	// 启动MQ监听
+	handleRPCRequest(node.GetRPCChannel())
+	redis = kv
+	outbox, err := kafka.NewOutbox(producer, outboxConfig)
 	if err != nil {
+		logger.Errorf(fmt.Sprintf("issr.Init open outbox err=%v", err))
 		panic(err)
 	}
-	kafkaClient = client
-	//创建kafka生产者
-	producer, err := kafka.NewSyncProducer(kafkaClient)
-	if err != nil {
-		panic(err)
+	usage = outbox
+	hooks = webhook.New(webhookConfig, kv)
+	events = exporter.New(exporterConfig, producer)
+	meter = billing.New(billingConfig, kv, reportUsage)
+	lives = billing.NewLive(billingConfig, kv, reportLive)
+	if tiers != nil {
+		meter.SetTiers(tiers)
+		lives.SetTiers(tiers)
 	}
-	kafkaProducer = producer
-	//连接nats
-	protoo = nprotoo.NewNatsProtoo(util.GenerateNatsUrlString(natsURL))
-	// 启动MQ监听
-	handleRPCRequest(node.GetRPCChannel())
-	//建立redis连接
-	redis = db.NewRedis(config)
 	//监听islb节点
 	go watch.WatchServiceNode("", WatchServiceCallBack)
-	go checkFailures()
+	go migrateFailures()
+	go checkBilling()
+	if hooks != nil {
+		go checkWebhookFailures()
+	}
 }
 
 // WatchServiceCallBack 查看所有的Node节点
@@ -67,11 +103,15 @@ func WatchServiceCallBack(state dis.NodeStateType, node dis.Node) {
 	if state == dis.ServerUp {
 		log.Infof("WatchServiceCallBack node up %v", node)
 		if node.Name == "islb" {
-			id := node.Nid
-			_, found := rpcs[id]
+			rpcsLock.Lock()
+			_, found := rpcs[node.Nid]
 			if !found {
-				rpcID := dis.GetRPCChannel(node)
-				rpcs[id] = protoo.NewRequestor(rpcID)
+				rpcs[node.Nid] = protoo.NewRequestor(dis.GetRPCChannel(node))
+			}
+			rpcsLock.Unlock()
+			if !found {
+				// 多个issr按组订阅islb的广播,每个事件只处理一次
+				protoo.OnBroadcastWithGroup(dis.GetEventChannel(node), "issr", handleIslbBroadcast)
 			}
 		}
 		if node.Name == "sfu" {
@@ -80,24 +120,61 @@ func WatchServiceCallBack(state dis.NodeStateType, node dis.Node) {
 		}
 	} else if state == dis.ServerDown {
 		log.Infof("WatchServiceCallBack node down %v", node.Nid)
-		if _, found := rpcs[node.Nid]; found {
-			delete(rpcs, node.Nid)
-		}
+		rpcsLock.Lock()
+		delete(rpcs, node.Nid)
+		rpcsLock.Unlock()
 	}
 }
 
-// findIslbNode 查询全局的可用的islb节点
+// SetTopology 设置区域之间的延迟,本区域没有islb时就近选择其他区域
+func SetTopology(t dis.Topology) {
+	topology = t
+}
+
+// SetWebhook 设置应用的webhook,需要在Init之前调用
+func SetWebhook(c webhook.Config) {
+	webhookConfig = c
+}
+
+// SetExporter 设置信令事件导出,需要在Init之前调用
+func SetExporter(c exporter.Config) {
+	exporterConfig = c
+}
+
+// SetOutbox 设置计费记录的发送箱,需要在Init之前调用
+func SetOutbox(c kafka.OutboxConfig) {
+	outboxConfig = c
+}
+
+// SetBilling 设置订阅计费,需要在Init之前调用
+func SetBilling(c billing.Config) {
+	billingConfig = c
+}
+
+// SetTiers 设置分辨率计费档位,可以在运行中重新加载,配置错误时返回错误并保留原来的档位
+func SetTiers(c timing.TierConfig) error {
+	t, err := timing.NewTiers(c)
+	if err != nil {
+		return err
+	}
+	tiers = t
+	if meter != nil {
+		meter.SetTiers(t)
+		lives.SetTiers(t)
+	}
+	return nil
+}
+
+// findIslbNode 查询可用的islb节点,优先本区域,其次就近选择其他区域
 func findIslbNode() *dis.Node {
-	servers, find := watch.GetNodes("islb")
+	islb, find := watch.GetNodeByDC(node.NodeInfo().Ndc, "islb", topology)
 	if find {
-		for _, node := range servers {
-			return &node
-		}
+		return islb
 	}
 	return nil
 }
 
-func getIslbRequestor() *nprotoo.Requestor {
+func getIslbRequestor() bus.Requestor {
 	islb := findIslbNode()
 
 	if islb == nil {
@@ -105,8 +182,9 @@ func getIslbRequestor() *nprotoo.Requestor {
 		return nil
 	}
 
-	find := false
+	rpcsLock.RLock()
 	rpc, find := rpcs[islb.Nid]
+	rpcsLock.RUnlock()
 	if !find {
 		log.Errorf("islb rpc not found")
 		return nil
@@ -125,43 +203,60 @@ func Close() {
 	if watch != nil {
 		watch.Close()
 	}
+	if events != nil {
+		events.Close()
+	}
+	if usage != nil {
+		usage.Close()
+	}
 	if kafkaProducer != nil {
 		kafkaProducer.Close()
 	}
-	if kafkaClient != nil {
-		kafkaClient.Close()
+}
+
+// checkWebhookFailures 定时重传推送失败的webhook事件
+func checkWebhookFailures() {
+	t := time.NewTicker(statCycle)
+	defer t.Stop()
+	for range t.C {
+		if sent := hooks.RetryFailures(); sent > 0 {
+			logger.Infof(fmt.Sprintf("issr.checkWebhookFailures resent %d events", sent))
+		}
 	}
 }
 
-// checkFailures 检查失败并重传
-func checkFailures() {
+// checkBilling 定时关闭心跳超时的sfu上的订阅和islb没有上报的直播流
+func checkBilling() {
 	t := time.NewTicker(statCycle)
 	defer t.Stop()
 	for range t.C {
-		length := redis.LLen(failureKey)
-		for i := int64(0); i < length; i++ {
-			failure := redis.LPop(failureKey)
-			if failure != "" {
-				msg := util.Unmarshal(failure)
-				timestamp := time.Now().UnixNano() / 1000
-				msg["timestamp"] = timestamp
-				str, err := json.Marshal(msg)
-				if err != nil {
-					logger.Errorf(fmt.Sprintf("issr.checkFailures json marshal failed=%v", err))
-				} else {
-					//logger.Infof(fmt.Sprintf("issr.checkFailures msg: %s", string(str)))
-					err = kafkaProducer.Produce("Livs-Usage-Event", string(str))
-					if err != nil {
-						logger.Errorf(fmt.Sprintf("issr.checkFailures kafka produce error=%v", err))
-						err = redis.RPush(failureKey, string(str))
-						if err != nil {
-							logger.Errorf(fmt.Sprintf("issr.checkFailures store failure err=%v", err))
-						}
-					}
-				}
-			} else {
-				break
+		if count := meter.Sweep(); count > 0 {
+			logger.Warnf(fmt.Sprintf("issr.checkBilling closed %d orphaned subscriptions", count))
+		}
+		if count := lives.Sweep(); count > 0 {
+			logger.Warnf(fmt.Sprintf("issr.checkBilling closed %d orphaned live streams", count))
+		}
+	}
+}
+
+// migrateFailures 把旧版本放入redis失败队列的计时数据转入发送箱
+func migrateFailures() {
+	count := 0
+	for {
+		failure := redis.LPop(failureKey)
+		if failure == "" {
+			break
+		}
+		if err := usage.Send(usageTopic, usageKey(failure), failure); err != nil {
+			logger.Errorf(fmt.Sprintf("issr.migrateFailures send err=%v", err))
+			if err = redis.RPush(failureKey, failure); err != nil {
+				logger.Errorf(fmt.Sprintf("issr.migrateFailures store failure err=%v", err))
 			}
+			break
 		}
+		count++
+	}
+	if count > 0 {
+		logger.Infof(fmt.Sprintf("issr.migrateFailures moved %d reports to outbox", count))
 	}
 }
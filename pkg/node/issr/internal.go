@@ -1,15 +1,19 @@
 package issr
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	dis "signal/infra/discovery"
 	"signal/infra/monitor"
+	"signal/pkg/billing"
+	"signal/pkg/bus"
+	"signal/pkg/exporter"
 	"signal/pkg/proto"
-	"signal/pkg/timing"
+	"signal/pkg/rollup"
 	"signal/util"
 	"time"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 // 处理广播消息
@@ -18,29 +22,57 @@ func handleBroadcast(msg map[string]interface{}, subj string) {
 		logger.Infof(fmt.Sprintf("issr.handleBroadcast recv msg=%v", msg))
 		method := util.Val(msg, "method")
 		data := msg["data"].(map[string]interface{})
+		if events != nil {
+			events.Export(exporter.SourceSfu, dis.GetNidFromEventChannel(subj), method, data)
+		}
 		switch method {
 		case proto.SfuToIssrOnSubscribeAdd:
-			subStreamStart(data)
+			subscriptionAdd(data)
 		case proto.SfuToIssrOnSubscribeRemove:
-			subStreamEnd(data)
+			subscriptionRemove(data)
+		case proto.SfuToIssrOnSubscribeHeartbeat:
+			subscriptionHeartbeat(data)
 		}
 	}(msg, subj)
 }
 
+// handleIslbBroadcast 处理islb的广播,导出事件并推送webhook
+func handleIslbBroadcast(msg map[string]interface{}, subj string) {
+	method := util.Val(msg, "method")
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if events != nil {
+		events.Export(exporter.SourceIslb, dis.GetNidFromEventChannel(subj), method, data)
+	}
+	if hooks != nil {
+		hooks.Handle(method, data)
+	}
+	switch method {
+	case proto.IslbToBizOnLiveAdd:
+		liveAdd(data)
+	case proto.IslbToBizOnLiveRemove:
+		lives.Stop(util.Val(data, "rid"), util.Val(data, "uid"), util.Val(data, "mid"), "stop")
+	case proto.IslbToIssrOnLiveHeartbeat:
+		liveHeartbeat(data)
+	}
+}
+
 // handleRPCMsgs 处理其他模块发送过来的消息
 func handleRPCRequest(rpcID string) {
 
 	logger.Infof(fmt.Sprintf("issr.handleRequest: rpcID=%s", rpcID), "rpcid", rpcID)
 
-	protoo.OnRequest(rpcID, func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
-		go func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
+	protoo.OnRequest(rpcID, func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
+		go func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
 			defer util.Recover("issr.handleRPCRequest")
 			//logger.Infof(fmt.Sprintf("issr.handleRPCRequest recv request=%v", request), "rpcid", rpcID)
 			method := request["method"].(string)
 			data := request["data"].(map[string]interface{})
 
 			var result map[string]interface{}
-			err := &nprotoo.Error{Code: 400, Reason: fmt.Sprintf("Unkown method [%s]", method)}
+			err := proto.NewError(proto.ErrInvalidMethod, method)
 
 			if method != "" {
 				processingTime := monitor.NewProcessingTimeGauge(method)
@@ -67,10 +99,16 @@ func handleRPCRequest(rpcID string) {
 	"method", proto.BizToSsReportStreamState, "rid", rid, "uid", uid, "mid", mid, "sid", sid, "resolution", hd, "seconds", seconds
 */
 // report 上报拉流计时数据
-func report(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func report(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	// 判断参数
 	if msg["appid"] == nil {
-		return nil, &nprotoo.Error{Code: -1, Reason: "can't find appid"}
+		return nil, proto.NewError(proto.ErrInvalidParams, "can't find appid")
+	}
+
+	// biz重试时id不变,没有id时按内容生成key
+	key := util.Val(msg, "id")
+	if key == "" {
+		key = usageKey(util.Marshal(msg))
 	}
 
 	timestamp := time.Now().UnixNano() / 1000
@@ -79,220 +117,107 @@ func report(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error)
 	str, err := json.Marshal(msg)
 	if err != nil {
 		logger.Errorf(fmt.Sprintf("issr.report json marshal failed=%v", err))
-		return nil, &nprotoo.Error{Code: -1, Reason: fmt.Sprintf("json marshal err:%v", err)}
+		return nil, proto.NewError(proto.ErrInternal, err)
 	}
-	err = kafkaProducer.Produce("Livs-Usage-Event", string(str))
+	err = usage.Send(usageTopic, key, string(str))
 	if err != nil {
-		logger.Errorf(fmt.Sprintf("issr.report kafka produce error=%v", err))
-		err = redis.RPush(failureKey, string(str))
-		if err != nil {
-			logger.Errorf(fmt.Sprintf("issr.report store failure err=%v", err))
-		}
-		return nil, &nprotoo.Error{Code: -1, Reason: fmt.Sprintf("kafka produce err:%v", err)}
+		logger.Errorf(fmt.Sprintf("issr.report outbox send error=%v", err))
+		return nil, proto.NewError(proto.ErrReportFailed, err)
 	}
+	end := time.Now().Unix()
+	addRollups(rollup.Rollup{
+		AppID:      util.Val(msg, "appid"),
+		Type:       int(util.InterfaceToInt64(msg["type"])),
+		MediaType:  util.Val(msg, "mediatype"),
+		Resolution: util.Val(msg, "resolution"),
+	}, end-util.InterfaceToInt64(msg["seconds"]), end)
 	logger.Infof(fmt.Sprintf("issr.report msg: %s", string(str)))
 	return util.Map(), nil
 }
 
-func subStreamStart(msg map[string]interface{}) {
-	//logger.Infof(fmt.Sprintf("issr.subStreamStart msg:%v", msg))
-	rid, ok := msg["rid"].(string)
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamStart rid can't be empty"))
+// subscriptionAdd 订阅添加,开始计费
+func subscriptionAdd(data map[string]interface{}) {
+	var n proto.SfuSubscriptionNotification
+	if err := proto.Decode(data, &n); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.subscriptionAdd invalid data err=%v", err.Reason))
 		return
 	}
-	uid, ok := msg["uid"].(string)
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamStart uid can't be empty"))
+	meter.Start(n.NID, n.Subscription)
+}
+
+// subscriptionRemove 订阅移除,结束计费
+func subscriptionRemove(data map[string]interface{}) {
+	var n proto.SfuSubscriptionNotification
+	if err := proto.Decode(data, &n); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.subscriptionRemove invalid data err=%v", err.Reason))
 		return
 	}
-	minfo, ok := msg["minfo"].(map[string]interface{})
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamStart	minfo can't be found"))
+	meter.Stop(n.SID, n.Reason)
+}
+
+// subscriptionHeartbeat sfu上报节点上所有的订阅,按心跳对账
+func subscriptionHeartbeat(data map[string]interface{}) {
+	var n proto.SfuHeartbeatNotification
+	if err := proto.Decode(data, &n); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.subscriptionHeartbeat invalid data err=%v", err.Reason))
 		return
 	}
-	appid := util.InterfaceToString(minfo["appid"])
-	if appid == "" {
-		logger.Errorf(fmt.Sprint("issr.subStreamStart	minfo can't be empty"))
+	meter.Heartbeat(n.NID, n.Subs)
+}
+
+// liveAdd 直播添加,开始计时
+func liveAdd(data map[string]interface{}) {
+	var live proto.LiveStream
+	if err := proto.Decode(data, &live); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.liveAdd invalid data err=%v", err.Reason))
 		return
 	}
+	lives.Start(live)
+}
 
-	resolution := util.InterfaceToString(minfo["resolution"])
-
-	isVideo := util.InterfaceToBool(minfo["video"])
-	isAudio := util.InterfaceToBool(minfo["audio"])
-	isScreen := util.InterfaceToBool(minfo["screen"])
-	isCanvas := util.InterfaceToBool(minfo["canvas"])
-
-	var mode string
-	if isVideo || isScreen || isCanvas {
-		mode = "video"
-	} else if isAudio {
-		mode = "audio"
+// liveHeartbeat islb上报负责的房间中所有的直播流,按上报对账
+func liveHeartbeat(data map[string]interface{}) {
+	var n proto.IslbLiveHeartbeatNotification
+	if err := proto.Decode(data, &n); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.liveHeartbeat invalid data err=%v", err.Reason))
+		return
 	}
+	lives.Heartbeat(n.Lives)
+}
 
-	//get distributed lock for user subscription timing
-	lockKey := proto.GetSubStreamTimingLockKey(appid, rid, uid)
-	redis.Lock(lockKey)
-	defer redis.Unlock(lockKey)
-	//for now ,just calc video stream
-	if mode == "video" && resolution != "" {
-		key := proto.GetSubVideoStreamTimingKey(appid, rid, uid)
-		info := redis.Get(key)
-		if info == "" {
-			val := util.Map("rid", rid, "uid", uid, "appid", appid, "mode", mode,
-				"pixels", timing.GetPixelsByResolution(resolution), "count", 1,
-				"time", time.Now().Unix())
-			err := redis.Set(key, util.Marshal(val), redisKeyTTL)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("issr.subStreamStart first set fail v=%v", err), "rid", rid, "uid", uid)
-				return
-			}
-		} else {
-			data := util.Unmarshal(info)
-			//logger.Infof(fmt.Sprintf("issr.subStreamStart data:%v", data), "rid", rid, "uid", uid)
-			count := util.InterfaceToInt64(data["count"])
-			count += 1
-			lastPixels := util.InterfaceToInt64(data["pixels"])
-			lastResolution := timing.TransformResolutionFromPixels(lastPixels)
-			pixels := timing.GetPixelsByResolution(resolution)
-			totalPixels := lastPixels + pixels
-			currentResolution := timing.TransformResolutionFromPixels(totalPixels)
-			start := util.InterfaceToInt64(data["time"])
-			end := time.Now().Unix()
-			isResChanged := currentResolution != lastResolution
-			if isResChanged {
-				interval := end - start
-				if interval > 0 && lastResolution != "" {
-					timestamp := time.Now().UnixNano() / 1000
-					timingReport := util.Map("appid", appid, "rid", rid, "uid", uid, "mediatype", "video",
-						"resolution", lastResolution, "timestamp", timestamp, "seconds", interval,
-						"type", timingType)
-					str := util.Marshal(timingReport)
-					err := kafkaProducer.Produce("Livs-Usage-Event", string(str))
-					logger.Infof(fmt.Sprintf("issr.subStreamStart report: %s", string(str)))
-					if err != nil {
-						logger.Errorf(fmt.Sprintf("issr.subStreamStart kafka produce error=%v", err))
-						err = redis.RPush(failureKey, string(str))
-						if err != nil {
-							logger.Errorf(fmt.Sprintf("issr.subStreamStart store failure err=%v", err))
-						}
-					}
-				}
-			}
-			var ts int64
-			if isResChanged {
-				ts = end
-			} else {
-				ts = start
-			}
-			val := util.Map("rid", rid, "uid", uid, "appid", appid, "pixels", totalPixels, "time", ts, "count", count)
-			err := redis.Set(key, util.Marshal(val), redisKeyTTL)
-			if err != nil {
-				logger.Errorf(fmt.Sprintf("issr.subStreamStart set fail v=%v", err), "rid", rid, "uid", uid)
-				return
-			}
-		}
+// reportUsage 订阅计费记录写入发送箱
+func reportUsage(r billing.Record) {
+	r.Type = timingType
+	if r.MediaType == billing.MediaAudio {
+		r.Type = audioTimingType
 	}
+	sendRecord(r)
 }
 
-func subStreamEnd(msg map[string]interface{}) {
-	//logger.Infof(fmt.Sprintf("issr.subStreamEnd msg:%v", msg))
-	rid, ok := msg["rid"].(string)
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamEnd rid can't be empty"))
-		return
-	}
-	uid, ok := msg["uid"].(string)
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamEnd uid can't be empty"))
-		return
-	}
-	minfo, ok := msg["minfo"].(map[string]interface{})
-	if !ok {
-		logger.Errorf(fmt.Sprint("issr.subStreamEnd	minfo can't be found"))
+// reportLive 直播计时记录写入发送箱
+func reportLive(r billing.Record) {
+	r.Type = liveTimingType
+	sendRecord(r)
+}
+
+// sendRecord 计费记录写入发送箱并累加到每日汇总
+func sendRecord(r billing.Record) {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		logger.Errorf(fmt.Sprintf("issr.sendRecord json marshal failed=%v", err))
 		return
 	}
-	appid := util.InterfaceToString(minfo["appid"])
-	if appid == "" {
-		logger.Errorf(fmt.Sprint("issr.subStreamEnd	appid can't be empty"))
+	str := string(buf)
+	logger.Infof(fmt.Sprintf("issr.sendRecord report: %s", str))
+	if err = usage.Send(usageTopic, r.Key(), str); err != nil {
+		logger.Errorf(fmt.Sprintf("issr.sendRecord outbox send error=%v", err))
 		return
 	}
+	addRollups(rollup.Rollup{AppID: r.AppID, Type: r.Type, MediaType: r.MediaType, Resolution: r.Resolution}, r.Start, r.End)
+}
 
-	resolution := util.InterfaceToString(minfo["resolution"])
-
-	isVideo := util.InterfaceToBool(minfo["video"])
-	isAudio := util.InterfaceToBool(minfo["audio"])
-	isScreen := util.InterfaceToBool(minfo["screen"])
-	isCanvas := util.InterfaceToBool(minfo["canvas"])
-
-	var mode string
-	if isVideo || isScreen || isCanvas {
-		mode = "video"
-	} else if isAudio {
-		mode = "audio"
-	}
-
-	//get distributed lock for user subscription timing
-	lockKey := proto.GetSubStreamTimingLockKey(appid, rid, uid)
-	redis.Lock(lockKey)
-	defer redis.Unlock(lockKey)
-	if mode == "video" && resolution != "" {
-		key := proto.GetSubVideoStreamTimingKey(appid, rid, uid)
-		info := redis.Get(key)
-		if info != "" {
-			data := util.Unmarshal(info)
-			//logger.Infof(fmt.Sprintf("issr.subStreamEnd data:%v", data), "rid", rid, "uid", uid)
-			count := util.InterfaceToInt64(data["count"])
-			count -= 1
-			lastPixels := util.InterfaceToInt64(data["pixels"])
-			lastResolution := timing.TransformResolutionFromPixels(lastPixels)
-			pixels := timing.GetPixelsByResolution(resolution)
-			totalPixels := lastPixels - pixels
-			currentResolution := timing.TransformResolutionFromPixels(totalPixels)
-			start := util.InterfaceToInt64(data["time"])
-			end := time.Now().Unix()
-			isResChanged := lastResolution != currentResolution
-			if isResChanged {
-				interval := end - start
-				if interval > 0 && lastResolution != "" {
-					timestamp := time.Now().UnixNano() / 1000
-					timingReport := util.Map("appid", appid, "rid", rid, "uid", uid, "mediatype", "video",
-						"resolution", lastResolution, "timestamp", timestamp, "seconds", interval,
-						"type", timingType)
-					str := util.Marshal(timingReport)
-					err := kafkaProducer.Produce("Livs-Usage-Event", string(str))
-					logger.Infof(fmt.Sprintf("issr.subStreamEnd report: %s", string(str)))
-					if err != nil {
-						logger.Errorf(fmt.Sprintf("issr.subStreamEnd kafka produce error=%v", err))
-						failureKey := proto.GetFailedStreamStateKey()
-						err = redis.RPush(failureKey, string(str))
-						if err != nil {
-							logger.Errorf(fmt.Sprintf("issr.subStreamEnd store failure err=%v", err))
-						}
-					}
-				}
-			}
-			if count != 0 {
-				var ts int64
-				if isResChanged {
-					ts = end
-				} else {
-					ts = start
-				}
-				val := util.Map("rid", rid, "uid", uid, "appid", appid, "pixels", totalPixels, "time", ts, "count", count)
-				err := redis.Set(key, util.Marshal(val), redisKeyTTL)
-				if err != nil {
-					logger.Errorf(fmt.Sprintf("issr.subStreamEnd set fail v=%v", err), "rid", rid, "uid", uid)
-					return
-				}
-			} else {
-				err := redis.Del(key)
-				if err != nil {
-					logger.Errorf(fmt.Sprintf("issr.subStreamEnd del fail v=%v", err), "rid", rid, "uid", uid)
-					return
-				}
-			}
-		}
-	}
+// usageKey 按内容生成计时数据的幂等key
+func usageKey(message string) string {
+	sum := sha1.Sum([]byte(message))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,553 @@
+package node_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dis "signal/infra/discovery"
+	h "signal/infra/http"
+	"signal/infra/kafka"
+	"signal/infra/logger"
+	db "signal/infra/redis"
+	"signal/pkg/bus"
+	"signal/pkg/exporter"
+	biz "signal/pkg/node/biz"
+	islb "signal/pkg/node/islb"
+	issr "signal/pkg/node/issr"
+	"signal/pkg/node/sfu"
+	"signal/pkg/proto"
+	"signal/pkg/rollup"
+	"signal/pkg/rtc"
+	"signal/pkg/rtc/plugins"
+	"signal/pkg/store"
+	"signal/pkg/webhook"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v2"
+)
+
+// 在一个进程内启动biz、islb、sfu和issr,节点之间使用进程内的注册中心和消息总线
+// 客户端通过websocket连接biz,覆盖 客户端 -> biz -> islb/sfu/issr -> biz -> 客户端 的完整流程
+
+const (
+	testDC      = "test"
+	testTimeout = 5 * time.Second
+	testAppID   = "test"
+	testSecret  = "secret"
+	otherAppID  = "other"
+)
+
+// cluster 进程内的服务集群
+type cluster struct {
+	registry *dis.MemoryRegistry
+	network  *bus.Network
+	port     int
+	api      string
+	sink     string
+	hooks    *hookReceiver
+}
+
+// hookReceiver 记录issr推送的webhook事件
+type hookReceiver struct {
+	sync.Mutex
+	events []proto.WebhookEvent
+}
+
+func (r *hookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var event proto.WebhookEvent
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.Lock()
+	r.events = append(r.events, event)
+	r.Unlock()
+}
+
+// expect 等待收到uid的事件
+func (r *hookReceiver) expect(t *testing.T, name, uid string) {
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		r.Lock()
+		for _, event := range r.events {
+			if event.Event == name && event.UID == uid && event.AppID == testAppID && event.RID == "room1" {
+				r.Unlock()
+				return
+			}
+		}
+		r.Unlock()
+	}
+	t.Errorf("webhook %s %s not received", name, uid)
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func (c *cluster) service(name string) (*dis.ServiceNode, *dis.ServiceWatcher) {
+	node := dis.NewServiceNodeWithRegistry(c.registry.Session(), testDC, testDC+"_"+name+"_1", name, "127.0.0.1")
+	node.RegisterNode()
+	return node, dis.NewServiceWatcherWithRegistry(c.registry.Session())
+}
+
+func (c *cluster) logger(name string) *logger.Logger {
+	return logger.NewLogger(testDC, name, testDC+"_"+name+"_1", "127.0.0.1", "error", false, nil)
+}
+
+func startCluster(t *testing.T) *cluster {
+	port := freePort(t)
+	apiPort := freePort(t)
+	c := &cluster{
+		registry: dis.NewMemoryRegistry(),
+		network:  bus.NewNetwork(),
+		port:     port,
+		api:      fmt.Sprintf("http://127.0.0.1:%d/api/v1/server/", apiPort),
+		sink:     filepath.Join(t.TempDir(), "usage.log"),
+		hooks:    &hookReceiver{},
+	}
+	hookServer := httptest.NewServer(c.hooks)
+	t.Cleanup(hookServer.Close)
+
+	node, watcher := c.service("islb")
+	islb.Init(node, watcher, c.network.Connect(), store.NewMemoryStore(), c.logger("islb"))
+
+	producer, err := kafka.NewFileProducer(c.sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, watcher = c.service("issr")
+	issr.SetWebhook(webhook.Config{Hooks: []webhook.Hook{{AppID: testAppID, URL: hookServer.URL, Secret: testSecret}}})
+	issr.SetExporter(exporter.Config{Enable: true})
+	issr.SetOutbox(kafka.OutboxConfig{Dir: t.TempDir()})
+	issr.SetRollup(rollup.NewMemoryStore())
+	issr.Init(node, watcher, c.network.Connect(), producer, db.NewMemory(), c.logger("issr"))
+
+	node, watcher = c.service("sfu")
+	sfu.Init(node, watcher, c.network.Connect(), rtc.Config{
+		Plugins: plugins.Config{
+			On:           true,
+			JitterBuffer: plugins.JitterBufferConfig{On: true, REMBCycle: 2, PLICycle: 1, MaxBandwidth: 1000, MaxBufferTime: 1000},
+		},
+	}, c.logger("sfu"))
+
+	node, watcher = c.service("biz")
+	biz.Init(node, watcher, c.network.Connect(), c.logger("biz"))
+	if err := biz.InitBalance(nil, dis.Weights{}, false, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	biz.InitSignalServer("127.0.0.1", port, "", "")
+	var server h.Http
+	server.Init("127.0.0.1", strconv.Itoa(apiPort))
+	g := server.Group("/api/v1", nil, nil)
+	biz.InitServerAPI(g, map[string]string{testAppID: testSecret, otherAppID: testSecret}, 0)
+	issr.InitUsageAPI(g, testSecret)
+
+	t.Cleanup(func() {
+		biz.Close()
+		sfu.Close()
+		issr.Close()
+		islb.Close()
+	})
+	return c
+}
+
+// serverRequest 以应用服务端的身份签名并调用biz的http接口
+func (c *cluster) serverRequest(t *testing.T, method string, data interface{}, secret string) (map[string]interface{}, int) {
+	return c.serverRequestAs(t, testAppID, method, data, secret)
+}
+
+// serverRequestAs 以appid对应的应用服务端签名并调用biz的http接口
+func (c *cluster) serverRequestAs(t *testing.T, appid, method string, data interface{}, secret string) (map[string]interface{}, int) {
+	body, _ := json.Marshal(data)
+	req, err := http.NewRequest(http.MethodPost, c.api+method, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SignRequest(req, appid, secret, body)
+	return c.send(t, req, body)
+}
+
+// send 发送已签名的请求,http服务还没有启动时重试
+func (c *cluster) send(t *testing.T, req *http.Request, body []byte) (map[string]interface{}, int) {
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		// 等待http服务启动
+		if resp, err = http.DefaultClient.Do(req.Clone(req.Context())); err == nil {
+			break
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := make(map[string]interface{})
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result, resp.StatusCode
+}
+
+// client 测试用的信令客户端
+type client struct {
+	t             *testing.T
+	uid           string
+	conn          *websocket.Conn
+	lock          sync.Mutex
+	pending       map[int]chan map[string]interface{}
+	notifications chan map[string]interface{}
+}
+
+func (c *cluster) dial(t *testing.T, uid string) *client {
+	url := fmt.Sprintf("ws://127.0.0.1:%d/ws?peer=%s&appid=test", c.port, uid)
+	var conn *websocket.Conn
+	var err error
+	// 等待信令服务启动
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if conn, _, err = websocket.DefaultDialer.Dial(url, nil); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("dial %s: %v", uid, err)
+	}
+	cl := &client{
+		t:             t,
+		uid:           uid,
+		conn:          conn,
+		pending:       make(map[int]chan map[string]interface{}),
+		notifications: make(chan map[string]interface{}, 100),
+	}
+	go cl.read()
+	t.Cleanup(func() { conn.Close() })
+	return cl
+}
+
+func (cl *client) read() {
+	for {
+		var msg map[string]interface{}
+		if err := cl.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg["response"] == true {
+			id := int(msg["id"].(float64))
+			cl.lock.Lock()
+			ch := cl.pending[id]
+			delete(cl.pending, id)
+			cl.lock.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+		} else if msg["notification"] == true {
+			cl.notifications <- msg
+		}
+	}
+}
+
+// request 发送请求,返回data或错误码
+func (cl *client) request(method string, data map[string]interface{}) (map[string]interface{}, int) {
+	id := rand.Intn(1 << 30)
+	ch := make(chan map[string]interface{}, 1)
+	cl.lock.Lock()
+	cl.pending[id] = ch
+	cl.lock.Unlock()
+	if err := cl.conn.WriteJSON(map[string]interface{}{"request": true, "id": id, "method": method, "data": data}); err != nil {
+		cl.t.Fatalf("%s %s: %v", cl.uid, method, err)
+	}
+	select {
+	case resp := <-ch:
+		if resp["ok"] != true {
+			return nil, int(resp["errorCode"].(float64))
+		}
+		result, _ := resp["data"].(map[string]interface{})
+		return result, 0
+	case <-time.After(testTimeout):
+		cl.t.Fatalf("%s %s: no response", cl.uid, method)
+	}
+	return nil, 0
+}
+
+// mustRequest 发送请求,失败时结束测试
+func (cl *client) mustRequest(method string, data map[string]interface{}) map[string]interface{} {
+	result, code := cl.request(method, data)
+	if code != 0 {
+		cl.t.Fatalf("%s %s: error %d", cl.uid, method, code)
+	}
+	return result
+}
+
+// expect 等待指定的通知,跳过其他通知
+func (cl *client) expect(method string) map[string]interface{} {
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-cl.notifications:
+			if msg["method"] == method {
+				data, _ := msg["data"].(map[string]interface{})
+				return data
+			}
+		case <-timeout:
+			cl.t.Fatalf("%s: no %s notification", cl.uid, method)
+			return nil
+		}
+	}
+}
+
+// offer 生成H264和Opus的offer,send为false时只接收
+func offer(t *testing.T, send bool) map[string]interface{} {
+	m := webrtc.MediaEngine{}
+	m.RegisterCodec(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	m.RegisterCodec(webrtc.NewRTPH264Codec(webrtc.DefaultPayloadTypeH264, 90000))
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	if send {
+		for _, pt := range []uint8{webrtc.DefaultPayloadTypeH264, webrtc.DefaultPayloadTypeOpus} {
+			track, err := pc.NewTrack(pt, rand.Uint32(), fmt.Sprintf("track%d", pt), "stream")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := pc.AddTrack(track); err != nil {
+				t.Fatal(err)
+			}
+		}
+	} else {
+		for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+			if _, err := pc.AddTransceiverFromKind(kind, webrtc.RtpTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	desc, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{"type": "offer", "sdp": desc.SDP}
+}
+
+func TestProtocol(t *testing.T) {
+	c := startCluster(t)
+	alice := c.dial(t, "alice")
+	bob := c.dial(t, "bob")
+
+	// 节点注册是异步的,等biz发现islb后才能加入房间
+	var code int
+	for deadline := time.Now().Add(testTimeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if _, code = alice.request(proto.ClientToBizJoin, map[string]interface{}{"rid": "room1", "info": map[string]interface{}{"name": "alice"}}); code != proto.ErrIslbUnavailable {
+			break
+		}
+	}
+	if code != 0 {
+		t.Fatalf("alice join: error %d", code)
+	}
+
+	result := bob.mustRequest(proto.ClientToBizJoin, map[string]interface{}{"rid": "room1", "info": map[string]interface{}{"name": "bob"}})
+	if users, _ := result["users"].([]interface{}); len(users) != 1 {
+		t.Errorf("bob join users = %v", result["users"])
+	}
+	if data := alice.expect(proto.BizToClientOnJoin); data["uid"] != "bob" {
+		t.Errorf("peer-join = %v", data)
+	}
+
+	// 发布流: biz -> sfu 协商, biz -> islb 保存并广播
+	minfo := map[string]interface{}{"audio": true, "video": true, "resolution": "720p"}
+	pub := alice.mustRequest(proto.ClientToBizPublish, map[string]interface{}{"rid": "room1", "jsep": offer(t, true), "minfo": minfo})
+	mid, _ := pub["mid"].(string)
+	if !strings.HasPrefix(mid, "alice#") || pub["nid"] != testDC+"_sfu_1" {
+		t.Fatalf("publish = %v", pub)
+	}
+	if jsep, _ := pub["jsep"].(map[string]interface{}); jsep["type"] != "answer" || jsep["sdp"] == "" {
+		t.Errorf("publish jsep = %v", pub["jsep"])
+	}
+	if data := bob.expect(proto.BizToClientOnStreamAdd); data["mid"] != mid {
+		t.Errorf("stream-add = %v", data)
+	}
+
+	sub := bob.mustRequest(proto.ClientToBizSubscribe, map[string]interface{}{"rid": "room1", "mid": mid, "jsep": offer(t, false), "minfo": minfo})
+	if jsep, _ := sub["jsep"].(map[string]interface{}); jsep["type"] != "answer" {
+		t.Errorf("subscribe = %v", sub)
+	}
+	if _, code := bob.request(proto.ClientToBizUnSubscribe, map[string]interface{}{"rid": "room1", "mid": "bob#unknown", "nid": pub["nid"]}); code != proto.ErrSubNotFound {
+		t.Errorf("unsubscribe unknown code = %d", code)
+	}
+
+	// 管理接口查询房间和sfu上的router
+	islbRPC := c.network.Connect().NewRequestor(dis.GetRPCChannel(dis.Node{Nid: testDC + "_islb_1"}))
+	info, rpcErr := islbRPC.SyncRequest(proto.BizToIslbGetRoomInfo, map[string]interface{}{"rid": "room1"})
+	if rpcErr != nil {
+		t.Fatalf("getRoomInfo: %v", rpcErr)
+	}
+	if users, _ := info["users"].([]interface{}); len(users) != 2 || info["sfu"] != testDC+"_sfu_1" {
+		t.Errorf("getRoomInfo = %v", info)
+	}
+	if rooms, err := islbRPC.SyncRequest(proto.BizToIslbGetRooms, map[string]interface{}{}); err != nil || len(rooms["rooms"].([]interface{})) != 1 {
+		t.Errorf("getRooms = %v, %v", rooms, err)
+	}
+	sfuRPC := c.network.Connect().NewRequestor(dis.GetRPCChannel(dis.Node{Nid: testDC + "_sfu_1"}))
+	routers, rpcErr := sfuRPC.SyncRequest(proto.BizToSfuGetRouters, map[string]interface{}{"rid": "room1"})
+	if rpcErr != nil {
+		t.Fatalf("getRouters: %v", rpcErr)
+	}
+	if list, _ := routers["routers"].([]interface{}); len(list) != 1 {
+		t.Errorf("getRouters = %v", routers)
+	} else if router := list[0].(map[string]interface{}); router["mid"] != mid || len(router["subs"].([]interface{})) != 1 {
+		t.Errorf("router = %v", router)
+	}
+
+	// 应用服务端经http给用户发消息和查询房间
+	if _, status := c.serverRequest(t, proto.ServerParticipants, map[string]interface{}{"rid": "room1"}, "bad"); status != http.StatusUnauthorized {
+		t.Errorf("bad signature status = %d", status)
+	}
+	if result, status := c.serverRequest(t, proto.ServerMessage, map[string]interface{}{"rid": "room1", "to": []string{"bob"}, "data": map[string]interface{}{"text": "hi"}}, testSecret); status != http.StatusOK || result["acks"].(map[string]interface{})["bob"] != true {
+		t.Errorf("server message = %d %v", status, result)
+	}
+	if data := bob.expect(proto.BizToClientOnMessage); data["uid"] != "server" {
+		t.Errorf("server message notification = %v", data)
+	}
+	if result, status := c.serverRequest(t, proto.ServerParticipants, map[string]interface{}{"rid": "room1"}, testSecret); status != http.StatusOK || len(result["users"].([]interface{})) != 2 {
+		t.Errorf("participants = %d %v", status, result)
+	}
+	// 房间属于其他应用
+	if _, status := c.serverRequestAs(t, otherAppID, proto.ServerKick, map[string]interface{}{"rid": "room1", "uid": "bob"}, testSecret); status != http.StatusForbidden {
+		t.Errorf("other app kick status = %d", status)
+	}
+	// 重放的请求
+	body := []byte(`{"rid":"room1","to":["bob"],"data":{"text":"again"}}`)
+	req, _ := http.NewRequest(http.MethodPost, c.api+proto.ServerMessage, bytes.NewReader(body))
+	h.SignRequest(req, testAppID, testSecret, body)
+	replay := req.Clone(req.Context())
+	replay.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if _, status := c.send(t, req, body); status != http.StatusOK {
+		t.Errorf("server message status = %d", status)
+	}
+	bob.expect(proto.BizToClientOnMessage)
+	if _, status := c.send(t, replay, body); status != http.StatusUnauthorized {
+		t.Errorf("replay status = %d", status)
+	}
+	// 内容相同的请求nonce不同,不是重放
+	for i := 0; i < 2; i++ {
+		if _, status := c.serverRequest(t, proto.ServerMessage, map[string]interface{}{"rid": "room1", "to": []string{"bob"}, "data": "same"}, testSecret); status != http.StatusOK {
+			t.Errorf("identical message %d status = %d", i, status)
+		}
+		bob.expect(proto.BizToClientOnMessage)
+	}
+	// 发送者必须是房间中的用户
+	if _, status := c.serverRequest(t, proto.ServerMessage, map[string]interface{}{"rid": "room1", "from": "mallory", "to": []string{"bob"}, "data": "hi"}, testSecret); status != http.StatusNotFound {
+		t.Errorf("unknown sender status = %d", status)
+	}
+	if result, status := c.serverRequest(t, proto.ServerMessage, map[string]interface{}{"rid": "room1", "from": "alice", "to": []string{"bob"}, "data": "hi"}, testSecret); status != http.StatusOK || result["acks"].(map[string]interface{})["bob"] != true {
+		t.Errorf("member sender = %d %v", status, result)
+	}
+	if data := bob.expect(proto.BizToClientOnMessage); data["uid"] != "alice" {
+		t.Errorf("member sender notification = %v", data)
+	}
+
+	// 广播经过islb保存到聊天记录
+	bob.mustRequest(proto.ClientToBizBroadcast, map[string]interface{}{"rid": "room1", "data": map[string]interface{}{"text": "hello"}})
+	if data := alice.expect(proto.BizToClientBroadcast); data["uid"] != "bob" {
+		t.Errorf("broadcast = %v", data)
+	}
+	history := alice.mustRequest(proto.ClientToBizGetHistory, map[string]interface{}{"rid": "room1", "offset": 0, "limit": 10})
+	if history["total"] != float64(1) {
+		t.Errorf("history = %v", history)
+	}
+	// 没有加入房间的用户不能读聊天记录和发消息
+	eve := c.dial(t, "eve")
+	if _, code := eve.request(proto.ClientToBizGetHistory, map[string]interface{}{"rid": "room1", "offset": 0, "limit": 10}); code != proto.ErrPeerNotFound {
+		t.Errorf("history without join code = %d", code)
+	}
+	if _, code := eve.request(proto.ClientToBizMessage, map[string]interface{}{"rid": "room1", "to": "bob", "data": "hi"}); code != proto.ErrPeerNotFound {
+		t.Errorf("message without join code = %d", code)
+	}
+
+	alice.mustRequest(proto.ClientToBizUnPublish, map[string]interface{}{"rid": "room1", "mid": mid})
+	if data := bob.expect(proto.BizToClientOnStreamRemove); data["mid"] != mid {
+		t.Errorf("stream-remove = %v", data)
+	}
+
+	bob.mustRequest(proto.ClientToBizLeave, map[string]interface{}{"rid": "room1"})
+	if data := alice.expect(proto.BizToClientOnLeave); data["uid"] != "bob" {
+		t.Errorf("peer-leave = %v", data)
+	}
+	result = alice.mustRequest(proto.ClientToBizGetRoomUsers, map[string]interface{}{"rid": "room1"})
+	if users, _ := result["users"].([]interface{}); len(users) != 0 {
+		t.Errorf("users after leave = %v", result["users"])
+	}
+
+	// islb的房间和流事件经issr推送webhook
+	c.hooks.expect(t, proto.WebhookPeerJoin, "bob")
+	c.hooks.expect(t, proto.WebhookStreamAdd, "alice")
+	c.hooks.expect(t, proto.WebhookStreamRemove, "alice")
+	c.hooks.expect(t, proto.WebhookPeerLeave, "bob")
+
+	// issr上报计时数据,写入文件
+	rpc := c.network.Connect().NewRequestor(dis.GetRPCChannel(dis.Node{Nid: testDC + "_issr_1"}))
+	if _, err := rpc.SyncRequest(proto.BizToIssrReportStreamState, map[string]interface{}{"appid": "test", "rid": "room1", "uid": "bob", "seconds": 60}); err != nil {
+		t.Fatalf("issr report: %v", err)
+	}
+	// 计时数据按天汇总,通过http接口导出csv
+	req, _ = http.NewRequest(http.MethodGet, strings.TrimSuffix(c.api, "server/")+"usage?appid=test&format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	day := time.Now().Format(rollup.DayLayout)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(csv), "test,"+day+",0,,,60,1.00") {
+		t.Errorf("usage csv = %d %s", resp.StatusCode, csv)
+	}
+
+	// 计时数据经发送箱异步写入,和导出的信令事件写入同一个文件
+	var topics map[string]int
+	for i := 0; i < 50; i++ {
+		topics = c.sinkTopics(t)
+		if topics["Livs-Usage-Event"] > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if topics["Livs-Usage-Event"] != 1 || topics[exporter.DefaultTopic] == 0 {
+		t.Errorf("sink topics = %v", topics)
+	}
+}
+
+// sinkTopics 统计写入文件的每个topic的消息数
+func (c *cluster) sinkTopics(t *testing.T) map[string]int {
+	file, err := os.Open(c.sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	topics := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line struct {
+			Topic string `json:"topic"`
+		}
+		json.Unmarshal(scanner.Bytes(), &line)
+		topics[line.Topic]++
+	}
+	return topics
+}
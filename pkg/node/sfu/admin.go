@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	h "signal/infra/http"
+	"signal/pkg/proto"
+	"signal/pkg/rtc"
+	"signal/util"
+)
+
+// InitAdmin 在g下注册router管理接口,token为空时不开启
+func InitAdmin(g *h.PathGroup, token string) {
+	if token == "" {
+		return
+	}
+	auth := h.TokenFilter(token)
+	g.Get("/admin/routers", adminRouters, auth)
+	g.Get("/admin/router", adminRouter, auth)
+	g.Post("/admin/router/close", adminCloseRouter, auth)
+}
+
+// writeAdminError 返回错误
+func writeAdminError(w http.ResponseWriter, status, code int, detail string) {
+	h.WriteJSON(w, status, util.Map("code", code, "reason", proto.NewError(code, detail).Reason))
+}
+
+// adminRouters 查询router状态,GET /admin/routers?rid=,rid为空时返回全部router
+func adminRouters(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	rid := req.URL.Query().Get("rid")
+	h.WriteJSON(w, http.StatusOK, proto.SfuRoutersResponse{Routers: rtc.GetRouterInfos(rid)})
+}
+
+// adminRouter 查询一个router的状态,GET /admin/router?id=
+func adminRouter(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Query().Get("id")
+	router := rtc.GetRouter(id)
+	if router == nil {
+		writeAdminError(w, http.StatusNotFound, proto.ErrRouterNotFound, id)
+		return
+	}
+	h.WriteJSON(w, http.StatusOK, router.Info(id))
+}
+
+// adminCloseRouter 关闭router,POST /admin/router/close?id=
+// 关闭后通知islb移除流,订阅者收到流移除的通知
+func adminCloseRouter(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	id := req.URL.Query().Get("id")
+	if rtc.GetRouter(id) == nil {
+		writeAdminError(w, http.StatusNotFound, proto.ErrRouterNotFound, id)
+		return
+	}
+	logger.Infof(fmt.Sprintf("sfu.adminCloseRouter id=%s", id))
+	rtc.DelRouter(id)
+	broadcaster.Say(proto.SfuToIslbOnStreamRemove, util.Map("mid", id, "nid", node.NodeInfo().Nid))
+	h.WriteJSON(w, http.StatusOK, util.Map())
+}
@@ -0,0 +1,66 @@
+package sfu
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"signal/pkg/proto"
+	"signal/pkg/rtc"
+)
+
+const drainCheckCycle = time.Second
+
+// Drain 标记节点下线并通知客户端迁移,所有流结束或超时后返回
+func Drain(timeout time.Duration) {
+	logger.Infof(fmt.Sprintf("sfu.Drain start timeout=%v", timeout))
+	if err := node.SetDraining(); err != nil {
+		logger.Errorf(fmt.Sprintf("sfu.Drain set draining err=%v", err))
+	}
+
+	streams := drainStreams()
+	broadcaster.Say(proto.SfuToIslbOnDrain, proto.ToMap(&proto.SfuDrainNotification{NID: node.NodeInfo().Nid, Streams: streams}))
+
+	deadline := time.Now().Add(timeout)
+	t := time.NewTicker(drainCheckCycle)
+	defer t.Stop()
+	for range t.C {
+		count := len(rtc.GetRouters())
+		if count == 0 {
+			logger.Infof("sfu.Drain all routers closed")
+			return
+		}
+		if time.Now().After(deadline) {
+			logger.Warnf(fmt.Sprintf("sfu.Drain timeout, %d routers left", count))
+			return
+		}
+	}
+}
+
+// drainStreams 获取节点上需要迁移的发布流和订阅流
+func drainStreams() []*proto.MigrateNotification {
+	nid := node.NodeInfo().Nid
+	streams := make([]*proto.MigrateNotification, 0)
+	rtc.MapRouter(func(id string, r *rtc.Router) {
+		// id = /pub/rid/{rid}/uid/{uid}/mid/{mid}
+		arr := strings.Split(id, "/")
+		if len(arr) < 8 {
+			return
+		}
+		rid, uid, mid := arr[3], arr[5], arr[7]
+		streams = append(streams, &proto.MigrateNotification{
+			RID: rid, UID: uid, MID: mid, NID: nid, Kind: proto.MigratePublish, Reason: "drain",
+		})
+		for sid := range r.GetSubs() {
+			streams = append(streams, &proto.MigrateNotification{
+				RID: rid, UID: proto.GetUIDFromMID(sid), MID: mid, SID: sid, NID: nid, Kind: proto.MigrateSubscribe, Reason: "drain",
+			})
+		}
+	})
+	return streams
+}
+
+// IsDraining 节点是否正在下线
+func IsDraining() bool {
+	return node != nil && node.IsDraining()
+}
@@ -4,13 +4,12 @@ import (
 	dis "signal/infra/discovery"
 	logger2 "signal/infra/logger"
 	"signal/infra/monitor"
+	"signal/pkg/bus"
 	"signal/pkg/proto"
 	"signal/pkg/rtc"
 	"signal/util"
 	"sync"
 	"time"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 const (
@@ -19,8 +18,8 @@ const (
 
 var (
 	logger                 *logger2.Logger
-	protoo                 *nprotoo.NatsProtoo
-	broadcaster            *nprotoo.Broadcaster
+	protoo                 bus.Bus
+	broadcaster            bus.Broadcaster
 	node                   *dis.ServiceNode
 	watch                  *dis.ServiceWatcher
 	routersLock            sync.RWMutex
@@ -28,18 +27,19 @@ var (
 )
 
 // Init 初始化服务
-func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, natsURL string, l *logger2.Logger) {
+func Init(serviceNode *dis.ServiceNode, ServiceWatcher *dis.ServiceWatcher, b bus.Bus, config rtc.Config, l *logger2.Logger) {
 	// 赋值
 	logger = l
 	node = serviceNode
 	watch = ServiceWatcher
-	protoo = nprotoo.NewNatsProtoo(util.GenerateNatsUrlString(natsURL))
+	protoo = b
 	broadcaster = protoo.NewBroadcaster(node.GetEventChannel())
 	// 启动
-	rtc.InitSfu()
+	rtc.InitSfu(config)
 	handleRPCRequest(node.GetRPCChannel())
 	go checkRTC()
 	go updatePayload()
+	go heartbeat()
 }
 
 // Close 关闭连接
@@ -59,24 +59,20 @@ func Close() {
 // checkRTC 通知信令流被移除
 func checkRTC() {
 	for mid := range rtc.CleanPub {
-		broadcaster.Say(proto.SfuToIslbOnStreamRemove, util.Map("mid", mid))
+		broadcaster.Say(proto.SfuToIslbOnStreamRemove, util.Map("mid", mid, "nid", node.NodeInfo().Nid))
 	}
 }
 
 // updatePayload 更新sfu服务器负载
 func updatePayload() {
+	sampler := newLoadSampler()
 	t := time.NewTicker(statCycle)
 	defer t.Stop()
 	for range t.C {
-		var streamcnt int = 0
-		routersLock.RLock()
-		pubs := rtc.GetRouters()
-		for _, pub := range pubs {
-			streamcnt++
-			subs := pub.GetSubs()
-			streamcnt += len(subs)
-		}
-		routersLock.RUnlock()
-		node.UpdateNodePayload(streamcnt)
+		load := sampler.sample()
+		loadLock.Lock()
+		currentLoad = load
+		loadLock.Unlock()
+		node.UpdateNodeLoad(load)
 	}
 }
@@ -3,11 +3,10 @@ package sfu
 import (
 	"fmt"
 	"signal/infra/monitor"
+	"signal/pkg/bus"
 	"signal/pkg/proto"
 	"signal/pkg/rtc"
 	"signal/util"
-
-	nprotoo "github.com/gearghost/nats-protoo"
 )
 
 // handleRPCMsgs 处理其他模块发送过来的消息
@@ -15,17 +14,17 @@ func handleRPCRequest(rpcID string) {
 
 	logger.Infof(fmt.Sprintf("sfu.handleRequest: rpcID=%s", rpcID), "rpcid", rpcID)
 
-	protoo.OnRequest(rpcID, func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
-		go func(request map[string]interface{}, accept nprotoo.AcceptFunc, reject nprotoo.RejectFunc) {
+	protoo.OnRequest(rpcID, func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
+		go func(request map[string]interface{}, accept bus.AcceptFunc, reject bus.RejectFunc) {
 			defer util.Recover("sfu.handleRPCRequest")
 			//log.Infof("sfu.handleRPCRequest recv rpc=%s, request=%v", rpcID, request)
 			logger.Infof(fmt.Sprintf("sfu.handleRPCRequest recv request=%v", request), "rpcid", rpcID)
 
-			method := request["method"].(string)
-			data := request["data"].(map[string]interface{})
+			method := util.Val(request, "method")
+			data, _ := request["data"].(map[string]interface{})
 
 			var result map[string]interface{}
-			err := &nprotoo.Error{Code: 400, Reason: fmt.Sprintf("Unkown method [%s]", method)}
+			err := proto.NewError(proto.ErrInvalidMethod, method)
 			if method != "" {
 				processingTime := monitor.NewProcessingTimeGauge(method)
 				processingTime.Start()
@@ -38,6 +37,8 @@ func handleRPCRequest(rpcID string) {
 					result, err = subscribe(data)
 				case proto.BizToSfuUnSubscribe:
 					result, err = unsubscribe(data)
+				case proto.BizToSfuGetRouters:
+					result, err = getRouters(data)
 				default:
 					//log.Warnf("sfu.handleRPCRequest invalid protocol method=%s data=%v", method, data)
 					logger.Warnf(fmt.Sprintf("sfu.handleRPCRequest invalid protocol method=%s data=%v", method, data), "rpcid", rpcID)
@@ -58,114 +59,145 @@ func handleRPCRequest(rpcID string) {
 	"method", proto.BizToSfuPublish, "rid", rid, "uid", uid, "minfo", minfo, "jsep", jsep
 */
 // publish 处理发布流
-func publish(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func publish(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("sfu.publish msg=%v", msg))
 	// 获取参数
-	if msg["jsep"] == nil {
-		return nil, &nprotoo.Error{Code: -1, Reason: "can't find jsep"}
+	var req proto.SfuPublishRequest
+	if err := proto.Decode(msg, &req); err != nil {
+		return nil, err
 	}
 
-	jsep, ok := msg["jsep"].(map[string]interface{})
-	if !ok {
-		return nil, &nprotoo.Error{Code: -1, Reason: "jsep can't transform to map"}
+	// 节点已满时拒绝新的发布流,由biz重新选择sfu
+	if load := GetLoad(); load.Overloaded() {
+		return nil, proto.NewError(proto.ErrSfuUnavailable, "sfu overloaded")
 	}
 
-	sdp := util.Val(jsep, "sdp")
-	rid := util.Val(msg, "rid")
-	uid := util.Val(msg, "uid")
-	mid := fmt.Sprintf("%s#%s", uid, util.RandStr(6))
-
-	options := msg["minfo"]
-	if options != nil {
-		options, ok := msg["minfo"].(map[string]interface{})
-		if ok {
-			key := proto.GetMediaPubKey(rid, uid, mid)
-			router := rtc.GetOrNewRouter(key)
-			resp, err := router.AddPub(sdp, mid, node.NodeInfo().Nip, options)
-			if err != nil {
-				return nil, &nprotoo.Error{Code: -1, Reason: fmt.Sprintf("AddPub err:%v", err)}
-			}
-			return util.Map("jsep", util.Map("type", "answer", "sdp", resp), "mid", mid), nil
-		}
+	rid := req.RID
+	uid := req.UID
+	mid := req.MID
+	if mid == "" {
+		mid = fmt.Sprintf("%s#%s", uid, util.RandStr(6))
+	} else if proto.GetUIDFromMID(mid) != uid {
+		// 迁移发布流时沿用原来的mid,mid必须属于该用户
+		return nil, proto.NewError(proto.ErrInvalidParams, "mid not belong to uid")
 	}
-	return nil, &nprotoo.Error{Code: -1, Reason: "can't find media info"}
+
+	key := proto.GetMediaPubKey(rid, uid, mid)
+	router := rtc.GetOrNewRouter(key)
+	resp, err := router.AddPub(req.Jsep.SDP, mid, node.NodeInfo().Nip, req.MInfo.Map())
+	if err != nil {
+		return nil, proto.NewError(mediaErrorCode(err, proto.ErrPublishFailed), err)
+	}
+	return proto.ToMap(&proto.SfuPublishResponse{Jsep: &proto.Jsep{Type: "answer", SDP: resp}, MID: mid}), nil
 }
 
 /*
 	"method", proto.BizToSfuUnPublish, "rid", rid, "uid", uid, "mid", mid
 */
 // unpublish 处理取消发布流
-func unpublish(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func unpublish(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("sfu.unpublish msg=%v", msg))
 	// 获取参数
-	rid := util.Val(msg, "rid")
-	uid := util.Val(msg, "uid")
-	mid := util.Val(msg, "mid")
+	var req proto.SfuUnPublishRequest
+	if err := proto.Decode(msg, &req); err != nil {
+		return nil, err
+	}
 
-	key := proto.GetMediaPubKey(rid, uid, mid)
+	key := proto.GetMediaPubKey(req.RID, req.UID, req.MID)
 	rtc.DelRouter(key)
 	return util.Map(), nil
 }
 
+/*
+	"method", proto.BizToSfuGetRouters, "rid", rid, "id", id
+*/
+// getRouters 获取router的转发状态,用于管理接口
+func getRouters(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
+	var req proto.SfuRoutersRequest
+	if msg != nil {
+		if err := proto.Decode(msg, &req); err != nil {
+			return nil, err
+		}
+	}
+	if req.ID == "" {
+		return proto.ToMap(&proto.SfuRoutersResponse{Routers: rtc.GetRouterInfos(req.RID)}), nil
+	}
+	router := rtc.GetRouter(req.ID)
+	if router == nil {
+		return nil, proto.NewError(proto.ErrRouterNotFound, req.ID)
+	}
+	return proto.ToMap(&proto.SfuRoutersResponse{Routers: []proto.RouterInfo{router.Info(req.ID)}}), nil
+}
+
 /*
 	"method", proto.BizToSfuSubscribe, "rid", rid, "uid", uid, "mid", mid, "minfo", minfo, "jsep", jsep
 */
 // subscribe 处理订阅流
-func subscribe(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func subscribe(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("sfu.subscribe msg=%v", msg))
 	// 获取参数
-	if msg["jsep"] == nil {
-		return nil, &nprotoo.Error{Code: -1, Reason: "can't find jsep"}
-	}
-
-	jsep, ok := msg["jsep"].(map[string]interface{})
-	if !ok {
-		return nil, &nprotoo.Error{Code: -1, Reason: "jsep can't transform to map"}
+	var req proto.SfuSubscribeRequest
+	if err := proto.Decode(msg, &req); err != nil {
+		return nil, err
 	}
 
-	sdp := util.Val(jsep, "sdp")
-	rid := util.Val(msg, "rid")
-	mid := util.Val(msg, "mid")
-	sid := util.Val(msg, "uid")
+	rid := req.RID
+	mid := req.MID
+	sid := req.UID
 	uid := proto.GetUIDFromMID(mid)
 	subID := fmt.Sprintf("%s#%s", sid, util.RandStr(6))
 
-	options := msg["minfo"]
-	if options != nil {
-		minfo, ok := options.(map[string]interface{})
-		if ok {
-			key := proto.GetMediaPubKey(rid, uid, mid)
-			router := rtc.GetRouter(key)
-			if router == nil {
-				return nil, &nprotoo.Error{Code: -1, Reason: fmt.Sprintf("can't get router:%s", key)}
-			}
+	key := proto.GetMediaPubKey(rid, uid, mid)
+	router := rtc.GetRouter(key)
+	if router == nil {
+		return nil, proto.NewError(proto.ErrRouterNotFound, key)
+	}
 
-			resp, err := router.AddSub(sdp, subID, node.NodeInfo().Nip, minfo)
-			if err != nil {
-				return nil, &nprotoo.Error{Code: -1, Reason: fmt.Sprintf("AddSub err:%v", err)}
-			}
-			return util.Map("jsep", util.Map("type", "answer", "sdp", resp), "mid", subID, "uid", uid), nil
-		}
+	resp, err := router.AddSub(req.Jsep.SDP, subID, node.NodeInfo().Nip, req.MInfo.Map())
+	if err != nil {
+		return nil, proto.NewError(mediaErrorCode(err, proto.ErrSubscribeFailed), err)
 	}
-	return nil, &nprotoo.Error{Code: -1, Reason: "can't find media info"}
+	addSubscription(proto.Subscription{SID: subID, RID: rid, UID: sid, MID: mid, MInfo: req.MInfo})
+	return proto.ToMap(&proto.SfuSubscribeResponse{Jsep: &proto.Jsep{Type: "answer", SDP: resp}, MID: subID, UID: uid}), nil
 }
 
 /*
 	"method", proto.BizToSfuUnSubscribe, "rid", rid, "uid", uid, "mid", mid
 */
 // unsubscribe 处理取消订阅流
-func unsubscribe(msg map[string]interface{}) (map[string]interface{}, *nprotoo.Error) {
+func unsubscribe(msg map[string]interface{}) (map[string]interface{}, *bus.Error) {
 	logger.Infof(fmt.Sprintf("sfu.unsubscribe msg=%v", msg))
 	// 获取参数
-	mid := util.Val(msg, "mid")
+	var req proto.SfuUnSubscribeRequest
+	if err := proto.Decode(msg, &req); err != nil {
+		return nil, err
+	}
+
+	mid := req.MID
+	found := false
 	rtc.MapRouter(func(id string, r *rtc.Router) {
 		subs := r.GetSubs()
 		for sid := range subs {
 			if sid == mid {
 				r.DelSub(mid)
+				found = true
 				return
 			}
 		}
 	})
+	if !removeSubscription(mid, "unsubscribe") && !found {
+		return nil, proto.NewError(proto.ErrSubNotFound, mid)
+	}
 	return util.Map(), nil
 }
+
+// mediaErrorCode 将rtc返回的错误转换为错误码,无法识别时返回def
+func mediaErrorCode(err error, def int) int {
+	switch err {
+	case rtc.ErrSdpParse:
+		return proto.ErrSdpParse
+	case rtc.ErrCodecUnsupported:
+		return proto.ErrCodecUnsupported
+	}
+	return def
+}
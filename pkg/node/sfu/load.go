@@ -0,0 +1,112 @@
+package sfu
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dis "signal/infra/discovery"
+	"signal/pkg/rtc"
+)
+
+var (
+	capacity    dis.Capacity
+	loadLock    sync.RWMutex
+	currentLoad dis.Load
+)
+
+// SetCapacity 设置节点承载上限,超过上限后不再接受新的发布流
+func SetCapacity(c dis.Capacity) {
+	capacity = c
+}
+
+// GetLoad 获取最近一次统计的节点负载
+func GetLoad() dis.Load {
+	loadLock.RLock()
+	defer loadLock.RUnlock()
+	return currentLoad
+}
+
+// cpuStat /proc/stat中cpu的累计时间
+type cpuStat struct {
+	idle  uint64
+	total uint64
+}
+
+// readCPUStat 读取/proc/stat第一行,非linux系统返回错误
+func readCPUStat() (cpuStat, error) {
+	var stat cpuStat
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return stat, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return stat, fmt.Errorf("/proc/stat is empty")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return stat, fmt.Errorf("/proc/stat format err")
+	}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return stat, err
+		}
+		stat.total += v
+		// idle和iowait
+		if i == 3 || i == 4 {
+			stat.idle += v
+		}
+	}
+	return stat, nil
+}
+
+// loadSampler 根据两次采样的差值计算cpu使用率和带宽
+type loadSampler struct {
+	last    time.Time
+	cpu     cpuStat
+	ingress uint64
+	egress  uint64
+}
+
+func newLoadSampler() *loadSampler {
+	s := &loadSampler{last: time.Now()}
+	s.cpu, _ = readCPUStat()
+	s.ingress, s.egress = rtc.GetTraffic()
+	return s
+}
+
+// sample 统计当前负载
+func (s *loadSampler) sample() dis.Load {
+	load := dis.Load{Capacity: capacity}
+	routersLock.RLock()
+	for _, pub := range rtc.GetRouters() {
+		load.Routers++
+		load.Subs += len(pub.GetSubs())
+	}
+	routersLock.RUnlock()
+
+	now := time.Now()
+	seconds := now.Sub(s.last).Seconds()
+	s.last = now
+	if cpu, err := readCPUStat(); err == nil {
+		if total := cpu.total - s.cpu.total; total > 0 {
+			load.CPU = 100 * float64(total-(cpu.idle-s.cpu.idle)) / float64(total)
+		}
+		s.cpu = cpu
+	}
+	ingress, egress := rtc.GetTraffic()
+	if seconds > 0 {
+		load.Ingress = int64(float64(ingress-s.ingress) * 8 / 1000 / seconds)
+		load.Egress = int64(float64(egress-s.egress) * 8 / 1000 / seconds)
+	}
+	s.ingress, s.egress = ingress, egress
+	return load
+}
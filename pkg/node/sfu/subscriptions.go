@@ -0,0 +1,81 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"signal/pkg/proto"
+	"signal/pkg/rtc"
+)
+
+// heartbeatCycle 上报订阅心跳的周期,issr按心跳对账计费
+const heartbeatCycle = time.Second * 30
+
+var (
+	subsLock      sync.Mutex
+	subscriptions = make(map[string]proto.Subscription)
+)
+
+// addSubscription 记录订阅并通知issr开始计费
+func addSubscription(s proto.Subscription) {
+	subsLock.Lock()
+	subscriptions[s.SID] = s
+	subsLock.Unlock()
+	broadcaster.Say(proto.SfuToIssrOnSubscribeAdd, proto.ToMap(&proto.SfuSubscriptionNotification{
+		Subscription: s, NID: node.NodeInfo().Nid,
+	}))
+}
+
+// removeSubscription 移除订阅并通知issr结束计费,订阅不存在时返回false
+func removeSubscription(sid, reason string) bool {
+	subsLock.Lock()
+	s, found := subscriptions[sid]
+	delete(subscriptions, sid)
+	subsLock.Unlock()
+	if !found {
+		return false
+	}
+	broadcaster.Say(proto.SfuToIssrOnSubscribeRemove, proto.ToMap(&proto.SfuSubscriptionNotification{
+		Subscription: s, NID: node.NodeInfo().Nid, Reason: reason,
+	}))
+	return true
+}
+
+// liveSubscriptions 对照router中的订阅,移除已经关闭的订阅,返回仍在转发的订阅
+func liveSubscriptions() []proto.Subscription {
+	live := make(map[string]bool)
+	rtc.MapRouter(func(id string, r *rtc.Router) {
+		for sid := range r.GetSubs() {
+			live[sid] = true
+		}
+	})
+
+	closed := make([]string, 0)
+	subs := make([]proto.Subscription, 0, len(live))
+	subsLock.Lock()
+	for sid, s := range subscriptions {
+		if live[sid] {
+			subs = append(subs, s)
+		} else {
+			closed = append(closed, sid)
+		}
+	}
+	subsLock.Unlock()
+	// router被清理或发布流关闭时订阅随之关闭,没有单独的取消订阅
+	for _, sid := range closed {
+		removeSubscription(sid, "closed")
+	}
+	return subs
+}
+
+// heartbeat 定时上报节点上所有的订阅
+func heartbeat() {
+	t := time.NewTicker(heartbeatCycle)
+	defer t.Stop()
+	for range t.C {
+		subs := liveSubscriptions()
+		broadcaster.Say(proto.SfuToIssrOnSubscribeHeartbeat, proto.ToMap(&proto.SfuHeartbeatNotification{
+			NID: node.NodeInfo().Nid, Subs: subs,
+		}))
+	}
+}
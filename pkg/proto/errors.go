@@ -0,0 +1,212 @@
+package proto
+
+import (
+	"fmt"
+	"strings"
+
+	"signal/pkg/bus"
+)
+
+// 错误码目录,所有服务共用,数值一旦发布不再修改
+//
+//	1xxx 请求参数错误
+//	2xxx 鉴权和房间状态错误
+//	3xxx 媒体协商错误
+//	4xxx 节点不可用
+//	5xxx 服务内部错误
+const (
+	// ErrOK 成功
+	ErrOK = 0
+
+	// ErrInvalidMethod 未知的方法
+	ErrInvalidMethod = 1001
+	// ErrInvalidData 请求数据为空或格式错误
+	ErrInvalidData = 1002
+	// ErrUIDMissing 缺少uid
+	ErrUIDMissing = 1003
+	// ErrRIDMissing 缺少rid
+	ErrRIDMissing = 1004
+	// ErrMIDMissing 缺少mid
+	ErrMIDMissing = 1005
+	// ErrJsepMissing 缺少jsep
+	ErrJsepMissing = 1006
+	// ErrSdpMissing 缺少sdp
+	ErrSdpMissing = 1007
+	// ErrMinfoMissing 缺少minfo
+	ErrMinfoMissing = 1008
+	// ErrToMissing 缺少消息接收者
+	ErrToMissing = 1009
+	// ErrInvalidParams 参数不合法
+	ErrInvalidParams = 1010
+
+	// ErrUnauthorized 未授权
+	ErrUnauthorized = 2001
+	// ErrRoomNotFound 房间不存在或未加入房间
+	ErrRoomNotFound = 2002
+	// ErrRoomFull 房间人数或房间绑定的sfu已满
+	ErrRoomFull = 2003
+	// ErrPeerNotFound 用户不存在
+	ErrPeerNotFound = 2004
+	// ErrPubNotFound 发布流不存在
+	ErrPubNotFound = 2005
+	// ErrSubNotFound 订阅流不存在
+	ErrSubNotFound = 2006
+	// ErrMediaNotFound 流信息不存在
+	ErrMediaNotFound = 2007
+	// ErrMcuNotBound 房间未绑定mcu
+	ErrMcuNotBound = 2008
+	// ErrRateLimited 请求过于频繁
+	ErrRateLimited = 2009
+
+	// ErrSdpParse sdp解析失败
+	ErrSdpParse = 3001
+	// ErrCodecUnsupported 不支持的编码格式
+	ErrCodecUnsupported = 3002
+	// ErrPublishFailed 发布流失败
+	ErrPublishFailed = 3003
+	// ErrSubscribeFailed 订阅流失败
+	ErrSubscribeFailed = 3004
+	// ErrRouterNotFound sfu上找不到对应的router
+	ErrRouterNotFound = 3005
+
+	// ErrSfuUnavailable sfu节点不可用
+	ErrSfuUnavailable = 4001
+	// ErrMcuUnavailable mcu节点不可用
+	ErrMcuUnavailable = 4002
+	// ErrIslbUnavailable islb节点不可用
+	ErrIslbUnavailable = 4003
+	// ErrBizUnavailable biz节点不可用
+	ErrBizUnavailable = 4005
+
+	// ErrTimeout 请求超时
+	ErrTimeout = 5001
+	// ErrStorage 存储读写失败
+	ErrStorage = 5002
+	// ErrReportFailed 上报失败
+	ErrReportFailed = 5003
+	// ErrInternal 服务内部错误
+	ErrInternal = 5004
+	// ErrUnknown 未知错误
+	ErrUnknown = 5999
+)
+
+// ErrorInfo 错误码描述
+type ErrorInfo struct {
+	Code       int               `json:"code"`
+	Messages   map[string]string `json:"messages"`
+	Retry      bool              `json:"retry"`
+	RetryAfter int               `json:"retryAfter"` // 建议重试间隔,毫秒
+}
+
+func errorInfo(code int, en, zh string, retry bool, retryAfter int) *ErrorInfo {
+	return &ErrorInfo{
+		Code:       code,
+		Messages:   map[string]string{"en": en, "zh": zh},
+		Retry:      retry,
+		RetryAfter: retryAfter,
+	}
+}
+
+var errorCatalogue = map[int]*ErrorInfo{
+	ErrOK: errorInfo(ErrOK, "OK", "成功", false, 0),
+
+	ErrInvalidMethod: errorInfo(ErrInvalidMethod, "method not found", "方法不存在", false, 0),
+	ErrInvalidData:   errorInfo(ErrInvalidData, "data not found", "请求数据不存在", false, 0),
+	ErrUIDMissing:    errorInfo(ErrUIDMissing, "uid not found", "缺少uid", false, 0),
+	ErrRIDMissing:    errorInfo(ErrRIDMissing, "rid not found", "缺少rid", false, 0),
+	ErrMIDMissing:    errorInfo(ErrMIDMissing, "mid not found", "缺少mid", false, 0),
+	ErrJsepMissing:   errorInfo(ErrJsepMissing, "jsep not found", "缺少jsep", false, 0),
+	ErrSdpMissing:    errorInfo(ErrSdpMissing, "sdp not found", "缺少sdp", false, 0),
+	ErrMinfoMissing:  errorInfo(ErrMinfoMissing, "media info not found", "缺少媒体信息", false, 0),
+	ErrToMissing:     errorInfo(ErrToMissing, "to not found", "缺少消息接收者", false, 0),
+	ErrInvalidParams: errorInfo(ErrInvalidParams, "invalid params", "参数不合法", false, 0),
+
+	ErrUnauthorized:  errorInfo(ErrUnauthorized, "unauthorized", "未授权", false, 0),
+	ErrRoomNotFound:  errorInfo(ErrRoomNotFound, "room not found", "房间不存在", false, 0),
+	ErrRoomFull:      errorInfo(ErrRoomFull, "room is full", "房间已满", false, 0),
+	ErrPeerNotFound:  errorInfo(ErrPeerNotFound, "peer not found", "用户不存在", false, 0),
+	ErrPubNotFound:   errorInfo(ErrPubNotFound, "pub not found", "发布流不存在", false, 0),
+	ErrSubNotFound:   errorInfo(ErrSubNotFound, "sub not found", "订阅流不存在", false, 0),
+	ErrMediaNotFound: errorInfo(ErrMediaNotFound, "media not found", "流信息不存在", false, 0),
+	ErrMcuNotBound:   errorInfo(ErrMcuNotBound, "mcu not bound", "房间未绑定mcu", false, 0),
+	ErrRateLimited:   errorInfo(ErrRateLimited, "too many requests", "请求过于频繁", true, 1000),
+
+	ErrSdpParse:         errorInfo(ErrSdpParse, "sdp parse failure", "sdp解析失败", false, 0),
+	ErrCodecUnsupported: errorInfo(ErrCodecUnsupported, "codec unsupported", "不支持的编码格式", false, 0),
+	ErrPublishFailed:    errorInfo(ErrPublishFailed, "publish failed", "发布流失败", true, 1000),
+	ErrSubscribeFailed:  errorInfo(ErrSubscribeFailed, "subscribe failed", "订阅流失败", true, 1000),
+	ErrRouterNotFound:   errorInfo(ErrRouterNotFound, "router not found", "找不到流转发对象", false, 0),
+
+	ErrSfuUnavailable:  errorInfo(ErrSfuUnavailable, "sfu unavailable", "sfu节点不可用", true, 2000),
+	ErrMcuUnavailable:  errorInfo(ErrMcuUnavailable, "mcu unavailable", "mcu节点不可用", true, 2000),
+	ErrIslbUnavailable: errorInfo(ErrIslbUnavailable, "islb unavailable", "islb节点不可用", true, 2000),
+	ErrBizUnavailable:  errorInfo(ErrBizUnavailable, "biz unavailable", "biz节点不可用", true, 2000),
+
+	ErrTimeout:      errorInfo(ErrTimeout, "request timeout", "请求超时", true, 1000),
+	ErrStorage:      errorInfo(ErrStorage, "storage error", "存储读写失败", true, 1000),
+	ErrReportFailed: errorInfo(ErrReportFailed, "report failed", "上报失败", true, 5000),
+	ErrInternal:     errorInfo(ErrInternal, "internal error", "服务内部错误", false, 0),
+	ErrUnknown:      errorInfo(ErrUnknown, "unknown error", "未知错误", false, 0),
+}
+
+// GetErrorInfo 获取错误码描述,未知错误码返回ErrUnknown的描述
+func GetErrorInfo(code int) *ErrorInfo {
+	if info, ok := errorCatalogue[code]; ok {
+		return info
+	}
+	return errorCatalogue[ErrUnknown]
+}
+
+// GetErrorCatalogue 获取全部错误码描述
+func GetErrorCatalogue() []*ErrorInfo {
+	infos := make([]*ErrorInfo, 0, len(errorCatalogue))
+	for _, info := range errorCatalogue {
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// ErrorMessage 获取错误码对应语言的描述,lang为空或不支持时返回英文
+func ErrorMessage(code int, lang string) string {
+	info := GetErrorInfo(code)
+	if msg, ok := info.Messages[lang]; ok {
+		return msg
+	}
+	return info.Messages["en"]
+}
+
+// LocalizeReason 把错误描述替换为对应语言,保留NewError附加的错误详情
+func LocalizeReason(code int, reason, lang string) string {
+	msg := ErrorMessage(code, lang)
+	def := ErrorMessage(code, "")
+	switch {
+	case reason == "" || reason == def:
+		return msg
+	case strings.HasPrefix(reason, def+": "):
+		return msg + reason[len(def):]
+	}
+	return msg + ": " + reason
+}
+
+// NewError 生成rpc错误,detail为附加的错误详情
+func NewError(code int, detail ...interface{}) *bus.Error {
+	reason := ErrorMessage(code, "")
+	if len(detail) > 0 {
+		reason = reason + ": " + fmt.Sprint(detail...)
+	}
+	return &bus.Error{Code: code, Reason: reason}
+}
+
+// ErrorCode 将rpc返回的错误转换为目录中的错误码,总线超时和取消都转换为ErrTimeout
+func ErrorCode(err *bus.Error) int {
+	if err == nil {
+		return ErrOK
+	}
+	if bus.IsTimeout(err) {
+		return ErrTimeout
+	}
+	if _, ok := errorCatalogue[err.Code]; ok {
+		return err.Code
+	}
+	return ErrUnknown
+}
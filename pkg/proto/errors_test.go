@@ -0,0 +1,42 @@
+package proto
+
+import (
+	"testing"
+
+	"signal/pkg/bus"
+)
+
+func TestErrorCatalogue(t *testing.T) {
+	for _, info := range GetErrorCatalogue() {
+		if info.Messages["en"] == "" || info.Messages["zh"] == "" {
+			t.Errorf("code %d missing message", info.Code)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if code := ErrorCode(&bus.Error{Code: 480, Reason: "Request timeout"}); code != ErrTimeout {
+		t.Errorf("timeout code = %d", code)
+	}
+	if code := ErrorCode(&bus.Error{Code: bus.CodeCanceled, Reason: "Request canceled"}); code != ErrTimeout {
+		t.Errorf("canceled code = %d", code)
+	}
+	if code := ErrorCode(NewError(ErrSdpParse, "bad sdp")); code != ErrSdpParse {
+		t.Errorf("sdp parse code = %d", code)
+	}
+	if code := ErrorCode(&bus.Error{Code: -1, Reason: "?"}); code != ErrUnknown {
+		t.Errorf("unknown code = %d", code)
+	}
+}
+
+func TestLocalizeReason(t *testing.T) {
+	if reason := LocalizeReason(ErrSdpParse, NewError(ErrSdpParse, "bad sdp").Reason, "zh"); reason != "sdp解析失败: bad sdp" {
+		t.Errorf("reason with detail = %s", reason)
+	}
+	if reason := LocalizeReason(ErrRIDMissing, ErrorMessage(ErrRIDMissing, ""), "zh"); reason != "缺少rid" {
+		t.Errorf("reason = %s", reason)
+	}
+	if reason := LocalizeReason(ErrInvalidParams, "limit should be positive", "zh"); reason != "参数不合法: limit should be positive" {
+		t.Errorf("other reason = %s", reason)
+	}
+}
@@ -0,0 +1,262 @@
+package proto
+
+import (
+	"reflect"
+	"sort"
+)
+
+// MethodSchema 信令方法的请求和响应结构
+type MethodSchema struct {
+	Method   string
+	Summary  string
+	Request  interface{}
+	Response interface{}
+}
+
+// ClientMethods 客户端请求biz的方法
+var ClientMethods = []MethodSchema{
+	{ClientToBizJoin, "加入房间", JoinRequest{}, JoinResponse{}},
+	{ClientToBizLeave, "离开房间", LeaveRequest{}, EmptyResponse{}},
+	{ClientToBizKeepAlive, "保活", KeepAliveRequest{}, EmptyResponse{}},
+	{ClientToBizPublish, "发布流", PublishRequest{}, PublishResponse{}},
+	{ClientToBizUnPublish, "取消发布流", UnPublishRequest{}, EmptyResponse{}},
+	{ClientToBizSubscribe, "订阅流", SubscribeRequest{}, SubscribeResponse{}},
+	{ClientToBizUnSubscribe, "取消订阅流", UnSubscribeRequest{}, EmptyResponse{}},
+	{ClientToBizStartLivestream, "开始直播", StartLivestreamRequest{}, StartLivestreamResponse{}},
+	{ClientToBizStopLivestream, "停止直播", StopLivestreamRequest{}, EmptyResponse{}},
+	{ClientToBizBroadcast, "发送广播", BroadcastRequest{}, EmptyResponse{}},
+	{ClientToBizGetRoomUsers, "获取房间其他用户实时流", ListUsersRequest{}, ListUsersResponse{}},
+	{ClientToBizGetRoomLives, "获取房间其他用户直播流", ListLivesRequest{}, ListLivesResponse{}},
+	{ClientToBizMessage, "发送消息给指定用户", MessageRequest{}, MessageResponse{}},
+	{ClientToBizGetHistory, "分页获取房间聊天记录", HistoryRequest{}, HistoryResponse{}},
+	{ClientToBizGetErrors, "获取错误码目录", ErrorsRequest{}, ErrorsResponse{}},
+	{ClientToBizRepublish, "将发布流迁移到其他sfu", RepublishRequest{}, PublishResponse{}},
+}
+
+// ClientNotifications biz推送给客户端的通知
+var ClientNotifications = []MethodSchema{
+	{BizToClientOnJoin, "有人加入房间", PeerNotification{}, nil},
+	{BizToClientOnLeave, "有人离开房间", PeerNotification{}, nil},
+	{BizToClientOnKick, "被服务器踢下线", PeerNotification{}, nil},
+	{BizToClientOnStreamAdd, "有人发布流", StreamNotification{}, nil},
+	{BizToClientOnStreamRemove, "有人取消发布流", StreamNotification{}, nil},
+	{BizToClientOnStreamUpdate, "有人的发布流迁移到其他sfu,需要重新订阅", StreamNotification{}, nil},
+	{BizToClientOnLiveStreamAdd, "有人开始直播", StreamNotification{}, nil},
+	{BizToClientOnLiveStreamRemove, "有人取消直播", StreamNotification{}, nil},
+	{BizToClientBroadcast, "收到广播", BroadcastNotification{}, nil},
+	{BizToClientOnMessage, "收到指定用户发送的消息", MessageNotification{}, nil},
+	{BizToClientOnMigrate, "节点下线或管理员要求,需要迁移", MigrateNotification{}, nil},
+}
+
+// BizMethods biz之间和管理接口的方法
+var BizMethods = []MethodSchema{
+	{BizToBizOnKick, "踢出房间", BizKickRequest{}, EmptyResponse{}},
+	{BizToBizOnMessage, "转发消息给本节点上的用户", BizMessageRequest{}, EmptyResponse{}},
+	{AdminToBizMigratePub, "通知发布者将流迁移到其他sfu", AdminMigrateRequest{}, EmptyResponse{}},
+	{BizToBizStartLive, "以指定用户的身份开始直播", BizStartLiveRequest{}, StartLivestreamResponse{}},
+	{BizToBizStopLive, "以指定用户的身份停止直播", BizStopLiveRequest{}, EmptyResponse{}},
+}
+
+// ServerMethods 应用服务端通过http调用的接口,请求需要签名
+var ServerMethods = []MethodSchema{
+	{ServerBroadcast, "向房间发送广播", ServerBroadcastRequest{}, IslbBroadcastResponse{}},
+	{ServerMessage, "给指定用户发送消息", ServerMessageRequest{}, MessageResponse{}},
+	{ServerKick, "踢出用户", BizKickRequest{}, EmptyResponse{}},
+	{ServerClose, "关闭房间,踢出所有用户", ServerRoomRequest{}, ServerCloseResponse{}},
+	{ServerParticipants, "查询房间的用户和流", ServerRoomRequest{}, ServerParticipantsResponse{}},
+	{ServerStartLive, "以指定用户的身份开始直播", BizStartLiveRequest{}, StartLivestreamResponse{}},
+	{ServerStopLive, "以指定用户的身份停止直播", BizStopLiveRequest{}, EmptyResponse{}},
+	{ServerStartRecord, "以指定用户的身份开始直播并录制", BizStartLiveRequest{}, StartLivestreamResponse{}},
+	{ServerStopRecord, "停止录制", BizStopLiveRequest{}, EmptyResponse{}},
+}
+
+// WebhookEvents issr推送给应用服务端的事件,应用服务端返回2xx表示接收成功
+var WebhookEvents = []MethodSchema{
+	{WebhookRoomCreated, "房间创建", WebhookEvent{}, nil},
+	{WebhookRoomClosed, "房间关闭", WebhookEvent{}, nil},
+	{WebhookPeerJoin, "有人加入房间", WebhookEvent{}, nil},
+	{WebhookPeerLeave, "有人离开房间", WebhookEvent{}, nil},
+	{WebhookPeerKick, "有人被踢出房间", WebhookEvent{}, nil},
+	{WebhookStreamAdd, "有人发布流", WebhookEvent{}, nil},
+	{WebhookStreamRemove, "有人取消发布流", WebhookEvent{}, nil},
+	{WebhookLiveStart, "开始直播", WebhookEvent{}, nil},
+	{WebhookLiveStop, "停止直播", WebhookEvent{}, nil},
+	{WebhookRecordFinished, "录制结束", WebhookEvent{}, nil},
+}
+
+// SfuMethods biz请求sfu的方法
+var SfuMethods = []MethodSchema{
+	{BizToSfuPublish, "发布流", SfuPublishRequest{}, SfuPublishResponse{}},
+	{BizToSfuUnPublish, "取消发布流", SfuUnPublishRequest{}, EmptyResponse{}},
+	{BizToSfuSubscribe, "订阅流", SfuSubscribeRequest{}, SfuSubscribeResponse{}},
+	{BizToSfuUnSubscribe, "取消订阅流", SfuUnSubscribeRequest{}, EmptyResponse{}},
+	{BizToSfuGetRouters, "获取router的转发状态", SfuRoutersRequest{}, SfuRoutersResponse{}},
+}
+
+// IslbMethods biz请求islb的方法
+var IslbMethods = []MethodSchema{
+	{BizToIslbOnJoin, "有人加入房间", IslbJoinRequest{}, EmptyResponse{}},
+	{BizToIslbOnLeave, "有人离开房间", IslbLeaveRequest{}, EmptyResponse{}},
+	{BizToIslbKeepAlive, "保活", IslbPeerRequest{}, EmptyResponse{}},
+	{BizToIslbGetBizInfo, "根据uid查询对应的biz", IslbPeerRequest{}, IslbNodeResponse{}},
+	{BizToIslbOnStreamAdd, "有人发布流", IslbStreamRequest{}, EmptyResponse{}},
+	{BizToIslbOnStreamRemove, "有人取消发布流", IslbStreamRemoveRequest{}, EmptyResponse{}},
+	{BizToIslbOnStreamUpdate, "有人的发布流迁移到其他sfu", IslbStreamRequest{}, EmptyResponse{}},
+	{BizToIslbGetSfuInfo, "根据mid查询对应的sfu", IslbMediaRequest{}, IslbNodeResponse{}},
+	{BizToIslbOnLiveAdd, "有人发起直播", IslbStreamRequest{}, EmptyResponse{}},
+	{BizToIslbOnLiveRemove, "有人取消直播", IslbStreamRemoveRequest{}, EmptyResponse{}},
+	{BizToIslbGetMcuInfo, "根据rid查询对应mcu", IslbMcuRequest{}, IslbNodeResponse{}},
+	{BizToIslbSetMcuInfo, "设置rid跟mcu绑定关系", IslbMcuRequest{}, IslbNodeResponse{}},
+	{BizToIslbGetMediaInfo, "根据rid,uid,mid获取media info", IslbMediaRequest{}, IslbMediaInfoResponse{}},
+	{BizToIslbGetRoomSfu, "根据rid查询房间绑定的sfu", IslbRoomSfuRequest{}, IslbNodeResponse{}},
+	{BizToIslbSetRoomSfu, "设置rid跟sfu绑定关系", IslbRoomSfuRequest{}, IslbNodeResponse{}},
+	{BizToIslbBroadcast, "发送广播", IslbBroadcastRequest{}, IslbBroadcastResponse{}},
+	{BizToIslbGetRoomUsers, "获取房间其他用户实时流", IslbPeerRequest{}, ListUsersResponse{}},
+	{BizToIslbGetRoomLives, "获取房间其他用户直播流", IslbPeerRequest{}, ListLivesResponse{}},
+	{BizToIslbGetHistory, "分页获取房间聊天记录", HistoryRequest{}, HistoryResponse{}},
+	{BizToIslbGetRooms, "获取有用户的房间", EmptyResponse{}, IslbRoomsResponse{}},
+	{BizToIslbGetRoomInfo, "获取房间的用户、流和绑定的节点", IslbPeerRequest{}, RoomInfo{}},
+}
+
+// schemaer 自定义json schema的类型实现该接口
+type schemaer interface {
+	JSONSchema() map[string]interface{}
+}
+
+var schemaerType = reflect.TypeOf((*schemaer)(nil)).Elem()
+
+// OpenAPI 生成信令协议的OpenAPI 3文档
+// 信令通过websocket(客户端)或nats(服务间)以protoo格式传输,
+// 文档中每个方法对应一个POST路径: /{服务}/{method},请求体为protoo的data字段
+func OpenAPI(version string) map[string]interface{} {
+	components := make(map[string]interface{})
+	paths := make(map[string]interface{})
+	addPaths(paths, components, "/biz/", "client", ClientMethods)
+	addPaths(paths, components, "/client/", "notification", ClientNotifications)
+	addPaths(paths, components, "/bizrpc/", "biz", BizMethods)
+	addPaths(paths, components, "/sfu/", "sfu", SfuMethods)
+	addPaths(paths, components, "/islb/", "islb", IslbMethods)
+	addPaths(paths, components, "/api/v1/server/", "server", ServerMethods)
+	addPaths(paths, components, "/webhook/", "webhook", WebhookEvents)
+
+	errorCodes := make([]int, 0, len(errorCatalogue))
+	for code := range errorCatalogue {
+		errorCodes = append(errorCodes, code)
+	}
+	sort.Ints(errorCodes)
+	components["Error"] = map[string]interface{}{
+		"type":     "object",
+		"required": []string{"code", "reason"},
+		"properties": map[string]interface{}{
+			"code":   map[string]interface{}{"type": "integer", "enum": errorCodes},
+			"reason": map[string]interface{}{"type": "string"},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "signal protocol",
+			"version":     version,
+			"description": "protoo signalling over websocket (client) and nats (server to server). Each method is described as POST /{service}/{method}; the request body is the protoo data field.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": components},
+	}
+}
+
+func addPaths(paths, components map[string]interface{}, prefix, tag string, methods []MethodSchema) {
+	for _, m := range methods {
+		op := map[string]interface{}{
+			"tags":        []string{tag},
+			"summary":     m.Summary,
+			"operationId": tag + "." + m.Method,
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(m.Request), components)),
+			},
+		}
+		responses := map[string]interface{}{}
+		if m.Response != nil {
+			responses["200"] = map[string]interface{}{
+				"description": "accept",
+				"content":     jsonContent(schemaOf(reflect.TypeOf(m.Response), components)),
+			}
+			responses["default"] = map[string]interface{}{
+				"description": "reject",
+				"content":     jsonContent(ref("Error")),
+			}
+		} else {
+			responses["200"] = map[string]interface{}{"description": "notification, no response"}
+		}
+		op["responses"] = responses
+		paths[prefix+m.Method] = map[string]interface{}{"post": op}
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaOf 根据类型生成json schema,命名结构体放入components
+func schemaOf(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	if t.Implements(schemaerType) {
+		return reflect.Zero(t).Interface().(schemaer).JSONSchema()
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), components)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), components)}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := components[name]; !ok {
+			// 先占位,防止递归引用
+			components[name] = nil
+			components[name] = structSchema(t, components)
+		}
+		return ref(name)
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := jsonName(field)
+		properties[name] = schemaOf(field.Type, components)
+		if field.Tag.Get("validate") == "required" {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
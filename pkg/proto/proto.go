@@ -40,6 +40,14 @@ const (
 	ClientToBizGetRoomUsers = "listusers"
 	// ClientToBizGetRoomLives C->Biz 获取房间所有用户直播流
 	ClientToBizGetRoomLives = "listlives"
+	// ClientToBizMessage C->Biz 发送消息给指定用户
+	ClientToBizMessage = "message"
+	// ClientToBizGetHistory C->Biz 分页获取房间聊天记录
+	ClientToBizGetHistory = "history"
+	// ClientToBizGetErrors C->Biz 获取错误码目录,包含多语言描述和重试建议
+	ClientToBizGetErrors = "errors"
+	// ClientToBizRepublish C->Biz 将发布流迁移到其他sfu,mid保持不变
+	ClientToBizRepublish = "republish"
 
 	// BizToClientOnJoin biz->C 有人加入房间
 	BizToClientOnJoin = "peer-join"
@@ -49,6 +57,8 @@ const (
 	BizToClientOnStreamAdd = "stream-add"
 	// BizToClientOnStreamRemove biz->C 有人取消发布流
 	BizToClientOnStreamRemove = "stream-remove"
+	// BizToClientOnStreamUpdate biz->C 有人的发布流迁移到其他sfu,需要重新订阅
+	BizToClientOnStreamUpdate = "stream-update"
 	//BizToClientOnLiveStreamAdd biz->C 有人开始直播
 	BizToClientOnLiveStreamAdd = "live-stream-add"
 	//BizToClientOnLiveStreamRemove biz->C 有人取消直播
@@ -56,9 +66,21 @@ const (
 
 	// BizToClientBroadcast biz->C 有人发送广播
 	BizToClientBroadcast = "broadcast"
+	// BizToClientOnMessage biz->C 收到指定用户发送的消息
+	BizToClientOnMessage = "message"
+	// BizToClientOnMigrate biz->C 节点下线,通知客户端迁移到其他节点
+	BizToClientOnMigrate = "migrate"
 	// BizToBizOnKick biz->biz 有人被服务器踢下线
 	BizToBizOnKick    = "peer-kick"
 	BizToClientOnKick = "peer-kick"
+	// BizToBizOnMessage biz->biz 转发消息给指定用户所在的biz
+	BizToBizOnMessage = "peer-message"
+	// BizToBizStartLive biz->biz 以指定用户的身份开始直播,由用户所在的biz处理
+	BizToBizStartLive = "peer-start-live"
+	// BizToBizStopLive biz->biz 以指定用户的身份停止直播
+	BizToBizStopLive = "peer-stop-live"
+	// AdminToBizMigratePub admin->biz 通知发布者将流迁移到其他sfu
+	AdminToBizMigratePub = "migrate-publisher"
 
 	/*
 		biz与sfu服务器通信
@@ -74,6 +96,8 @@ const (
 	BizToSfuUnSubscribe = "unsubscribe"
 	//BizToSfuSubscribeRTP Biz->Sfu 请求sfu创建offer
 	BizToSfuSubscribeRTP = "subscribertp"
+	// BizToSfuGetRouters Biz->Sfu 获取router的转发状态
+	BizToSfuGetRouters = "getRouters"
 
 	/*
 		biz与mcu服务器通信
@@ -96,6 +120,8 @@ const (
 	BizToIslbOnStreamAdd = "stream-add"
 	// BizToIslbOnStreamRemove biz->islb 有人取消发布流
 	BizToIslbOnStreamRemove = "stream-remove"
+	// BizToIslbOnStreamUpdate biz->islb 有人的发布流迁移到其他sfu
+	BizToIslbOnStreamUpdate = "stream-update"
 	// BizToIslbOnLiveAdd biz->islb 有人发起直播
 	BizToIslbOnLiveAdd = "live-add"
 	// BizToIslbOnLiveRemove biz->islb 有人取消直播
@@ -114,6 +140,8 @@ const (
 	BizToIslbGetRoomUsers = "getRoomUsers"
 	// BizToIslbGetRoomLives biz->islb 获取房间其他用户直播流
 	BizToIslbGetRoomLives = "getRoomLives"
+	// BizToIslbGetHistory biz->islb 分页获取房间聊天记录
+	BizToIslbGetHistory = "getHistory"
 
 	//BizToIslbGetMcuInfo biz->islb 根据rid查询对应mcu
 	BizToIslbGetMcuInfo = "getMcuInfo"
@@ -121,6 +149,14 @@ const (
 	BizToIslbSetMcuInfo = "setMcuInfo"
 	//BizToIslbGetMediaInfo biz->islb 根据rid,uid,mid获取media info
 	BizToIslbGetMediaInfo = "getMediaInfo"
+	// BizToIslbGetRoomSfu biz->islb 根据rid查询房间绑定的sfu
+	BizToIslbGetRoomSfu = "getRoomSfu"
+	// BizToIslbSetRoomSfu biz->islb 设置rid跟sfu绑定关系
+	BizToIslbSetRoomSfu = "setRoomSfu"
+	// BizToIslbGetRooms biz->islb 获取有用户的房间
+	BizToIslbGetRooms = "getRooms"
+	// BizToIslbGetRoomInfo biz->islb 获取房间的用户、流和绑定的节点
+	BizToIslbGetRoomInfo = "getRoomInfo"
 
 	// IslbToBizOnJoin islb->biz 有人加入房间
 	IslbToBizOnJoin = BizToClientOnJoin
@@ -130,12 +166,20 @@ const (
 	IslbToBizOnStreamAdd = BizToClientOnStreamAdd
 	// IslbToBizOnStreamRemove islb->biz 有人取消发布流
 	IslbToBizOnStreamRemove = BizToClientOnStreamRemove
+	// IslbToBizOnStreamUpdate islb->biz 有人的发布流迁移到其他sfu
+	IslbToBizOnStreamUpdate = BizToClientOnStreamUpdate
 	// IslbToBizOnLiveAdd biz->islb 有人发起直播
 	IslbToBizOnLiveAdd = BizToIslbOnLiveAdd
 	// IslbToBizOnLiveRemove biz->islb 有人取消直播
 	IslbToBizOnLiveRemove = BizToIslbOnLiveRemove
 	// IslbToBizBroadcast islb->biz 有人发送广播
 	IslbToBizBroadcast = ClientToBizBroadcast
+	// IslbToBizOnMigrate islb->biz sfu下线,通知用户迁移流
+	IslbToBizOnMigrate = BizToClientOnMigrate
+	// IslbToBizOnRoomAdd islb->biz 房间第一个用户加入
+	IslbToBizOnRoomAdd = "room-add"
+	// IslbToBizOnRoomRemove islb->biz 房间最后一个用户离开
+	IslbToBizOnRoomRemove = "room-remove"
 
 	/*
 		sfu,mcu的广播
@@ -147,46 +191,144 @@ const (
 	McuToIslbOnStreamRemove = "mcu-stream-remove"
 	//McuToIslbOnRoomRemove mcu->biz mcu房间移除通知
 	McuToIslbOnRoomRemove = "mcu-room-remove"
+	// SfuToIslbOnDrain sfu->islb sfu下线,通知islb需要迁移的流
+	SfuToIslbOnDrain = "sfu-drain"
 
 	//SfuToIssrOnSubscribeAdd Sfu->Issr Sfu通知Issr订阅流添加消息
 	SfuToIssrOnSubscribeAdd = "sfu-subscribe-add"
 	//SfuToIssrOnSubscribeRemove Sfu->Issr Sfu通知Issr订阅流移除消息
 	SfuToIssrOnSubscribeRemove = "sfu-subscribe-remove"
+	//SfuToIssrOnSubscribeHeartbeat Sfu->Issr Sfu定时上报节点上所有的订阅,issr据此对账
+	SfuToIssrOnSubscribeHeartbeat = "sfu-subscribe-heartbeat"
+	//IslbToIssrOnLiveHeartbeat Islb->Issr Islb定时上报负责的房间中所有的直播流,issr据此对账
+	IslbToIssrOnLiveHeartbeat = "islb-live-heartbeat"
+
+	/*
+		应用服务端通过http调用biz,路径为/api/v1/server/{method}
+	*/
+
+	// ServerBroadcast server->biz 向房间发送广播
+	ServerBroadcast = "broadcast"
+	// ServerMessage server->biz 给指定用户发送消息
+	ServerMessage = "message"
+	// ServerKick server->biz 踢出用户
+	ServerKick = "kick"
+	// ServerClose server->biz 关闭房间
+	ServerClose = "close"
+	// ServerParticipants server->biz 查询房间的用户和流
+	ServerParticipants = "participants"
+	// ServerStartLive server->biz 以指定用户的身份开始直播
+	ServerStartLive = "live/start"
+	// ServerStopLive server->biz 以指定用户的身份停止直播
+	ServerStopLive = "live/stop"
+	// ServerStartRecord server->biz 以指定用户的身份开始直播并录制
+	ServerStartRecord = "record/start"
+	// ServerStopRecord server->biz 停止录制
+	ServerStopRecord = "record/stop"
+
+	/*
+		issr推送给应用服务端的webhook事件
+	*/
+
+	// WebhookRoomCreated 房间创建
+	WebhookRoomCreated = "room.created"
+	// WebhookRoomClosed 房间关闭
+	WebhookRoomClosed = "room.closed"
+	// WebhookPeerJoin 有人加入房间
+	WebhookPeerJoin = "peer.join"
+	// WebhookPeerLeave 有人离开房间
+	WebhookPeerLeave = "peer.leave"
+	// WebhookPeerKick 有人被踢出房间
+	WebhookPeerKick = "peer.kick"
+	// WebhookStreamAdd 有人发布流
+	WebhookStreamAdd = "stream.add"
+	// WebhookStreamRemove 有人取消发布流
+	WebhookStreamRemove = "stream.remove"
+	// WebhookLiveStart 开始直播
+	WebhookLiveStart = "live.start"
+	// WebhookLiveStop 停止直播
+	WebhookLiveStop = "live.stop"
+	// WebhookRecordFinished 录制结束
+	WebhookRecordFinished = "recording.finished"
 )
 
+// LeaveReasonKick 用户被踢出房间
+const LeaveReasonKick = "kick"
+
+// NodeMethods biz请求其他节点(biz、sfu、mcu、islb)的方法,配置文件按方法名设置请求策略
+var NodeMethods = []string{
+	BizToBizOnKick, BizToBizOnMessage, BizToBizStartLive, BizToBizStopLive, AdminToBizMigratePub,
+	BizToSfuPublish, BizToSfuUnPublish, BizToSfuSubscribe, BizToSfuUnSubscribe, BizToSfuSubscribeRTP, BizToSfuGetRouters,
+	BizToMcuPublishRTP, BizToMcuUnpublish,
+	BizToIslbOnJoin, BizToIslbOnLeave, BizToIslbOnStreamAdd, BizToIslbOnStreamRemove, BizToIslbOnStreamUpdate,
+	BizToIslbOnLiveAdd, BizToIslbOnLiveRemove, BizToIslbKeepAlive, BizToIslbBroadcast,
+	BizToIslbGetBizInfo, BizToIslbGetSfuInfo, BizToIslbGetRoomUsers, BizToIslbGetRoomLives, BizToIslbGetHistory,
+	BizToIslbGetMcuInfo, BizToIslbSetMcuInfo, BizToIslbGetMediaInfo,
+	BizToIslbGetRoomSfu, BizToIslbSetRoomSfu, BizToIslbGetRooms, BizToIslbGetRoomInfo,
+}
+
 // GetUIDFromMID 从mid中获取uid
 func GetUIDFromMID(mid string) string {
 	return strings.Split(mid, "#")[0]
 }
 
-// GetUserInfoKey 获取用户的信息
-func GetUserInfoKey(rid, uid string) string {
-	return "/user/rid/" + rid + "/uid/" + uid
+// GetMediaPubKey 获取用户发布流对应的sfu信息,sfu用作router的key
+func GetMediaPubKey(rid, uid, mid string) string {
+	return "/pub/rid/" + rid + "/uid/" + uid + "/mid/" + mid
 }
 
-// GetUserNodeKey 获取用户的服务器信息
-func GetUserNodeKey(rid, uid string) string {
-	return "/node/rid/" + rid + "/uid/" + uid
+// ParseMediaKey 解析 /kind/rid/R/uid/U/mid/M 格式的key,返回kind,rid,uid,mid
+// 也兼容没有mid的 /kind/rid/R/uid/U 格式
+func ParseMediaKey(key string) (kind, rid, uid, mid string, ok bool) {
+	arr := strings.Split(key, "/")
+	if len(arr) < 6 || arr[2] != "rid" || arr[4] != "uid" {
+		return "", "", "", "", false
+	}
+	if len(arr) >= 8 && arr[6] == "mid" {
+		mid = arr[7]
+	}
+	return arr[1], arr[3], arr[5], mid, true
 }
 
-// GetMediaInfoKey 获取用户发布的流信息
-func GetMediaInfoKey(rid, uid, mid string) string {
-	return "/media/rid/" + rid + "/uid/" + uid + "/mid/" + mid
+// 房间索引数据,同一房间的key都带有{rid},集群模式下落在同一个slot,可以在一个事务里操作
+const (
+	RoomMembers   = "members"   // hash uid -> biz nid
+	RoomUsers     = "users"     // hash uid -> 用户信息
+	RoomAlive     = "alive"     // zset uid -> 保活截止时间
+	RoomPubs      = "pubs"      // hash uid/mid -> sfu nid
+	RoomMedia     = "media"     // hash uid/mid -> 流信息
+	RoomLives     = "lives"     // hash uid/mid -> mcu nid
+	RoomLiveMedia = "livemedia" // hash uid/mid -> 直播流信息
+	RoomApp       = "app"       // string 房间所属的appid
+)
+
+// GetRoomKey 获取房间索引 key
+func GetRoomKey(rid, kind string) string {
+	return "/room/{" + rid + "}/" + kind
 }
 
-// GetMediaPubKey 获取用户发布流对应的sfu信息
-func GetMediaPubKey(rid, uid, mid string) string {
-	return "/pub/rid/" + rid + "/uid/" + uid + "/mid/" + mid
+// GetRoomIndexKey 获取有用户的房间索引 key, zset rid -> 最晚的用户保活截止时间
+func GetRoomIndexKey() string {
+	return "/room/index"
+}
+
+// GetStreamField 获取房间索引中流对应的字段
+func GetStreamField(uid, mid string) string {
+	return uid + "/" + mid
 }
 
-// GetLiveInfoKey 获取用户发布的直播流信息
-func GetLiveInfoKey(rid, uid, mid string) string {
-	return "/livemedia/rid/" + rid + "/uid/" + uid + "/mid/" + mid
+// ParseStreamField 解析房间索引中流对应的字段,返回uid,mid
+func ParseStreamField(field string) (string, string) {
+	arr := strings.SplitN(field, "/", 2)
+	if len(arr) < 2 {
+		return arr[0], ""
+	}
+	return arr[0], arr[1]
 }
 
-// GetLivePubKey 获取用户发布直播流对应的mcu节点
-func GetLivePubKey(rid, uid, mid string) string {
-	return "/livepub/rid/" + rid + "/uid/" + uid + "/mid/" + mid
+// GetRoomSfuKey 获取房间绑定的sfu节点 key
+func GetRoomSfuKey(rid string) string {
+	return "/sfu/rid/" + rid
 }
 
 // GetMcuInfoKey 获取MCU节点 key
@@ -194,24 +336,49 @@ func GetMcuInfoKey(rid string) string {
 	return "/mcu/rid/" + rid
 }
 
+// GetChatHistoryKey 获取房间聊天记录 key
+func GetChatHistoryKey(rid string) string {
+	return "/chat/rid/" + rid
+}
+
 // GetFailedStreamStateKey 获取报告失败拉流状态信息key
 func GetFailedStreamStateKey() string {
 	return "/zx/report/failure"
 }
 
-// GetSubVideoStreamTime 获取订阅视频流Unix时间 key
-func GetSubVideoStreamTimingKey(appid, rid, uid string) string {
-	return "/zx/timing/video/" + appid + "/" + rid + "/" + uid
+// GetFailedWebhookKey 推送失败的webhook事件
+func GetFailedWebhookKey() string {
+	return "/zx/webhook/failure"
+}
+
+// GetBillingSessionKey 获取订阅计费会话 key
+func GetBillingSessionKey(sid string) string {
+	return "/zx/billing/session/" + sid
+}
+
+// GetBillingNodeKey 获取sfu节点上计费中的订阅 key
+func GetBillingNodeKey(nid string) string {
+	return "/zx/billing/node/" + nid
+}
+
+// GetBillingNodesKey 获取有计费订阅的sfu节点 key
+func GetBillingNodesKey() string {
+	return "/zx/billing/nodes"
+}
+
+// GetBillingLockKey 获取计费会话和节点的 lock key
+func GetBillingLockKey(id string) string {
+	return "/zx/billing/lock/" + id
 }
 
-// GetSubAudioStreamTime 获取订阅音频流Unix时间 key
-func GetSubAudioStreamTimingKey(appid, rid, uid string) string {
-	return "/zx/timing/audio/" + appid + "/" + rid + "/" + uid
+// GetLiveSessionKey 获取直播计时会话 key,id为rid/uid/mid
+func GetLiveSessionKey(id string) string {
+	return "/zx/billing/live/" + id
 }
 
-//GetUserTimingLock 获取用户计时 lock key
-func GetSubStreamTimingLockKey(appid, rid, uid string) string {
-	return "/zx/timing/lock/" + appid + "/" + rid + "/" + uid
+// GetLiveSessionsKey 获取计时中的直播流 key
+func GetLiveSessionsKey() string {
+	return "/zx/billing/lives"
 }
 
 // GetSubStreamTime 获取订阅流Unix时间 key
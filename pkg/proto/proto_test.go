@@ -0,0 +1,43 @@
+package proto
+
+import (
+	"testing"
+)
+
+func TestParseMediaKey(t *testing.T) {
+	kind, rid, uid, mid, ok := ParseMediaKey(GetMediaPubKey("room1", "user1", "user1#abc"))
+	if !ok || kind != "pub" || rid != "room1" || uid != "user1" || mid != "user1#abc" {
+		t.Errorf("pub key = %s %s %s %s %v", kind, rid, uid, mid, ok)
+	}
+	kind, rid, uid, mid, ok = ParseMediaKey("/node/rid/room1/uid/user1")
+	if !ok || kind != "node" || rid != "room1" || uid != "user1" || mid != "" {
+		t.Errorf("node key = %s %s %s %s %v", kind, rid, uid, mid, ok)
+	}
+	if _, _, _, _, ok = ParseMediaKey("/sfu/rid/room1"); ok {
+		t.Error("room key should not parse")
+	}
+}
+
+func TestStreamField(t *testing.T) {
+	uid, mid := ParseStreamField(GetStreamField("user1", "user1#abc"))
+	if uid != "user1" || mid != "user1#abc" {
+		t.Errorf("field = %s %s", uid, mid)
+	}
+	if key := GetRoomKey("room1", RoomPubs); key != "/room/{room1}/pubs" {
+		t.Errorf("room key = %s", key)
+	}
+}
+
+func TestNodeMethods(t *testing.T) {
+	methods := make(map[string]bool)
+	for _, method := range NodeMethods {
+		methods[method] = true
+	}
+	for _, schemas := range [][]MethodSchema{BizMethods, SfuMethods, IslbMethods} {
+		for _, schema := range schemas {
+			if !methods[schema.Method] {
+				t.Errorf("%s not in NodeMethods", schema.Method)
+			}
+		}
+	}
+}
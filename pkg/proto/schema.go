@@ -0,0 +1,698 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+	信令请求和响应的结构定义
+	required字段由Validate检查,缺失时返回对应的错误码
+*/
+
+// Jsep sdp描述
+type Jsep struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp" validate:"required"`
+}
+
+// MediaInfo 流信息
+type MediaInfo struct {
+	Audio      bool   `json:"audio"`
+	Video      bool   `json:"video"`
+	Screen     bool   `json:"screen,omitempty"`
+	Canvas     bool   `json:"canvas,omitempty"`
+	Resolution string `json:"resolution,omitempty"` // 240p/360p/480p/720p/1080p
+	AppID      string `json:"appid,omitempty"`
+	Index      int    `json:"index,omitempty"`  // 直播时 1,主播 0,连麦者
+	Record     int    `json:"record,omitempty"` // 直播时 1,启用录制
+}
+
+// Map 转换为map,用于rpc传递和rtc参数
+func (m *MediaInfo) Map() map[string]interface{} {
+	return ToMap(m)
+}
+
+// MaxRecipients 一条消息最多的接收者数量,每个接收者都需要查询所在的biz
+const MaxRecipients = 100
+
+// Recipients 消息接收者,兼容单个uid和uid数组
+type Recipients []string
+
+// UnmarshalJSON 支持 "uid1" 和 ["uid1", "uid2"] 两种格式
+func (r *Recipients) UnmarshalJSON(b []byte) error {
+	var id string
+	if err := json.Unmarshal(b, &id); err == nil {
+		if id != "" {
+			*r = Recipients{id}
+		}
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(b, &ids); err != nil {
+		return fmt.Errorf("to should be string or string array")
+	}
+	*r = ids
+	return nil
+}
+
+// JSONSchema 自定义json schema
+func (r Recipients) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "maxItems": MaxRecipients},
+		},
+	}
+}
+
+// Check 检查接收者数量
+func (r Recipients) Check() error {
+	if len(r) > MaxRecipients {
+		return fmt.Errorf("to should not exceed %d recipients", MaxRecipients)
+	}
+	return nil
+}
+
+// StreamInfo 房间中的一路流
+type StreamInfo struct {
+	RID   string     `json:"rid"`
+	UID   string     `json:"uid"`
+	MID   string     `json:"mid"`
+	NID   string     `json:"nid"`
+	MInfo *MediaInfo `json:"minfo,omitempty"`
+}
+
+// RoomUser 房间中的用户,发布多路流时每路流一条记录
+type RoomUser struct {
+	UID   string                 `json:"uid"`
+	NID   string                 `json:"nid"`
+	Info  map[string]interface{} `json:"info,omitempty"`
+	Media *StreamInfo            `json:"media,omitempty"`
+}
+
+// ChatMessage 房间聊天记录
+type ChatMessage struct {
+	MsgID string      `json:"msgid"`
+	RID   string      `json:"rid"`
+	UID   string      `json:"uid"`
+	Data  interface{} `json:"data"`
+	Time  int64       `json:"time"`
+}
+
+/*
+	客户端与biz服务器通信
+*/
+
+// JoinRequest 加入房间
+type JoinRequest struct {
+	RID  string                 `json:"rid" validate:"required"`
+	Info map[string]interface{} `json:"info,omitempty"`
+}
+
+// JoinResponse 加入房间响应
+type JoinResponse struct {
+	Users []*RoomUser   `json:"users"`
+	Lives []*StreamInfo `json:"lives"`
+}
+
+// LeaveRequest 离开房间
+type LeaveRequest struct {
+	RID string `json:"rid" validate:"required"`
+}
+
+// KeepAliveRequest 保活
+type KeepAliveRequest struct {
+	RID  string                 `json:"rid" validate:"required"`
+	Info map[string]interface{} `json:"info,omitempty"`
+}
+
+// PublishRequest 发布流
+type PublishRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	Jsep  *Jsep      `json:"jsep" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// PublishResponse 发布流响应
+type PublishResponse struct {
+	Jsep    *Jsep      `json:"jsep"`
+	MID     string     `json:"mid"`
+	NID     string     `json:"nid"`
+	MInfo   *MediaInfo `json:"minfo"`
+	DC      string     `json:"dc,omitempty"`
+	CrossDC bool       `json:"crossdc,omitempty"` // sfu不在客户端所在区域
+}
+
+// RepublishRequest 将发布流迁移到其他sfu,nid为目标sfu,为空时自动选择
+type RepublishRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	NID   string     `json:"nid,omitempty"`
+	Jsep  *Jsep      `json:"jsep" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// UnPublishRequest 取消发布流
+type UnPublishRequest struct {
+	RID string `json:"rid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+	NID string `json:"nid,omitempty"`
+}
+
+// SubscribeRequest 订阅流
+type SubscribeRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	NID   string     `json:"nid,omitempty"`
+	Jsep  *Jsep      `json:"jsep" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// SubscribeResponse 订阅流响应
+type SubscribeResponse struct {
+	Jsep    *Jsep  `json:"jsep"`
+	SID     string `json:"sid"`
+	UID     string `json:"uid"`
+	DC      string `json:"dc,omitempty"`
+	CrossDC bool   `json:"crossdc,omitempty"` // sfu不在客户端所在区域
+}
+
+// UnSubscribeRequest 取消订阅流,mid为订阅返回的sid
+type UnSubscribeRequest struct {
+	RID string `json:"rid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+	NID string `json:"nid" validate:"required"`
+}
+
+// StartLivestreamRequest 开始直播
+type StartLivestreamRequest struct {
+	RID    string `json:"rid" validate:"required"`
+	MID    string `json:"mid" validate:"required"`
+	NID    string `json:"nid,omitempty"`
+	Record int    `json:"record"` // 0,不启用录制 1,启用录制
+	Index  int    `json:"index"`  // 1,主播 0,连麦者
+}
+
+// Check 检查参数取值
+func (r *StartLivestreamRequest) Check() error {
+	if r.Record != 0 && r.Record != 1 {
+		return fmt.Errorf("record should be 0 or 1")
+	}
+	if r.Index != 0 && r.Index != 1 {
+		return fmt.Errorf("index should be 0 or 1")
+	}
+	return nil
+}
+
+// StartLivestreamResponse 开始直播响应
+type StartLivestreamResponse struct {
+	MCU string `json:"mcu"`
+	MID string `json:"mid"`
+}
+
+// StopLivestreamRequest 停止直播
+type StopLivestreamRequest struct {
+	RID string `json:"rid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+	NID string `json:"nid" validate:"required"`
+	MCU string `json:"mcu,omitempty"`
+}
+
+// BroadcastRequest 发送广播
+type BroadcastRequest struct {
+	RID  string      `json:"rid" validate:"required"`
+	Data interface{} `json:"data"`
+}
+
+// MessageRequest 发送消息给指定用户
+type MessageRequest struct {
+	RID  string      `json:"rid" validate:"required"`
+	To   Recipients  `json:"to" validate:"required"`
+	Data interface{} `json:"data"`
+}
+
+// Check 检查接收者数量
+func (r *MessageRequest) Check() error {
+	return r.To.Check()
+}
+
+// MessageResponse 发送消息响应,acks为每个用户的送达结果
+type MessageResponse struct {
+	MsgID string          `json:"msgid"`
+	Acks  map[string]bool `json:"acks"`
+}
+
+// HistoryRequest 分页获取房间聊天记录
+type HistoryRequest struct {
+	RID    string `json:"rid" validate:"required"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+// Check 检查分页参数
+func (r *HistoryRequest) Check() error {
+	if r.Offset < 0 || r.Limit < 0 {
+		return fmt.Errorf("offset and limit should not be negative")
+	}
+	return nil
+}
+
+// HistoryResponse 聊天记录响应,按时间倒序
+type HistoryResponse struct {
+	RID      string         `json:"rid"`
+	Total    int64          `json:"total"`
+	Offset   int64          `json:"offset"`
+	Messages []*ChatMessage `json:"messages"`
+}
+
+// ListUsersRequest 获取房间其他用户实时流
+type ListUsersRequest struct {
+	RID string `json:"rid" validate:"required"`
+}
+
+// ListUsersResponse 房间其他用户实时流
+type ListUsersResponse struct {
+	Users []*RoomUser `json:"users"`
+}
+
+// ListLivesRequest 获取房间其他用户直播流
+type ListLivesRequest struct {
+	RID string `json:"rid" validate:"required"`
+}
+
+// ListLivesResponse 房间其他用户直播流
+type ListLivesResponse struct {
+	Lives []*StreamInfo `json:"lives"`
+}
+
+// ErrorsRequest 获取错误码目录
+type ErrorsRequest struct{}
+
+// ErrorsResponse 错误码目录
+type ErrorsResponse struct {
+	Errors []*ErrorInfo `json:"errors"`
+}
+
+// EmptyResponse 空响应
+type EmptyResponse struct{}
+
+/*
+	biz推送给客户端的通知
+*/
+
+// PeerNotification 有人加入,离开或被踢出房间
+type PeerNotification struct {
+	RID  string                 `json:"rid"`
+	UID  string                 `json:"uid"`
+	NID  string                 `json:"nid,omitempty"`
+	Info map[string]interface{} `json:"info,omitempty"`
+}
+
+// StreamNotification 有人发布或取消发布流,直播流
+type StreamNotification StreamInfo
+
+// BroadcastNotification 收到广播
+type BroadcastNotification ChatMessage
+
+// MessageNotification 收到指定用户发送的消息
+type MessageNotification struct {
+	RID   string      `json:"rid"`
+	UID   string      `json:"uid"`
+	MsgID string      `json:"msgid"`
+	Data  interface{} `json:"data"`
+}
+
+const (
+	// MigrateNode biz节点下线,客户端需要重连到其他biz
+	MigrateNode = "node"
+	// MigratePublish sfu节点下线,客户端需要重新发布流
+	MigratePublish = "publish"
+	// MigrateSubscribe sfu节点下线,客户端需要重新订阅流
+	MigrateSubscribe = "subscribe"
+)
+
+// MigrateNotification 节点下线,通知客户端迁移
+// kind为publish时mid为发布的流,为subscribe时mid为订阅的流,sid为订阅id
+type MigrateNotification struct {
+	RID    string `json:"rid"`
+	UID    string `json:"uid"`
+	Kind   string `json:"kind"`
+	NID    string `json:"nid"`
+	MID    string `json:"mid,omitempty"`
+	SID    string `json:"sid,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+/*
+	sfu推送给islb的广播
+*/
+
+// SfuDrainNotification sfu下线,需要迁移的流
+type SfuDrainNotification struct {
+	NID     string                 `json:"nid"`
+	Streams []*MigrateNotification `json:"streams"`
+}
+
+/*
+	sfu推送给issr的广播
+*/
+
+// Subscription sfu上的一路订阅,sid为订阅id,uid为订阅者,mid为发布流
+type Subscription struct {
+	SID   string     `json:"sid" validate:"required"`
+	RID   string     `json:"rid" validate:"required"`
+	UID   string     `json:"uid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// SfuSubscriptionNotification 订阅添加和移除
+type SfuSubscriptionNotification struct {
+	Subscription
+	NID    string `json:"nid" validate:"required"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SfuHeartbeatNotification sfu定时上报节点上所有的订阅
+type SfuHeartbeatNotification struct {
+	NID  string         `json:"nid" validate:"required"`
+	Subs []Subscription `json:"subs"`
+}
+
+/*
+	islb推送给issr的广播
+*/
+
+// LiveStream islb上的一路直播流,uid为发起直播的用户,nid为mcu节点
+type LiveStream struct {
+	AppID string     `json:"appid"`
+	RID   string     `json:"rid" validate:"required"`
+	UID   string     `json:"uid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	NID   string     `json:"nid"`
+	MInfo *MediaInfo `json:"minfo"`
+}
+
+// IslbLiveHeartbeatNotification islb定时上报负责的房间中所有的直播流
+type IslbLiveHeartbeatNotification struct {
+	NID   string       `json:"nid" validate:"required"`
+	Lives []LiveStream `json:"lives"`
+}
+
+/*
+	biz之间和管理接口
+*/
+
+// BizKickRequest 踢出房间
+type BizKickRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid" validate:"required"`
+}
+
+// BizMessageRequest 转发消息给本节点上的用户
+type BizMessageRequest struct {
+	RID  string               `json:"rid" validate:"required"`
+	To   string               `json:"to" validate:"required"`
+	Data *MessageNotification `json:"data" validate:"required"`
+}
+
+// BizStartLiveRequest 以指定用户的身份开始直播,其他字段同StartLivestreamRequest
+type BizStartLiveRequest struct {
+	RID    string `json:"rid" validate:"required"`
+	UID    string `json:"uid" validate:"required"`
+	MID    string `json:"mid" validate:"required"`
+	NID    string `json:"nid,omitempty"`
+	Record int    `json:"record"`
+	Index  int    `json:"index"`
+}
+
+// Check 检查参数取值
+func (r *BizStartLiveRequest) Check() error {
+	return (&StartLivestreamRequest{Record: r.Record, Index: r.Index}).Check()
+}
+
+// BizStopLiveRequest 以指定用户的身份停止直播,其他字段同StopLivestreamRequest
+type BizStopLiveRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+	NID string `json:"nid" validate:"required"`
+	MCU string `json:"mcu,omitempty"`
+}
+
+// AdminMigrateRequest 通知发布者迁移流,nid为目标sfu,为空时自动选择
+type AdminMigrateRequest struct {
+	RID string `json:"rid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+	NID string `json:"nid,omitempty"`
+}
+
+/*
+	应用服务端接口,http请求需要签名
+*/
+
+// ServerBroadcastRequest 以from的身份向房间发送广播,from为空时为server,否则必须是房间中的用户
+type ServerBroadcastRequest struct {
+	RID  string      `json:"rid" validate:"required"`
+	From string      `json:"from,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+// ServerMessageRequest 以from的身份给指定用户发送消息,from为空时为server,否则必须是房间中的用户
+type ServerMessageRequest struct {
+	RID  string      `json:"rid" validate:"required"`
+	From string      `json:"from,omitempty"`
+	To   Recipients  `json:"to" validate:"required"`
+	Data interface{} `json:"data"`
+}
+
+// Check 检查接收者数量
+func (r *ServerMessageRequest) Check() error {
+	return r.To.Check()
+}
+
+// ServerRoomRequest 指定房间
+type ServerRoomRequest struct {
+	RID string `json:"rid" validate:"required"`
+}
+
+// ServerCloseResponse 关闭房间响应,kicked为踢出的用户数
+type ServerCloseResponse struct {
+	Kicked int `json:"kicked"`
+}
+
+// ServerParticipantsResponse 房间的用户、实时流和直播流
+type ServerParticipantsResponse struct {
+	RID   string       `json:"rid"`
+	Users []RoomUser   `json:"users"`
+	Pubs  []StreamInfo `json:"pubs"`
+	Lives []StreamInfo `json:"lives"`
+}
+
+// WebhookEvent 推送给应用服务端的事件,请求按infra/http.Sign签名
+// data为islb广播的原始数据
+type WebhookEvent struct {
+	ID    string                 `json:"id"`
+	Event string                 `json:"event"`
+	AppID string                 `json:"appid"`
+	RID   string                 `json:"rid"`
+	UID   string                 `json:"uid,omitempty"`
+	MID   string                 `json:"mid,omitempty"`
+	Time  int64                  `json:"time"` // 事件时间,unix毫秒
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventRecordVersion 导出的信令事件的版本,字段不兼容变化时加一
+const EventRecordVersion = 1
+
+// EventRecord issr导出到kafka的信令事件,source为发出广播的服务,nid为发出广播的节点
+// event为广播的method,data为广播的原始数据
+type EventRecord struct {
+	Version int                    `json:"version"`
+	ID      string                 `json:"id"`
+	Source  string                 `json:"source"`
+	NID     string                 `json:"nid"`
+	Event   string                 `json:"event"`
+	AppID   string                 `json:"appid,omitempty"`
+	RID     string                 `json:"rid,omitempty"`
+	UID     string                 `json:"uid,omitempty"`
+	MID     string                 `json:"mid,omitempty"`
+	Time    int64                  `json:"time"` // issr收到事件的时间,unix毫秒
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+/*
+	biz与sfu服务器通信
+*/
+
+// SfuPublishRequest 发布流,迁移时mid为原来的流id
+type SfuPublishRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	UID   string     `json:"uid" validate:"required"`
+	MID   string     `json:"mid,omitempty"`
+	Jsep  *Jsep      `json:"jsep" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// SfuPublishResponse 发布流响应
+type SfuPublishResponse struct {
+	Jsep *Jsep  `json:"jsep"`
+	MID  string `json:"mid"`
+}
+
+// SfuUnPublishRequest 取消发布流
+type SfuUnPublishRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid" validate:"required"`
+	MID string `json:"mid" validate:"required"`
+}
+
+// SfuSubscribeRequest 订阅流,uid为订阅者
+type SfuSubscribeRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	UID   string     `json:"uid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	Jsep  *Jsep      `json:"jsep" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// SfuSubscribeResponse 订阅流响应,mid为订阅id,uid为发布者
+type SfuSubscribeResponse struct {
+	Jsep *Jsep  `json:"jsep"`
+	MID  string `json:"mid"`
+	UID  string `json:"uid"`
+}
+
+// SfuUnSubscribeRequest 取消订阅流,mid为订阅id
+type SfuUnSubscribeRequest struct {
+	RID string `json:"rid"`
+	UID string `json:"uid"`
+	MID string `json:"mid" validate:"required"`
+}
+
+// SfuRoutersRequest 获取router状态,id不为空时只返回该router,否则按rid过滤
+type SfuRoutersRequest struct {
+	RID string `json:"rid,omitempty"`
+	ID  string `json:"id,omitempty"`
+}
+
+// SfuRoutersResponse router状态
+type SfuRoutersResponse struct {
+	Routers []RouterInfo `json:"routers"`
+}
+
+// RouterInfo sfu上一路发布流的转发状态,subs为订阅id,stat为抖动缓冲统计
+type RouterInfo struct {
+	ID     string      `json:"id"`
+	RID    string      `json:"rid"`
+	UID    string      `json:"uid"`
+	MID    string      `json:"mid"`
+	Pub    string      `json:"pub,omitempty"`
+	Tracks []TrackInfo `json:"tracks"`
+	Subs   []string    `json:"subs"`
+	Stat   string      `json:"stat,omitempty"`
+}
+
+/*
+	biz与islb服务器通信
+*/
+
+// IslbPeerRequest 只包含房间和用户的请求
+// 用于 keepalive, getBizInfo, getRoomUsers, getRoomLives
+type IslbPeerRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid"`
+}
+
+// IslbJoinRequest 有人加入房间,info为json字符串
+type IslbJoinRequest struct {
+	RID   string `json:"rid" validate:"required"`
+	UID   string `json:"uid" validate:"required"`
+	NID   string `json:"nid" validate:"required"`
+	Info  string `json:"info"`
+	AppID string `json:"appid,omitempty"`
+}
+
+// IslbLeaveRequest 有人离开房间,被踢出时reason为kick
+type IslbLeaveRequest struct {
+	RID    string `json:"rid" validate:"required"`
+	UID    string `json:"uid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// IslbStreamRequest 有人发布流或直播流
+type IslbStreamRequest struct {
+	RID   string     `json:"rid" validate:"required"`
+	UID   string     `json:"uid" validate:"required"`
+	MID   string     `json:"mid" validate:"required"`
+	NID   string     `json:"nid" validate:"required"`
+	MInfo *MediaInfo `json:"minfo" validate:"required"`
+}
+
+// IslbStreamRemoveRequest 有人取消发布流或直播流,mid为空时删除用户所有流
+type IslbStreamRemoveRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid" validate:"required"`
+	MID string `json:"mid"`
+}
+
+// IslbMediaRequest 根据mid查询sfu或流信息
+type IslbMediaRequest struct {
+	RID string `json:"rid" validate:"required"`
+	UID string `json:"uid"`
+	MID string `json:"mid" validate:"required"`
+}
+
+// IslbNodeResponse 查询节点响应
+type IslbNodeResponse struct {
+	RID string `json:"rid,omitempty"`
+	NID string `json:"nid"`
+}
+
+// IslbMcuRequest 查询或设置房间绑定的mcu
+type IslbMcuRequest struct {
+	RID string `json:"rid" validate:"required"`
+	NID string `json:"nid,omitempty"`
+}
+
+// IslbRoomSfuRequest 查询或设置房间绑定的sfu
+// force为false时只在房间未绑定时设置,返回实际绑定的sfu
+type IslbRoomSfuRequest struct {
+	RID   string `json:"rid" validate:"required"`
+	NID   string `json:"nid,omitempty"`
+	Force bool   `json:"force,omitempty"`
+}
+
+// IslbMediaInfoResponse 流信息响应
+type IslbMediaInfoResponse struct {
+	MInfo *MediaInfo `json:"minfo"`
+}
+
+// IslbBroadcastRequest 发送广播
+type IslbBroadcastRequest struct {
+	RID  string      `json:"rid" validate:"required"`
+	UID  string      `json:"uid" validate:"required"`
+	Data interface{} `json:"data"`
+}
+
+// IslbBroadcastResponse 发送广播响应
+type IslbBroadcastResponse struct {
+	MsgID string `json:"msgid"`
+}
+
+// IslbRoomsResponse 有用户的房间
+type IslbRoomsResponse struct {
+	Rooms []string `json:"rooms"`
+}
+
+// RoomInfo 房间的用户、流和绑定的节点,appid为房间所属的应用
+type RoomInfo struct {
+	RID   string       `json:"rid"`
+	AppID string       `json:"appid,omitempty"`
+	Users []RoomUser   `json:"users"`
+	Pubs  []StreamInfo `json:"pubs"`
+	Lives []StreamInfo `json:"lives"`
+	Sfu   string       `json:"sfu,omitempty"`
+	Mcu   string       `json:"mcu,omitempty"`
+}
@@ -0,0 +1,114 @@
+package proto
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"signal/pkg/bus"
+)
+
+// Checker 需要额外检查取值的请求实现该接口
+type Checker interface {
+	Check() error
+}
+
+// 必填字段缺失时返回的错误码,未列出的字段返回ErrInvalidParams
+var missingCodes = map[string]int{
+	"uid":   ErrUIDMissing,
+	"rid":   ErrRIDMissing,
+	"mid":   ErrMIDMissing,
+	"jsep":  ErrJsepMissing,
+	"sdp":   ErrSdpMissing,
+	"minfo": ErrMinfoMissing,
+	"to":    ErrToMissing,
+}
+
+// Decode 将请求数据解析到v并校验,v必须为结构体指针
+func Decode(data map[string]interface{}, v interface{}) *bus.Error {
+	if data == nil {
+		return NewError(ErrInvalidData)
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return NewError(ErrInvalidData, err)
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			return NewError(ErrInvalidParams, e.Field+" should be "+e.Type.String())
+		}
+		return NewError(ErrInvalidParams, err)
+	}
+	return Validate(v)
+}
+
+// Validate 检查required字段和Checker
+func Validate(v interface{}) *bus.Error {
+	if err := validateStruct(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	if c, ok := v.(Checker); ok {
+		if err := c.Check(); err != nil {
+			return NewError(ErrInvalidParams, err)
+		}
+	}
+	return nil
+}
+
+func validateStruct(val reflect.Value) *bus.Error {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil
+	}
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fv := val.Field(i)
+		name := jsonName(field)
+		if field.Tag.Get("validate") == "required" && isEmpty(fv) {
+			if code, ok := missingCodes[name]; ok {
+				return NewError(code)
+			}
+			return NewError(ErrInvalidParams, name+" not found")
+		}
+		if err := validateStruct(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonName 获取字段的json名称
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// ToMap 将结构体转换为map,用于rpc传递和websocket响应
+func ToMap(v interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(buf, &m)
+	return m
+}
@@ -0,0 +1,69 @@
+package proto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var req PublishRequest
+	err := Decode(map[string]interface{}{"rid": "room1", "jsep": "offer"}, &req)
+	if err == nil || err.Code != ErrInvalidParams {
+		t.Errorf("jsep type err = %v", err)
+	}
+
+	req = PublishRequest{}
+	err = Decode(map[string]interface{}{"rid": "room1", "jsep": map[string]interface{}{"type": "offer"}}, &req)
+	if err == nil || err.Code != ErrSdpMissing {
+		t.Errorf("sdp missing err = %v", err)
+	}
+
+	req = PublishRequest{}
+	err = Decode(map[string]interface{}{
+		"rid":   "room1",
+		"jsep":  map[string]interface{}{"type": "offer", "sdp": "v=0"},
+		"minfo": map[string]interface{}{"audio": true, "video": true, "resolution": "480p"},
+	}, &req)
+	if err != nil {
+		t.Fatalf("decode err = %v", err)
+	}
+	if req.MInfo.Resolution != "480p" || !req.MInfo.Video {
+		t.Errorf("minfo = %+v", req.MInfo)
+	}
+
+	var msg MessageRequest
+	if err := Decode(map[string]interface{}{"rid": "room1", "to": "uid1"}, &msg); err != nil || len(msg.To) != 1 {
+		t.Errorf("to string err = %v to = %v", err, msg.To)
+	}
+	msg = MessageRequest{}
+	if err := Decode(map[string]interface{}{"rid": "room1", "to": []interface{}{}}, &msg); err == nil || err.Code != ErrToMissing {
+		t.Errorf("to empty err = %v", err)
+	}
+	to := make([]interface{}, MaxRecipients+1)
+	for i := range to {
+		to[i] = fmt.Sprintf("uid%d", i)
+	}
+	msg = MessageRequest{}
+	if err := Decode(map[string]interface{}{"rid": "room1", "to": to}, &msg); err == nil || err.Code != ErrInvalidParams {
+		t.Errorf("too many recipients err = %v", err)
+	}
+
+	var history HistoryRequest
+	if err := Decode(map[string]interface{}{"rid": "room1", "offset": -1}, &history); err == nil || err.Code != ErrInvalidParams {
+		t.Errorf("offset err = %v", err)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := OpenAPI("test")
+	paths := doc["paths"].(map[string]interface{})
+	for _, m := range ClientMethods {
+		if _, ok := paths["/biz/"+m.Method]; !ok {
+			t.Errorf("method %s missing", m.Method)
+		}
+	}
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	if schemas["PublishRequest"] == nil || schemas["Jsep"] == nil {
+		t.Errorf("schemas missing")
+	}
+}
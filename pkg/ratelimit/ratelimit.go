@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rule 限流规则,rate为每秒产生的令牌数,burst为桶容量
+type Rule struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// Bucket 令牌桶
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket 创建令牌桶,初始为满
+func NewBucket(rule Rule, now time.Time) *Bucket {
+	burst := float64(rule.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		rate:   rule.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// refill 按经过的时间补充令牌
+func (b *Bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Allow 取一个令牌,没有令牌时返回false
+func (b *Bucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Return 归还一个令牌,不超过桶容量
+func (b *Bucket) Return() {
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// full 桶是否已满,满的桶可以删除
+func (b *Bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Limiter 按key分别限流
+type Limiter struct {
+	rule    Rule
+	buckets map[string]*Bucket
+	lock    sync.Mutex
+}
+
+// NewLimiter 创建限流器
+func NewLimiter(rule Rule) *Limiter {
+	return &Limiter{
+		rule:    rule,
+		buckets: make(map[string]*Bucket),
+	}
+}
+
+// Allow key对应的桶是否还有令牌
+func (l *Limiter) Allow(key string) bool {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rule, now)
+		l.buckets[key] = b
+	}
+	return b.Allow(now)
+}
+
+// Return 归还key对应的桶取走的令牌,请求被其他限流器拒绝时调用
+func (l *Limiter) Return(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.Return()
+	}
+}
+
+// Cleanup 删除已经补满的桶,返回剩余的桶数量
+func (l *Limiter) Cleanup() int {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	return len(l.buckets)
+}
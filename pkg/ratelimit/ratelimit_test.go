@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(Rule{Rate: 2, Burst: 3}, now)
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("burst request %d rejected", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Fatalf("request over burst allowed")
+	}
+	// 0.5秒补充1个令牌
+	now = now.Add(500 * time.Millisecond)
+	if !b.Allow(now) {
+		t.Fatalf("request after refill rejected")
+	}
+	if b.Allow(now) {
+		t.Fatalf("request over refill allowed")
+	}
+	now = now.Add(time.Hour)
+	if !b.full(now) {
+		t.Fatalf("bucket should be full after idle")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(Rule{Rate: 1, Burst: 1})
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatalf("limiter a")
+	}
+	if !l.Allow("b") {
+		t.Fatalf("limiter b should be independent")
+	}
+	if n := l.Cleanup(); n != 2 {
+		t.Fatalf("cleanup left %d buckets", n)
+	}
+	l.Return("a")
+	if !l.Allow("a") {
+		t.Fatalf("returned token rejected")
+	}
+}
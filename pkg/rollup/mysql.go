@@ -0,0 +1,100 @@
+package rollup
+
+import (
+	"strings"
+
+	"signal/infra/mysql"
+)
+
+const rollupTable = "usage_rollup"
+
+// MysqlStore 汇总保存在mysql,按维度唯一索引累加
+type MysqlStore struct {
+	db *mysql.MysqlDriver
+}
+
+// NewMysqlStore 连接mysql并创建汇总表
+func NewMysqlStore(c mysql.MysqlConfig) (*MysqlStore, error) {
+	db := mysql.NewMysqlDriver(c)
+	_, err := db.DbCon.Exec("CREATE TABLE IF NOT EXISTS `" + rollupTable + "` (" +
+		"`appid` VARCHAR(128) NOT NULL," +
+		"`day` DATE NOT NULL," +
+		"`type` INT NOT NULL," +
+		"`mediatype` VARCHAR(16) NOT NULL," +
+		"`resolution` VARCHAR(16) NOT NULL," +
+		"`seconds` BIGINT NOT NULL DEFAULT 0," +
+		"PRIMARY KEY (`appid`, `day`, `type`, `mediatype`, `resolution`)," +
+		"KEY `idx_day` (`day`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MysqlStore{db: db}, nil
+}
+
+// Add 累加用量
+func (s *MysqlStore) Add(rollups []Rollup) error {
+	for _, r := range rollups {
+		_, err := s.db.DbCon.Exec("INSERT INTO `"+rollupTable+"` (`appid`, `day`, `type`, `mediatype`, `resolution`, `seconds`) "+
+			"VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE `seconds` = `seconds` + VALUES(`seconds`)",
+			r.AppID, r.Day, r.Type, r.MediaType, r.Resolution, r.Seconds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query 查询用量
+func (s *MysqlStore) Query(q Query) ([]Rollup, error) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if q.AppID != "" {
+		add("`appid` = ?", q.AppID)
+	}
+	if q.From != "" {
+		add("`day` >= ?", q.From)
+	}
+	if q.To != "" {
+		add("`day` <= ?", q.To)
+	}
+	if q.Type != 0 {
+		add("`type` = ?", q.Type)
+	}
+	if q.MediaType != "" {
+		add("`mediatype` = ?", q.MediaType)
+	}
+	if q.Resolution != "" {
+		add("`resolution` = ?", q.Resolution)
+	}
+	sql := "SELECT `appid`, DATE_FORMAT(`day`, '%Y-%m-%d'), `type`, `mediatype`, `resolution`, `seconds` FROM `" + rollupTable + "`"
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY `appid`, `day`, `type`, `mediatype`, `resolution`"
+
+	rows, err := s.db.Find(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]Rollup, 0)
+	for rows.Next() {
+		var r Rollup
+		if err := rows.Scan(&r.AppID, &r.Day, &r.Type, &r.MediaType, &r.Resolution, &r.Seconds); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// Close 关闭连接
+func (s *MysqlStore) Close() {
+	s.db.Close()
+}
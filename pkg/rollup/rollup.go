@@ -0,0 +1,132 @@
+package rollup
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 按appid、日期、计时类型、媒体类型和分辨率档位汇总用量,供客服直接查询
+// 跨天的计费区间按秒拆分到每一天,日期按issr所在时区计算
+
+// DayLayout 日期格式
+const DayLayout = "2006-01-02"
+
+// Rollup 一天的用量汇总,type为计时类型,seconds为累计秒数
+type Rollup struct {
+	AppID      string `json:"appid"`
+	Day        string `json:"day"`
+	Type       int    `json:"type"`
+	MediaType  string `json:"mediatype"`
+	Resolution string `json:"resolution"`
+	Seconds    int64  `json:"seconds"`
+}
+
+// Query 查询条件,from和to为日期,包含两端,其他条件为空时不过滤
+type Query struct {
+	AppID      string
+	From       string
+	To         string
+	Type       int
+	MediaType  string
+	Resolution string
+}
+
+// Store 用量汇总存储
+type Store interface {
+	// Add 累加用量
+	Add(rollups []Rollup) error
+	// Query 按appid、日期、类型、媒体类型、分辨率排序返回
+	Query(q Query) ([]Rollup, error)
+}
+
+// Split 把[start, end)的用量按天拆分,start和end为unix秒
+func Split(r Rollup, start, end int64) []Rollup {
+	rollups := make([]Rollup, 0, 1)
+	for start < end {
+		t := time.Unix(start, 0)
+		next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Unix()
+		if next > end {
+			next = end
+		}
+		day := r
+		day.Day = t.Format(DayLayout)
+		day.Seconds = next - start
+		rollups = append(rollups, day)
+		start = next
+	}
+	return rollups
+}
+
+// key 汇总维度
+func (r *Rollup) key() Rollup {
+	k := *r
+	k.Seconds = 0
+	return k
+}
+
+// match 是否满足查询条件
+func (q *Query) match(r *Rollup) bool {
+	return (q.AppID == "" || r.AppID == q.AppID) &&
+		(q.From == "" || r.Day >= q.From) &&
+		(q.To == "" || r.Day <= q.To) &&
+		(q.Type == 0 || r.Type == q.Type) &&
+		(q.MediaType == "" || r.MediaType == q.MediaType) &&
+		(q.Resolution == "" || r.Resolution == q.Resolution)
+}
+
+// Sort 按appid、日期、类型、媒体类型、分辨率排序
+func Sort(rollups []Rollup) {
+	sort.Slice(rollups, func(i, j int) bool {
+		a, b := rollups[i], rollups[j]
+		if a.AppID != b.AppID {
+			return a.AppID < b.AppID
+		}
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.MediaType != b.MediaType {
+			return a.MediaType < b.MediaType
+		}
+		return a.Resolution < b.Resolution
+	})
+}
+
+// MemoryStore 进程内的汇总,用于单进程部署和测试,重启后丢失
+type MemoryStore struct {
+	sync.Mutex
+	rollups map[Rollup]int64
+}
+
+// NewMemoryStore 创建进程内的汇总
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rollups: make(map[Rollup]int64)}
+}
+
+// Add 累加用量
+func (s *MemoryStore) Add(rollups []Rollup) error {
+	s.Lock()
+	defer s.Unlock()
+	for i := range rollups {
+		s.rollups[rollups[i].key()] += rollups[i].Seconds
+	}
+	return nil
+}
+
+// Query 查询用量
+func (s *MemoryStore) Query(q Query) ([]Rollup, error) {
+	s.Lock()
+	result := make([]Rollup, 0)
+	for k, seconds := range s.rollups {
+		if q.match(&k) {
+			k.Seconds = seconds
+			result = append(result, k)
+		}
+	}
+	s.Unlock()
+	Sort(result)
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	start := time.Date(2021, 3, 1, 23, 59, 0, 0, time.Local).Unix()
+	rollups := Split(Rollup{AppID: "a", MediaType: "video", Resolution: "HD"}, start, start+180)
+	if len(rollups) != 2 {
+		t.Fatalf("rollups = %+v", rollups)
+	}
+	if rollups[0].Day != "2021-03-01" || rollups[0].Seconds != 60 || rollups[1].Day != "2021-03-02" || rollups[1].Seconds != 120 {
+		t.Errorf("rollups = %+v", rollups)
+	}
+	if len(Split(Rollup{}, start, start)) != 0 {
+		t.Error("empty interval split")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	s.Add([]Rollup{
+		{AppID: "a", Day: "2021-03-02", Type: 200, MediaType: "video", Resolution: "HD", Seconds: 60},
+		{AppID: "a", Day: "2021-03-01", Type: 200, MediaType: "video", Resolution: "HD", Seconds: 30},
+		{AppID: "a", Day: "2021-03-01", Type: 201, MediaType: "audio", Seconds: 10},
+		{AppID: "b", Day: "2021-03-01", Type: 200, MediaType: "video", Resolution: "HD", Seconds: 5},
+	})
+	s.Add([]Rollup{{AppID: "a", Day: "2021-03-01", Type: 200, MediaType: "video", Resolution: "HD", Seconds: 30}})
+
+	result, _ := s.Query(Query{AppID: "a", From: "2021-03-01", To: "2021-03-01"})
+	if len(result) != 2 || result[0].Seconds != 60 || result[0].Resolution != "HD" || result[1].MediaType != "audio" {
+		t.Errorf("day query = %+v", result)
+	}
+	result, _ = s.Query(Query{Resolution: "HD"})
+	if len(result) != 3 || result[0].Day != "2021-03-01" || result[2].AppID != "b" {
+		t.Errorf("resolution query = %+v", result)
+	}
+}
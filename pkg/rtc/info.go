@@ -0,0 +1,39 @@
+package rtc
+
+import (
+	"sort"
+
+	"signal/pkg/proto"
+	"signal/pkg/rtc/plugins"
+)
+
+// Info 获取router的发布流、track、订阅和抖动缓冲统计
+func (r *Router) Info(id string) proto.RouterInfo {
+	info := proto.RouterInfo{ID: id, Tracks: r.tracks, Subs: make([]string, 0)}
+	_, info.RID, info.UID, info.MID, _ = proto.ParseMediaKey(id)
+	if pub := r.GetPub(); pub != nil {
+		info.Pub = pub.ID()
+	}
+	for sid := range r.GetSubs() {
+		info.Subs = append(info.Subs, sid)
+	}
+	sort.Strings(info.Subs)
+	if r.pluginChain != nil {
+		if jb, ok := r.pluginChain.GetPlugin(plugins.TypeJitterBuffer).(*plugins.JitterBuffer); ok {
+			info.Stat = jb.Stat()
+		}
+	}
+	return info
+}
+
+// GetRouterInfos 获取router状态,rid为空时返回全部router
+func GetRouterInfos(rid string) []proto.RouterInfo {
+	infos := make([]proto.RouterInfo, 0)
+	MapRouter(func(id string, r *Router) {
+		if _, routerRID, _, _, ok := proto.ParseMediaKey(id); rid == "" || (ok && routerRID == rid) {
+			infos = append(infos, r.Info(id))
+		}
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
@@ -3,6 +3,7 @@ package plugins
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"signal/pkg/log"
@@ -33,7 +34,7 @@ type JitterBufferConfig struct {
 // JitterBuffer core buffer module
 type JitterBuffer struct {
 	buffers   map[uint32]*Buffer
-	stop      bool
+	stop      int32
 	bandwidth uint64
 	lostRate  float64
 
@@ -88,7 +89,7 @@ func (j *JitterBuffer) AttachPub(t transport.Transport) {
 	j.Pub = t
 	go func() {
 		for {
-			if j.stop {
+			if atomic.LoadInt32(&j.stop) == 1 {
 				return
 			}
 			pkt, err := j.Pub.ReadRTP()
@@ -158,7 +159,7 @@ func (j *JitterBuffer) ReadRTP() <-chan *rtp.Packet {
 func (j *JitterBuffer) nackLoop(b *Buffer) {
 	go func() {
 		for nack := range b.GetRTCPChan() {
-			if j.stop {
+			if atomic.LoadInt32(&j.stop) == 1 {
 				return
 			}
 			if j.Pub == nil {
@@ -175,7 +176,7 @@ func (j *JitterBuffer) nackLoop(b *Buffer) {
 func (j *JitterBuffer) rembLoop() {
 	go func() {
 		for {
-			if j.stop {
+			if atomic.LoadInt32(&j.stop) == 1 {
 				return
 			}
 
@@ -229,7 +230,7 @@ func (j *JitterBuffer) rembLoop() {
 func (j *JitterBuffer) pliLoop() {
 	go func() {
 		for {
-			if j.stop {
+			if atomic.LoadInt32(&j.stop) == 1 {
 				return
 			}
 
@@ -266,10 +267,9 @@ func (j *JitterBuffer) GetPacket(ssrc uint32, sn uint16) *rtp.Packet {
 
 // Stop stop all buffer
 func (j *JitterBuffer) Stop() {
-	if j.stop {
+	if !atomic.CompareAndSwapInt32(&j.stop, 0, 1) {
 		return
 	}
-	j.stop = true
 	for _, buffer := range j.buffers {
 		buffer.Stop()
 	}
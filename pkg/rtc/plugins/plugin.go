@@ -3,6 +3,7 @@ package plugins
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	"signal/pkg/log"
 	"signal/pkg/rtc/transport"
@@ -38,7 +39,7 @@ type PluginChain struct {
 	pub        transport.Transport
 	plugins    []Plugin
 	pluginLock sync.RWMutex
-	stop       bool
+	stop       int32
 	config     Config
 }
 
@@ -47,7 +48,7 @@ func NewPluginChain() *PluginChain {
 }
 
 func (p *PluginChain) ReadRTP() *rtp.Packet {
-	if p.stop {
+	if atomic.LoadInt32(&p.stop) == 1 {
 		return nil
 	}
 
@@ -171,9 +172,8 @@ func (p *PluginChain) DelPluginChain() {
 }
 
 func (p *PluginChain) Close() {
-	if p.stop {
+	if !atomic.CompareAndSwapInt32(&p.stop, 0, 1) {
 		return
 	}
-	p.stop = true
 	p.DelPluginChain()
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"signal/pkg/log"
@@ -23,6 +24,13 @@ const (
 	liveCycle   = 6 * time.Second
 )
 
+var (
+	// ErrSdpParse sdp解析失败
+	ErrSdpParse = errors.New("offer sdp is err")
+	// ErrCodecUnsupported sdp中没有支持的编码格式
+	ErrCodecUnsupported = errors.New("no supported codec in offer sdp")
+)
+
 //                                      +--->sub
 //                                      |
 // pub--->pubCh-->pluginChain-->subCh---+--->sub
@@ -33,7 +41,7 @@ type Router struct {
 	pub         transport.Transport
 	subs        map[string]transport.Transport
 	subLock     sync.RWMutex
-	stop        bool
+	stop        int32
 	liveTime    time.Time
 	pluginChain *plugins.PluginChain
 	tracks      []proto.TrackInfo
@@ -63,7 +71,7 @@ func (r *Router) start() {
 	go func() {
 		defer util.Recover("[Router.start]")
 		for {
-			if r.stop {
+			if atomic.LoadInt32(&r.stop) == 1 {
 				return
 			}
 
@@ -84,6 +92,8 @@ func (r *Router) start() {
 				continue
 			}
 			r.liveTime = time.Now().Add(liveCycle)
+			size := uint64(pkt.MarshalSize())
+			atomic.AddUint64(&ingressBytes, size)
 			// nonblock sending
 			go func() {
 				for _, t := range r.GetSubs() {
@@ -99,6 +109,8 @@ func (r *Router) start() {
 						if t.WriteErrTotal() > maxWriteErr {
 							r.DelSub(t.ID())
 						}
+					} else {
+						atomic.AddUint64(&egressBytes, size)
 					}
 					t.WriteErrReset()
 				}
@@ -116,8 +128,13 @@ func (r *Router) AddPub(sdp, id, ip string, options map[string]interface{}) (str
 
 	tracks, err := sdpTotracks(sdp)
 	if err != nil {
+		pub.Close()
 		return "", err
 	}
+	if len(tracks) == 0 {
+		pub.Close()
+		return "", ErrCodecUnsupported
+	}
 
 	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
 	answer, err := pub.Answer(offer, true)
@@ -186,7 +203,7 @@ func (r *Router) AddSub(sdp, id, ip string, options map[string]interface{}) (str
 func (r *Router) DoRtcp(id string, sub *transport.WebRTCTransport) {
 	for {
 		pkt := <-sub.GetRTCPChan()
-		if r.stop {
+		if atomic.LoadInt32(&r.stop) == 1 {
 			return
 		}
 		switch pkt.(type) {
@@ -262,11 +279,10 @@ func (r *Router) DelSubs() {
 
 // Close release all
 func (r *Router) Close() {
-	if r.stop {
+	if !atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
 		return
 	}
 	log.Infof("Router.Close")
-	r.stop = true
 	r.DelPub()
 	r.DelSubs()
 }
@@ -305,7 +321,7 @@ func (r *Router) Alive() bool {
 func sdpTotracks(sdp string) ([]proto.TrackInfo, error) {
 	sdpObj, err := sdps.Parse(sdp)
 	if err != nil {
-		return nil, errors.New("offer sdp is err")
+		return nil, ErrSdpParse
 	}
 
 	var infos []proto.TrackInfo
@@ -2,9 +2,9 @@ package rtc
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
-	conf "signal/pkg/conf/sfu"
 	"signal/pkg/log"
 	"signal/pkg/rtc/plugins"
 	"signal/pkg/rtc/transport"
@@ -23,57 +23,44 @@ const (
 )
 
 var (
-	stop          bool
+	stop          int32
 	routers       = make(map[string]*Router)
 	routerLock    sync.RWMutex
 	CleanPub      = make(chan string, maxCleanSize)
 	pluginsConfig plugins.Config
 )
 
+// Config sfu参数
+type Config struct {
+	// ICEPortRange WebRTC端口范围[min, max],为空时不限制
+	ICEPortRange []uint16
+	ICEServers   []webrtc.ICEServer
+	Plugins      plugins.Config
+}
+
 // InitSfu 启动sfu
-func InitSfu() {
+func InitSfu(config Config) {
 	var icePortStart, icePortEnd uint16
-	if len(conf.WebRTC.ICEPortRange) == 2 {
-		icePortStart = conf.WebRTC.ICEPortRange[0]
-		icePortEnd = conf.WebRTC.ICEPortRange[1]
+	if len(config.ICEPortRange) == 2 {
+		icePortStart = config.ICEPortRange[0]
+		icePortEnd = config.ICEPortRange[1]
 	}
 
-	log.Init(conf.Log.Level)
-	var iceServers []webrtc.ICEServer
-	for _, iceServer := range conf.WebRTC.ICEServers {
-		s := webrtc.ICEServer{
-			URLs:       iceServer.URLs,
-			Username:   iceServer.Username,
-			Credential: iceServer.Credential,
-		}
-		iceServers = append(iceServers, s)
-	}
-	if err := InitIce(iceServers, icePortStart, icePortEnd); err != nil {
+	if err := InitIce(config.ICEServers, icePortStart, icePortEnd); err != nil {
 		panic(err)
 	}
 
-	pluginConfig := plugins.Config{
-		On: conf.Plugins.On,
-		JitterBuffer: plugins.JitterBufferConfig{
-			On:            conf.Plugins.JitterBuffer.On,
-			REMBCycle:     conf.Plugins.JitterBuffer.REMBCycle,
-			PLICycle:      conf.Plugins.JitterBuffer.PLICycle,
-			MaxBandwidth:  conf.Plugins.JitterBuffer.MaxBandwidth,
-			MaxBufferTime: conf.Plugins.JitterBuffer.MaxBufferTime,
-		},
-	}
-
-	if err := CheckPlugins(pluginConfig); err != nil {
+	if err := CheckPlugins(config.Plugins); err != nil {
 		panic(err)
 	}
 
-	InitPlugins(pluginConfig)
+	InitPlugins(config.Plugins)
 	go CheckRoute()
 }
 
 // FreeSfu 关闭sfu
 func FreeSfu() {
-	stop = true
+	atomic.StoreInt32(&stop, 1)
 	routerLock.Lock()
 	defer routerLock.Unlock()
 	for id, router := range routers {
@@ -150,7 +137,7 @@ func CheckRoute() {
 	t := time.NewTicker(statCycle)
 	defer t.Stop()
 	for {
-		if stop {
+		if atomic.LoadInt32(&stop) == 1 {
 			return
 		}
 
@@ -0,0 +1,14 @@
+package rtc
+
+import "sync/atomic"
+
+// 启动以来收发的rtp字节数
+var (
+	ingressBytes uint64
+	egressBytes  uint64
+)
+
+// GetTraffic 获取启动以来收到和转发的rtp字节数
+func GetTraffic() (ingress, egress uint64) {
+	return atomic.LoadUint64(&ingressBytes), atomic.LoadUint64(&egressBytes)
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"signal/pkg/log"
 
@@ -70,7 +71,7 @@ type WebRTCTransport struct {
 
 	rtpCh  chan *rtp.Packet
 	rtcpCh chan rtcp.Packet
-	stop   bool
+	stop   int32
 	alive  bool
 	isPub  bool
 
@@ -119,7 +120,6 @@ func NewWebRTCTransport(id string, options map[string]interface{}, bPub bool) *W
 		nIndex:    0,
 		nCount:    0,
 		bandwidth: 1000,
-		stop:      false,
 		alive:     true,
 	}
 	err := w.init(options, bPub)
@@ -255,7 +255,7 @@ func (w *WebRTCTransport) Answer(offer webrtc.SessionDescription, bPub bool) (we
 func (w *WebRTCTransport) receiveInTrackRTP(remoteTrack *webrtc.Track) {
 	go func() {
 		for {
-			if w.stop {
+			if atomic.LoadInt32(&w.stop) == 1 {
 				return
 			}
 
@@ -293,7 +293,7 @@ func (w *WebRTCTransport) receiveOutTrackRTCP() {
 // receiveRTCP 接收一路rtcp包
 func (w *WebRTCTransport) receiveRTCP(sender *webrtc.RTPSender) {
 	for {
-		if w.stop {
+		if atomic.LoadInt32(&w.stop) == 1 {
 			return
 		}
 
@@ -372,11 +372,10 @@ func (w *WebRTCTransport) GetOutTracks() map[uint32]*webrtc.Track {
 
 // Close all
 func (w *WebRTCTransport) Close() {
-	if w.stop {
+	if !atomic.CompareAndSwapInt32(&w.stop, 0, 1) {
 		return
 	}
 	w.pc.Close()
-	w.stop = true
 }
 
 // WriteErrTotal return write error
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	dis "signal/infra/discovery"
+)
+
+// etcd存储
+// 房间数据都在 /islb/room/{rid}/ 下,用户key的租约即保活时间,流,绑定关系和聊天记录使用RoomTTL的租约
+// 同一个房间的聊天记录共用一个租约,每次写入时续期
+const etcdRoomPrefix = "/islb/room/"
+
+// EtcdStore etcd存储
+type EtcdStore struct {
+	etcd   *dis.Etcd
+	lock   sync.Mutex
+	leases map[string]*historyLease
+}
+
+// historyLease 房间聊天记录的租约,expire之后租约可能已经过期,需要重新申请
+type historyLease struct {
+	id     int64
+	expire time.Time
+}
+
+// NewEtcdStore 创建etcd存储
+func NewEtcdStore(e *dis.Etcd) *EtcdStore {
+	return &EtcdStore{etcd: e, leases: make(map[string]*historyLease)}
+}
+
+func etcdRoomKey(rid string, parts ...string) string {
+	return etcdRoomPrefix + rid + "/" + strings.Join(parts, "/")
+}
+
+func etcdStreamDir(kind StreamKind) string {
+	if kind == Live {
+		return "lives"
+	}
+	return "pubs"
+}
+
+// Join 保存房间用户
+func (s *EtcdStore) Join(rid string, member Member, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(member)
+	if err != nil {
+		return false, err
+	}
+	return s.etcd.PutFirst(etcdRoomKey(rid, "members", member.UID), string(data), ttl, etcdRoomKey(rid, "members", ""))
+}
+
+// Leave 删除房间用户
+func (s *EtcdStore) Leave(rid, uid string) (bool, error) {
+	return s.etcd.DeleteLast(etcdRoomKey(rid, "members", uid), etcdRoomKey(rid, "members", ""))
+}
+
+// KeepAlive 续期用户key的租约,保活时间沿用Join时的ttl
+func (s *EtcdStore) KeepAlive(rid, uid string, ttl time.Duration) error {
+	_, err := s.etcd.Refresh(etcdRoomKey(rid, "members", uid))
+	return err
+}
+
+// GetMember 获取房间用户
+func (s *EtcdStore) GetMember(rid, uid string) (*Member, error) {
+	val, err := s.etcd.GetValue(etcdRoomKey(rid, "members", uid))
+	if err != nil || val == "" {
+		return nil, err
+	}
+	var member Member
+	if err := json.Unmarshal([]byte(val), &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetMembers 获取房间所有用户
+func (s *EtcdStore) GetMembers(rid string) ([]Member, error) {
+	data, err := s.etcd.GetByPrefix(etcdRoomKey(rid, "members", ""))
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(data))
+	for key, val := range data {
+		var member Member
+		if err := json.Unmarshal([]byte(val), &member); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// GetRooms 获取有用户的房间,用户key过期时由租约删除
+func (s *EtcdStore) GetRooms() ([]string, error) {
+	keys, err := s.etcd.GetKeysByPrefix(etcdRoomPrefix)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	rooms := make([]string, 0)
+	for _, key := range keys {
+		// /islb/room/{rid}/members/{uid}
+		arr := strings.Split(strings.TrimPrefix(key, etcdRoomPrefix), "/")
+		if len(arr) == 3 && arr[1] == "members" && !found[arr[0]] {
+			found[arr[0]] = true
+			rooms = append(rooms, arr[0])
+		}
+	}
+	return rooms, nil
+}
+
+// AddStream 保存流信息和流对应的节点
+func (s *EtcdStore) AddStream(kind StreamKind, rid string, stream Stream) error {
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return err
+	}
+	return s.etcd.Put(etcdRoomKey(rid, etcdStreamDir(kind), stream.UID, stream.MID), string(data), RoomTTL)
+}
+
+// RemoveStreams 删除流
+func (s *EtcdStore) RemoveStreams(kind StreamKind, rid, uid, mid string) ([]string, error) {
+	data, err := s.etcd.DeleteWithPrev(etcdRoomKey(rid, etcdStreamDir(kind), uid, mid), mid == "")
+	if err != nil {
+		return nil, err
+	}
+	mids := make([]string, 0, len(data))
+	for _, val := range data {
+		var stream Stream
+		if err := json.Unmarshal([]byte(val), &stream); err == nil {
+			mids = append(mids, stream.MID)
+		}
+	}
+	return mids, nil
+}
+
+// GetStream 获取流
+func (s *EtcdStore) GetStream(kind StreamKind, rid, uid, mid string) (*Stream, error) {
+	val, err := s.etcd.GetValue(etcdRoomKey(rid, etcdStreamDir(kind), uid, mid))
+	if err != nil || val == "" {
+		return nil, err
+	}
+	var stream Stream
+	if err := json.Unmarshal([]byte(val), &stream); err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// GetStreams 获取房间所有流
+func (s *EtcdStore) GetStreams(kind StreamKind, rid string) ([]Stream, error) {
+	data, err := s.etcd.GetByPrefix(etcdRoomKey(rid, etcdStreamDir(kind), ""))
+	if err != nil {
+		return nil, err
+	}
+	streams := make([]Stream, 0, len(data))
+	for key, val := range data {
+		var stream Stream
+		if err := json.Unmarshal([]byte(val), &stream); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// HasStreams 房间是否还有流
+func (s *EtcdStore) HasStreams(kind StreamKind, rid string) (bool, error) {
+	count, err := s.etcd.CountByPrefix(etcdRoomKey(rid, etcdStreamDir(kind), ""))
+	return count > 0, err
+}
+
+// GetBinding 获取房间绑定的节点
+func (s *EtcdStore) GetBinding(kind, rid string) (string, error) {
+	return s.etcd.GetValue(etcdRoomKey(rid, "bind", kind))
+}
+
+// SetBinding 绑定房间节点
+func (s *EtcdStore) SetBinding(kind, rid, nid string, force bool) (string, error) {
+	key := etcdRoomKey(rid, "bind", kind)
+	if force {
+		return nid, s.etcd.Put(key, nid, RoomTTL)
+	}
+	bound, _, err := s.etcd.PutIfAbsent(key, nid, RoomTTL)
+	if err != nil {
+		return "", err
+	}
+	if bound == "" {
+		bound = nid
+	}
+	return bound, nil
+}
+
+// ClearBinding 删除房间绑定的节点
+func (s *EtcdStore) ClearBinding(kind, rid string) error {
+	return s.etcd.Delete(etcdRoomKey(rid, "bind", kind), false)
+}
+
+// RefreshBinding 续期房间绑定的租约
+func (s *EtcdStore) RefreshBinding(kind, rid string) error {
+	_, err := s.etcd.Refresh(etcdRoomKey(rid, "bind", kind))
+	return err
+}
+
+// AddHistory 保存一条聊天记录,key按写入时间排序,超出size的旧记录按key范围一次删除
+func (s *EtcdStore) AddHistory(rid, record string, size int) error {
+	lease, err := s.historyLease(rid)
+	if err != nil {
+		return err
+	}
+	prefix := etcdRoomKey(rid, "chat", "")
+	if err := s.etcd.PutWithLease(prefix+fmt.Sprintf("%020d", time.Now().UnixNano()), record, lease); err != nil {
+		return err
+	}
+	// 只读取最新的size+1个key,第size+1个及更早的记录都删除
+	keys, err := s.etcd.GetKeysByPrefixDesc(prefix, int64(size+1))
+	if err != nil || len(keys) <= size {
+		return err
+	}
+	return s.etcd.DeleteRange(prefix, keys[size]+"\x00")
+}
+
+// historyLease 获取房间聊天记录的租约并续期,没有租约或续期失败时重新申请
+func (s *EtcdStore) historyLease(rid string) (int64, error) {
+	now := time.Now()
+	s.lock.Lock()
+	l := s.leases[rid]
+	s.lock.Unlock()
+	if l != nil && now.Before(l.expire) && s.etcd.RefreshLease(l.id) == nil {
+		s.lock.Lock()
+		l.expire = now.Add(RoomTTL)
+		s.lock.Unlock()
+		return l.id, nil
+	}
+	id, err := s.etcd.GrantLease(RoomTTL)
+	if err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// 顺便清理已经过期的房间
+	for key, l := range s.leases {
+		if now.After(l.expire) {
+			delete(s.leases, key)
+		}
+	}
+	s.leases[rid] = &historyLease{id: id, expire: now.Add(RoomTTL)}
+	return id, nil
+}
+
+// GetHistory 分页获取聊天记录
+func (s *EtcdStore) GetHistory(rid string, offset, limit int64) (int64, []string, error) {
+	resp, err := s.etcd.GetResponseByPrefix(etcdRoomKey(rid, "chat", ""))
+	if err != nil {
+		return 0, nil, err
+	}
+	total := int64(len(resp.Kvs))
+	records := make([]string, 0)
+	for i := offset; i < total && i < offset+limit; i++ {
+		records = append(records, string(resp.Kvs[i].Value))
+	}
+	return total, records, nil
+}
+
+// Close 关闭存储
+func (s *EtcdStore) Close() error {
+	return s.etcd.Close()
+}
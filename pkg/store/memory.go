@@ -0,0 +1,323 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// 内存存储,用于测试和单节点部署
+// 用户按保活截止时间在读取时过期,房间在RoomTTL内没有写入时整体清理
+
+type memoryMember struct {
+	Member
+	deadline time.Time
+}
+
+type memoryRoom struct {
+	members  map[string]*memoryMember
+	streams  [2]map[string]Stream // 按StreamKind区分,key为uid/mid
+	bindings map[string]string
+	history  []string // 按时间倒序
+	expire   time.Time
+}
+
+// MemoryStore 内存存储
+type MemoryStore struct {
+	sync.Mutex
+	rooms  map[string]*memoryRoom
+	now    func() time.Time
+	lastGC time.Time
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rooms: make(map[string]*memoryRoom),
+		now:   time.Now,
+	}
+}
+
+func streamKey(uid, mid string) string {
+	return uid + "/" + mid
+}
+
+// room 获取房间,create为true时不存在则创建并刷新房间过期时间
+func (s *MemoryStore) room(rid string, create bool) *memoryRoom {
+	now := s.now()
+	if now.Sub(s.lastGC) > time.Minute {
+		s.lastGC = now
+		for id, r := range s.rooms {
+			if now.After(r.expire) {
+				delete(s.rooms, id)
+			}
+		}
+	}
+	r := s.rooms[rid]
+	if r != nil && now.After(r.expire) {
+		delete(s.rooms, rid)
+		r = nil
+	}
+	if r == nil {
+		if !create {
+			return nil
+		}
+		r = &memoryRoom{
+			members:  make(map[string]*memoryMember),
+			streams:  [2]map[string]Stream{make(map[string]Stream), make(map[string]Stream)},
+			bindings: make(map[string]string),
+		}
+		s.rooms[rid] = r
+	}
+	if create {
+		r.expire = now.Add(RoomTTL)
+	}
+	return r
+}
+
+// member 获取未过期的用户
+func (s *MemoryStore) member(r *memoryRoom, uid string) *memoryMember {
+	m := r.members[uid]
+	if m != nil && s.now().After(m.deadline) {
+		delete(r.members, uid)
+		return nil
+	}
+	return m
+}
+
+// Join 保存房间用户
+func (s *MemoryStore) Join(rid string, member Member, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, true)
+	created := s.empty(r)
+	r.members[member.UID] = &memoryMember{Member: member, deadline: s.now().Add(ttl)}
+	return created, nil
+}
+
+// Leave 删除房间用户
+func (s *MemoryStore) Leave(rid, uid string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, false)
+	if r == nil {
+		return false, nil
+	}
+	_, found := r.members[uid]
+	delete(r.members, uid)
+	return found && s.empty(r), nil
+}
+
+// empty 房间是否没有未过期的用户
+func (s *MemoryStore) empty(r *memoryRoom) bool {
+	for uid := range r.members {
+		if s.member(r, uid) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// KeepAlive 刷新房间用户的保活时间
+func (s *MemoryStore) KeepAlive(rid, uid string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, false)
+	if r == nil {
+		return nil
+	}
+	if m := s.member(r, uid); m != nil {
+		m.deadline = s.now().Add(ttl)
+		s.room(rid, true)
+	}
+	return nil
+}
+
+// GetMember 获取房间用户
+func (s *MemoryStore) GetMember(rid, uid string) (*Member, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, false)
+	if r == nil {
+		return nil, nil
+	}
+	m := s.member(r, uid)
+	if m == nil {
+		return nil, nil
+	}
+	member := m.Member
+	return &member, nil
+}
+
+// GetMembers 获取房间所有用户
+func (s *MemoryStore) GetMembers(rid string) ([]Member, error) {
+	s.Lock()
+	defer s.Unlock()
+	members := make([]Member, 0)
+	r := s.room(rid, false)
+	if r == nil {
+		return members, nil
+	}
+	for uid := range r.members {
+		if m := s.member(r, uid); m != nil {
+			members = append(members, m.Member)
+		}
+	}
+	return members, nil
+}
+
+// GetRooms 获取有用户的房间
+func (s *MemoryStore) GetRooms() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	rooms := make([]string, 0)
+	for rid := range s.rooms {
+		r := s.room(rid, false)
+		if r == nil {
+			continue
+		}
+		for uid := range r.members {
+			if s.member(r, uid) != nil {
+				rooms = append(rooms, rid)
+				break
+			}
+		}
+	}
+	return rooms, nil
+}
+
+// AddStream 保存流信息和流对应的节点
+func (s *MemoryStore) AddStream(kind StreamKind, rid string, stream Stream) error {
+	s.Lock()
+	defer s.Unlock()
+	s.room(rid, true).streams[kind][streamKey(stream.UID, stream.MID)] = stream
+	return nil
+}
+
+// RemoveStreams 删除流
+func (s *MemoryStore) RemoveStreams(kind StreamKind, rid, uid, mid string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	mids := make([]string, 0)
+	r := s.room(rid, false)
+	if r == nil {
+		return mids, nil
+	}
+	for key, stream := range r.streams[kind] {
+		if stream.UID == uid && (mid == "" || stream.MID == mid) {
+			delete(r.streams[kind], key)
+			mids = append(mids, stream.MID)
+		}
+	}
+	return mids, nil
+}
+
+// GetStream 获取流
+func (s *MemoryStore) GetStream(kind StreamKind, rid, uid, mid string) (*Stream, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, false)
+	if r == nil {
+		return nil, nil
+	}
+	stream, ok := r.streams[kind][streamKey(uid, mid)]
+	if !ok {
+		return nil, nil
+	}
+	return &stream, nil
+}
+
+// GetStreams 获取房间所有流
+func (s *MemoryStore) GetStreams(kind StreamKind, rid string) ([]Stream, error) {
+	s.Lock()
+	defer s.Unlock()
+	streams := make([]Stream, 0)
+	if r := s.room(rid, false); r != nil {
+		for _, stream := range r.streams[kind] {
+			streams = append(streams, stream)
+		}
+	}
+	return streams, nil
+}
+
+// HasStreams 房间是否还有流
+func (s *MemoryStore) HasStreams(kind StreamKind, rid string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, false)
+	return r != nil && len(r.streams[kind]) > 0, nil
+}
+
+// GetBinding 获取房间绑定的节点
+func (s *MemoryStore) GetBinding(kind, rid string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if r := s.room(rid, false); r != nil {
+		return r.bindings[kind], nil
+	}
+	return "", nil
+}
+
+// SetBinding 绑定房间节点
+func (s *MemoryStore) SetBinding(kind, rid, nid string, force bool) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, true)
+	if bound := r.bindings[kind]; bound != "" && !force {
+		return bound, nil
+	}
+	r.bindings[kind] = nid
+	return nid, nil
+}
+
+// ClearBinding 删除房间绑定的节点
+func (s *MemoryStore) ClearBinding(kind, rid string) error {
+	s.Lock()
+	defer s.Unlock()
+	if r := s.room(rid, false); r != nil {
+		delete(r.bindings, kind)
+	}
+	return nil
+}
+
+// RefreshBinding 刷新房间的保留时间,绑定关系随房间一起过期
+func (s *MemoryStore) RefreshBinding(kind, rid string) error {
+	s.Lock()
+	defer s.Unlock()
+	if r := s.room(rid, false); r != nil && r.bindings[kind] != "" {
+		s.room(rid, true)
+	}
+	return nil
+}
+
+// AddHistory 保存一条聊天记录
+func (s *MemoryStore) AddHistory(rid, record string, size int) error {
+	s.Lock()
+	defer s.Unlock()
+	r := s.room(rid, true)
+	r.history = append([]string{record}, r.history...)
+	if len(r.history) > size {
+		r.history = r.history[:size]
+	}
+	return nil
+}
+
+// GetHistory 分页获取聊天记录
+func (s *MemoryStore) GetHistory(rid string, offset, limit int64) (int64, []string, error) {
+	s.Lock()
+	defer s.Unlock()
+	records := make([]string, 0)
+	r := s.room(rid, false)
+	if r == nil {
+		return 0, records, nil
+	}
+	total := int64(len(r.history))
+	for i := offset; i < total && i < offset+limit; i++ {
+		records = append(records, r.history[i])
+	}
+	return total, records, nil
+}
+
+// Close 关闭存储
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMemoryMembers(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	if created, _ := s.Join("room1", Member{UID: "a", NID: "biz1", Info: "{}"}, time.Minute); !created {
+		t.Errorf("first join should create room")
+	}
+	if created, _ := s.Join("room1", Member{UID: "b", NID: "biz2"}, time.Minute); created {
+		t.Errorf("second join should not create room")
+	}
+	if m, _ := s.GetMember("room1", "a"); m == nil || m.NID != "biz1" {
+		t.Fatalf("member a = %v", m)
+	}
+
+	now = now.Add(40 * time.Second)
+	s.KeepAlive("room1", "a", time.Minute)
+	s.KeepAlive("room1", "c", time.Minute)
+	now = now.Add(30 * time.Second)
+	members, _ := s.GetMembers("room1")
+	if len(members) != 1 || members[0].UID != "a" {
+		t.Errorf("members after expiry = %v", members)
+	}
+	if m, _ := s.GetMember("room1", "b"); m != nil {
+		t.Errorf("expired member b = %v", m)
+	}
+
+	if rooms, _ := s.GetRooms(); len(rooms) != 1 || rooms[0] != "room1" {
+		t.Errorf("rooms = %v", rooms)
+	}
+
+	if removed, _ := s.Leave("room1", "c"); removed {
+		t.Errorf("leave of unknown member removed room")
+	}
+	if removed, _ := s.Leave("room1", "a"); !removed {
+		t.Errorf("last leave should remove room")
+	}
+	if m, _ := s.GetMember("room1", "a"); m != nil {
+		t.Errorf("left member a = %v", m)
+	}
+	if rooms, _ := s.GetRooms(); len(rooms) != 0 {
+		t.Errorf("rooms after leave = %v", rooms)
+	}
+}
+
+func TestMemoryStreams(t *testing.T) {
+	s := NewMemoryStore()
+	s.AddStream(Media, "room1", Stream{UID: "a", MID: "a#1", NID: "sfu1"})
+	s.AddStream(Media, "room1", Stream{UID: "a", MID: "a#2", NID: "sfu1"})
+	s.AddStream(Media, "room1", Stream{UID: "ab", MID: "ab#1", NID: "sfu2"})
+	s.AddStream(Live, "room1", Stream{UID: "a", MID: "a#3", NID: "mcu1"})
+
+	if st, _ := s.GetStream(Media, "room1", "a", "a#2"); st == nil || st.NID != "sfu1" {
+		t.Errorf("stream a#2 = %v", st)
+	}
+	mids, _ := s.RemoveStreams(Media, "room1", "a", "")
+	sort.Strings(mids)
+	if len(mids) != 2 || mids[0] != "a#1" || mids[1] != "a#2" {
+		t.Errorf("removed = %v", mids)
+	}
+	if has, _ := s.HasStreams(Media, "room1"); !has {
+		t.Error("stream ab#1 should remain")
+	}
+	if lives, _ := s.GetStreams(Live, "room1"); len(lives) != 1 {
+		t.Errorf("lives = %v", lives)
+	}
+	if mids, _ := s.RemoveStreams(Media, "room1", "ab", "ab#1"); len(mids) != 1 {
+		t.Errorf("removed = %v", mids)
+	}
+	if has, _ := s.HasStreams(Media, "room1"); has {
+		t.Error("room should have no streams")
+	}
+}
+
+func TestMemoryBindingAndHistory(t *testing.T) {
+	s := NewMemoryStore()
+	if nid, _ := s.SetBinding(BindSfu, "room1", "sfu1", false); nid != "sfu1" {
+		t.Errorf("bind = %s", nid)
+	}
+	if nid, _ := s.SetBinding(BindSfu, "room1", "sfu2", false); nid != "sfu1" {
+		t.Errorf("bind without force = %s", nid)
+	}
+	if nid, _ := s.SetBinding(BindSfu, "room1", "sfu2", true); nid != "sfu2" {
+		t.Errorf("bind with force = %s", nid)
+	}
+	s.ClearBinding(BindSfu, "room1")
+	if nid, _ := s.GetBinding(BindSfu, "room1"); nid != "" {
+		t.Errorf("cleared bind = %s", nid)
+	}
+
+	for _, r := range []string{"1", "2", "3", "4"} {
+		s.AddHistory("room1", r, 3)
+	}
+	total, records, _ := s.GetHistory("room1", 1, 5)
+	if total != 3 || len(records) != 2 || records[0] != "3" || records[1] != "2" {
+		t.Errorf("history = %d %v", total, records)
+	}
+}
+
+func TestMemoryRoomExpire(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	s.AddStream(Media, "room1", Stream{UID: "a", MID: "a#1"})
+	now = now.Add(RoomTTL + time.Second)
+	if has, _ := s.HasStreams(Media, "room1"); has {
+		t.Error("room should expire")
+	}
+
+	// 绑定关系随保活续期
+	s.SetBinding(BindApp, "room2", "app1", false)
+	now = now.Add(RoomTTL - time.Second)
+	s.RefreshBinding(BindApp, "room2")
+	now = now.Add(RoomTTL - time.Second)
+	if appid, _ := s.GetBinding(BindApp, "room2"); appid != "app1" {
+		t.Errorf("refreshed binding = %s", appid)
+	}
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	db "signal/infra/redis"
+	"signal/pkg/proto"
+)
+
+// redis存储
+// 房间数据按房间存放在hash和zset中,所有写操作都在Lua脚本中原子执行
+// members/users保存房间用户,alive保存用户保活截止时间,过期用户在读取时清理
+// pubs/media保存实时流,lives/livemedia保存直播流
+
+var (
+	// KEYS: members users alive; ARGV: uid nid info deadline ttl now
+	// 返回1表示加入前房间没有未过期的用户
+	joinScript = db.NewScript(`
+local created = redis.call('ZCOUNT', KEYS[3], ARGV[6], '+inf') == 0
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+for i = 1, 3 do
+	redis.call('EXPIRE', KEYS[i], ARGV[5])
+end
+if created then
+	return 1
+end
+return 0
+`)
+
+	// KEYS: members users alive; ARGV: uid now
+	// 返回1表示删除了最后一个未过期的用户
+	leaveScript = db.NewScript(`
+local n = redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+if n == 1 and redis.call('ZCOUNT', KEYS[3], ARGV[2], '+inf') == 0 then
+	return 1
+end
+return 0
+`)
+
+	// KEYS: members users alive; ARGV: uid deadline ttl
+	keepaliveScript = db.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+for i = 1, 3 do
+	redis.call('EXPIRE', KEYS[i], ARGV[3])
+end
+return 1
+`)
+
+	// KEYS: members users alive; ARGV: uid now
+	memberScript = db.NewScript(`
+local deadline = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if not deadline or tonumber(deadline) < tonumber(ARGV[2]) then
+	return false
+end
+local nid = redis.call('HGET', KEYS[1], ARGV[1])
+if not nid then
+	return false
+end
+return {nid, redis.call('HGET', KEYS[2], ARGV[1]) or ''}
+`)
+
+	// KEYS: members users alive; ARGV: now
+	// 先清理保活过期的用户,再返回 uid nid info 三元组列表
+	membersScript = db.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', '(' .. ARGV[1])
+for _, uid in ipairs(expired) do
+	redis.call('HDEL', KEYS[1], uid)
+	redis.call('HDEL', KEYS[2], uid)
+	redis.call('ZREM', KEYS[3], uid)
+end
+local result = {}
+local members = redis.call('HGETALL', KEYS[1])
+for i = 1, #members, 2 do
+	table.insert(result, members[i])
+	table.insert(result, members[i + 1])
+	table.insert(result, redis.call('HGET', KEYS[2], members[i]) or '')
+end
+return result
+`)
+
+	// KEYS: rooms; ARGV: rid deadline ttl
+	// 房间索引,保存房间最晚的用户保活截止时间
+	// 房间key带有{rid}可能不在同一个slot,索引单独更新
+	roomIndexScript = db.NewScript(`
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+	// KEYS: rooms; ARGV: now
+	roomsScript = db.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+return redis.call('ZRANGE', KEYS[1], 0, -1)
+`)
+
+	// KEYS: pubs media; ARGV: field nid minfo ttl
+	streamAddScript = db.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[4])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+return 1
+`)
+
+	// KEYS: pubs media; ARGV: field prefix
+	// field不为空时删除指定流,否则删除prefix开头的所有流,返回删除的发布记录
+	streamRemoveScript = db.NewScript(`
+local removed = {}
+if ARGV[1] ~= '' then
+	if redis.call('HDEL', KEYS[1], ARGV[1]) == 1 then
+		table.insert(removed, ARGV[1])
+	end
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	return removed
+end
+for _, field in ipairs(redis.call('HKEYS', KEYS[1])) do
+	if string.sub(field, 1, #ARGV[2]) == ARGV[2] then
+		redis.call('HDEL', KEYS[1], field)
+		table.insert(removed, field)
+	end
+end
+for _, field in ipairs(redis.call('HKEYS', KEYS[2])) do
+	if string.sub(field, 1, #ARGV[2]) == ARGV[2] then
+		redis.call('HDEL', KEYS[2], field)
+	end
+end
+return removed
+`)
+
+	// KEYS: history; ARGV: record size ttl
+	// 新记录在列表头部,只保留最近size条
+	historyScript = db.NewScript(`
+redis.call('LPUSH', KEYS[1], ARGV[1])
+redis.call('LTRIM', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+)
+
+// RedisStore redis存储
+type RedisStore struct {
+	redis *db.Redis
+}
+
+// NewRedisStore 创建redis存储
+func NewRedisStore(r *db.Redis) *RedisStore {
+	return &RedisStore{redis: r}
+}
+
+func memberKeys(rid string) []string {
+	return []string{proto.GetRoomKey(rid, proto.RoomMembers), proto.GetRoomKey(rid, proto.RoomUsers), proto.GetRoomKey(rid, proto.RoomAlive)}
+}
+
+// streamKeys 流索引,实时流和直播流使用不同的hash
+func streamKeys(kind StreamKind, rid string) []string {
+	if kind == Live {
+		return []string{proto.GetRoomKey(rid, proto.RoomLives), proto.GetRoomKey(rid, proto.RoomLiveMedia)}
+	}
+	return []string{proto.GetRoomKey(rid, proto.RoomPubs), proto.GetRoomKey(rid, proto.RoomMedia)}
+}
+
+func bindingKey(kind, rid string) string {
+	switch kind {
+	case BindMcu:
+		return proto.GetMcuInfoKey(rid)
+	case BindApp:
+		return proto.GetRoomKey(rid, proto.RoomApp)
+	}
+	return proto.GetRoomSfuKey(rid)
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+func strs(val interface{}) []string {
+	arr, _ := val.([]interface{})
+	res := make([]string, 0, len(arr))
+	for _, v := range arr {
+		res = append(res, fmt.Sprint(v))
+	}
+	return res
+}
+
+// Join 保存房间用户
+func (s *RedisStore) Join(rid string, member Member, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	deadline := now.Add(ttl).Unix()
+	val, err := s.redis.Run(joinScript, memberKeys(rid), member.UID, member.NID, member.Info, deadline, seconds(RoomTTL), now.Unix())
+	if err != nil {
+		return false, err
+	}
+	_, err = s.redis.Run(roomIndexScript, []string{proto.GetRoomIndexKey()}, rid, deadline, seconds(RoomTTL))
+	return fmt.Sprint(val) == "1", err
+}
+
+// Leave 删除房间用户
+func (s *RedisStore) Leave(rid, uid string) (bool, error) {
+	val, err := s.redis.Run(leaveScript, memberKeys(rid), uid, time.Now().Unix())
+	return fmt.Sprint(val) == "1", err
+}
+
+// KeepAlive 刷新房间用户的保活时间
+func (s *RedisStore) KeepAlive(rid, uid string, ttl time.Duration) error {
+	deadline := time.Now().Add(ttl).Unix()
+	val, err := s.redis.Run(keepaliveScript, memberKeys(rid), uid, deadline, seconds(RoomTTL))
+	if err != nil || fmt.Sprint(val) != "1" {
+		return err
+	}
+	_, err = s.redis.Run(roomIndexScript, []string{proto.GetRoomIndexKey()}, rid, deadline, seconds(RoomTTL))
+	return err
+}
+
+// GetMember 获取房间用户
+func (s *RedisStore) GetMember(rid, uid string) (*Member, error) {
+	val, err := s.redis.Run(memberScript, memberKeys(rid), uid, time.Now().Unix())
+	if err != nil || val == nil {
+		return nil, err
+	}
+	arr := strs(val)
+	if len(arr) < 2 {
+		return nil, nil
+	}
+	return &Member{UID: uid, NID: arr[0], Info: arr[1]}, nil
+}
+
+// GetMembers 获取房间所有用户,同时清理保活过期的用户
+func (s *RedisStore) GetMembers(rid string) ([]Member, error) {
+	val, err := s.redis.Run(membersScript, memberKeys(rid), time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	arr := strs(val)
+	members := make([]Member, 0, len(arr)/3)
+	for i := 0; i+2 < len(arr); i += 3 {
+		members = append(members, Member{UID: arr[i], NID: arr[i+1], Info: arr[i+2]})
+	}
+	return members, nil
+}
+
+// GetRooms 获取有用户的房间,房间内所有用户保活过期后从索引中删除
+func (s *RedisStore) GetRooms() ([]string, error) {
+	val, err := s.redis.Run(roomsScript, []string{proto.GetRoomIndexKey()}, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	return strs(val), nil
+}
+
+// AddStream 保存流信息和流对应的节点
+func (s *RedisStore) AddStream(kind StreamKind, rid string, stream Stream) error {
+	field := proto.GetStreamField(stream.UID, stream.MID)
+	_, err := s.redis.Run(streamAddScript, streamKeys(kind, rid), field, stream.NID, stream.MInfo, seconds(RoomTTL))
+	return err
+}
+
+// RemoveStreams 删除流
+func (s *RedisStore) RemoveStreams(kind StreamKind, rid, uid, mid string) ([]string, error) {
+	field := ""
+	if mid != "" {
+		field = proto.GetStreamField(uid, mid)
+	}
+	val, err := s.redis.Run(streamRemoveScript, streamKeys(kind, rid), field, proto.GetStreamField(uid, ""))
+	if err != nil {
+		return nil, err
+	}
+	fields := strs(val)
+	mids := make([]string, 0, len(fields))
+	for _, f := range fields {
+		_, mid := proto.ParseStreamField(f)
+		mids = append(mids, mid)
+	}
+	return mids, nil
+}
+
+// GetStream 获取流
+func (s *RedisStore) GetStream(kind StreamKind, rid, uid, mid string) (*Stream, error) {
+	keys := streamKeys(kind, rid)
+	field := proto.GetStreamField(uid, mid)
+	nid := s.redis.HGet(keys[0], field)
+	minfo := s.redis.HGet(keys[1], field)
+	if nid == "" && minfo == "" {
+		return nil, nil
+	}
+	return &Stream{UID: uid, MID: mid, NID: nid, MInfo: minfo}, nil
+}
+
+// GetStreams 获取房间所有流
+func (s *RedisStore) GetStreams(kind StreamKind, rid string) ([]Stream, error) {
+	keys := streamKeys(kind, rid)
+	pubs := s.redis.HGetAll(keys[0])
+	media := s.redis.HGetAll(keys[1])
+	streams := make([]Stream, 0, len(pubs))
+	for field, nid := range pubs {
+		uid, mid := proto.ParseStreamField(field)
+		streams = append(streams, Stream{UID: uid, MID: mid, NID: nid, MInfo: media[field]})
+	}
+	return streams, nil
+}
+
+// HasStreams 房间是否还有流
+func (s *RedisStore) HasStreams(kind StreamKind, rid string) (bool, error) {
+	return s.redis.HLen(streamKeys(kind, rid)[0]) > 0, nil
+}
+
+// GetBinding 获取房间绑定的节点
+func (s *RedisStore) GetBinding(kind, rid string) (string, error) {
+	return s.redis.Get(bindingKey(kind, rid)), nil
+}
+
+// SetBinding 绑定房间节点
+func (s *RedisStore) SetBinding(kind, rid, nid string, force bool) (string, error) {
+	key := bindingKey(kind, rid)
+	if force {
+		return nid, s.redis.Set(key, nid, RoomTTL)
+	}
+	if !s.redis.SetNx(key, nid, RoomTTL) {
+		if bound := s.redis.Get(key); bound != "" {
+			return bound, nil
+		}
+	}
+	return nid, nil
+}
+
+// ClearBinding 删除房间绑定的节点
+func (s *RedisStore) ClearBinding(kind, rid string) error {
+	return s.redis.Del(bindingKey(kind, rid))
+}
+
+// RefreshBinding 刷新房间绑定的保留时间
+func (s *RedisStore) RefreshBinding(kind, rid string) error {
+	return s.redis.Expire(bindingKey(kind, rid), RoomTTL)
+}
+
+// AddHistory 保存一条聊天记录
+func (s *RedisStore) AddHistory(rid, record string, size int) error {
+	_, err := s.redis.Run(historyScript, []string{proto.GetChatHistoryKey(rid)}, record, size, seconds(RoomTTL))
+	return err
+}
+
+// GetHistory 分页获取聊天记录
+func (s *RedisStore) GetHistory(rid string, offset, limit int64) (int64, []string, error) {
+	key := proto.GetChatHistoryKey(rid)
+	return s.redis.LLen(key), s.redis.LRange(key, offset, offset+limit-1), nil
+}
+
+// Close 关闭存储
+func (s *RedisStore) Close() error {
+	return s.redis.Close()
+}
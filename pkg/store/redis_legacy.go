@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"signal/pkg/proto"
+)
+
+const (
+	legacyLockKey   = "/room/legacy/lock"
+	legacyLockTTL   = 10 * time.Minute
+	legacyScanCount = 500
+	legacyMemberTTL = 60 * time.Second
+)
+
+// 旧版本按key存储的房间数据,先迁移用户和发布记录,剩下的user/media是没有对应记录的残留数据
+var legacyKinds = []string{"node", "pub", "livepub", "user", "media", "livemedia"}
+
+// MigrateLegacy 把旧版本按key存储的房间数据迁移到房间索引,返回迁移的key数量
+// 每个islb启动时执行,滚动升级期间旧版本islb写入的数据也会被后启动的islb迁移
+// 其他islb正在迁移时直接返回
+func (s *RedisStore) MigrateLegacy(owner string) (int, error) {
+	if !s.redis.SetNx(legacyLockKey, owner, legacyLockTTL) {
+		return 0, nil
+	}
+	defer s.redis.Del(legacyLockKey)
+
+	total := 0
+	for _, kind := range legacyKinds {
+		err := s.redis.Scan("/"+kind+"/rid/*", legacyScanCount, func(keys []string) error {
+			for _, key := range keys {
+				ok, err := s.migrateLegacyKey(key)
+				if err != nil {
+					return fmt.Errorf("migrate %s: %v", key, err)
+				}
+				if ok {
+					total++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// migrateLegacyKey 迁移一个旧版本key,迁移完成后删除
+func (s *RedisStore) migrateLegacyKey(key string) (bool, error) {
+	kind, rid, uid, mid, ok := proto.ParseMediaKey(key)
+	if !ok {
+		return false, nil
+	}
+	var err error
+	switch kind {
+	case "node":
+		nid := s.redis.Get(key)
+		if nid == "" {
+			return false, nil
+		}
+		// 迁移的用户按刚加入处理,biz会继续保活
+		userKey := legacyKey("user", rid, uid, "")
+		member := Member{UID: uid, NID: nid, Info: s.redis.Get(userKey)}
+		if _, err = s.Join(rid, member, legacyMemberTTL); err == nil {
+			s.redis.Del(userKey)
+		}
+	case "user":
+		// 没有对应node的用户已经过期
+	case "pub", "livepub":
+		if mid == "" {
+			return false, nil
+		}
+		streamKind, mediaKind := Media, "media"
+		if kind == "livepub" {
+			streamKind, mediaKind = Live, "livemedia"
+		}
+		nid := s.redis.Get(key)
+		if nid == "" {
+			return false, nil
+		}
+		mediaKey := legacyKey(mediaKind, rid, uid, mid)
+		stream := Stream{UID: uid, MID: mid, NID: nid, MInfo: s.redis.Get(mediaKey)}
+		if err = s.AddStream(streamKind, rid, stream); err == nil {
+			s.redis.Del(mediaKey)
+		}
+	case "media", "livemedia":
+		if mid == "" {
+			return false, nil
+		}
+		streamKind := Media
+		if kind == "livemedia" {
+			streamKind = Live
+		}
+		if minfo := s.redis.Get(key); minfo != "" {
+			err = s.redis.HSet(streamKeys(streamKind, rid)[1], proto.GetStreamField(uid, mid), minfo)
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.redis.Del(key)
+}
+
+// legacyKey 旧版本的key
+func legacyKey(kind, rid, uid, mid string) string {
+	key := "/" + kind + "/rid/" + rid + "/uid/" + uid
+	if mid != "" {
+		key += "/mid/" + mid
+	}
+	return key
+}
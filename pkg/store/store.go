@@ -0,0 +1,124 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	dis "signal/infra/discovery"
+	db "signal/infra/redis"
+)
+
+// StreamKind 流类型
+type StreamKind int
+
+const (
+	// Media 实时流,对应sfu
+	Media StreamKind = iota
+	// Live 直播流,对应mcu
+	Live
+)
+
+// 房间绑定的节点类型
+const (
+	BindSfu = "sfu"
+	BindMcu = "mcu"
+	// BindApp 房间所属的appid,用于webhook
+	BindApp = "app"
+)
+
+// 存储后端
+const (
+	BackendRedis  = "redis"
+	BackendEtcd   = "etcd"
+	BackendMemory = "memory"
+)
+
+// RoomTTL 房间流,绑定关系和聊天记录的保留时间,每次写入时刷新
+const RoomTTL = 24 * time.Hour
+
+// Member 房间用户
+type Member struct {
+	UID  string `json:"uid"`
+	NID  string `json:"nid"`
+	Info string `json:"info"`
+}
+
+// Stream 房间流
+type Stream struct {
+	UID   string `json:"uid"`
+	MID   string `json:"mid"`
+	NID   string `json:"nid"`
+	MInfo string `json:"minfo"`
+}
+
+// RoomStore islb房间状态存储
+type RoomStore interface {
+	// Join 保存房间用户,ttl内未保活视为已离开,返回加入前房间是否没有用户
+	Join(rid string, member Member, ttl time.Duration) (bool, error)
+	// Leave 删除房间用户,返回是否删除了房间最后一个用户
+	Leave(rid, uid string) (bool, error)
+	// KeepAlive 刷新房间用户的保活时间,用户不存在时忽略
+	KeepAlive(rid, uid string, ttl time.Duration) error
+	// GetMember 获取房间用户,不存在或保活过期时返回nil
+	GetMember(rid, uid string) (*Member, error)
+	// GetMembers 获取房间所有用户
+	GetMembers(rid string) ([]Member, error)
+	// GetRooms 获取有用户的房间,用于管理接口
+	GetRooms() ([]string, error)
+
+	// AddStream 保存流信息和流对应的节点,已存在时覆盖
+	AddStream(kind StreamKind, rid string, stream Stream) error
+	// RemoveStreams 删除流,mid为空时删除用户的所有流,返回被删除的mid
+	RemoveStreams(kind StreamKind, rid, uid, mid string) ([]string, error)
+	// GetStream 获取流,不存在时返回nil
+	GetStream(kind StreamKind, rid, uid, mid string) (*Stream, error)
+	// GetStreams 获取房间所有流
+	GetStreams(kind StreamKind, rid string) ([]Stream, error)
+	// HasStreams 房间是否还有流
+	HasStreams(kind StreamKind, rid string) (bool, error)
+
+	// GetBinding 获取房间绑定的节点,未绑定时返回空
+	GetBinding(kind, rid string) (string, error)
+	// SetBinding 绑定房间节点,force为false时房间已绑定则返回已绑定的节点
+	SetBinding(kind, rid, nid string, force bool) (string, error)
+	// ClearBinding 删除房间绑定的节点
+	ClearBinding(kind, rid string) error
+	// RefreshBinding 刷新房间绑定的保留时间,未绑定时忽略
+	RefreshBinding(kind, rid string) error
+
+	// AddHistory 保存一条聊天记录,只保留最近size条
+	AddHistory(rid, record string, size int) error
+	// GetHistory 按时间倒序分页获取聊天记录,返回总条数
+	GetHistory(rid string, offset, limit int64) (int64, []string, error)
+
+	// Close 关闭存储
+	Close() error
+}
+
+// Config 存储配置
+type Config struct {
+	Backend string
+	Redis   db.Config
+	Etcd    []string
+}
+
+// New 根据配置创建存储,backend为空时使用redis
+func New(c Config) (RoomStore, error) {
+	switch c.Backend {
+	case "", BackendRedis:
+		r := db.NewRedis(c.Redis)
+		if r == nil {
+			return nil, errors.New("store: redis connect failed")
+		}
+		return NewRedisStore(r), nil
+	case BackendEtcd:
+		e, err := dis.NewEtcd(c.Etcd)
+		if err != nil {
+			return nil, err
+		}
+		return NewEtcdStore(e), nil
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, errors.New("store: unknown backend " + c.Backend)
+}
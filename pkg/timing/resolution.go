@@ -1,41 +1,227 @@
 package timing
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-func GetPixelsByResolution(resolution string) int64 {
-	switch strings.ToLower(resolution) {
-	case "240":
-		return 57600
-	case "360":
-		return 129600
-	case "480p":
-		return 407040
-	case "720p":
-		return 921600
-	case "1080p":
-		return 2073600
-	case "2k":
-		return 3686400
-	case "4k":
-		return 8847360
+// 视频分辨率按像素数划分计费档位,分辨率名称、档位边界和聚合规则可以按appid配置
+// 客户端上报的分辨率先按名称查像素数,没有配置的名称按 宽x高 解析
+
+// 档位变化时的聚合规则
+const (
+	AggregateSplit = "split" // 档位变化时结束当前区间,每段按各自的档位计费
+	AggregateMax   = "max"   // 区间内按出现过的最高档位计费,到达周期时按当前档位开始新区间
+)
+
+// ErrUnknownResolution 分辨率没有配置且不是 宽x高 格式,或者超过了最高档位
+var ErrUnknownResolution = errors.New("unknown resolution")
+
+// Level 计费档位,max为档位包含的最大像素数
+type Level struct {
+	Name string `mapstructure:"name"`
+	Max  int64  `mapstructure:"max"`
+}
+
+// TierConfig 分辨率档位配置,apps按appid覆盖,没有设置的字段使用默认值
+type TierConfig struct {
+	Resolutions map[string]string `mapstructure:"resolutions"` // 分辨率名称 -> 宽x高或像素数
+	Levels      []Level           `mapstructure:"levels"`      // 按max从小到大
+	Aggregate   string            `mapstructure:"aggregate"`   // split或max,默认split
+	Apps        []AppTierConfig   `mapstructure:"apps"`
+}
+
+// AppTierConfig 应用的档位配置,没有设置的字段使用TierConfig中的配置
+type AppTierConfig struct {
+	AppID       string            `mapstructure:"appid"`
+	Resolutions map[string]string `mapstructure:"resolutions"`
+	Levels      []Level           `mapstructure:"levels"`
+	Aggregate   string            `mapstructure:"aggregate"`
+}
+
+// DefaultTierConfig 默认的分辨率和档位
+func DefaultTierConfig() TierConfig {
+	return TierConfig{
+		Resolutions: map[string]string{
+			"240":   "320x180",
+			"360":   "480x270",
+			"480p":  "848x480",
+			"720p":  "1280x720",
+			"1080p": "1920x1080",
+			"2k":    "2560x1440",
+			"4k":    "4096x2160",
+		},
+		Levels: []Level{
+			{Name: "SD", Max: 407040},
+			{Name: "HD", Max: 921600},
+			{Name: "FHD", Max: 2073600},
+			{Name: "2K", Max: 3686400},
+			{Name: "2KP", Max: 8847360},
+		},
+		Aggregate: AggregateSplit,
+	}
+}
+
+// tierSet 一个应用的档位
+type tierSet struct {
+	pixels    map[string]int64
+	levels    []Level
+	aggregate string
+}
+
+// Tiers 按配置把分辨率转换为计费档位,创建后只读,可以并发使用
+type Tiers struct {
+	def  *tierSet
+	apps map[string]*tierSet
+}
+
+// NewTiers 检查配置并创建Tiers,配置为空时使用默认值
+func NewTiers(c TierConfig) (*Tiers, error) {
+	def, err := newTierSet(DefaultTierConfig(), c)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tiers{def: def, apps: make(map[string]*tierSet)}
+	for _, app := range c.Apps {
+		if app.AppID == "" || t.apps[app.AppID] != nil {
+			return nil, fmt.Errorf("app %q: appid is empty or duplicated", app.AppID)
+		}
+		set, err := newTierSet(c, TierConfig{Resolutions: app.Resolutions, Levels: app.Levels, Aggregate: app.Aggregate})
+		if err != nil {
+			return nil, fmt.Errorf("app %s: %v", app.AppID, err)
+		}
+		t.apps[app.AppID] = set
+	}
+	return t, nil
+}
+
+// newTierSet 用c覆盖base,resolutions在默认值上按名称合并,levels整体替换
+func newTierSet(base, c TierConfig) (*tierSet, error) {
+	set := &tierSet{pixels: make(map[string]int64), levels: base.Levels, aggregate: base.Aggregate}
+	for _, resolutions := range []map[string]string{DefaultTierConfig().Resolutions, base.Resolutions, c.Resolutions} {
+		for name, value := range resolutions {
+			pixels, err := parsePixels(value)
+			if err != nil {
+				return nil, fmt.Errorf("resolution %s: %v", name, err)
+			}
+			set.pixels[strings.ToLower(name)] = pixels
+		}
+	}
+	if len(c.Levels) > 0 {
+		set.levels = c.Levels
+	}
+	if len(set.levels) == 0 {
+		set.levels = DefaultTierConfig().Levels
+	}
+	if !sort.SliceIsSorted(set.levels, func(i, j int) bool { return set.levels[i].Max < set.levels[j].Max }) {
+		return nil, errors.New("levels should be sorted by max")
+	}
+	names := make(map[string]bool)
+	for i, level := range set.levels {
+		if level.Name == "" || level.Max <= 0 || names[level.Name] || (i > 0 && level.Max == set.levels[i-1].Max) {
+			return nil, fmt.Errorf("invalid level %s max=%d", level.Name, level.Max)
+		}
+		names[level.Name] = true
+	}
+	if c.Aggregate != "" {
+		set.aggregate = c.Aggregate
+	}
+	switch set.aggregate {
+	case "":
+		set.aggregate = AggregateSplit
+	case AggregateSplit, AggregateMax:
 	default:
-		return 0
+		return nil, fmt.Errorf("invalid aggregate %s", set.aggregate)
+	}
+	return set, nil
+}
+
+// parsePixels 解析 宽x高 或像素数
+func parsePixels(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if i := strings.IndexByte(value, 'x'); i > 0 {
+		width, err1 := strconv.ParseInt(value[:i], 10, 64)
+		height, err2 := strconv.ParseInt(value[i+1:], 10, 64)
+		if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+			return 0, ErrUnknownResolution
+		}
+		return width * height, nil
+	}
+	pixels, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || pixels <= 0 {
+		return 0, ErrUnknownResolution
+	}
+	return pixels, nil
+}
+
+func (t *Tiers) set(appid string) *tierSet {
+	if set, ok := t.apps[appid]; ok {
+		return set
+	}
+	return t.def
+}
+
+// Pixels 分辨率的像素数,没有配置的名称按 宽x高 解析
+func (t *Tiers) Pixels(appid, resolution string) (int64, error) {
+	name := strings.ToLower(strings.TrimSpace(resolution))
+	if pixels, ok := t.set(appid).pixels[name]; ok {
+		return pixels, nil
+	}
+	if !strings.ContainsRune(name, 'x') {
+		return 0, ErrUnknownResolution
+	}
+	return parsePixels(name)
+}
+
+// Tier 分辨率对应的档位
+func (t *Tiers) Tier(appid, resolution string) (string, error) {
+	pixels, err := t.Pixels(appid, resolution)
+	if err != nil {
+		return "", err
 	}
+	for _, level := range t.set(appid).levels {
+		if pixels <= level.Max {
+			return level.Name, nil
+		}
+	}
+	return "", ErrUnknownResolution
 }
 
+// Rank 档位从低到高的序号,不是档位时返回-1
+func (t *Tiers) Rank(appid, tier string) int {
+	for i, level := range t.set(appid).levels {
+		if level.Name == tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// Aggregate 档位变化时的聚合规则
+func (t *Tiers) Aggregate(appid string) string {
+	return t.set(appid).aggregate
+}
+
+var defaultTiers, _ = NewTiers(TierConfig{})
+
+// GetPixelsByResolution 按默认配置获取分辨率的像素数,未知分辨率返回0
+func GetPixelsByResolution(resolution string) int64 {
+	pixels, _ := defaultTiers.Pixels("", resolution)
+	return pixels
+}
+
+// TransformResolutionFromPixels 按默认档位转换像素数,超出范围时返回空
 func TransformResolutionFromPixels(pixels int64) string {
-	if pixels > 0 && pixels <= 407040 {
-		return "SD"
-	} else if pixels > 407040 && pixels <= 921600 {
-		return "HD"
-	} else if pixels > 921600 && pixels <= 2073600 {
-		return "FHD"
-	} else if pixels > 2073600 && pixels <= 3686400 {
-		return "2K"
-	} else if pixels > 3686400 && pixels <= 8847360 {
-		return "2KP"
+	if pixels <= 0 {
+		return ""
+	}
+	for _, level := range defaultTiers.def.levels {
+		if pixels <= level.Max {
+			return level.Name
+		}
 	}
 	return ""
 }
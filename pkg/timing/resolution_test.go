@@ -13,3 +13,47 @@ func TestGetPixelsByResolution(t *testing.T) {
 	t.Log(GetPixelsByResolution("2k"))
 	t.Log(GetPixelsByResolution("4k"))
 }
+
+func TestTiers(t *testing.T) {
+	tiers, err := NewTiers(TierConfig{
+		Resolutions: map[string]string{"540p": "960x540"},
+		Apps: []AppTierConfig{
+			{AppID: "a", Levels: []Level{{Name: "LOW", Max: 600000}, {Name: "HIGH", Max: 3000000}}, Aggregate: AggregateMax},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		appid, resolution, tier string
+	}{
+		{"", "720P", "HD"},
+		{"", "540p", "HD"},
+		{"", "640x360", "SD"},
+		{"a", "720p", "HIGH"},
+		{"a", "540p", "LOW"},
+	} {
+		if tier, err := tiers.Tier(c.appid, c.resolution); err != nil || tier != c.tier {
+			t.Errorf("tier(%s, %s) = %s, %v", c.appid, c.resolution, tier, err)
+		}
+	}
+	for _, resolution := range []string{"", "8k", "7680x4320"} {
+		if _, err := tiers.Tier("", resolution); err != ErrUnknownResolution {
+			t.Errorf("tier(%s) err = %v", resolution, err)
+		}
+	}
+	if tiers.Aggregate("a") != AggregateMax || tiers.Aggregate("b") != AggregateSplit || tiers.Rank("a", "HIGH") != 1 {
+		t.Error("app config not applied")
+	}
+
+	for _, c := range []TierConfig{
+		{Resolutions: map[string]string{"bad": "1280*720"}},
+		{Levels: []Level{{Name: "HD", Max: 921600}, {Name: "SD", Max: 407040}}},
+		{Aggregate: "sum"},
+		{Apps: []AppTierConfig{{AppID: "a"}, {AppID: "a"}}},
+	} {
+		if _, err := NewTiers(c); err == nil {
+			t.Errorf("invalid config %+v accepted", c)
+		}
+	}
+}
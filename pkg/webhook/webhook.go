@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	h "signal/infra/http"
+	db "signal/infra/redis"
+	"signal/pkg/log"
+	"signal/pkg/proto"
+	"signal/util"
+)
+
+// 把islb广播的房间和流事件推送给应用服务端
+// 请求体为proto.WebhookEvent,按infra/http.Sign签名,应用服务端返回2xx表示接收成功
+// 推送失败时按backoff重试,仍失败时放入失败队列,由RetryFailures定时重传
+
+// Hook 应用的webhook地址,events为空时推送所有事件
+type Hook struct {
+	AppID  string   `mapstructure:"appid"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"`
+	Events []string `mapstructure:"events"`
+}
+
+// Config webhook配置
+type Config struct {
+	Timeout int    `mapstructure:"timeout"` // 单次请求超时,秒
+	Retries int    `mapstructure:"retries"` // 失败后立即重试的次数,仍失败时放入失败队列
+	Backoff int    `mapstructure:"backoff"` // 第一次重试前等待的时间,毫秒,之后每次翻倍
+	Hooks   []Hook `mapstructure:"hooks"`
+}
+
+const (
+	defaultTimeout = 5
+	defaultBackoff = 1000
+	// HeaderEvent 事件类型请求头
+	HeaderEvent = "X-Signal-Event"
+	// FailureTTL 失败队列中的事件超过该时间后丢弃
+	FailureTTL = 24 * time.Hour
+)
+
+// failure 失败队列中的事件,url用于找到推送失败的地址
+type failure struct {
+	URL   string             `json:"url"`
+	Event proto.WebhookEvent `json:"event"`
+}
+
+// Dispatcher webhook推送
+type Dispatcher struct {
+	hooks   map[string][]Hook
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	kv      db.KV
+	key     string
+}
+
+// New 创建Dispatcher,kv保存推送失败的事件,没有配置webhook时返回nil
+func New(c Config, kv db.KV) *Dispatcher {
+	if len(c.Hooks) == 0 {
+		return nil
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = defaultBackoff
+	}
+	d := &Dispatcher{
+		hooks:   make(map[string][]Hook),
+		client:  &http.Client{Timeout: time.Duration(c.Timeout) * time.Second},
+		retries: c.Retries,
+		backoff: time.Duration(c.Backoff) * time.Millisecond,
+		kv:      kv,
+		key:     proto.GetFailedWebhookKey(),
+	}
+	for _, hook := range c.Hooks {
+		d.hooks[hook.AppID] = append(d.hooks[hook.AppID], hook)
+	}
+	return d
+}
+
+// Events 把islb的广播转换为webhook事件,不需要推送或没有appid的广播返回空
+func Events(method string, data map[string]interface{}) []proto.WebhookEvent {
+	var names []string
+	switch method {
+	case proto.IslbToBizOnRoomAdd:
+		names = []string{proto.WebhookRoomCreated}
+	case proto.IslbToBizOnRoomRemove:
+		names = []string{proto.WebhookRoomClosed}
+	case proto.IslbToBizOnJoin:
+		names = []string{proto.WebhookPeerJoin}
+	case proto.IslbToBizOnLeave:
+		if util.Val(data, "reason") == proto.LeaveReasonKick {
+			names = []string{proto.WebhookPeerKick}
+		} else {
+			names = []string{proto.WebhookPeerLeave}
+		}
+	case proto.IslbToBizOnStreamAdd:
+		names = []string{proto.WebhookStreamAdd}
+	case proto.IslbToBizOnStreamRemove:
+		names = []string{proto.WebhookStreamRemove}
+	case proto.IslbToBizOnLiveAdd:
+		names = []string{proto.WebhookLiveStart}
+	case proto.IslbToBizOnLiveRemove:
+		names = []string{proto.WebhookLiveStop}
+		if util.InterfaceToString(data["record"]) == "1" {
+			names = append(names, proto.WebhookRecordFinished)
+		}
+	}
+	appid := util.Val(data, "appid")
+	if appid == "" {
+		return nil
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	events := make([]proto.WebhookEvent, 0, len(names))
+	for _, name := range names {
+		events = append(events, proto.WebhookEvent{
+			ID:    util.RandStr(16),
+			Event: name,
+			AppID: appid,
+			RID:   util.Val(data, "rid"),
+			UID:   util.Val(data, "uid"),
+			MID:   util.Val(data, "mid"),
+			Time:  now,
+			Data:  data,
+		})
+	}
+	return events
+}
+
+// Handle 处理islb的广播,转换为事件后异步推送
+func (d *Dispatcher) Handle(method string, data map[string]interface{}) {
+	for _, event := range Events(method, data) {
+		for _, hook := range d.match(event) {
+			go d.Deliver(hook, event)
+		}
+	}
+}
+
+// match 订阅了事件的webhook
+func (d *Dispatcher) match(event proto.WebhookEvent) []Hook {
+	var hooks []Hook
+	for _, hook := range d.hooks[event.AppID] {
+		if len(hook.Events) == 0 {
+			hooks = append(hooks, hook)
+			continue
+		}
+		for _, name := range hook.Events {
+			if name == event.Event {
+				hooks = append(hooks, hook)
+				break
+			}
+		}
+	}
+	return hooks
+}
+
+// Deliver 推送事件,失败时按backoff重试,仍失败时放入失败队列
+func (d *Dispatcher) Deliver(hook Hook, event proto.WebhookEvent) {
+	backoff := d.backoff
+	err := d.send(hook, event)
+	for i := 0; err != nil && i < d.retries; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = d.send(hook, event)
+	}
+	if err != nil {
+		log.Warnf("webhook.Deliver %s %s to %s err=%v", event.Event, event.ID, hook.URL, err)
+		d.fail(hook, event)
+	}
+}
+
+// send 签名并发送一次请求
+func (d *Dispatcher) send(hook Hook, event proto.WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Event)
+	h.SignRequest(req, hook.AppID, hook.Secret, body)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// fail 放入失败队列
+func (d *Dispatcher) fail(hook Hook, event proto.WebhookEvent) {
+	str, err := json.Marshal(failure{URL: hook.URL, Event: event})
+	if err != nil {
+		log.Errorf("webhook.fail json marshal failed=%v", err)
+		return
+	}
+	if err := d.kv.RPush(d.key, string(str)); err != nil {
+		log.Errorf("webhook.fail store failure err=%v", err)
+	}
+}
+
+// RetryFailures 重传失败队列中的事件,每个事件只发送一次,失败时重新放回队列
+// 地址已经从配置中删除或超过FailureTTL的事件丢弃,返回重传成功的数量
+func (d *Dispatcher) RetryFailures() int {
+	sent := 0
+	length := d.kv.LLen(d.key)
+	for i := int64(0); i < length; i++ {
+		str := d.kv.LPop(d.key)
+		if str == "" {
+			break
+		}
+		var f failure
+		if err := json.Unmarshal([]byte(str), &f); err != nil {
+			log.Errorf("webhook.RetryFailures json unmarshal failed=%v", err)
+			continue
+		}
+		if time.Since(time.Unix(0, f.Event.Time*int64(time.Millisecond))) > FailureTTL {
+			log.Warnf("webhook.RetryFailures drop expired %s %s", f.Event.Event, f.Event.ID)
+			continue
+		}
+		hook, ok := d.find(f.Event.AppID, f.URL)
+		if !ok {
+			log.Warnf("webhook.RetryFailures drop %s %s, hook %s removed", f.Event.Event, f.Event.ID, f.URL)
+			continue
+		}
+		if err := d.send(hook, f.Event); err != nil {
+			d.fail(hook, f.Event)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// find 按appid和地址查找webhook
+func (d *Dispatcher) find(appid, url string) (Hook, bool) {
+	for _, hook := range d.hooks[appid] {
+		if hook.URL == url {
+			return hook, true
+		}
+	}
+	return Hook{}, false
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	h "signal/infra/http"
+	db "signal/infra/redis"
+	"signal/pkg/proto"
+)
+
+func TestEvents(t *testing.T) {
+	cases := []struct {
+		method string
+		data   map[string]interface{}
+		events []string
+	}{
+		{proto.IslbToBizOnRoomAdd, map[string]interface{}{"rid": "r", "appid": "a"}, []string{proto.WebhookRoomCreated}},
+		{proto.IslbToBizOnJoin, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a"}, []string{proto.WebhookPeerJoin}},
+		{proto.IslbToBizOnLeave, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a"}, []string{proto.WebhookPeerLeave}},
+		{proto.IslbToBizOnLeave, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a", "reason": "kick"}, []string{proto.WebhookPeerKick}},
+		{proto.IslbToBizOnLiveRemove, map[string]interface{}{"rid": "r", "mid": "m", "appid": "a"}, []string{proto.WebhookLiveStop}},
+		{proto.IslbToBizOnLiveRemove, map[string]interface{}{"rid": "r", "mid": "m", "appid": "a", "record": float64(1)}, []string{proto.WebhookLiveStop, proto.WebhookRecordFinished}},
+		{proto.IslbToBizOnStreamUpdate, map[string]interface{}{"rid": "r", "appid": "a"}, nil},
+		{proto.IslbToBizOnJoin, map[string]interface{}{"rid": "r", "uid": "u"}, nil},
+	}
+	for _, c := range cases {
+		events := Events(c.method, c.data)
+		if len(events) != len(c.events) {
+			t.Errorf("%s %v = %v", c.method, c.data, events)
+			continue
+		}
+		for i, e := range events {
+			if e.Event != c.events[i] || e.AppID != "a" || e.RID != "r" || e.ID == "" {
+				t.Errorf("%s event %d = %+v", c.method, i, e)
+			}
+		}
+	}
+}
+
+// receiver 校验签名并记录收到的事件,fail为true时返回500
+type receiver struct {
+	sync.Mutex
+	fail   bool
+	events []proto.WebhookEvent
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	sign := h.Sign("secret", req.Header.Get(h.HeaderAppID), req.Header.Get(h.HeaderTimestamp), req.Header.Get(h.HeaderNonce), req.Method, req.URL.RequestURI(), body)
+	if sign != req.Header.Get(h.HeaderSignature) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var e proto.WebhookEvent
+	json.Unmarshal(body, &e)
+	if e.Event != req.Header.Get(HeaderEvent) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, e)
+}
+
+func TestDeliver(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	kv := db.NewMemory()
+	d := New(Config{Retries: 1, Backoff: 1, Hooks: []Hook{
+		{AppID: "a", URL: server.URL + "/all", Secret: "secret"},
+		{AppID: "a", URL: server.URL + "/live", Secret: "secret", Events: []string{proto.WebhookLiveStart}},
+	}}, kv)
+	event := Events(proto.IslbToBizOnJoin, map[string]interface{}{"rid": "r", "uid": "u", "appid": "a"})[0]
+	if hooks := d.match(event); len(hooks) != 1 || hooks[0].URL != server.URL+"/all" {
+		t.Fatalf("match = %v", hooks)
+	}
+
+	d.Deliver(d.hooks["a"][0], event)
+	if len(r.events) != 1 || r.events[0].ID != event.ID {
+		t.Fatalf("events = %v", r.events)
+	}
+
+	// 重试后仍失败时放入失败队列,恢复后重传
+	r.fail = true
+	d.Deliver(d.hooks["a"][0], event)
+	if kv.LLen(proto.GetFailedWebhookKey()) != 1 {
+		t.Fatalf("failure queue = %d", kv.LLen(proto.GetFailedWebhookKey()))
+	}
+	if sent := d.RetryFailures(); sent != 0 || kv.LLen(proto.GetFailedWebhookKey()) != 1 {
+		t.Fatalf("retry while failing sent = %d", sent)
+	}
+	r.fail = false
+	if sent := d.RetryFailures(); sent != 1 || kv.LLen(proto.GetFailedWebhookKey()) != 0 {
+		t.Fatalf("retry sent = %d", sent)
+	}
+	if len(r.events) != 2 {
+		t.Fatalf("events = %v", r.events)
+	}
+
+	// 地址从配置中删除后丢弃
+	d.fail(Hook{AppID: "a", URL: server.URL + "/removed"}, event)
+	if sent := d.RetryFailures(); sent != 0 || kv.LLen(proto.GetFailedWebhookKey()) != 0 {
+		t.Fatalf("removed hook sent = %d", sent)
+	}
+}
@@ -2,7 +2,6 @@ package ws
 
 import (
 	"signal/pkg/log"
-	"signal/pkg/timing"
 
 	"github.com/gearghost/go-protoo/peer"
 	"github.com/gearghost/go-protoo/transport"
@@ -11,8 +10,8 @@ import (
 // Peer peer对象
 type Peer struct {
 	peer.Peer
-	livestreamtimer *timing.LiveStreamTimer
-	appid           string
+	appid  string
+	region string
 }
 
 // NewPeer 初始化peer对象
@@ -34,12 +33,14 @@ func (p *Peer) GetAppID() string {
 	return p.appid
 }
 
-func (p *Peer) SetLiveStreamTimer(timer *timing.LiveStreamTimer) {
-	p.livestreamtimer = timer
+// SetRegion 设置客户端连接时提示的区域
+func (p *Peer) SetRegion(region string) {
+	p.region = region
 }
 
-func (p *Peer) GetLiveStreamTimer() *timing.LiveStreamTimer {
-	return p.livestreamtimer
+// GetRegion 获取客户端提示的区域,没有提示时为空
+func (p *Peer) GetRegion() string {
+	return p.region
 }
 
 // On 事件处理
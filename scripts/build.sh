@@ -10,6 +10,7 @@ BIZ_BIN=biz
 ISLB_BIN=islb
 SFU_BIN=sfu
 ISSR_BIN=issr
+ALLINONE_BIN=allinone
 
 PROJECT=$1
 
@@ -17,11 +18,12 @@ BUILD_PATH1=$APP_DIR/bin/$BIZ_BIN
 BUILD_PATH3=$APP_DIR/bin/$ISLB_BIN
 BUILD_PATH4=$APP_DIR/bin/$SFU_BIN
 BUILD_PATH5=$APP_DIR/bin/$ISSR_BIN
+BUILD_PATH6=$APP_DIR/bin/$ALLINONE_BIN
 
 help(){
     echo ""
     echo "build script"
-    echo "Usage: ./build.sh biz|islb|sfu|issr|all"
+    echo "Usage: ./build.sh biz|islb|sfu|issr|allinone|all"
     echo "Usage: ./build.sh [-h]"
     echo ""
 }
@@ -58,6 +60,14 @@ build_issr()
     go build -tags netgo -o $BUILD_PATH5
 }
 
+build_allinone()
+{
+    echo "------------------build $ALLINONE_BIN------------------"
+    echo "go build -o $BUILD_PATH6"
+    cd $APP_DIR/cmd/allinone
+    go build -tags netgo -o $BUILD_PATH6
+}
+
 while getopts "o:h" arg
 do
     case $arg in
@@ -105,6 +115,9 @@ $SFU_BIN)
 $ISSR_BIN)
     build_issr
     ;;
+$ALLINONE_BIN)
+    build_allinone
+    ;;
 all)
     build_biz
     build_islb